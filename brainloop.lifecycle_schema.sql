@@ -22,7 +22,9 @@ CREATE TABLE IF NOT EXISTS sessions (
     session_id TEXT PRIMARY KEY,
     status TEXT NOT NULL,           -- 'pending_audit' | 'committed' | 'abandoned'
     created_at INTEGER NOT NULL,
-    completed_at INTEGER
+    completed_at INTEGER,
+    project_root TEXT,              -- Racine du projet dont les patterns sont injectés
+    imported_from TEXT              -- Session d'origine d'une session importée (mode import)
 );
 
 -- Blocks dans sessions
@@ -30,17 +32,38 @@ CREATE TABLE IF NOT EXISTS session_blocks (
     block_id TEXT PRIMARY KEY,
     session_id TEXT NOT NULL,
     description TEXT NOT NULL,
-    type TEXT NOT NULL,             -- 'sql' | 'go' | 'python' | 'code'
+    type TEXT NOT NULL,             -- 'sql' | 'go' | 'python' | 'code' | 'files' | 'edit'
     target TEXT NOT NULL,           -- file_path ou db_path
-    code TEXT,                      -- Code généré actuel
+    code TEXT,                      -- Code généré actuel (patch pour 'edit')
+    initial_code TEXT,              -- Code de la première génération (historique refine)
+    pending_code TEXT,              -- Code régénéré au commit, en attente de confirmation
+    stale INTEGER DEFAULT 0,        -- 1 si une dépendance a changé depuis la génération
+    validation_json TEXT,           -- Dernier rapport du gate de validation (avec code_hash validé)
+    test_json TEXT,                 -- Test d'acceptation (kind 'go' | 'shell', code, root)
+    test_result_json TEXT,          -- Dernier résultat du test d'acceptation (avec code_hash testé)
+    pattern_id TEXT,                -- Patterns injectés dans la dernière génération (detected_patterns)
     iterations INTEGER DEFAULT 0,
-    status TEXT DEFAULT 'pending',  -- 'pending' | 'committed'
+    version INTEGER DEFAULT 0,      -- Incrémenté à chaque écriture (concurrence optimiste)
+    status TEXT DEFAULT 'pending',  -- 'pending' | 'committed' | 'failed'
+    generation_error TEXT,          -- Erreur de génération d'un block 'failed' (mode retry)
     generated_at INTEGER NOT NULL,
     last_refined_at INTEGER,
     committed_at INTEGER,
     FOREIGN KEY (session_id) REFERENCES sessions(session_id)
 );
 
+-- Dépendances entre blocks d'une session (depends_on généré et commité avant block_id)
+CREATE TABLE IF NOT EXISTS block_dependencies (
+    session_id TEXT NOT NULL,
+    block_id TEXT NOT NULL,
+    depends_on TEXT NOT NULL,
+    PRIMARY KEY (block_id, depends_on),
+    FOREIGN KEY (block_id) REFERENCES session_blocks(block_id),
+    FOREIGN KEY (depends_on) REFERENCES session_blocks(block_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_block_dependencies_depends_on ON block_dependencies(depends_on);
+
 -- Audit feedback sur blocks
 CREATE TABLE IF NOT EXISTS block_refinements (
     refinement_id TEXT PRIMARY KEY,
@@ -52,6 +75,54 @@ CREATE TABLE IF NOT EXISTS block_refinements (
     FOREIGN KEY (block_id) REFERENCES session_blocks(block_id)
 );
 
+-- Sauvegardes prises avant chaque commit (rollback loop)
+CREATE TABLE IF NOT EXISTS commit_backups (
+    backup_id TEXT PRIMARY KEY,
+    commit_id TEXT NOT NULL,        -- regroupe les cibles d'un même commit
+    session_id TEXT NOT NULL,
+    block_id TEXT NOT NULL,
+    target TEXT NOT NULL,           -- fichier ou base SQLite modifié par le commit
+    kind TEXT NOT NULL,             -- 'file' | 'sqlite' | 'absent' (cible inexistante avant commit)
+    backup_path TEXT,               -- copie des octets ou snapshot VACUUM INTO
+    backup_hash TEXT,               -- sha256 de la sauvegarde
+    committed_hash TEXT,            -- sha256 de la cible juste après le commit
+    created_at INTEGER NOT NULL,
+    restored_at INTEGER,
+    FOREIGN KEY (block_id) REFERENCES session_blocks(block_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_commit_backups_session ON commit_backups(session_id, restored_at);
+CREATE INDEX IF NOT EXISTS idx_commit_backups_block ON commit_backups(block_id, restored_at);
+
+-- Recettes de blocks réutilisables (loop propose avec recipe), une ligne par version
+CREATE TABLE IF NOT EXISTS block_recipes (
+    name TEXT NOT NULL,
+    version INTEGER NOT NULL,           -- 1 à la création, +1 par action recipe version
+    description_template TEXT NOT NULL, -- {{variable}} remplacées au propose
+    type TEXT NOT NULL,
+    target_pattern TEXT NOT NULL,       -- {{variable}} remplacées au propose
+    depends_on_json TEXT,               -- recettes proposées avant celle-ci, comme dépendances
+    test_json TEXT,                     -- test d'acceptation, {{variable}} remplacées
+    note TEXT,                          -- raison de la version
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (name, version)
+);
+
+-- Journal des événements de session (loop report), dans l'ordre
+CREATE TABLE IF NOT EXISTS session_events (
+    event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id TEXT NOT NULL,
+    block_id TEXT,                  -- NULL pour un événement de la session
+    kind TEXT NOT NULL,             -- 'created' | 'generated' | 'failed' | 'audited' | 'refined' | 'reverted' | 'validated' | 'committed' | 'rolled_back' | 'abandoned' | 'resumed' | 'imported'
+    actor TEXT,                     -- Client MCP (clientInfo de initialize)
+    detail TEXT,                    -- Feedback, erreur, résultat du gate, fichiers écrits…
+    temperature REAL,
+    tokens INTEGER,
+    timestamp INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_events_session ON session_events(session_id, event_id);
+
 -- Reader cache (éviter re-lecture)
 CREATE TABLE IF NOT EXISTS reader_cache (
     hash TEXT PRIMARY KEY,          -- sha256(file_path + file_mtime)
@@ -90,6 +161,8 @@ INSERT OR IGNORE INTO schema_metadata (key, value) VALUES
 CREATE TABLE IF NOT EXISTS cerebras_usage (
     request_id TEXT PRIMARY KEY,
     operation TEXT NOT NULL,        -- Action name
+    provider TEXT,                  -- Endpoint ayant servi l'appel (chaîne de fallback)
+    block_id TEXT,                  -- Block pour lequel l'appel a été fait
     model TEXT NOT NULL,            -- zai-glm-4.6
     temperature REAL NOT NULL,
     tokens_prompt INTEGER,
@@ -18,12 +18,16 @@ CREATE TABLE IF NOT EXISTS telemetry_events (
     description TEXT
 );
 
--- Secrets (Cerebras API key)
+-- Secrets (clés API, plusieurs clés nommées par provider)
 CREATE TABLE IF NOT EXISTS secrets (
     secret_name TEXT PRIMARY KEY,
     secret_value TEXT NOT NULL,     -- Plaintext pour dev, encrypted pour prod
     created_at INTEGER NOT NULL,
-    last_rotated INTEGER
+    last_rotated INTEGER,           -- Changement = rechargement à chaud de la clé
+    provider TEXT,                  -- Pool de clés ('cerebras', ...), NULL = secret simple
+    disabled INTEGER DEFAULT 0,     -- 1 = désactivée automatiquement (401)
+    disabled_at INTEGER,
+    disabled_reason TEXT
 );
 
 -- Configuration initiale
@@ -0,0 +1,142 @@
+package cerebras
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"brainloop/internal/validation"
+)
+
+// maxCandidates bounds the number of candidates requested in best-of-N mode
+const maxCandidates = 8
+
+// candidateTemperatureStep spreads candidate temperatures so they differ
+const candidateTemperatureStep = 0.15
+
+// Candidate is one generation of a best-of-N run with its validation report
+type Candidate struct {
+	Index       int                `json:"index"`
+	Temperature float64            `json:"temperature"`
+	Valid       bool               `json:"valid"`
+	Score       float64            `json:"score"`
+	Checks      []validation.Check `json:"checks,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	Code        string             `json:"-"`
+}
+
+// BestOfNResult holds the selected candidate and the scores of all candidates
+type BestOfNResult struct {
+	Code       string            `json:"-"`
+	Selected   int               `json:"selected"`
+	Candidates []Candidate       `json:"candidates"`
+	Usage      *GenerationResult `json:"-"` // tokens summed over all candidates
+}
+
+// GenerateBestOfN requests n candidates with parallel calls at increasing
+// temperatures, scores each with local validation and returns the best one.
+// It only fails if no candidate could be generated.
+func (c *Client) GenerateBestOfN(prompt, codeType string, patterns interface{}, temperature float64, n int) (*BestOfNResult, error) {
+	return c.GenerateBestOfNWithSlots(prompt, codeType, patterns, temperature, n, nil)
+}
+
+// GenerateBestOfNWithSlots is GenerateBestOfN with each candidate call made
+// while holding a slot from acquire, which returns the function releasing it.
+// A nil acquire runs every candidate at once.
+func (c *Client) GenerateBestOfNWithSlots(prompt, codeType string, patterns interface{}, temperature float64, n int, acquire func() func()) (*BestOfNResult, error) {
+	if n < 1 {
+		n = 1
+	}
+	if n > maxCandidates {
+		n = maxCandidates
+	}
+
+	startTime := time.Now()
+	systemPrompt := buildSystemPrompt(codeType, patterns)
+
+	candidates := make([]Candidate, n)
+	results := make([]*GenerationResult, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+
+			temp := candidateTemperature(temperature, idx)
+			candidates[idx] = Candidate{Index: idx, Temperature: temp}
+
+			if acquire != nil {
+				defer acquire()()
+			}
+
+			if err := c.limiter.Wait(context.Background()); err != nil {
+				candidates[idx].Error = err.Error()
+				return
+			}
+
+			result, err := c.Generate(systemPrompt, prompt, temp)
+			if err != nil {
+				c.limiter.RecordError()
+				candidates[idx].Error = err.Error()
+				return
+			}
+			c.limiter.RecordSuccess()
+
+			results[idx] = result
+			candidates[idx].Code = result.Content
+			if codeType != "files" {
+				// Multi-file output keeps its path-annotated fences
+				candidates[idx].Code = CleanCode(result.Content, codeType)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Score generated candidates; failed generations rank last
+	reports := make([]validation.Report, n)
+	usage := &GenerationResult{Temperature: temperature}
+	var lastErr string
+	for i := range candidates {
+		if results[i] == nil {
+			lastErr = candidates[i].Error
+			reports[i] = validation.Report{Valid: false, Score: -1}
+			continue
+		}
+
+		reports[i] = validation.Validate(candidates[i].Code, codeType)
+		candidates[i].Valid = reports[i].Valid
+		candidates[i].Score = reports[i].Score
+		candidates[i].Checks = reports[i].Checks
+
+		usage.PromptTokens += results[i].PromptTokens
+		usage.CompletionTokens += results[i].CompletionTokens
+	}
+
+	best := validation.Rank(reports)[0]
+	if results[best] == nil {
+		return nil, fmt.Errorf("all %d candidates failed: %s", n, lastErr)
+	}
+
+	usage.Content = results[best].Content
+	usage.Model = results[best].Model
+	usage.Provider = results[best].Provider
+	usage.LatencyMs = int(time.Since(startTime).Milliseconds())
+
+	return &BestOfNResult{
+		Code:       candidates[best].Code,
+		Selected:   best,
+		Candidates: candidates,
+		Usage:      usage,
+	}, nil
+}
+
+// candidateTemperature returns the temperature of the idx-th candidate
+func candidateTemperature(base float64, idx int) float64 {
+	temp := base + float64(idx)*candidateTemperatureStep
+	if temp > 1 {
+		temp = 1
+	}
+	return temp
+}
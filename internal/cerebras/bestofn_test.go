@@ -0,0 +1,89 @@
+package cerebras
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGenerateBestOfNSelectsValidCandidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		// Only the second candidate's temperature yields a runnable schema
+		content := "CREATE TABLE users (id INTEGER PRIMARY KEY"
+		if req.Temperature > 0.2 && req.Temperature < 0.3 {
+			content = "```sql\nCREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY);\n```"
+		}
+
+		json.NewEncoder(w).Encode(ChatResponse{
+			Model:   "test-model",
+			Choices: []Choice{{Message: Message{Role: "assistant", Content: content}}},
+			Usage:   Usage{PromptTokens: 10, CompletionTokens: 5},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key", WithBaseURL(server.URL))
+
+	result, err := client.GenerateBestOfN("users table", "sql", nil, 0.1, 3)
+	if err != nil {
+		t.Fatalf("GenerateBestOfN failed: %v", err)
+	}
+
+	if result.Selected != 1 || !result.Candidates[1].Valid {
+		t.Errorf("Expected candidate 1 to win, got %d (%+v)", result.Selected, result.Candidates)
+	}
+	if result.Code != "CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY);" {
+		t.Errorf("Expected cleaned winning code, got %q", result.Code)
+	}
+	if len(result.Candidates) != 3 || result.Candidates[0].Valid {
+		t.Errorf("Expected 3 scored candidates with an invalid first one, got %+v", result.Candidates)
+	}
+	if result.Usage.PromptTokens != 30 {
+		t.Errorf("Expected usage summed over candidates, got %d", result.Usage.PromptTokens)
+	}
+}
+
+func TestGenerateBestOfNWithSlotsBoundsConcurrentCalls(t *testing.T) {
+	var running, peak int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+
+		json.NewEncoder(w).Encode(ChatResponse{
+			Model:   "test-model",
+			Choices: []Choice{{Message: Message{Role: "assistant", Content: "SELECT 1;"}}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key", WithBaseURL(server.URL))
+	slots := make(chan struct{}, 2)
+	acquire := func() func() {
+		slots <- struct{}{}
+		return func() { <-slots }
+	}
+
+	result, err := client.GenerateBestOfNWithSlots("one", "sql", nil, 0.1, 4, acquire)
+	if err != nil {
+		t.Fatalf("GenerateBestOfNWithSlots failed: %v", err)
+	}
+	if len(result.Candidates) != 4 {
+		t.Errorf("Expected 4 candidates, got %d", len(result.Candidates))
+	}
+	if p := atomic.LoadInt32(&peak); p > 2 {
+		t.Errorf("Expected at most 2 calls at once, got %d", p)
+	}
+}
@@ -0,0 +1,193 @@
+package cerebras
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen rejects calls until the open timeout elapses
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a few probe calls through to decide whether to close again
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerConfig holds circuit breaker thresholds
+type BreakerConfig struct {
+	FailureThreshold  int           // consecutive failures that open the circuit
+	OpenTimeout       time.Duration // time spent open before probing
+	HalfOpenSuccesses int           // successful probes needed to close again, and probes allowed at once
+}
+
+// DefaultBreakerConfig returns default circuit breaker thresholds
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold:  5,
+		OpenTimeout:       30 * time.Second,
+		HalfOpenSuccesses: 1,
+	}
+}
+
+// CircuitBreaker tracks the health of a single endpoint
+type CircuitBreaker struct {
+	config BreakerConfig
+	mu     sync.Mutex
+
+	state               BreakerState
+	consecutiveFailures int
+	halfOpenSuccesses   int
+	halfOpenInFlight    int // probes let through and not yet recorded
+	openedAt            time.Time
+	lastError           string
+	lastChange          time.Time
+	totalSuccesses      int
+	totalFailures       int
+}
+
+// BreakerSnapshot is a point-in-time view of a circuit breaker
+type BreakerSnapshot struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	TotalSuccesses      int          `json:"total_successes"`
+	TotalFailures       int          `json:"total_failures"`
+	OpenedAt            int64        `json:"opened_at,omitempty"`
+	LastChange          int64        `json:"last_change"`
+	LastError           string       `json:"last_error,omitempty"`
+}
+
+// NewCircuitBreaker creates a closed circuit breaker
+func NewCircuitBreaker(config BreakerConfig) *CircuitBreaker {
+	defaults := DefaultBreakerConfig()
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaults.FailureThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaults.OpenTimeout
+	}
+	if config.HalfOpenSuccesses <= 0 {
+		config.HalfOpenSuccesses = defaults.HalfOpenSuccesses
+	}
+
+	return &CircuitBreaker{
+		config:     config,
+		state:      BreakerClosed,
+		lastChange: time.Now(),
+	}
+}
+
+// Allow reports whether a call may be attempted, moving an open breaker to
+// half-open once its timeout has elapsed. A half-open breaker lets through
+// only the probes it still needs; every allowed call must be followed by
+// RecordSuccess, RecordFailure or ReleaseProbe.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.halfOpenSuccesses = 0
+		b.halfOpenInFlight = 1
+		return true
+	case BreakerHalfOpen:
+		if b.halfOpenSuccesses+b.halfOpenInFlight >= b.config.HalfOpenSuccesses {
+			return false
+		}
+		b.halfOpenInFlight++
+		return true
+	default:
+		return true
+	}
+}
+
+// ReleaseProbe records a call whose outcome says nothing about the endpoint's
+// health, such as a client error, freeing its half-open probe
+func (b *CircuitBreaker) ReleaseProbe() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen && b.halfOpenInFlight > 0 {
+		b.halfOpenInFlight--
+	}
+}
+
+// RecordSuccess records a successful call
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.totalSuccesses++
+	b.consecutiveFailures = 0
+
+	if b.state == BreakerHalfOpen {
+		if b.halfOpenInFlight > 0 {
+			b.halfOpenInFlight--
+		}
+		b.halfOpenSuccesses++
+		if b.halfOpenSuccesses >= b.config.HalfOpenSuccesses {
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+// RecordFailure records a failed call, opening the circuit when the threshold
+// is reached or when a half-open probe fails
+func (b *CircuitBreaker) RecordFailure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.totalFailures++
+	b.consecutiveFailures++
+	if err != nil {
+		b.lastError = err.Error()
+	}
+
+	if b.state == BreakerHalfOpen || b.consecutiveFailures >= b.config.FailureThreshold {
+		b.setState(BreakerOpen)
+		b.openedAt = time.Now()
+		b.halfOpenInFlight = 0
+	}
+}
+
+// State returns the current state without transitioning it
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Snapshot returns the breaker's current statistics
+func (b *CircuitBreaker) Snapshot() BreakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snap := BreakerSnapshot{
+		State:               b.state,
+		ConsecutiveFailures: b.consecutiveFailures,
+		TotalSuccesses:      b.totalSuccesses,
+		TotalFailures:       b.totalFailures,
+		LastChange:          b.lastChange.Unix(),
+		LastError:           b.lastError,
+	}
+	if b.state == BreakerOpen {
+		snap.OpenedAt = b.openedAt.Unix()
+	}
+
+	return snap
+}
+
+// setState changes state; callers must hold mu
+func (b *CircuitBreaker) setState(state BreakerState) {
+	if b.state != state {
+		b.state = state
+		b.lastChange = time.Now()
+	}
+}
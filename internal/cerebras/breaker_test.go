@@ -0,0 +1,70 @@
+package cerebras
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	breaker := NewCircuitBreaker(BreakerConfig{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond, HalfOpenSuccesses: 1})
+
+	breaker.RecordFailure(errors.New("boom"))
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("Expected closed after 1 failure, got %s", breaker.State())
+	}
+
+	breaker.RecordFailure(errors.New("boom"))
+	if breaker.State() != BreakerOpen || breaker.Allow() {
+		t.Fatalf("Expected open breaker rejecting calls, got %s", breaker.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !breaker.Allow() || breaker.State() != BreakerHalfOpen {
+		t.Fatalf("Expected half-open probe after timeout, got %s", breaker.State())
+	}
+
+	breaker.RecordFailure(errors.New("still down"))
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("Expected failed probe to reopen, got %s", breaker.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	breaker.Allow()
+	breaker.RecordSuccess()
+	if breaker.State() != BreakerClosed {
+		t.Errorf("Expected successful probe to close, got %s", breaker.State())
+	}
+}
+
+func TestCircuitBreakerLimitsHalfOpenProbes(t *testing.T) {
+	breaker := NewCircuitBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond, HalfOpenSuccesses: 2})
+
+	breaker.RecordFailure(errors.New("boom"))
+	time.Sleep(20 * time.Millisecond)
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if breaker.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("Expected 2 concurrent probes while half-open, got %d", allowed)
+	}
+
+	// A probe whose outcome says nothing frees its slot
+	breaker.ReleaseProbe()
+	if !breaker.Allow() {
+		t.Fatal("Expected a released probe slot to be reusable")
+	}
+
+	breaker.RecordSuccess()
+	if breaker.Allow() {
+		t.Fatal("Expected no new probe while one success and one probe are pending")
+	}
+	breaker.RecordSuccess()
+	if breaker.State() != BreakerClosed || !breaker.Allow() || !breaker.Allow() {
+		t.Errorf("Expected the breaker to close and let every call through, got %s", breaker.State())
+	}
+}
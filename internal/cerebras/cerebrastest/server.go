@@ -0,0 +1,301 @@
+// Package cerebrastest provides a local OpenAI-compatible stand-in for the
+// Cerebras API, so generation paths can be exercised without an API key.
+package cerebrastest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"brainloop/internal/cerebras"
+)
+
+// Response is a scripted reply. A non-zero Status returns an API error with
+// Body instead of a completion; Delay is applied before answering, which
+// makes timeouts injectable.
+type Response struct {
+	Content   string              `json:"content"`
+	ToolCalls []cerebras.ToolCall `json:"tool_calls,omitempty"`
+	Status    int                 `json:"status,omitempty"`
+	Body      string              `json:"body,omitempty"`
+	Delay     time.Duration       `json:"delay,omitempty"`
+}
+
+// Responder builds a reply for a request that matched no script or fixture
+type Responder func(req cerebras.ChatRequest) Response
+
+// RecordedRequest is a request received by the server with the usage it was billed
+type RecordedRequest struct {
+	Request    cerebras.ChatRequest
+	PromptHash string
+	Source     string // "fault" | "script" | "fixture" | "responder" | "unmatched"
+	Status     int
+	Usage      cerebras.Usage
+}
+
+// Server is a local stand-in for the chat completions endpoint
+type Server struct {
+	httpServer *httptest.Server
+
+	mu        sync.Mutex
+	faults    []Response
+	script    []Response
+	fixtures  map[string]Response
+	responder Responder
+	requests  []RecordedRequest
+}
+
+// NewServer starts a stand-in server. Requests are answered, in order of
+// precedence, by injected faults, scripted responses, fixtures keyed by
+// prompt hash, and finally the responder; unmatched requests get a 501.
+func NewServer() *Server {
+	s := &Server{fixtures: make(map[string]Response)}
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL returns the base URL to give to cerebras.WithBaseURL
+func (s *Server) URL() string {
+	return s.httpServer.URL
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Client returns a cerebras client pointed at the server
+func (s *Server) Client(opts ...cerebras.Option) *cerebras.Client {
+	return cerebras.NewClient("cerebrastest", append([]cerebras.Option{cerebras.WithBaseURL(s.URL())}, opts...)...)
+}
+
+// Script queues responses returned in order to the next requests
+func (s *Server) Script(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, responses...)
+}
+
+// ScriptContent queues plain completions
+func (s *Server) ScriptContent(contents ...string) {
+	for _, content := range contents {
+		s.Script(Response{Content: content})
+	}
+}
+
+// InjectFault makes the next count requests fail with status (429, 500, ...)
+// after delay; a status of 0 with a delay only slows the next responses down
+func (s *Server) InjectFault(status int, delay time.Duration, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < count; i++ {
+		s.faults = append(s.faults, Response{
+			Status: status,
+			Body:   fmt.Sprintf(`{"error":{"message":"injected fault (status %d)"}}`, status),
+			Delay:  delay,
+		})
+	}
+}
+
+// SetResponder sets the fallback used when no script or fixture matches
+func (s *Server) SetResponder(responder Responder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responder = responder
+}
+
+// AddFixture registers the response for a prompt hash
+func (s *Server) AddFixture(hash string, response Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures[hash] = response
+}
+
+// LoadFixtures reads fixture files from dir. Each file is named after a
+// prompt hash: <hash>.json holds a Response, any other extension holds the
+// raw completion content.
+func (s *Server) LoadFixtures(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read fixtures: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read fixture %s: %w", entry.Name(), err)
+		}
+
+		ext := filepath.Ext(entry.Name())
+		hash := strings.TrimSuffix(entry.Name(), ext)
+
+		response := Response{Content: string(data)}
+		if ext == ".json" {
+			response = Response{}
+			if err := json.Unmarshal(data, &response); err != nil {
+				return fmt.Errorf("invalid fixture %s: %w", entry.Name(), err)
+			}
+		}
+
+		s.AddFixture(hash, response)
+	}
+
+	return nil
+}
+
+// Requests returns every request received so far
+func (s *Server) Requests() []RecordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RecordedRequest(nil), s.requests...)
+}
+
+// Usage returns the token usage summed over all successful requests
+func (s *Server) Usage() cerebras.Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total cerebras.Usage
+	for _, r := range s.requests {
+		total.PromptTokens += r.Usage.PromptTokens
+		total.CompletionTokens += r.Usage.CompletionTokens
+		total.TotalTokens += r.Usage.TotalTokens
+	}
+	return total
+}
+
+// PromptHash identifies a request by its messages, ignoring sampling parameters
+func PromptHash(req cerebras.ChatRequest) string {
+	h := sha256.New()
+	for _, msg := range req.Messages {
+		io.WriteString(h, msg.Role)
+		h.Write([]byte{0})
+		io.WriteString(h, msg.Content)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// handle serves POST /chat/completions
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req cerebras.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":{"message":"invalid request: %v"}}`, err), http.StatusBadRequest)
+		return
+	}
+
+	hash := PromptHash(req)
+	response, source := s.resolve(req, hash)
+
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+			s.record(RecordedRequest{Request: req, PromptHash: hash, Source: source, Status: http.StatusRequestTimeout})
+			return
+		}
+	}
+
+	if response.Status != 0 && response.Status != http.StatusOK {
+		s.record(RecordedRequest{Request: req, PromptHash: hash, Source: source, Status: response.Status})
+		http.Error(w, response.Body, response.Status)
+		return
+	}
+
+	usage := estimateUsage(req, response.Content)
+	s.record(RecordedRequest{Request: req, PromptHash: hash, Source: source, Status: http.StatusOK, Usage: usage})
+
+	finishReason := "stop"
+	if len(response.ToolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+
+	model := req.Model
+	if model == "" {
+		model = "cerebrastest"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cerebras.ChatResponse{
+		ID:      "chatcmpl-" + hash,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []cerebras.Choice{{
+			Message:      cerebras.Message{Role: "assistant", Content: response.Content, ToolCalls: response.ToolCalls},
+			FinishReason: finishReason,
+		}},
+		Usage: usage,
+	})
+}
+
+// resolve picks the response for a request and reports where it came from
+func (s *Server) resolve(req cerebras.ChatRequest, hash string) (Response, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.faults) > 0 {
+		response := s.faults[0]
+		s.faults = s.faults[1:]
+		return response, "fault"
+	}
+
+	if len(s.script) > 0 {
+		response := s.script[0]
+		s.script = s.script[1:]
+		return response, "script"
+	}
+
+	if response, ok := s.fixtures[hash]; ok {
+		return response, "fixture"
+	}
+
+	if s.responder != nil {
+		return s.responder(req), "responder"
+	}
+
+	return Response{
+		Status: http.StatusNotImplemented,
+		Body:   fmt.Sprintf(`{"error":{"message":"no scripted response for prompt %s"}}`, hash),
+	}, "unmatched"
+}
+
+// record appends a request to the log
+func (s *Server) record(r RecordedRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+}
+
+// estimateUsage approximates token counts at four characters per token
+func estimateUsage(req cerebras.ChatRequest, completion string) cerebras.Usage {
+	promptChars := 0
+	for _, msg := range req.Messages {
+		promptChars += len(msg.Content)
+	}
+
+	usage := cerebras.Usage{
+		PromptTokens:     (promptChars + 3) / 4,
+		CompletionTokens: (len(completion) + 3) / 4,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
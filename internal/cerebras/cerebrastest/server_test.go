@@ -0,0 +1,95 @@
+package cerebrastest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"brainloop/internal/cerebras"
+)
+
+func TestScriptedResponses(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	server.ScriptContent("first", "second")
+	client := server.Client()
+
+	for _, expected := range []string{"first", "second"} {
+		result, err := client.Generate("system", "user", 0.1)
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if result.Content != expected {
+			t.Errorf("Expected %q, got %q", expected, result.Content)
+		}
+	}
+
+	if usage := server.Usage(); usage.PromptTokens == 0 || usage.CompletionTokens == 0 {
+		t.Errorf("Expected recorded usage, got %+v", usage)
+	}
+
+	// Script exhausted and nothing else configured
+	if _, err := client.Generate("system", "user", 0.1); err == nil {
+		t.Error("Expected unmatched request to fail")
+	}
+}
+
+func TestFixturesByPromptHash(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	hash := PromptHash(cerebras.ChatRequest{Messages: []cerebras.Message{
+		{Role: "system", Content: "system"},
+		{Role: "user", Content: "hello"},
+	}})
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, hash+".txt"), []byte("fixture reply"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.LoadFixtures(dir); err != nil {
+		t.Fatalf("LoadFixtures failed: %v", err)
+	}
+
+	result, err := server.Client().Generate("system", "hello", 0.7)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.Content != "fixture reply" {
+		t.Errorf("Expected fixture reply, got %q", result.Content)
+	}
+	if requests := server.Requests(); requests[0].Source != "fixture" {
+		t.Errorf("Expected fixture source, got %s", requests[0].Source)
+	}
+}
+
+func TestInjectedFaults(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	server.InjectFault(429, 0, 1)
+	server.SetResponder(func(req cerebras.ChatRequest) Response {
+		return Response{Content: "recovered"}
+	})
+	client := server.Client()
+
+	_, err := client.Generate("system", "user", 0.1)
+	var apiErr *cerebras.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 {
+		t.Fatalf("Expected injected 429, got %v", err)
+	}
+
+	result, err := client.Generate("system", "user", 0.1)
+	if err != nil || result.Content != "recovered" {
+		t.Fatalf("Expected responder after fault, got %v / %v", result, err)
+	}
+
+	// A delay longer than the client timeout surfaces as a timeout
+	server.InjectFault(0, 200*time.Millisecond, 1)
+	if _, err := server.Client(cerebras.WithTimeout(50*time.Millisecond)).Generate("system", "user", 0.1); err == nil {
+		t.Error("Expected timeout error")
+	}
+}
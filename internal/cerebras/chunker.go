@@ -0,0 +1,295 @@
+package cerebras
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxDigestPromptLen is the maximum source size sent in a single digest prompt
+const maxDigestPromptLen = 10000
+
+// Chunk represents a structural slice of a source submitted to the map phase
+type Chunk struct {
+	Index   int    `json:"index"`
+	Section string `json:"section"` // e.g. "header", "tables[0:12]", "text"
+	Content string `json:"content"`
+	Hash    string `json:"hash"`
+}
+
+// structuralKeys lists, per source type, the analysis arrays that carry most of the content
+var structuralKeys = map[string][]string{
+	"sqlite":   {"tables", "schemas", "indexes"},
+	"markdown": {"sections", "code_blocks", "links", "images"},
+	"code":     {"functions", "types", "classes", "imports", "constants", "tables", "indexes"},
+	"config":   {"critical_settings", "environment_vars", "secrets", "keys", "sections"},
+}
+
+// positionalKeys are ignored when placing chunk boundaries so that shifting
+// line numbers after an edit elsewhere does not move them
+var positionalKeys = map[string]bool{
+	"line_number": true,
+	"start_line":  true,
+	"end_line":    true,
+	"line":        true,
+}
+
+// textBoundaries marks the lines that start a new structural segment in raw text
+var textBoundaries = map[string]*regexp.Regexp{
+	"sqlite":   regexp.MustCompile(`(?i)^\s*CREATE\s+(TABLE|INDEX|VIEW|TRIGGER)`),
+	"markdown": regexp.MustCompile(`^#{1,6}\s+`),
+	"code":     regexp.MustCompile(`^(func |type |var \(|const \(|class |def |async def |(?i:CREATE\s+))`),
+	"config":   regexp.MustCompile(`^(\[[^\]]+\]|[A-Za-z0-9_-]+:\s*$)`),
+}
+
+// ChunkSource splits source data into chunks of at most maxLen characters.
+// JSON analyses are split on their structural arrays (tables, sections,
+// top-level declarations); raw text is split on type-specific boundaries.
+func ChunkSource(sourceType, sourceData string, maxLen int) []Chunk {
+	if maxLen <= 0 {
+		maxLen = maxDigestPromptLen
+	}
+
+	if len(sourceData) <= maxLen {
+		return finalizeChunks([]Chunk{{Section: "full", Content: sourceData}})
+	}
+
+	var analysis map[string]interface{}
+	if err := json.Unmarshal([]byte(sourceData), &analysis); err == nil {
+		return finalizeChunks(chunkAnalysis(sourceType, analysis, maxLen))
+	}
+
+	return finalizeChunks(chunkText(sourceType, "text", sourceData, maxLen))
+}
+
+// chunkAnalysis splits a JSON analysis into a header chunk and element groups
+func chunkAnalysis(sourceType string, analysis map[string]interface{}, maxLen int) []Chunk {
+	keys := structuralKeys[sourceType]
+	isStructural := make(map[string]bool)
+	for _, key := range keys {
+		isStructural[key] = true
+	}
+
+	// Any other array large enough to matter is also treated as structural
+	var extraKeys []string
+	for key, value := range analysis {
+		if isStructural[key] {
+			continue
+		}
+		if arr, ok := value.([]interface{}); ok && len(marshalCompact(arr)) > maxLen/4 {
+			extraKeys = append(extraKeys, key)
+			isStructural[key] = true
+		}
+	}
+	sort.Strings(extraKeys)
+	keys = append(append([]string{}, keys...), extraKeys...)
+
+	// Header: every non-structural field, shared context for the reduce phase
+	header := make(map[string]interface{})
+	for key, value := range analysis {
+		if !isStructural[key] {
+			header[key] = value
+		}
+	}
+
+	var chunks []Chunk
+	if len(header) > 0 {
+		headerJSON := marshalCompact(header)
+		if len(headerJSON) <= maxLen {
+			chunks = append(chunks, Chunk{Section: "header", Content: headerJSON})
+		} else {
+			chunks = append(chunks, chunkText(sourceType, "header", headerJSON, maxLen)...)
+		}
+	}
+
+	for _, key := range keys {
+		arr, ok := analysis[key].([]interface{})
+		if !ok || len(arr) == 0 {
+			continue
+		}
+		chunks = append(chunks, chunkArray(sourceType, key, arr, maxLen)...)
+	}
+
+	return chunks
+}
+
+// chunkArray packs array elements into chunks below maxLen. A group ends
+// after an element chosen by its own content (see contentBoundary), or
+// earlier when the next element would not fit, so inserting or removing an
+// element only moves the boundaries of its own group.
+func chunkArray(sourceType, key string, arr []interface{}, maxLen int) []Chunk {
+	var chunks []Chunk
+	var group []interface{}
+	start := 0
+	size := 0
+
+	flush := func(end int) {
+		if len(group) == 0 {
+			return
+		}
+		content := marshalCompact(map[string]interface{}{key: group})
+		chunks = append(chunks, Chunk{
+			Section: fmt.Sprintf("%s[%d:%d]", key, start, end),
+			Content: content,
+		})
+		group = nil
+		size = 0
+		start = end
+	}
+
+	// Reserve room for the wrapping object
+	budget := maxLen - len(key) - 8
+
+	for i, elem := range arr {
+		elemJSON := marshalCompact(elem)
+
+		if len(elemJSON) > budget {
+			// A single element too large on its own is split as text
+			flush(i)
+			section := fmt.Sprintf("%s[%d]", key, i)
+			chunks = append(chunks, chunkText(sourceType, section, elemJSON, maxLen)...)
+			start = i + 1
+			continue
+		}
+
+		if size+len(elemJSON)+1 > budget {
+			flush(i)
+		}
+
+		group = append(group, elem)
+		size += len(elemJSON) + 1
+
+		if contentBoundary(marshalCompact(stripPositional(elem)), budget) {
+			flush(i + 1)
+		}
+	}
+	flush(len(arr))
+
+	return chunks
+}
+
+// chunkText splits raw text on structural boundaries, falling back to line boundaries
+func chunkText(sourceType, section, text string, maxLen int) []Chunk {
+	boundary := textBoundaries[sourceType]
+	lines := strings.Split(text, "\n")
+
+	// Group lines into structural segments
+	var segments []string
+	var current []string
+	for _, line := range lines {
+		if boundary != nil && boundary.MatchString(line) && len(current) > 0 {
+			segments = append(segments, strings.Join(current, "\n"))
+			current = nil
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		segments = append(segments, strings.Join(current, "\n"))
+	}
+
+	// Pack segments up to content-defined boundaries, hard-splitting any
+	// segment that exceeds maxLen
+	var chunks []Chunk
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		chunks = append(chunks, Chunk{
+			Section: fmt.Sprintf("%s#%d", section, len(chunks)),
+			Content: buf.String(),
+		})
+		buf.Reset()
+	}
+
+	for _, segment := range segments {
+		for len(segment) > maxLen {
+			flush()
+			cut := strings.LastIndex(segment[:maxLen], "\n")
+			if cut <= 0 {
+				cut = maxLen
+			}
+			buf.WriteString(segment[:cut])
+			flush()
+			segment = strings.TrimPrefix(segment[cut:], "\n")
+		}
+
+		if buf.Len() > 0 && buf.Len()+len(segment)+1 > maxLen {
+			flush()
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(segment)
+
+		if contentBoundary(segment, maxLen) {
+			flush()
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+// contentBoundary reports whether a chunk ends after an element, from the
+// element's content alone: its hash falls below a threshold proportional to
+// its size, so chunks average half of budget wherever they start
+func contentBoundary(content string, budget int) bool {
+	hash := sha256.Sum256([]byte(content))
+	probability := math.Min(1, 2*float64(len(content))/float64(budget))
+	return float64(binary.BigEndian.Uint64(hash[:8])) < probability*math.MaxUint64
+}
+
+// finalizeChunks assigns indexes and content hashes
+func finalizeChunks(chunks []Chunk) []Chunk {
+	for i := range chunks {
+		chunks[i].Index = i
+		chunks[i].Hash = hashChunkContent(chunks[i].Content)
+	}
+	return chunks
+}
+
+// hashChunkContent hashes chunk content. Positional fields are part of it: a
+// cached digest quotes the line numbers of the chunk it was made from.
+func hashChunkContent(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+// stripPositional removes positional keys from a decoded JSON value
+func stripPositional(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			if positionalKeys[key] {
+				continue
+			}
+			out[key] = stripPositional(inner)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, inner := range v {
+			out[i] = stripPositional(inner)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// marshalCompact marshals a value to compact JSON, ignoring errors
+func marshalCompact(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
@@ -0,0 +1,140 @@
+package cerebras
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChunkSourceSmallInput(t *testing.T) {
+	chunks := ChunkSource("code", `{"language":"go"}`, 1000)
+
+	if len(chunks) != 1 {
+		t.Fatalf("Expected 1 chunk, got %d", len(chunks))
+	}
+
+	if chunks[0].Section != "full" {
+		t.Errorf("Expected section 'full', got %s", chunks[0].Section)
+	}
+}
+
+func TestChunkSourceSplitsTables(t *testing.T) {
+	var tables []map[string]interface{}
+	for i := 0; i < 50; i++ {
+		tables = append(tables, map[string]interface{}{
+			"name":      fmt.Sprintf("table_%d", i),
+			"columns":   []string{"id", "name", "created_at", "payload"},
+			"row_count": i * 10,
+		})
+	}
+
+	analysis, _ := json.Marshal(map[string]interface{}{
+		"pragmas":     map[string]string{"journal_mode": "wal"},
+		"table_count": len(tables),
+		"tables":      tables,
+	})
+
+	chunks := ChunkSource("sqlite", string(analysis), 1000)
+	if len(chunks) < 3 {
+		t.Fatalf("Expected at least 3 chunks, got %d", len(chunks))
+	}
+
+	if chunks[0].Section != "header" {
+		t.Errorf("Expected first chunk to be the header, got %s", chunks[0].Section)
+	}
+
+	seen := 0
+	for i, chunk := range chunks {
+		if len(chunk.Content) > 1000 {
+			t.Errorf("Chunk %d exceeds limit: %d chars", i, len(chunk.Content))
+		}
+		if chunk.Index != i {
+			t.Errorf("Expected index %d, got %d", i, chunk.Index)
+		}
+
+		var decoded map[string][]interface{}
+		if err := json.Unmarshal([]byte(chunk.Content), &decoded); err == nil {
+			seen += len(decoded["tables"])
+		}
+	}
+
+	if seen != len(tables) {
+		t.Errorf("Expected all %d tables across chunks, got %d", len(tables), seen)
+	}
+}
+
+func TestChunkSourceMarkdownText(t *testing.T) {
+	var doc strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&doc, "## Section %d\n\n%s\n\n", i, strings.Repeat("lorem ipsum ", 20))
+	}
+
+	chunks := ChunkSource("markdown", doc.String(), 800)
+	if len(chunks) < 2 {
+		t.Fatalf("Expected multiple chunks, got %d", len(chunks))
+	}
+
+	for i, chunk := range chunks {
+		if !strings.HasPrefix(chunk.Content, "## Section") {
+			t.Errorf("Chunk %d does not start on a section boundary: %q", i, chunk.Content[:20])
+		}
+	}
+}
+
+func TestChunkHashIncludesLineNumbers(t *testing.T) {
+	a := `{"sections":[{"title":"Intro","level":1,"line_number":3}]}`
+	b := `{"sections":[{"title":"Intro","level":1,"line_number":42}]}`
+
+	if hashChunkContent(a) == hashChunkContent(b) {
+		t.Error("Expected a moved chunk to miss the cache: its digest quotes line numbers")
+	}
+}
+
+func TestChunkBoundariesSurviveInsert(t *testing.T) {
+	sections := func(n, inserted int) string {
+		var list []map[string]interface{}
+		line := 1
+		for i := 0; i < n; i++ {
+			if i == inserted {
+				list = append(list, map[string]interface{}{"title": "Inserted", "text": strings.Repeat("new ", 30), "line_number": line})
+				line += 10
+			}
+			list = append(list, map[string]interface{}{"title": fmt.Sprintf("Section %d", i), "text": strings.Repeat("lorem ", 30), "line_number": line})
+			line += 10
+		}
+		data, _ := json.Marshal(map[string]interface{}{"sections": list})
+		return string(data)
+	}
+
+	// Boundaries ignore line numbers, even though hashes do not
+	boundaries := func(chunks []Chunk) map[string]bool {
+		set := make(map[string]bool)
+		for _, chunk := range chunks {
+			var decoded map[string][]map[string]interface{}
+			if err := json.Unmarshal([]byte(chunk.Content), &decoded); err != nil {
+				t.Fatalf("Chunk %s is not JSON: %v", chunk.Section, err)
+			}
+			list := decoded["sections"]
+			set[fmt.Sprintf("%v..%v", list[0]["title"], list[len(list)-1]["title"])] = true
+		}
+		return set
+	}
+
+	before := ChunkSource("markdown", sections(200, -1), 2000)
+	after := ChunkSource("markdown", sections(200, 100), 2000)
+	if len(before) < 10 {
+		t.Fatalf("Expected many chunks, got %d", len(before))
+	}
+
+	changed := 0
+	kept := boundaries(before)
+	for group := range boundaries(after) {
+		if !kept[group] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("Expected an insert to change at most 2 groups, %d of %d changed", changed, len(after))
+	}
+}
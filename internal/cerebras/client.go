@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -14,32 +15,109 @@ type Client struct {
 	apiKey  string
 	baseURL string
 	client  *http.Client
+	limiter *RateLimiter
+	router  *router
+	keys    *keyring
+	action  string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithBaseURL points the client at another OpenAI-compatible endpoint
+// (a local stand-in server, a proxy, ...)
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithHTTPClient replaces the underlying HTTP client
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.client = httpClient
+	}
+}
+
+// WithTimeout sets the HTTP timeout of a single API call
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.client.Timeout = timeout
+	}
 }
 
 // NewClient creates a new Cerebras API client
-func NewClient(apiKey string) *Client {
-	return &Client{
+func NewClient(apiKey string, opts ...Option) *Client {
+	c := &Client{
 		apiKey:  apiKey,
 		baseURL: "https://api.cerebras.ai/v1",
 		client: &http.Client{
 			Timeout: 120 * time.Second,
 		},
+		limiter: NewRateLimiter(60),
+		keys:    newKeyring(),
+		action:  defaultRoute,
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	c.router = newRouter(Endpoint{
+		Name:        "cerebras",
+		BaseURL:     c.baseURL,
+		Model:       defaultModel,
+		APIKey:      apiKey,
+		KeyProvider: defaultKeyProvider,
+	})
+
+	return c
 }
 
 // ChatRequest represents a chat completion request
 type ChatRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature"`
-	MaxTokens   int       `json:"max_tokens"`
-	Stream      bool      `json:"stream"`
+	Model       string      `json:"model"`
+	Messages    []Message   `json:"messages"`
+	Temperature float64     `json:"temperature"`
+	MaxTokens   int         `json:"max_tokens"`
+	Stream      bool        `json:"stream"`
+	Tools       []Tool      `json:"tools,omitempty"`
+	ToolChoice  interface{} `json:"tool_choice,omitempty"` // "auto" | "none" | {"type":"function",...}
 }
 
 // Message represents a chat message
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
+}
+
+// Tool represents a function the model may call
+type Tool struct {
+	Type     string       `json:"type"` // always "function"
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction describes a callable function and its JSON schema parameters
+type ToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ToolCall represents a function call requested by the model
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction carries the called function name and its JSON-encoded arguments
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 // ChatResponse represents a chat completion response
@@ -50,6 +128,9 @@ type ChatResponse struct {
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
 	Usage   Usage    `json:"usage"`
+
+	// Provider is the endpoint that served the request (not part of the API payload)
+	Provider string `json:"-"`
 }
 
 // Choice represents a response choice
@@ -72,7 +153,7 @@ func (c *Client) Generate(systemPrompt, userPrompt string, temperature float64)
 
 	// Build request
 	reqBody := ChatRequest{
-		Model: "zai-glm-4.6",
+		Model: defaultModel,
 		Messages: []Message{
 			{Role: "system", Content: systemPrompt},
 			{Role: "user", Content: userPrompt},
@@ -82,19 +163,88 @@ func (c *Client) Generate(systemPrompt, userPrompt string, temperature float64)
 		Stream:      false,
 	}
 
+	chatResp, err := c.Chat(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	content := chatResp.Choices[0].Message.Content
+	latencyMs := time.Since(startTime).Milliseconds()
+
+	return &GenerationResult{
+		Content:          content,
+		Model:            chatResp.Model,
+		Provider:         chatResp.Provider,
+		Temperature:      temperature,
+		PromptTokens:     chatResp.Usage.PromptTokens,
+		CompletionTokens: chatResp.Usage.CompletionTokens,
+		LatencyMs:        int(latencyMs),
+	}, nil
+}
+
+// Chat sends a raw chat completion request and returns the parsed response.
+// The request goes through the client's provider chain: endpoints whose
+// circuit is open are skipped, and failures fail over to the next endpoint.
+func (c *Client) Chat(reqBody ChatRequest) (*ChatResponse, error) {
+	endpoints := c.router.endpointsFor(c.action)
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no provider configured for action %s", c.action)
+	}
+
+	var failures []string
+	var lastErr error
+	for _, ep := range endpoints {
+		if !ep.breaker.Allow() {
+			failures = append(failures, fmt.Sprintf("%s: circuit open", ep.Name))
+			continue
+		}
+
+		chatResp, err := c.chatEndpoint(ep.Endpoint, reqBody)
+		if err == nil {
+			ep.breaker.RecordSuccess()
+			c.router.notify(ep, true)
+			chatResp.Provider = ep.Name
+			return chatResp, nil
+		}
+
+		// Client errors are not the provider's fault: no failover
+		if !isFailoverError(err) {
+			ep.breaker.ReleaseProbe()
+			return nil, err
+		}
+
+		ep.breaker.RecordFailure(err)
+		c.router.notify(ep, false)
+		failures = append(failures, fmt.Sprintf("%s: %v", ep.Name, err))
+		lastErr = err
+	}
+
+	if len(endpoints) == 1 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	return nil, fmt.Errorf("all providers failed for action %s: %s", c.action, strings.Join(failures, "; "))
+}
+
+// send sends a chat completion request to a single endpoint with the given key
+func (c *Client) send(ep Endpoint, apiKey string, reqBody ChatRequest) (*ChatResponse, error) {
+	if ep.Model != "" {
+		reqBody.Model = ep.Model
+	}
+
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", c.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", ep.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
 
 	// Send request
 	resp, err := c.client.Do(req)
@@ -111,7 +261,7 @@ func (c *Client) Generate(systemPrompt, userPrompt string, temperature float64)
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	// Parse response
@@ -125,25 +275,17 @@ func (c *Client) Generate(systemPrompt, userPrompt string, temperature float64)
 		return nil, fmt.Errorf("no choices in response")
 	}
 
-	content := chatResp.Choices[0].Message.Content
-	latencyMs := time.Since(startTime).Milliseconds()
-
-	return &GenerationResult{
-		Content:          content,
-		Model:            chatResp.Model,
-		Temperature:      temperature,
-		PromptTokens:     chatResp.Usage.PromptTokens,
-		CompletionTokens: chatResp.Usage.CompletionTokens,
-		LatencyMs:        int(latencyMs),
-	}, nil
+	return &chatResp, nil
 }
 
 // GenerationResult contains the result of a generation request
 type GenerationResult struct {
 	Content          string
 	Model            string
+	Provider         string
 	Temperature      float64
 	PromptTokens     int
 	CompletionTokens int
 	LatencyMs        int
+	ToolCalls        []ToolCallRecord
 }
@@ -3,7 +3,11 @@ package cerebras
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"strings"
+	"time"
+
+	"brainloop/internal/validation"
 )
 
 // GenerateCode generates code using Cerebras with pattern injection
@@ -18,7 +22,7 @@ func (c *Client) GenerateCode(prompt string, codeType string, patterns interface
 	}
 
 	// Clean code (remove markdown fences)
-	code := cleanCode(result.Content, codeType)
+	code := CleanCode(result.Content, codeType)
 
 	return code, nil
 }
@@ -32,6 +36,37 @@ func (c *Client) GenerateCodeWithTemperature(prompt string, codeType string, pat
 	return c.Generate(systemPrompt, prompt, temperature)
 }
 
+// GenerateConversation generates code from a multi-turn history of user and
+// assistant messages; the system prompt for codeType is prepended
+func (c *Client) GenerateConversation(codeType string, patterns interface{}, history []Message, temperature float64) (*GenerationResult, error) {
+	startTime := time.Now()
+
+	messages := make([]Message, 0, len(history)+1)
+	messages = append(messages, Message{Role: "system", Content: buildSystemPrompt(codeType, patterns)})
+	messages = append(messages, history...)
+
+	chatResp, err := c.Chat(ChatRequest{
+		Model:       defaultModel,
+		Messages:    messages,
+		Temperature: temperature,
+		MaxTokens:   8000,
+		Stream:      false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &GenerationResult{
+		Content:          chatResp.Choices[0].Message.Content,
+		Model:            chatResp.Model,
+		Provider:         chatResp.Provider,
+		Temperature:      temperature,
+		PromptTokens:     chatResp.Usage.PromptTokens,
+		CompletionTokens: chatResp.Usage.CompletionTokens,
+		LatencyMs:        int(time.Since(startTime).Milliseconds()),
+	}, nil
+}
+
 // buildSystemPrompt creates an enhanced system prompt with pattern injection
 func buildSystemPrompt(codeType string, patterns interface{}) string {
 	basePrompts := map[string]string{
@@ -62,6 +97,27 @@ IMPORTANT RULES:
 - Use standard library when possible`,
 
 		"code": `You are an expert programmer. Generate clean, well-structured code following best practices for the target language.`,
+
+		"edit": `You are an expert programmer editing an existing file. Make only the requested change and keep everything else as it is.
+
+IMPORTANT RULES:
+- Return only the changes, never the whole file
+- Use a unified diff against the content shown (---/+++ headers, @@ hunk headers, 3 lines of context), or SEARCH/REPLACE blocks:
+<<<<<<< SEARCH
+exact lines from the file
+=======
+replacement lines
+>>>>>>> REPLACE
+- Copy context and SEARCH lines exactly, including indentation
+- Include enough context for each change to be found unambiguously`,
+
+		"files": `You are an expert programmer. Generate every file needed to fulfil the request, following best practices for each language.
+
+IMPORTANT RULES:
+- Put each file in its own fenced code block
+- Annotate each fence with the language and the target path relative to the project root: ` + "```go path=internal/store/repository.go" + `
+- Never put two files in the same block and never repeat a path
+- Use modernc.org/sqlite (NOT github.com/mattn/go-sqlite3) for Go database code`,
 	}
 
 	systemPrompt := basePrompts[codeType]
@@ -80,8 +136,8 @@ IMPORTANT RULES:
 	return systemPrompt
 }
 
-// cleanCode removes markdown code fences and trims whitespace
-func cleanCode(content, codeType string) string {
+// CleanCode removes markdown code fences and trims whitespace
+func CleanCode(content, codeType string) string {
 	// Remove markdown code fences
 	lines := strings.Split(content, "\n")
 	var cleaned []string
@@ -125,6 +181,7 @@ func ExtractCodeBlocks(content string) []CodeBlock {
 
 	var currentBlock *CodeBlock
 	var currentLines []string
+	previous := ""
 
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
@@ -132,11 +189,20 @@ func ExtractCodeBlocks(content string) []CodeBlock {
 		if strings.HasPrefix(trimmed, "```") {
 			if currentBlock == nil {
 				// Start new block
-				lang := strings.TrimPrefix(trimmed, "```")
-				currentBlock = &CodeBlock{Language: lang}
+				lang, path := parseFenceInfo(strings.TrimPrefix(trimmed, "```"))
+				if path == "" {
+					path = pathFromHeading(previous)
+				}
+				currentBlock = &CodeBlock{Language: lang, Path: path}
 				currentLines = []string{}
 			} else {
 				// End current block
+				if currentBlock.Path == "" && len(currentLines) > 0 {
+					if path := pathFromComment(currentLines[0]); path != "" {
+						currentBlock.Path = path
+						currentLines = currentLines[1:]
+					}
+				}
 				currentBlock.Content = strings.Join(currentLines, "\n")
 				blocks = append(blocks, *currentBlock)
 				currentBlock = nil
@@ -146,34 +212,138 @@ func ExtractCodeBlocks(content string) []CodeBlock {
 			// Inside code block
 			currentLines = append(currentLines, line)
 		}
+
+		if trimmed != "" {
+			previous = trimmed
+		}
 	}
 
 	return blocks
 }
 
-// CodeBlock represents a code block with language
+// CodeBlock represents a code block with language and optional target path
 type CodeBlock struct {
 	Language string
+	Path     string
 	Content  string
 }
 
-// ValidateCode performs basic validation on generated code
-func ValidateCode(code string, codeType string) error {
-	if strings.TrimSpace(code) == "" {
-		return fmt.Errorf("generated code is empty")
+// parseFenceInfo extracts language and path from a fence info string.
+// Supported forms: "go path=a.go", "go title=a.go", "go:a.go", "a.go".
+func parseFenceInfo(info string) (string, string) {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return "", ""
+	}
+
+	lang := fields[0]
+	path := ""
+
+	if idx := strings.Index(lang, ":"); idx > 0 {
+		lang, path = lang[:idx], lang[idx+1:]
+	}
+
+	for _, field := range fields[1:] {
+		for _, prefix := range []string{"path=", "file=", "title=", "filename="} {
+			if strings.HasPrefix(field, prefix) {
+				path = strings.Trim(strings.TrimPrefix(field, prefix), `"'`)
+			}
+		}
+	}
+
+	// A bare file name as info string: infer language from the extension
+	if path == "" && strings.Contains(lang, ".") {
+		path = lang
+		lang = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+
+	return lang, path
+}
+
+// pathFromHeading extracts a path from a line preceding a fence, such as
+// "File: a.go", "**a.go**" or "### a.go"
+func pathFromHeading(line string) string {
+	candidate := strings.TrimLeft(line, "#*` ")
+	candidate = strings.TrimRight(candidate, "*`: ")
+	for _, prefix := range []string{"File:", "file:", "Path:", "path:"} {
+		candidate = strings.TrimSpace(strings.TrimPrefix(candidate, prefix))
+	}
+	candidate = strings.Trim(candidate, "*` ")
+
+	if looksLikePath(candidate) {
+		return candidate
+	}
+	return ""
+}
+
+// pathFromComment extracts a path from a first-line comment such as "// File: a.go"
+func pathFromComment(line string) string {
+	trimmed := strings.TrimSpace(line)
+	for _, prefix := range []string{"//", "#", "--"} {
+		if strings.HasPrefix(trimmed, prefix) {
+			rest := strings.TrimSpace(strings.TrimPrefix(trimmed, prefix))
+			for _, label := range []string{"File:", "file:", "Path:", "path:"} {
+				if strings.HasPrefix(rest, label) {
+					candidate := strings.TrimSpace(strings.TrimPrefix(rest, label))
+					if looksLikePath(candidate) {
+						return candidate
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// looksLikePath reports whether s looks like a relative file path
+func looksLikePath(s string) bool {
+	if s == "" || strings.ContainsAny(s, " \t") {
+		return false
+	}
+	return filepath.Ext(s) != ""
+}
+
+// GenerateFiles generates several files from a single prompt. Every returned
+// block carries the target path the model annotated it with.
+func (c *Client) GenerateFiles(prompt string, patterns interface{}, temperature float64) ([]CodeBlock, *GenerationResult, error) {
+	systemPrompt := buildSystemPrompt("files", patterns)
+
+	result, err := c.Generate(systemPrompt, prompt, temperature)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Type-specific validation
-	switch codeType {
-	case "go":
-		if !strings.Contains(code, "package") {
-			return fmt.Errorf("Go code missing package declaration")
+	files, err := ExtractFileBlocks(result.Content)
+	if err != nil {
+		return nil, result, err
+	}
+
+	return files, result, nil
+}
+
+// ExtractFileBlocks extracts path-annotated code blocks, failing if any block has no path
+func ExtractFileBlocks(content string) ([]CodeBlock, error) {
+	blocks := ExtractCodeBlocks(content)
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no code blocks in response")
+	}
+
+	seen := make(map[string]bool)
+	for i, block := range blocks {
+		if block.Path == "" {
+			return nil, fmt.Errorf("code block %d (%s) has no target path", i+1, block.Language)
 		}
-	case "sql":
-		if !strings.Contains(strings.ToUpper(code), "CREATE TABLE") {
-			return fmt.Errorf("SQL code missing CREATE TABLE statement")
+		if seen[block.Path] {
+			return nil, fmt.Errorf("duplicate target path: %s", block.Path)
 		}
+		seen[block.Path] = true
 	}
 
-	return nil
+	return blocks, nil
+}
+
+// ValidateCode validates generated code locally (Go is parsed and
+// type-checked, SQL is executed in a scratch in-memory database)
+func ValidateCode(code string, codeType string) error {
+	return validation.Validate(code, codeType).Err()
 }
@@ -0,0 +1,51 @@
+package cerebras
+
+import "testing"
+
+func TestExtractFileBlocks(t *testing.T) {
+	content := "Here are the files.\n\n" +
+		"```go path=internal/store/repository.go\npackage store\n```\n\n" +
+		"**internal/store/repository_test.go**\n```go\npackage store\n```\n\n" +
+		"```sql\n-- File: migrations/003_users.sql\nCREATE TABLE users (id INTEGER);\n```\n"
+
+	files, err := ExtractFileBlocks(content)
+	if err != nil {
+		t.Fatalf("ExtractFileBlocks failed: %v", err)
+	}
+
+	expected := []string{
+		"internal/store/repository.go",
+		"internal/store/repository_test.go",
+		"migrations/003_users.sql",
+	}
+
+	if len(files) != len(expected) {
+		t.Fatalf("Expected %d files, got %d", len(expected), len(files))
+	}
+
+	for i, path := range expected {
+		if files[i].Path != path {
+			t.Errorf("File %d: expected path %s, got %s", i, path, files[i].Path)
+		}
+	}
+
+	if files[2].Content != "CREATE TABLE users (id INTEGER);" {
+		t.Errorf("Expected path comment to be stripped, got %q", files[2].Content)
+	}
+}
+
+func TestExtractFileBlocksMissingPath(t *testing.T) {
+	content := "```go\npackage main\n```\n"
+
+	if _, err := ExtractFileBlocks(content); err == nil {
+		t.Error("Expected error for block without path")
+	}
+}
+
+func TestExtractFileBlocksDuplicatePath(t *testing.T) {
+	content := "```go path=a.go\npackage a\n```\n```go path=a.go\npackage b\n```\n"
+
+	if _, err := ExtractFileBlocks(content); err == nil {
+		t.Error("Expected error for duplicate path")
+	}
+}
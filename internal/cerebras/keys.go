@@ -0,0 +1,311 @@
+package cerebras
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultKeyProvider is the key pool used by endpoints without a dedicated secret
+const defaultKeyProvider = "cerebras"
+
+// defaultKeyRPM is the per-key request budget (Cerebras free tier)
+const defaultKeyRPM = 60
+
+// ErrNoAPIKey is returned when every key of a pool is disabled
+var ErrNoAPIKey = errors.New("no enabled API key")
+
+// APIKeyConfig is a named key as stored in the secrets table
+type APIKeyConfig struct {
+	Name           string
+	Value          string
+	RotatedAt      int64
+	Disabled       bool
+	DisabledReason string
+}
+
+// APIKeyStatus describes a pooled key without exposing its value
+type APIKeyStatus struct {
+	Provider       string `json:"provider"`
+	Name           string `json:"name"`
+	Disabled       bool   `json:"disabled"`
+	DisabledReason string `json:"disabled_reason,omitempty"`
+	RotatedAt      int64  `json:"rotated_at,omitempty"`
+	Requests       int64  `json:"requests"`
+	Failures       int64  `json:"failures"`
+}
+
+// KeyObserver is notified when a key is disabled after an authentication failure
+type KeyObserver func(provider, name, reason string)
+
+// pooledKey is a key with its own rate limiter
+type pooledKey struct {
+	APIKeyConfig
+	limiter  *RateLimiter
+	requests int64
+	failures int64
+}
+
+// keyPool spreads requests round-robin over the enabled keys of a provider
+type keyPool struct {
+	provider string
+	mu       sync.Mutex
+	keys     []*pooledKey
+	next     int
+}
+
+// keyring holds the key pools of every provider
+type keyring struct {
+	mu       sync.RWMutex
+	pools    map[string]*keyPool
+	observer KeyObserver
+}
+
+func newKeyring() *keyring {
+	return &keyring{pools: make(map[string]*keyPool)}
+}
+
+// pool returns the pool of a provider, or nil if no keys were configured
+func (k *keyring) pool(provider string) *keyPool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.pools[provider]
+}
+
+// SetAPIKeys replaces the keys of a provider. Keys whose name and rotation
+// time are unchanged keep their limiter and counters, so the call can be
+// repeated on every reload. It reports whether anything changed.
+func (c *Client) SetAPIKeys(provider string, keys []APIKeyConfig) bool {
+	c.keys.mu.Lock()
+	pool, ok := c.keys.pools[provider]
+	if !ok {
+		pool = &keyPool{provider: provider}
+		c.keys.pools[provider] = pool
+	}
+	c.keys.mu.Unlock()
+
+	return pool.update(keys)
+}
+
+// SetKeyObserver registers a callback invoked when a key is disabled
+func (c *Client) SetKeyObserver(observer KeyObserver) {
+	c.keys.mu.Lock()
+	defer c.keys.mu.Unlock()
+	c.keys.observer = observer
+}
+
+// APIKeyStatuses returns the state of every pooled key, sorted by provider and name
+func (c *Client) APIKeyStatuses() []APIKeyStatus {
+	c.keys.mu.RLock()
+	pools := make([]*keyPool, 0, len(c.keys.pools))
+	for _, pool := range c.keys.pools {
+		pools = append(pools, pool)
+	}
+	c.keys.mu.RUnlock()
+
+	var statuses []APIKeyStatus
+	for _, pool := range pools {
+		statuses = append(statuses, pool.statuses()...)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Provider != statuses[j].Provider {
+			return statuses[i].Provider < statuses[j].Provider
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// update merges a new key list into the pool
+func (p *keyPool) update(configs []APIKeyConfig) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing := make(map[string]*pooledKey, len(p.keys))
+	for _, key := range p.keys {
+		existing[key.Name] = key
+	}
+
+	changed := len(configs) != len(p.keys)
+	keys := make([]*pooledKey, 0, len(configs))
+	for _, cfg := range configs {
+		key, ok := existing[cfg.Name]
+		if !ok {
+			keys = append(keys, &pooledKey{APIKeyConfig: cfg, limiter: NewRateLimiter(defaultKeyRPM)})
+			changed = true
+			continue
+		}
+
+		if key.APIKeyConfig != cfg {
+			if key.RotatedAt != cfg.RotatedAt {
+				// A rotated key starts with a clean backoff state
+				key.limiter.ResetBackoff()
+			}
+			key.APIKeyConfig = cfg
+			changed = true
+		}
+		keys = append(keys, key)
+	}
+
+	p.keys = keys
+	if p.next >= len(keys) {
+		p.next = 0
+	}
+
+	return changed
+}
+
+// acquire picks the next enabled key with available budget, round-robin.
+// When every key is at its limit, it waits on the first key in rotation.
+func (p *keyPool) acquire() (*pooledKey, error) {
+	p.mu.Lock()
+	var candidates []*pooledKey
+	for i := 0; i < len(p.keys); i++ {
+		key := p.keys[(p.next+i)%len(p.keys)]
+		if !key.Disabled {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(p.keys) > 0 {
+		p.next = (p.next + 1) % len(p.keys)
+	}
+	p.mu.Unlock()
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%s: %w", p.provider, ErrNoAPIKey)
+	}
+
+	for _, key := range candidates {
+		if key.limiter.TryAcquire() {
+			return key, nil
+		}
+	}
+
+	if err := candidates[0].limiter.Wait(context.Background()); err != nil {
+		return nil, fmt.Errorf("%s key %s: %w", p.provider, candidates[0].Name, err)
+	}
+	return candidates[0], nil
+}
+
+// size returns the number of enabled keys
+func (p *keyPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, key := range p.keys {
+		if !key.Disabled {
+			n++
+		}
+	}
+	return n
+}
+
+// record updates a key's counters and limiter backoff after a call
+func (p *keyPool) record(key *pooledKey, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key.requests++
+	if err == nil {
+		key.limiter.RecordSuccess()
+		return
+	}
+
+	key.failures++
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == 429 {
+		// Rate limited: back this key off so rotation skips it
+		key.limiter.RecordError()
+	}
+}
+
+// disable marks a key unusable until it is rotated
+func (p *keyPool) disable(key *pooledKey, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key.Disabled = true
+	key.DisabledReason = reason
+}
+
+// statuses returns the state of the pool's keys
+func (p *keyPool) statuses() []APIKeyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]APIKeyStatus, 0, len(p.keys))
+	for _, key := range p.keys {
+		statuses = append(statuses, APIKeyStatus{
+			Provider:       p.provider,
+			Name:           key.Name,
+			Disabled:       key.Disabled,
+			DisabledReason: key.DisabledReason,
+			RotatedAt:      key.RotatedAt,
+			Requests:       key.requests,
+			Failures:       key.failures,
+		})
+	}
+	return statuses
+}
+
+// chatEndpoint sends a request to an endpoint, drawing the API key from the
+// endpoint's key pool when one is configured. A 401 disables the key and a
+// 429 backs it off; either way the request is retried with the next key.
+func (c *Client) chatEndpoint(ep Endpoint, reqBody ChatRequest) (*ChatResponse, error) {
+	pool := c.keys.pool(ep.KeyProvider)
+	if pool == nil {
+		return c.send(ep, ep.APIKey, reqBody)
+	}
+
+	attempts := pool.size()
+	if attempts == 0 {
+		return nil, fmt.Errorf("%s: %w", ep.KeyProvider, ErrNoAPIKey)
+	}
+
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		key, err := pool.acquire()
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+
+		resp, err := c.send(ep, key.Value, reqBody)
+		pool.record(key, err)
+		if err == nil {
+			return resp, nil
+		}
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || (apiErr.StatusCode != 401 && apiErr.StatusCode != 429) {
+			return nil, err
+		}
+
+		if apiErr.StatusCode == 401 {
+			reason := fmt.Sprintf("401 from %s at %s", ep.Name, time.Now().UTC().Format(time.RFC3339))
+			pool.disable(key, reason)
+			c.keys.notifyDisabled(pool.provider, key.Name, reason)
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// notifyDisabled reports a disabled key to the observer
+func (k *keyring) notifyDisabled(provider, name, reason string) {
+	k.mu.RLock()
+	observer := k.observer
+	k.mu.RUnlock()
+
+	if observer != nil {
+		observer(provider, name, reason)
+	}
+}
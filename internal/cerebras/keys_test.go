@@ -0,0 +1,117 @@
+package cerebras
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// keyServer answers with 401 for revoked keys and records the keys it saw
+func keyServer(t *testing.T, revoked string) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var seen []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Authorization")[len("Bearer "):]
+		mu.Lock()
+		seen = append(seen, key)
+		mu.Unlock()
+
+		if key == revoked {
+			http.Error(w, "invalid api key", http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(ChatResponse{
+			Model:   "test-model",
+			Choices: []Choice{{Message: Message{Role: "assistant", Content: "ok"}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+}
+
+func TestKeyPoolRoundRobin(t *testing.T) {
+	server, seen := keyServer(t, "")
+
+	client := NewClient("legacy", WithBaseURL(server.URL))
+	client.SetAPIKeys("cerebras", []APIKeyConfig{
+		{Name: "KEY_A", Value: "a"},
+		{Name: "KEY_B", Value: "b"},
+	})
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.Generate("system", "user", 0.1); err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+	}
+
+	counts := map[string]int{}
+	for _, key := range seen() {
+		counts[key]++
+	}
+	if counts["a"] != 2 || counts["b"] != 2 || counts["legacy"] != 0 {
+		t.Errorf("Expected traffic spread over pooled keys, got %v", counts)
+	}
+}
+
+func TestKeyPoolDisablesKeyOn401(t *testing.T) {
+	server, seen := keyServer(t, "revoked")
+
+	client := NewClient("legacy", WithBaseURL(server.URL))
+	client.SetAPIKeys("cerebras", []APIKeyConfig{
+		{Name: "KEY_A", Value: "revoked", RotatedAt: 1},
+		{Name: "KEY_B", Value: "b", RotatedAt: 1},
+	})
+
+	var disabled []string
+	client.SetKeyObserver(func(provider, name, reason string) {
+		disabled = append(disabled, provider+"/"+name)
+	})
+
+	result, err := client.Generate("system", "user", 0.1)
+	if err != nil || result.Content != "ok" {
+		t.Fatalf("Expected retry with the next key, got %v", err)
+	}
+	if len(disabled) != 1 || disabled[0] != "cerebras/KEY_A" {
+		t.Errorf("Expected KEY_A to be disabled, got %v", disabled)
+	}
+
+	// The disabled key is no longer used
+	client.Generate("system", "user", 0.1)
+	if keys := seen(); keys[len(keys)-1] != "b" || len(keys) != 3 {
+		t.Errorf("Expected only KEY_B after disabling, got %v", keys)
+	}
+
+	// Rotating the key re-enables it
+	changed := client.SetAPIKeys("cerebras", []APIKeyConfig{
+		{Name: "KEY_A", Value: "a2", RotatedAt: 2},
+		{Name: "KEY_B", Value: "b", RotatedAt: 1},
+	})
+	if !changed {
+		t.Error("Expected rotation to be reported as a change")
+	}
+	for _, status := range client.APIKeyStatuses() {
+		if status.Disabled {
+			t.Errorf("Expected rotated key to be enabled, got %+v", status)
+		}
+	}
+}
+
+func TestKeyPoolAllKeysDisabled(t *testing.T) {
+	server, _ := keyServer(t, "")
+
+	client := NewClient("legacy", WithBaseURL(server.URL))
+	client.SetAPIKeys("cerebras", []APIKeyConfig{{Name: "KEY_A", Value: "a", Disabled: true}})
+
+	if _, err := client.Generate("system", "user", 0.1); err == nil {
+		t.Error("Expected an error when every key is disabled")
+	}
+}
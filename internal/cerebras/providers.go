@@ -0,0 +1,252 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultModel is the model used when an endpoint does not specify one
+const defaultModel = "zai-glm-4.6"
+
+// defaultRoute is the route used by actions without a dedicated provider list
+const defaultRoute = "default"
+
+// Endpoint is a provider/model pair an action can be served by
+type Endpoint struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"base_url"`
+	Model   string `json:"model"`
+	APIKey  string `json:"-"`
+
+	// APIKeySecret names the secret holding the key (resolved at configuration)
+	APIKeySecret string `json:"api_key_secret,omitempty"`
+
+	// KeyProvider names the key pool rotated for this endpoint; endpoints
+	// without an APIKeySecret default to the "cerebras" pool
+	KeyProvider string `json:"key_provider,omitempty"`
+}
+
+// ProviderConfig is the provider chain configuration stored in lifecycle config
+type ProviderConfig struct {
+	Endpoints []Endpoint          `json:"endpoints"`
+	Routes    map[string][]string `json:"routes"` // action -> ordered endpoint names
+	Breaker   struct {
+		FailureThreshold   int `json:"failure_threshold"`
+		OpenTimeoutSeconds int `json:"open_timeout_seconds"`
+		HalfOpenSuccesses  int `json:"half_open_successes"`
+	} `json:"breaker"`
+}
+
+// ProviderStatus describes an endpoint and the state of its circuit breaker
+type ProviderStatus struct {
+	Name    string          `json:"name"`
+	BaseURL string          `json:"base_url"`
+	Model   string          `json:"model"`
+	Breaker BreakerSnapshot `json:"breaker"`
+}
+
+// BreakerObserver is notified after every call attempt on an endpoint
+type BreakerObserver func(endpoint string, success bool, snapshot BreakerSnapshot)
+
+// APIError is returned when the provider answers with a non-200 status
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// router resolves an action to an ordered list of endpoints with breakers
+type router struct {
+	mu        sync.RWMutex
+	endpoints map[string]*routedEndpoint
+	routes    map[string][]string
+	observer  BreakerObserver
+}
+
+type routedEndpoint struct {
+	Endpoint
+	breaker *CircuitBreaker
+}
+
+// newRouter creates a router with a single default endpoint
+func newRouter(endpoint Endpoint) *router {
+	return &router{
+		endpoints: map[string]*routedEndpoint{
+			endpoint.Name: {Endpoint: endpoint, breaker: NewCircuitBreaker(DefaultBreakerConfig())},
+		},
+		routes: map[string][]string{defaultRoute: {endpoint.Name}},
+	}
+}
+
+// endpointsFor returns the endpoints serving an action, in fallback order
+func (r *router) endpointsFor(action string) []*routedEndpoint {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names, ok := r.routes[action]
+	if !ok || len(names) == 0 {
+		names = r.routes[defaultRoute]
+	}
+
+	endpoints := make([]*routedEndpoint, 0, len(names))
+	for _, name := range names {
+		if ep, ok := r.endpoints[name]; ok {
+			endpoints = append(endpoints, ep)
+		}
+	}
+
+	return endpoints
+}
+
+// notify reports a call outcome to the observer
+func (r *router) notify(ep *routedEndpoint, success bool) {
+	r.mu.RLock()
+	observer := r.observer
+	r.mu.RUnlock()
+
+	if observer != nil {
+		observer(ep.Name, success, ep.breaker.Snapshot())
+	}
+}
+
+// ParseProviderConfig parses a provider chain configuration
+func ParseProviderConfig(data string) (*ProviderConfig, error) {
+	var cfg ProviderConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		return nil, fmt.Errorf("invalid provider config: %w", err)
+	}
+
+	if len(cfg.Endpoints) == 0 {
+		return nil, fmt.Errorf("provider config has no endpoints")
+	}
+
+	return &cfg, nil
+}
+
+// ConfigureProviders replaces the provider chain. resolveKey resolves
+// APIKeySecret names; endpoints without one use the client's own key and
+// endpoints without a base URL or model use the client's defaults.
+func (c *Client) ConfigureProviders(cfg *ProviderConfig, resolveKey func(secretName string) (string, error)) error {
+	breakerCfg := BreakerConfig{
+		FailureThreshold:  cfg.Breaker.FailureThreshold,
+		OpenTimeout:       time.Duration(cfg.Breaker.OpenTimeoutSeconds) * time.Second,
+		HalfOpenSuccesses: cfg.Breaker.HalfOpenSuccesses,
+	}
+
+	endpoints := make(map[string]*routedEndpoint, len(cfg.Endpoints))
+	var order []string
+	for _, ep := range cfg.Endpoints {
+		if ep.Name == "" {
+			return fmt.Errorf("provider endpoint without name")
+		}
+		if _, dup := endpoints[ep.Name]; dup {
+			return fmt.Errorf("duplicate provider endpoint: %s", ep.Name)
+		}
+
+		if ep.BaseURL == "" {
+			ep.BaseURL = c.baseURL
+		}
+		if ep.Model == "" {
+			ep.Model = defaultModel
+		}
+		ep.APIKey = c.apiKey
+		if ep.APIKeySecret == "" && ep.KeyProvider == "" {
+			ep.KeyProvider = defaultKeyProvider
+		}
+		if ep.APIKeySecret != "" {
+			if resolveKey == nil {
+				return fmt.Errorf("endpoint %s: cannot resolve secret %s", ep.Name, ep.APIKeySecret)
+			}
+			key, err := resolveKey(ep.APIKeySecret)
+			if err != nil {
+				return fmt.Errorf("endpoint %s: %w", ep.Name, err)
+			}
+			ep.APIKey = key
+		}
+
+		endpoints[ep.Name] = &routedEndpoint{Endpoint: ep, breaker: NewCircuitBreaker(breakerCfg)}
+		order = append(order, ep.Name)
+	}
+
+	routes := make(map[string][]string)
+	for action, names := range cfg.Routes {
+		for _, name := range names {
+			if _, ok := endpoints[name]; !ok {
+				return fmt.Errorf("route %s references unknown endpoint %s", action, name)
+			}
+		}
+		routes[action] = names
+	}
+	if len(routes[defaultRoute]) == 0 {
+		// Without an explicit default, every endpoint is tried in declaration order
+		routes[defaultRoute] = order
+	}
+
+	c.router.mu.Lock()
+	c.router.endpoints = endpoints
+	c.router.routes = routes
+	c.router.mu.Unlock()
+
+	return nil
+}
+
+// SetBreakerObserver registers a callback invoked after every call attempt
+func (c *Client) SetBreakerObserver(observer BreakerObserver) {
+	c.router.mu.Lock()
+	defer c.router.mu.Unlock()
+	c.router.observer = observer
+}
+
+// ProviderStatuses returns every endpoint with its breaker state, sorted by name
+func (c *Client) ProviderStatuses() []ProviderStatus {
+	c.router.mu.RLock()
+	defer c.router.mu.RUnlock()
+
+	statuses := make([]ProviderStatus, 0, len(c.router.endpoints))
+	for _, ep := range c.router.endpoints {
+		statuses = append(statuses, ProviderStatus{
+			Name:    ep.Name,
+			BaseURL: ep.BaseURL,
+			Model:   ep.Model,
+			Breaker: ep.breaker.Snapshot(),
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// ForAction returns a client that routes calls through the provider list of action.
+// The returned client shares breakers, limiter and configuration with c.
+func (c *Client) ForAction(action string) *Client {
+	clone := *c
+	clone.action = action
+	return &clone
+}
+
+// isFailoverError reports whether an error should trip the breaker and move
+// on to the next provider (network failures, timeouts, 408, 429 and 5xx).
+// Anything else, such as a malformed response, is returned as is.
+func isFailoverError(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == 429 || apiErr.StatusCode == 408 || apiErr.StatusCode >= 500
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,110 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChatFailsOverToNextProvider(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(ChatResponse{
+			Model:   req.Model,
+			Choices: []Choice{{Message: Message{Role: "assistant", Content: "ok"}}},
+		})
+	}))
+	defer healthy.Close()
+
+	client := NewClient("test-key")
+	cfg, err := ParseProviderConfig(`{
+		"endpoints": [
+			{"name": "primary", "base_url": "` + failing.URL + `"},
+			{"name": "backup", "base_url": "` + healthy.URL + `", "model": "backup-model"}
+		],
+		"routes": {"generate_file": ["primary", "backup"]},
+		"breaker": {"failure_threshold": 1, "open_timeout_seconds": 60}
+	}`)
+	if err != nil {
+		t.Fatalf("ParseProviderConfig failed: %v", err)
+	}
+	if err := client.ConfigureProviders(cfg, nil); err != nil {
+		t.Fatalf("ConfigureProviders failed: %v", err)
+	}
+
+	var observed []string
+	client.SetBreakerObserver(func(endpoint string, success bool, snapshot BreakerSnapshot) {
+		observed = append(observed, endpoint+":"+string(snapshot.State))
+	})
+
+	result, err := client.ForAction("generate_file").Generate("system", "user", 0.1)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.Provider != "backup" || result.Model != "backup-model" {
+		t.Errorf("Expected backup provider and model, got %s/%s", result.Provider, result.Model)
+	}
+	if len(observed) != 2 || observed[0] != "primary:open" || observed[1] != "backup:closed" {
+		t.Errorf("Unexpected breaker notifications: %v", observed)
+	}
+
+	statuses := client.ProviderStatuses()
+	if len(statuses) != 2 || statuses[1].Name != "primary" || statuses[1].Breaker.State != BreakerOpen {
+		t.Errorf("Expected primary breaker open in statuses, got %+v", statuses)
+	}
+}
+
+func TestChatDoesNotFailOverOnClientError(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key", WithBaseURL(server.URL))
+
+	_, err := client.Generate("system", "user", 0.1)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 APIError, got %v", err)
+	}
+	if state := client.ProviderStatuses()[0].Breaker.State; state != BreakerClosed {
+		t.Errorf("Expected breaker to stay closed on client error, got %s", state)
+	}
+}
+
+func TestIsFailoverError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unavailable", &APIError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"rate limited", fmt.Errorf("wrapped: %w", &APIError{StatusCode: http.StatusTooManyRequests}), true},
+		{"request timeout", &APIError{StatusCode: http.StatusRequestTimeout}, true},
+		{"bad request", &APIError{StatusCode: http.StatusBadRequest}, false},
+		{"connection refused", fmt.Errorf("failed to send request: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"deadline", fmt.Errorf("failed to send request: %w", context.DeadlineExceeded), true},
+		{"canceled", fmt.Errorf("failed to send request: %w", context.Canceled), false},
+		{"malformed response", fmt.Errorf("failed to unmarshal response: %w", &json.SyntaxError{}), false},
+		{"no choices", errors.New("no choices in response"), false},
+	}
+	for _, tc := range cases {
+		if got := isFailoverError(tc.err); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
@@ -3,17 +3,63 @@ package cerebras
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
 )
 
+// maxConcurrentChunks bounds the number of chunk digests in flight
+const maxConcurrentChunks = 4
+
+// DigestCache stores per-chunk digests so that unchanged chunks are not re-digested
+type DigestCache interface {
+	GetChunkDigest(hash string) (string, bool)
+	SetChunkDigest(hash, sourceType, digest string) error
+}
+
 // GenerateDigest generates a structured digest of source data
 func (c *Client) GenerateDigest(sourceType, sourceData string) (string, error) {
+	return c.GenerateDigestWithCache(sourceType, sourceData, nil)
+}
+
+// GenerateDigestWithCache generates a structured digest, map-reducing over
+// structural chunks when the source exceeds the prompt limit. Chunk digests
+// are looked up in and stored to cache when it is not nil.
+func (c *Client) GenerateDigestWithCache(sourceType, sourceData string, cache DigestCache) (string, error) {
+	if len(sourceData) <= maxDigestPromptLen {
+		digest, err := c.digestOnce(sourceType, buildDigestUserPrompt(sourceType, sourceData))
+		if err != nil {
+			return "", err
+		}
+		return marshalDigest(digest)
+	}
+
+	chunks := ChunkSource(sourceType, sourceData, maxDigestPromptLen)
+
+	// Map: digest each chunk concurrently
+	partials, err := c.digestChunks(sourceType, chunks, cache)
+	if err != nil {
+		return "", err
+	}
+
+	// Reduce: merge partial digests into the final schema
+	digest, err := c.mergeDigests(sourceType, partials)
+	if err != nil {
+		return "", err
+	}
+	digest["chunk_count"] = len(chunks)
+
+	return marshalDigest(digest)
+}
+
+// digestOnce runs a single digest prompt and parses the JSON response
+func (c *Client) digestOnce(sourceType, userPrompt string) (map[string]interface{}, error) {
 	systemPrompt := buildDigestSystemPrompt(sourceType)
-	userPrompt := buildDigestUserPrompt(sourceType, sourceData)
 
 	// Generate with moderate temperature for balanced output
 	result, err := c.Generate(systemPrompt, userPrompt, 0.3)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Parse and validate JSON response
@@ -26,7 +72,106 @@ func (c *Client) GenerateDigest(sourceType, sourceData string) (string, error) {
 		}
 	}
 
-	// Re-marshal to ensure valid JSON
+	return digest, nil
+}
+
+// digestChunks digests chunks concurrently within the rate limit
+func (c *Client) digestChunks(sourceType string, chunks []Chunk, cache DigestCache) ([]string, error) {
+	partials := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, maxConcurrentChunks)
+	var wg sync.WaitGroup
+
+	for i, chunk := range chunks {
+		if cache != nil {
+			if cached, ok := cache.GetChunkDigest(chunk.Hash); ok {
+				partials[i] = cached
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func(idx int, chunk Chunk) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// Rate limits are enforced per provider key by Generate
+			userPrompt := buildChunkUserPrompt(sourceType, chunk, len(chunks))
+			digest, err := c.digestOnce(sourceType, userPrompt)
+			if err != nil {
+				errs[idx] = fmt.Errorf("chunk %d (%s): %w", chunk.Index, chunk.Section, err)
+				return
+			}
+
+			partials[idx] = marshalCompact(digest)
+			if cache != nil {
+				if err := cache.SetChunkDigest(chunk.Hash, sourceType, partials[idx]); err != nil {
+					log.Printf("Warning: failed to cache chunk digest: %v", err)
+				}
+			}
+		}(i, chunk)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to digest chunk: %w", err)
+		}
+	}
+
+	return partials, nil
+}
+
+// mergeDigests reduces partial digests into one, merging hierarchically
+// when the partials themselves exceed the prompt limit
+func (c *Client) mergeDigests(sourceType string, partials []string) (map[string]interface{}, error) {
+	if len(partials) == 1 {
+		var digest map[string]interface{}
+		if err := json.Unmarshal([]byte(partials[0]), &digest); err == nil {
+			return digest, nil
+		}
+	}
+
+	var groups [][]string
+	var group []string
+	size := 0
+	for _, partial := range partials {
+		if len(group) > 0 && size+len(partial) > maxDigestPromptLen {
+			groups = append(groups, group)
+			group = nil
+			size = 0
+		}
+		group = append(group, partial)
+		size += len(partial)
+	}
+	groups = append(groups, group)
+
+	if len(groups) == 1 {
+		return c.digestOnce(sourceType, buildMergeUserPrompt(sourceType, partials))
+	}
+
+	// Merge each group first, then merge the group digests
+	merged := make([]string, 0, len(groups))
+	for _, g := range groups {
+		digest, err := c.digestOnce(sourceType, buildMergeUserPrompt(sourceType, g))
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge partial digests: %w", err)
+		}
+		merged = append(merged, marshalCompact(digest))
+	}
+
+	// Group digests too large to share a prompt would be merged forever
+	if len(merged) >= len(partials) {
+		return nil, fmt.Errorf("failed to merge %d partial digests: their merges do not fit the %d byte prompt limit", len(partials), maxDigestPromptLen)
+	}
+
+	return c.mergeDigests(sourceType, merged)
+}
+
+// marshalDigest re-marshals a digest to ensure valid, indented JSON
+func marshalDigest(digest map[string]interface{}) (string, error) {
 	digestJSON, err := json.MarshalIndent(digest, "", "  ")
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal digest: %w", err)
@@ -125,15 +270,26 @@ For configuration files, include:
 
 // buildDigestUserPrompt creates user prompt for digest generation
 func buildDigestUserPrompt(sourceType, sourceData string) string {
-	// Truncate if too long (max 10000 chars for prompt)
-	maxLen := 10000
-	if len(sourceData) > maxLen {
-		sourceData = sourceData[:maxLen] + "\n\n... (truncated for brevity)"
+	// Safety net only: larger sources go through the chunked pipeline
+	if len(sourceData) > maxDigestPromptLen {
+		sourceData = sourceData[:maxDigestPromptLen] + "\n\n... (truncated for brevity)"
 	}
 
 	return fmt.Sprintf("Analyze this %s and provide a structured digest:\n\n%s", sourceType, sourceData)
 }
 
+// buildChunkUserPrompt creates user prompt for digesting a single chunk
+func buildChunkUserPrompt(sourceType string, chunk Chunk, total int) string {
+	return fmt.Sprintf("This is part %d of %d (%s) of a larger %s. Analyze ONLY this part and provide a structured digest using the same JSON schema; omit fields this part gives no information about:\n\n%s",
+		chunk.Index+1, total, chunk.Section, sourceType, chunk.Content)
+}
+
+// buildMergeUserPrompt creates user prompt for merging partial digests
+func buildMergeUserPrompt(sourceType string, partials []string) string {
+	return fmt.Sprintf("These are partial digests of consecutive parts of the same %s. Merge them into a single digest following the JSON schema: concatenate lists, deduplicate entries, and write summaries that cover the whole source:\n\n%s",
+		sourceType, strings.Join(partials, "\n\n"))
+}
+
 // DigestResult represents a parsed digest
 type DigestResult struct {
 	SourceType      string                 `json:"source_type"`
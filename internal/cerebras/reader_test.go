@@ -0,0 +1,35 @@
+package cerebras
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMergeDigestsStopsWhenMergesDoNotShrink(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		// Every merge is as large as its input
+		content := marshalCompact(map[string]interface{}{"summary": strings.Repeat("x", maxDigestPromptLen)})
+		json.NewEncoder(w).Encode(ChatResponse{
+			Model:   "test-model",
+			Choices: []Choice{{Message: Message{Role: "assistant", Content: content}}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key", WithBaseURL(server.URL))
+	partial := marshalCompact(map[string]interface{}{"summary": strings.Repeat("y", maxDigestPromptLen)})
+
+	if _, err := client.mergeDigests("code", []string{partial, partial, partial}); err == nil {
+		t.Fatal("Expected merging oversized digests to fail")
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("Expected a single round of merges, got %d calls", n)
+	}
+}
@@ -0,0 +1,218 @@
+package cerebras
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// defaultMaxToolSteps bounds the number of tool-calling rounds per generation
+const defaultMaxToolSteps = 5
+
+// maxToolOutputLen caps the size of a tool result fed back to the model
+const maxToolOutputLen = 8000
+
+// ToolHandler executes a tool call with decoded JSON arguments
+type ToolHandler func(args map[string]interface{}) (string, error)
+
+// ToolRegistry holds the local tools the model may call during generation
+type ToolRegistry struct {
+	tools map[string]registeredTool
+	order []string
+}
+
+type registeredTool struct {
+	definition Tool
+	handler    ToolHandler
+}
+
+// ToolCallRecord records a tool call executed during generation
+type ToolCallRecord struct {
+	Step        int    `json:"step"`
+	Name        string `json:"name"`
+	Arguments   string `json:"arguments"`
+	OutputBytes int    `json:"output_bytes"`
+	Error       string `json:"error,omitempty"`
+}
+
+// NewToolRegistry creates an empty tool registry
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		tools: make(map[string]registeredTool),
+	}
+}
+
+// Register adds a tool; parameters is a JSON schema object
+func (r *ToolRegistry) Register(name, description string, parameters map[string]interface{}, handler ToolHandler) {
+	if _, exists := r.tools[name]; !exists {
+		r.order = append(r.order, name)
+	}
+
+	r.tools[name] = registeredTool{
+		definition: Tool{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        name,
+				Description: description,
+				Parameters:  parameters,
+			},
+		},
+		handler: handler,
+	}
+}
+
+// Definitions returns tool definitions in registration order
+func (r *ToolRegistry) Definitions() []Tool {
+	defs := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		defs = append(defs, r.tools[name].definition)
+	}
+	return defs
+}
+
+// Execute runs a tool call. Failures are returned as tool output so the
+// model can recover, and also reported through the error.
+func (r *ToolRegistry) Execute(call ToolCall) (string, error) {
+	tool, ok := r.tools[call.Function.Name]
+	if !ok {
+		err := fmt.Errorf("unknown tool: %s", call.Function.Name)
+		return "error: " + err.Error(), err
+	}
+
+	args := make(map[string]interface{})
+	if call.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			err = fmt.Errorf("invalid arguments for %s: %w", call.Function.Name, err)
+			return "error: " + err.Error(), err
+		}
+	}
+
+	output, err := tool.handler(args)
+	if err != nil {
+		return "error: " + err.Error(), err
+	}
+
+	if len(output) > maxToolOutputLen {
+		output = output[:maxToolOutputLen] + "\n\n... (truncated)"
+	}
+
+	return output, nil
+}
+
+// toolsPrompt is appended to the system prompt of generations that may call tools
+const toolsPrompt = "\n\nYou can call the provided tools to inspect existing files, databases and project patterns before writing code. Only call a tool when the information is needed; then answer with the final code."
+
+// GenerateWithTools runs a generation in which the model may call local tools.
+// After maxSteps tool rounds the model is asked for its final answer without tools.
+func (c *Client) GenerateWithTools(systemPrompt, userPrompt string, temperature float64, registry *ToolRegistry, maxSteps int) (*GenerationResult, error) {
+	if registry == nil || len(registry.order) == 0 {
+		return c.Generate(systemPrompt, userPrompt, temperature)
+	}
+
+	return c.runTools([]Message{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: userPrompt},
+	}, temperature, registry, maxSteps)
+}
+
+// GenerateConversationWithTools generates code from a multi-turn message
+// history, letting the model call local tools first
+func (c *Client) GenerateConversationWithTools(codeType string, patterns interface{}, history []Message, temperature float64, registry *ToolRegistry, maxSteps int) (*GenerationResult, error) {
+	if registry == nil || len(registry.order) == 0 {
+		return c.GenerateConversation(codeType, patterns, history, temperature)
+	}
+
+	messages := make([]Message, 0, len(history)+1)
+	messages = append(messages, Message{Role: "system", Content: buildSystemPrompt(codeType, patterns) + toolsPrompt})
+	messages = append(messages, history...)
+
+	return c.runTools(messages, temperature, registry, maxSteps)
+}
+
+// runTools runs the tool-calling loop from messages until the model answers
+func (c *Client) runTools(messages []Message, temperature float64, registry *ToolRegistry, maxSteps int) (*GenerationResult, error) {
+	if maxSteps <= 0 {
+		maxSteps = defaultMaxToolSteps
+	}
+
+	startTime := time.Now()
+	result := &GenerationResult{Temperature: temperature}
+
+	for step := 0; ; step++ {
+		reqBody := ChatRequest{
+			Model:       defaultModel,
+			Messages:    messages,
+			Temperature: temperature,
+			MaxTokens:   8000,
+			Stream:      false,
+			Tools:       registry.Definitions(),
+			ToolChoice:  "auto",
+		}
+
+		// Step budget exhausted: force a final answer
+		if step >= maxSteps {
+			reqBody.ToolChoice = "none"
+		}
+
+		chatResp, err := c.Chat(reqBody)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Model = chatResp.Model
+		result.Provider = chatResp.Provider
+		result.PromptTokens += chatResp.Usage.PromptTokens
+		result.CompletionTokens += chatResp.Usage.CompletionTokens
+
+		reply := chatResp.Choices[0].Message
+		if len(reply.ToolCalls) == 0 || step >= maxSteps {
+			result.Content = reply.Content
+			break
+		}
+
+		// Execute requested tools locally and feed results back
+		messages = append(messages, Message{
+			Role:      "assistant",
+			Content:   reply.Content,
+			ToolCalls: reply.ToolCalls,
+		})
+
+		for _, call := range reply.ToolCalls {
+			output, err := registry.Execute(call)
+
+			record := ToolCallRecord{
+				Step:        step + 1,
+				Name:        call.Function.Name,
+				Arguments:   call.Function.Arguments,
+				OutputBytes: len(output),
+			}
+			if err != nil {
+				record.Error = err.Error()
+			}
+			result.ToolCalls = append(result.ToolCalls, record)
+
+			messages = append(messages, Message{
+				Role:       "tool",
+				Content:    output,
+				ToolCallID: call.ID,
+				Name:       call.Function.Name,
+			})
+		}
+	}
+
+	result.LatencyMs = int(time.Since(startTime).Milliseconds())
+
+	return result, nil
+}
+
+// GenerateCodeWithTools generates code, letting the model call local tools first
+func (c *Client) GenerateCodeWithTools(prompt string, codeType string, patterns interface{}, temperature float64, registry *ToolRegistry, maxSteps int) (string, *GenerationResult, error) {
+	systemPrompt := buildSystemPrompt(codeType, patterns) + toolsPrompt
+
+	result, err := c.GenerateWithTools(systemPrompt, prompt, temperature, registry, maxSteps)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return CleanCode(result.Content, codeType), result, nil
+}
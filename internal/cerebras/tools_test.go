@@ -0,0 +1,131 @@
+package cerebras
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestToolRegistryExecute(t *testing.T) {
+	registry := NewToolRegistry()
+	registry.Register("echo", "Echo the input", nil, func(args map[string]interface{}) (string, error) {
+		return args["text"].(string), nil
+	})
+
+	output, err := registry.Execute(ToolCall{Function: ToolCallFunction{Name: "echo", Arguments: `{"text":"hi"}`}})
+	if err != nil || output != "hi" {
+		t.Errorf("Expected 'hi', got %q (err=%v)", output, err)
+	}
+
+	output, err = registry.Execute(ToolCall{Function: ToolCallFunction{Name: "missing"}})
+	if err == nil || !strings.HasPrefix(output, "error:") {
+		t.Errorf("Expected error output for unknown tool, got %q", output)
+	}
+}
+
+func TestGenerateWithToolsLoop(t *testing.T) {
+	var requests []ChatRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		reply := Message{Role: "assistant", Content: "package main"}
+		if len(requests) == 1 {
+			reply = Message{Role: "assistant", ToolCalls: []ToolCall{{
+				ID:       "call_1",
+				Type:     "function",
+				Function: ToolCallFunction{Name: "read_code", Arguments: `{"file_path":"main.go"}`},
+			}}}
+		}
+
+		json.NewEncoder(w).Encode(ChatResponse{
+			Model:   "test-model",
+			Choices: []Choice{{Message: reply}},
+			Usage:   Usage{PromptTokens: 10, CompletionTokens: 5},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key", WithBaseURL(server.URL))
+
+	called := ""
+	registry := NewToolRegistry()
+	registry.Register("read_code", "Read code", nil, func(args map[string]interface{}) (string, error) {
+		called = args["file_path"].(string)
+		return `{"package":"main"}`, nil
+	})
+
+	result, err := client.GenerateWithTools("system", "user", 0.1, registry, 3)
+	if err != nil {
+		t.Fatalf("GenerateWithTools failed: %v", err)
+	}
+
+	if called != "main.go" {
+		t.Errorf("Expected tool to be called with main.go, got %q", called)
+	}
+	if result.Content != "package main" {
+		t.Errorf("Expected final content, got %q", result.Content)
+	}
+	if result.PromptTokens != 20 || len(result.ToolCalls) != 1 {
+		t.Errorf("Expected accumulated usage and 1 tool call, got %d tokens, %d calls", result.PromptTokens, len(result.ToolCalls))
+	}
+
+	last := requests[len(requests)-1].Messages
+	if last[len(last)-1].Role != "tool" || last[len(last)-1].ToolCallID != "call_1" {
+		t.Errorf("Expected tool result message in second request, got %+v", last[len(last)-1])
+	}
+}
+
+func TestGenerateConversationWithToolsKeepsHistory(t *testing.T) {
+	var requests []ChatRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		reply := Message{Role: "assistant", Content: "package main // v2"}
+		if len(requests) == 1 {
+			reply = Message{Role: "assistant", ToolCalls: []ToolCall{{
+				ID:       "call_1",
+				Type:     "function",
+				Function: ToolCallFunction{Name: "read_code", Arguments: `{"file_path":"main.go"}`},
+			}}}
+		}
+
+		json.NewEncoder(w).Encode(ChatResponse{Model: "test-model", Choices: []Choice{{Message: reply}}})
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key", WithBaseURL(server.URL))
+
+	registry := NewToolRegistry()
+	registry.Register("read_code", "Read code", nil, func(args map[string]interface{}) (string, error) {
+		return `{"package":"main"}`, nil
+	})
+
+	history := []Message{
+		{Role: "user", Content: "Write main"},
+		{Role: "assistant", Content: "package main"},
+		{Role: "user", Content: "Add a comment"},
+	}
+	result, err := client.GenerateConversationWithTools("go", nil, history, 0.3, registry, 3)
+	if err != nil {
+		t.Fatalf("GenerateConversationWithTools failed: %v", err)
+	}
+	if result.Content != "package main // v2" || len(result.ToolCalls) != 1 {
+		t.Errorf("Expected the final answer after 1 tool call, got %q with %d calls", result.Content, len(result.ToolCalls))
+	}
+
+	first := requests[0]
+	if len(first.Tools) != 1 || len(first.Messages) != 4 || first.Messages[3].Content != "Add a comment" {
+		t.Errorf("Expected the system prompt, the history and the tools, got %+v", first)
+	}
+	if last := requests[1].Messages; last[len(last)-1].Role != "tool" {
+		t.Errorf("Expected the tool result after the history, got %+v", last[len(last)-1])
+	}
+}
@@ -51,6 +51,10 @@ func (h *Helper) InitLifecycleDB(path string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to execute lifecycle schema: %w", err)
 	}
 
+	if err := h.ensureColumns(db, lifecycleColumns); err != nil {
+		return nil, fmt.Errorf("failed to migrate lifecycle schema: %w", err)
+	}
+
 	return db, nil
 }
 
@@ -89,6 +93,18 @@ func (h *Helper) InitMetadataDB(path string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to execute metadata schema: %w", err)
 	}
 
+	if err := h.ensureColumns(db, metadataColumns); err != nil {
+		return nil, fmt.Errorf("failed to migrate metadata schema: %w", err)
+	}
+
+	// Keys stored before per-provider pools belong to the Cerebras pool
+	if _, err := db.Exec(`
+		UPDATE secrets SET provider = 'cerebras'
+		WHERE secret_name = 'CEREBRAS_API_KEY' AND provider IS NULL
+	`); err != nil {
+		return nil, fmt.Errorf("failed to migrate legacy API key: %w", err)
+	}
+
 	return db, nil
 }
 
@@ -123,3 +139,85 @@ func (h *Helper) openDB(path string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// column describes a column added to an existing table after its creation
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// lifecycleColumns lists columns added to lifecycle tables since their first release.
+// CREATE TABLE IF NOT EXISTS does not alter existing databases, so they are added here.
+var lifecycleColumns = []column{
+	{"session_blocks", "initial_code", "TEXT"},
+	{"cerebras_usage", "provider", "TEXT"},
+	{"session_blocks", "pending_code", "TEXT"},
+	{"session_blocks", "stale", "INTEGER DEFAULT 0"},
+	{"session_blocks", "validation_json", "TEXT"},
+	{"session_blocks", "test_json", "TEXT"},
+	{"session_blocks", "test_result_json", "TEXT"},
+	{"sessions", "project_root", "TEXT"},
+	{"session_blocks", "pattern_id", "TEXT"},
+	{"session_blocks", "version", "INTEGER DEFAULT 0"},
+	{"session_blocks", "generation_error", "TEXT"},
+	{"cerebras_usage", "block_id", "TEXT"},
+	{"sessions", "imported_from", "TEXT"},
+}
+
+// metadataColumns lists columns added to metadata tables since their first release
+var metadataColumns = []column{
+	{"secrets", "provider", "TEXT"},
+	{"secrets", "disabled", "INTEGER DEFAULT 0"},
+	{"secrets", "disabled_at", "INTEGER"},
+	{"secrets", "disabled_reason", "TEXT"},
+}
+
+// ensureColumns adds missing columns to existing tables
+func (h *Helper) ensureColumns(db *sql.DB, columns []column) error {
+	existing := make(map[string]map[string]bool)
+
+	for _, col := range columns {
+		if _, ok := existing[col.table]; !ok {
+			names, err := tableColumns(db, col.table)
+			if err != nil {
+				return err
+			}
+			existing[col.table] = names
+		}
+
+		if existing[col.table][col.name] {
+			continue
+		}
+
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", col.table, col.name, err)
+		}
+		existing[col.table][col.name] = true
+	}
+
+	return nil
+}
+
+// tableColumns returns the set of column names of a table
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+
+	return names, rows.Err()
+}
@@ -2,10 +2,15 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
+// ErrVersionConflict reports a block changed since the version a write was based on
+var ErrVersionConflict = errors.New("block version conflict")
+
 // LifecycleDB provides helper methods for lifecycle database operations
 type LifecycleDB struct {
 	db *sql.DB
@@ -28,13 +33,14 @@ func (l *LifecycleDB) CreateSession(sessionID, status string) error {
 // GetSession retrieves a session by ID
 func (l *LifecycleDB) GetSession(sessionID string) (map[string]interface{}, error) {
 	var status string
+	var projectRoot, importedFrom sql.NullString
 	var createdAt, completedAt sql.NullInt64
 
 	err := l.db.QueryRow(`
-		SELECT status, created_at, completed_at
+		SELECT status, created_at, completed_at, project_root, imported_from
 		FROM sessions
 		WHERE session_id = ?
-	`, sessionID).Scan(&status, &createdAt, &completedAt)
+	`, sessionID).Scan(&status, &createdAt, &completedAt, &projectRoot, &importedFrom)
 
 	if err != nil {
 		return nil, err
@@ -49,20 +55,215 @@ func (l *LifecycleDB) GetSession(sessionID string) (map[string]interface{}, erro
 	if completedAt.Valid {
 		result["completed_at"] = completedAt.Int64
 	}
+	if projectRoot.Valid {
+		result["project_root"] = projectRoot.String
+	}
+	if importedFrom.Valid {
+		result["imported_from"] = importedFrom.String
+	}
 
 	return result, nil
 }
 
-// UpdateSessionStatus updates session status
+// SetSessionProjectRoot binds a session to the project its code is generated for
+func (l *LifecycleDB) SetSessionProjectRoot(sessionID, projectRoot string) error {
+	_, err := l.db.Exec(`
+		UPDATE sessions SET project_root = ? WHERE session_id = ?
+	`, projectRoot, sessionID)
+	return err
+}
+
+// UpdateSessionStatus updates session status; a session back in pending_audit has no completion time
 func (l *LifecycleDB) UpdateSessionStatus(sessionID, status string) error {
 	_, err := l.db.Exec(`
 		UPDATE sessions
-		SET status = ?, completed_at = ?
+		SET status = ?, completed_at = CASE WHEN ? = 'pending_audit' THEN NULL ELSE ? END
 		WHERE session_id = ?
-	`, status, time.Now().Unix(), sessionID)
+	`, status, status, time.Now().Unix(), sessionID)
 	return err
 }
 
+// sessionActivity is the last time a session or one of its blocks changed, or
+// anything was recorded in its event log (audits, validations, tests…)
+const sessionActivity = `MAX(s.created_at,
+	COALESCE((SELECT MAX(MAX(b.generated_at, COALESCE(b.last_refined_at, 0), COALESCE(b.committed_at, 0)))
+	          FROM session_blocks b WHERE b.session_id = s.session_id), 0),
+	COALESCE((SELECT MAX(e.timestamp) FROM session_events e WHERE e.session_id = s.session_id), 0))`
+
+// ListSessions returns sessions, newest first, with block counts and last
+// activity. Empty status matches every status; createdAfter and createdBefore
+// are ignored when zero.
+func (l *LifecycleDB) ListSessions(status string, createdAfter, createdBefore int64, limit int) ([]map[string]interface{}, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+
+	rows, err := l.db.Query(`
+		SELECT s.session_id, s.status, s.created_at, s.completed_at,
+		       (SELECT COUNT(*) FROM session_blocks b WHERE b.session_id = s.session_id),
+		       (SELECT COUNT(*) FROM session_blocks b WHERE b.session_id = s.session_id AND b.status = 'committed'),
+		       `+sessionActivity+`
+		FROM sessions s
+		WHERE (? = '' OR s.status = ?)
+		  AND (? = 0 OR s.created_at >= ?)
+		  AND (? = 0 OR s.created_at < ?)
+		ORDER BY s.created_at DESC, s.rowid DESC
+		LIMIT ?
+	`, status, status, createdAfter, createdAfter, createdBefore, createdBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var sessionID, sessionStatus string
+		var createdAt, lastActivity int64
+		var completedAt sql.NullInt64
+		var blocks, committed int
+
+		if err := rows.Scan(&sessionID, &sessionStatus, &createdAt, &completedAt, &blocks, &committed, &lastActivity); err != nil {
+			return nil, err
+		}
+
+		result := map[string]interface{}{
+			"session_id":       sessionID,
+			"status":           sessionStatus,
+			"created_at":       createdAt,
+			"blocks":           blocks,
+			"blocks_committed": committed,
+			"last_activity":    lastActivity,
+		}
+		if completedAt.Valid {
+			result["completed_at"] = completedAt.Int64
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// GetSessionBlockIDs returns the IDs of a session's blocks in creation order
+func (l *LifecycleDB) GetSessionBlockIDs(sessionID string) ([]string, error) {
+	return l.queryBlockIDs(`
+		SELECT block_id FROM session_blocks WHERE session_id = ? ORDER BY generated_at ASC, rowid ASC
+	`, sessionID)
+}
+
+// FindImportedSessions returns the sessions that are, or were imported from, a session
+func (l *LifecycleDB) FindImportedSessions(sessionID string) ([]string, error) {
+	return l.queryBlockIDs(`
+		SELECT session_id FROM sessions WHERE session_id = ? OR imported_from = ? ORDER BY created_at ASC
+	`, sessionID, sessionID)
+}
+
+// BlockExists reports whether a block ID is taken, in any session
+func (l *LifecycleDB) BlockExists(blockID string) (bool, error) {
+	var n int
+	err := l.db.QueryRow(`SELECT COUNT(*) FROM session_blocks WHERE block_id = ?`, blockID).Scan(&n)
+	return n > 0, err
+}
+
+// importColumns are the columns ImportSession writes, per table
+var importColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"sessions", []string{"session_id", "status", "created_at", "completed_at", "project_root", "imported_from"}},
+	{"session_blocks", []string{"block_id", "session_id", "description", "type", "target", "code", "initial_code",
+		"pending_code", "stale", "validation_json", "test_json", "test_result_json", "pattern_id", "iterations",
+		"version", "status", "generation_error", "generated_at", "last_refined_at", "committed_at"}},
+	{"block_dependencies", []string{"session_id", "block_id", "depends_on"}},
+	{"block_refinements", []string{"refinement_id", "block_id", "feedback", "temperature", "refined_code", "created_at"}},
+	{"cerebras_usage", []string{"request_id", "block_id", "operation", "provider", "model", "temperature",
+		"tokens_prompt", "tokens_completion", "latency_ms", "timestamp"}},
+	{"session_events", []string{"session_id", "block_id", "kind", "actor", "detail", "temperature", "tokens", "timestamp"}},
+}
+
+// ImportSession writes a session and its rows in one transaction: rows are
+// keyed by column name, in the order of importColumns (the session, its
+// blocks, dependencies, refinements, usage records and events); a column a
+// row lacks is stored as NULL. Nothing is written when a row is refused.
+func (l *LifecycleDB) ImportSession(session map[string]interface{}, blocks, dependencies, refinements, usage, events []map[string]interface{}) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tables := [][]map[string]interface{}{{session}, blocks, dependencies, refinements, usage, events}
+	for i, rows := range tables {
+		table, columns := importColumns[i].table, importColumns[i].columns
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (?%s)",
+			table, strings.Join(columns, ", "), strings.Repeat(", ?", len(columns)-1))
+		for _, row := range rows {
+			values := make([]interface{}, len(columns))
+			for j, column := range columns {
+				values[j] = row[column]
+			}
+			if _, err := tx.Exec(query, values...); err != nil {
+				return fmt.Errorf("failed to import into %s: %w", table, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CountSessionsByStatus returns the number of sessions per status and the total number of blocks
+func (l *LifecycleDB) CountSessionsByStatus() (map[string]int, int, error) {
+	rows, err := l.db.Query(`SELECT status, COUNT(*) FROM sessions GROUP BY status`)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, 0, err
+		}
+		counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var blocks int
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM session_blocks`).Scan(&blocks); err != nil {
+		return nil, 0, err
+	}
+
+	return counts, blocks, nil
+}
+
+// IdleSessions returns the pending_audit sessions with no activity since cutoff
+func (l *LifecycleDB) IdleSessions(cutoff int64) ([]string, error) {
+	return l.queryBlockIDs(`
+		SELECT s.session_id FROM sessions s
+		WHERE s.status = 'pending_audit' AND `+sessionActivity+` < ?
+	`, cutoff)
+}
+
+// ExpireSession abandons a session if it is still pending_audit with no
+// activity since cutoff, and reports whether it did
+func (l *LifecycleDB) ExpireSession(sessionID string, cutoff int64) (bool, error) {
+	result, err := l.db.Exec(`
+		UPDATE sessions SET status = 'abandoned', completed_at = ?
+		WHERE session_id IN (
+			SELECT s.session_id FROM sessions s
+			WHERE s.session_id = ? AND s.status = 'pending_audit' AND `+sessionActivity+` < ?
+		)
+	`, time.Now().Unix(), sessionID, cutoff)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
 // CreateBlock creates a new block in a session
 func (l *LifecycleDB) CreateBlock(blockID, sessionID, description, blockType, target string) error {
 	_, err := l.db.Exec(`
@@ -76,18 +277,20 @@ func (l *LifecycleDB) CreateBlock(blockID, sessionID, description, blockType, ta
 // GetBlock retrieves a block by ID
 func (l *LifecycleDB) GetBlock(blockID string) (map[string]interface{}, error) {
 	var sessionID, description, blockType, target, status string
-	var code sql.NullString
-	var iterations int
+	var code, initialCode, pendingCode, validationJSON, testJSON, testResultJSON, patternID, generationError sql.NullString
+	var iterations, version int
 	var generatedAt int64
-	var lastRefinedAt, committedAt sql.NullInt64
+	var lastRefinedAt, committedAt, stale sql.NullInt64
 
 	err := l.db.QueryRow(`
-		SELECT session_id, description, type, target, code, iterations, status,
-		       generated_at, last_refined_at, committed_at
+		SELECT session_id, description, type, target, code, initial_code, pending_code, iterations, status,
+		       generated_at, last_refined_at, committed_at, stale, validation_json, test_json, test_result_json, pattern_id,
+		       COALESCE(version, 0), generation_error
 		FROM session_blocks
 		WHERE block_id = ?
-	`, blockID).Scan(&sessionID, &description, &blockType, &target, &code, &iterations,
-		&status, &generatedAt, &lastRefinedAt, &committedAt)
+	`, blockID).Scan(&sessionID, &description, &blockType, &target, &code, &initialCode, &pendingCode, &iterations,
+		&status, &generatedAt, &lastRefinedAt, &committedAt, &stale, &validationJSON, &testJSON, &testResultJSON, &patternID,
+		&version, &generationError)
 
 	if err != nil {
 		return nil, err
@@ -100,13 +303,36 @@ func (l *LifecycleDB) GetBlock(blockID string) (map[string]interface{}, error) {
 		"type":         blockType,
 		"target":       target,
 		"iterations":   iterations,
+		"version":      version,
 		"status":       status,
 		"generated_at": generatedAt,
+		"stale":        stale.Valid && stale.Int64 != 0,
 	}
 
 	if code.Valid {
 		result["code"] = code.String
 	}
+	if initialCode.Valid {
+		result["initial_code"] = initialCode.String
+	}
+	if pendingCode.Valid {
+		result["pending_code"] = pendingCode.String
+	}
+	if validationJSON.Valid {
+		result["validation_json"] = validationJSON.String
+	}
+	if testJSON.Valid {
+		result["test_json"] = testJSON.String
+	}
+	if testResultJSON.Valid {
+		result["test_result_json"] = testResultJSON.String
+	}
+	if patternID.Valid {
+		result["pattern_id"] = patternID.String
+	}
+	if generationError.Valid {
+		result["generation_error"] = generationError.String
+	}
 	if lastRefinedAt.Valid {
 		result["last_refined_at"] = lastRefinedAt.Int64
 	}
@@ -117,13 +343,181 @@ func (l *LifecycleDB) GetBlock(blockID string) (map[string]interface{}, error) {
 	return result, nil
 }
 
-// UpdateBlockCode updates the code for a block
+// updateBlockCodeSQL is the update shared by UpdateBlockCode and ApplyRefinement
+const updateBlockCodeSQL = `
+	UPDATE session_blocks
+	SET code = ?, initial_code = COALESCE(initial_code, ?), pending_code = NULL, stale = 0,
+	    iterations = iterations + 1, last_refined_at = ?, version = COALESCE(version, 0) + 1
+	WHERE block_id = ?`
+
+// UpdateBlockCode updates the code for a block; the first code stored is kept as initial_code.
+// Any regenerated code awaiting confirmation is discarded and the block is no longer stale.
 func (l *LifecycleDB) UpdateBlockCode(blockID, code string) error {
+	_, err := l.db.Exec(updateBlockCodeSQL, code, code, time.Now().Unix(), blockID)
+	return err
+}
+
+// SetGeneratedCode stores the first code generated for a block, or the code of
+// a retried failed block, provided the block is still at version; otherwise
+// nothing is written and ErrVersionConflict is returned
+func (l *LifecycleDB) SetGeneratedCode(blockID, code string, version int) error {
+	result, err := l.db.Exec(`
+		UPDATE session_blocks
+		SET code = ?, initial_code = COALESCE(initial_code, ?), status = 'pending', generation_error = NULL,
+		    iterations = iterations + 1, last_refined_at = ?, version = COALESCE(version, 0) + 1
+		WHERE block_id = ? AND status != 'committed' AND COALESCE(version, 0) = ?
+	`, code, code, time.Now().Unix(), blockID, version)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// FailBlock records that a block's code could not be generated
+func (l *LifecycleDB) FailBlock(blockID, generationError string) error {
 	_, err := l.db.Exec(`
 		UPDATE session_blocks
-		SET code = ?, iterations = iterations + 1, last_refined_at = ?
+		SET status = 'failed', generation_error = ?, version = COALESCE(version, 0) + 1
 		WHERE block_id = ?
-	`, code, time.Now().Unix(), blockID)
+	`, generationError, blockID)
+	return err
+}
+
+// ApplyRefinement records a refinement and makes its code the block's code,
+// provided the block is still at the version the refinement was based on and
+// not committed; otherwise nothing is written and ErrVersionConflict is returned
+func (l *LifecycleDB) ApplyRefinement(refinementID, blockID, feedback, refinedCode string, temperature float64, version int) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(updateBlockCodeSQL+` AND COALESCE(version, 0) = ? AND status != 'committed'`,
+		refinedCode, refinedCode, time.Now().Unix(), blockID, version)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrVersionConflict
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO block_refinements
+		(refinement_id, block_id, feedback, temperature, refined_code, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, refinementID, blockID, feedback, temperature, refinedCode, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetBlockPendingCode stores regenerated code awaiting confirmation before commit
+func (l *LifecycleDB) SetBlockPendingCode(blockID, code string) error {
+	_, err := l.db.Exec(`
+		UPDATE session_blocks SET pending_code = ?, version = COALESCE(version, 0) + 1 WHERE block_id = ?
+	`, code, blockID)
+	return err
+}
+
+// AddBlockDependency records that blockID depends on dependsOn
+func (l *LifecycleDB) AddBlockDependency(sessionID, blockID, dependsOn string) error {
+	_, err := l.db.Exec(`
+		INSERT OR IGNORE INTO block_dependencies (session_id, block_id, depends_on)
+		VALUES (?, ?, ?)
+	`, sessionID, blockID, dependsOn)
+	return err
+}
+
+// GetBlockDependencies returns the IDs of the blocks a block depends on
+func (l *LifecycleDB) GetBlockDependencies(blockID string) ([]string, error) {
+	return l.queryBlockIDs(`
+		SELECT depends_on FROM block_dependencies WHERE block_id = ? ORDER BY depends_on
+	`, blockID)
+}
+
+// GetBlockDependants returns the IDs of the blocks that depend on a block
+func (l *LifecycleDB) GetBlockDependants(blockID string) ([]string, error) {
+	return l.queryBlockIDs(`
+		SELECT block_id FROM block_dependencies WHERE depends_on = ? ORDER BY block_id
+	`, blockID)
+}
+
+// MarkDependantsStale flags the direct dependants of a block as stale and
+// returns their IDs
+func (l *LifecycleDB) MarkDependantsStale(blockID string) ([]string, error) {
+	dependants, err := l.GetBlockDependants(blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := l.db.Exec(`
+		UPDATE session_blocks SET stale = 1, version = COALESCE(version, 0) + 1
+		WHERE block_id IN (SELECT block_id FROM block_dependencies WHERE depends_on = ?)
+	`, blockID); err != nil {
+		return nil, err
+	}
+
+	return dependants, nil
+}
+
+// queryBlockIDs runs a query returning a single ID column
+func (l *LifecycleDB) queryBlockIDs(query string, args ...interface{}) ([]string, error) {
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// SetBlockValidation stores the latest validation gate report of a block
+func (l *LifecycleDB) SetBlockValidation(blockID, validationJSON string) error {
+	_, err := l.db.Exec(`
+		UPDATE session_blocks SET validation_json = ? WHERE block_id = ?
+	`, validationJSON, blockID)
+	return err
+}
+
+// SetBlockTest stores the acceptance test of a block
+func (l *LifecycleDB) SetBlockTest(blockID, testJSON string) error {
+	_, err := l.db.Exec(`
+		UPDATE session_blocks SET test_json = ? WHERE block_id = ?
+	`, testJSON, blockID)
+	return err
+}
+
+// SetBlockPatterns records the pattern set injected into a block's latest generation
+func (l *LifecycleDB) SetBlockPatterns(blockID, patternID string) error {
+	_, err := l.db.Exec(`
+		UPDATE session_blocks SET pattern_id = ? WHERE block_id = ?
+	`, patternID, blockID)
+	return err
+}
+
+// SetBlockTestResult stores the latest acceptance test result of a block
+func (l *LifecycleDB) SetBlockTestResult(blockID, resultJSON string) error {
+	_, err := l.db.Exec(`
+		UPDATE session_blocks SET test_result_json = ? WHERE block_id = ?
+	`, resultJSON, blockID)
 	return err
 }
 
@@ -131,12 +525,132 @@ func (l *LifecycleDB) UpdateBlockCode(blockID, code string) error {
 func (l *LifecycleDB) CommitBlock(blockID string) error {
 	_, err := l.db.Exec(`
 		UPDATE session_blocks
-		SET status = 'committed', committed_at = ?
+		SET status = 'committed', committed_at = ?, version = COALESCE(version, 0) + 1
 		WHERE block_id = ?
 	`, time.Now().Unix(), blockID)
 	return err
 }
 
+// CommitBlocks marks several blocks as committed and records the processed log
+// entry of each in one transaction. Every commit holds "block_id", "hash" and
+// "result_json"; either all of them are recorded or none is.
+func (l *LifecycleDB) CommitBlocks(commits []map[string]string) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	for _, c := range commits {
+		if _, err := tx.Exec(`
+			INSERT INTO processed_log (hash, operation, timestamp, result_json)
+			VALUES (?, 'commit', ?, ?)
+		`, c["hash"], now, c["result_json"]); err != nil {
+			return fmt.Errorf("failed to mark block %s processed: %w", c["block_id"], err)
+		}
+		if _, err := tx.Exec(`
+			UPDATE session_blocks
+			SET status = 'committed', committed_at = ?, version = COALESCE(version, 0) + 1
+			WHERE block_id = ?
+		`, now, c["block_id"]); err != nil {
+			return fmt.Errorf("failed to commit block %s: %w", c["block_id"], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UncommitBlock returns a rolled back block to the pending state and forgets
+// the processed log entry of its commit, so the same code can be committed again
+func (l *LifecycleDB) UncommitBlock(blockID, processedHash string) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE session_blocks
+		SET status = 'pending', committed_at = NULL, version = COALESCE(version, 0) + 1
+		WHERE block_id = ?
+	`, blockID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM processed_log WHERE hash = ?`, processedHash); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddCommitBackup records the prior state of a commit target
+func (l *LifecycleDB) AddCommitBackup(backupID, commitID, sessionID, blockID, target, kind, backupPath, backupHash string) error {
+	_, err := l.db.Exec(`
+		INSERT INTO commit_backups
+		(backup_id, commit_id, session_id, block_id, target, kind, backup_path, backup_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, backupID, commitID, sessionID, blockID, target, kind, backupPath, backupHash, time.Now().Unix())
+	return err
+}
+
+// SetBackupCommittedHash records the hash of a target right after its commit
+func (l *LifecycleDB) SetBackupCommittedHash(backupID, committedHash string) error {
+	_, err := l.db.Exec(`
+		UPDATE commit_backups SET committed_hash = ? WHERE backup_id = ?
+	`, committedHash, backupID)
+	return err
+}
+
+// GetCommitBackups returns the backups not yet restored for a session, or for
+// a single block when blockID is set, newest first
+func (l *LifecycleDB) GetCommitBackups(sessionID, blockID string) ([]map[string]interface{}, error) {
+	rows, err := l.db.Query(`
+		SELECT backup_id, commit_id, block_id, target, kind, backup_path, backup_hash, committed_hash, created_at
+		FROM commit_backups
+		WHERE session_id = ? AND (? = '' OR block_id = ?) AND restored_at IS NULL
+		ORDER BY created_at DESC, rowid DESC
+	`, sessionID, blockID, blockID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var backupID, commitID, block, target, kind string
+		var backupPath, backupHash, committedHash sql.NullString
+		var createdAt int64
+
+		if err := rows.Scan(&backupID, &commitID, &block, &target, &kind, &backupPath, &backupHash, &committedHash, &createdAt); err != nil {
+			return nil, err
+		}
+
+		results = append(results, map[string]interface{}{
+			"backup_id":      backupID,
+			"commit_id":      commitID,
+			"session_id":     sessionID,
+			"block_id":       block,
+			"target":         target,
+			"kind":           kind,
+			"backup_path":    backupPath.String,
+			"backup_hash":    backupHash.String,
+			"committed_hash": committedHash.String,
+			"created_at":     createdAt,
+		})
+	}
+
+	return results, rows.Err()
+}
+
+// MarkBackupRestored records that a backup was restored
+func (l *LifecycleDB) MarkBackupRestored(backupID string) error {
+	_, err := l.db.Exec(`
+		UPDATE commit_backups SET restored_at = ? WHERE backup_id = ?
+	`, time.Now().Unix(), backupID)
+	return err
+}
+
 // AddRefinement records a refinement for a block
 func (l *LifecycleDB) AddRefinement(refinementID, blockID, feedback, refinedCode string, temperature float64) error {
 	_, err := l.db.Exec(`
@@ -147,6 +661,122 @@ func (l *LifecycleDB) AddRefinement(refinementID, blockID, feedback, refinedCode
 	return err
 }
 
+// GetBlockRefinements retrieves all refinements of a block, oldest first
+func (l *LifecycleDB) GetBlockRefinements(blockID string) ([]map[string]interface{}, error) {
+	rows, err := l.db.Query(`
+		SELECT refinement_id, feedback, temperature, refined_code, created_at
+		FROM block_refinements
+		WHERE block_id = ?
+		ORDER BY created_at ASC, rowid ASC
+	`, blockID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var refinementID, feedback, refinedCode string
+		var temperature float64
+		var createdAt int64
+
+		if err := rows.Scan(&refinementID, &feedback, &temperature, &refinedCode, &createdAt); err != nil {
+			return nil, err
+		}
+
+		results = append(results, map[string]interface{}{
+			"refinement_id": refinementID,
+			"block_id":      blockID,
+			"feedback":      feedback,
+			"temperature":   temperature,
+			"refined_code":  refinedCode,
+			"created_at":    createdAt,
+		})
+	}
+
+	return results, rows.Err()
+}
+
+// InsertRecipe stores a version of a block recipe; storing an existing version fails
+func (l *LifecycleDB) InsertRecipe(name string, version int, descriptionTemplate, blockType, targetPattern, dependsOnJSON, testJSON, note string) error {
+	_, err := l.db.Exec(`
+		INSERT INTO block_recipes
+		(name, version, description_template, type, target_pattern, depends_on_json, test_json, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?)
+	`, name, version, descriptionTemplate, blockType, targetPattern, dependsOnJSON, testJSON, note, time.Now().Unix())
+	return err
+}
+
+// GetRecipe retrieves a version of a recipe, the latest when version is 0, or
+// nil when it does not exist
+func (l *LifecycleDB) GetRecipe(name string, version int) (map[string]interface{}, error) {
+	recipes, err := l.queryRecipes(`
+		SELECT name, version, description_template, type, target_pattern, depends_on_json, test_json, note, created_at
+		FROM block_recipes
+		WHERE name = ? AND (? = 0 OR version = ?)
+		ORDER BY version DESC
+		LIMIT 1
+	`, name, version, version)
+	if err != nil || len(recipes) == 0 {
+		return nil, err
+	}
+	return recipes[0], nil
+}
+
+// ListRecipes returns the latest version of every recipe by name, or every
+// version of one recipe, newest first, when name is set
+func (l *LifecycleDB) ListRecipes(name string) ([]map[string]interface{}, error) {
+	if name != "" {
+		return l.queryRecipes(`
+			SELECT name, version, description_template, type, target_pattern, depends_on_json, test_json, note, created_at
+			FROM block_recipes
+			WHERE name = ?
+			ORDER BY version DESC
+		`, name)
+	}
+	return l.queryRecipes(`
+		SELECT r.name, r.version, r.description_template, r.type, r.target_pattern, r.depends_on_json, r.test_json, r.note, r.created_at
+		FROM block_recipes r
+		WHERE r.version = (SELECT MAX(version) FROM block_recipes WHERE name = r.name)
+		ORDER BY r.name
+	`)
+}
+
+// queryRecipes scans block_recipes rows
+func (l *LifecycleDB) queryRecipes(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var name, descriptionTemplate, blockType, targetPattern string
+		var dependsOnJSON, testJSON, note sql.NullString
+		var version int
+		var createdAt int64
+
+		if err := rows.Scan(&name, &version, &descriptionTemplate, &blockType, &targetPattern, &dependsOnJSON, &testJSON, &note, &createdAt); err != nil {
+			return nil, err
+		}
+
+		results = append(results, map[string]interface{}{
+			"name":                 name,
+			"version":              version,
+			"description_template": descriptionTemplate,
+			"type":                 blockType,
+			"target_pattern":       targetPattern,
+			"depends_on_json":      dependsOnJSON.String,
+			"test_json":            testJSON.String,
+			"note":                 note.String,
+			"created_at":           createdAt,
+		})
+	}
+
+	return results, rows.Err()
+}
+
 // GetCachedDigest retrieves a cached digest
 func (l *LifecycleDB) GetCachedDigest(hash string) (string, error) {
 	var digestJSON string
@@ -183,6 +813,60 @@ func (l *LifecycleDB) SetCachedDigest(hash, sourceType, sourcePath, digestJSON s
 	return err
 }
 
+// SavePatterns stores the patterns detected in a project
+func (l *LifecycleDB) SavePatterns(patternID, sourcePath, patternType, patternJSON string, confidence float64, detectedAt int64) error {
+	_, err := l.db.Exec(`
+		INSERT INTO detected_patterns
+		(pattern_id, source_path, pattern_type, pattern_json, confidence_score, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, patternID, sourcePath, patternType, patternJSON, confidence, detectedAt)
+	return err
+}
+
+// GetLatestPatterns retrieves the patterns last detected in a project, or nil when none were
+func (l *LifecycleDB) GetLatestPatterns(sourcePath string) (map[string]interface{}, error) {
+	return l.queryPatterns(`
+		SELECT pattern_id, source_path, pattern_type, pattern_json, confidence_score, detected_at
+		FROM detected_patterns
+		WHERE source_path = ?
+		ORDER BY detected_at DESC, rowid DESC
+		LIMIT 1
+	`, sourcePath)
+}
+
+// GetPatterns retrieves a detected pattern set by ID, or nil when it does not exist
+func (l *LifecycleDB) GetPatterns(patternID string) (map[string]interface{}, error) {
+	return l.queryPatterns(`
+		SELECT pattern_id, source_path, pattern_type, pattern_json, confidence_score, detected_at
+		FROM detected_patterns
+		WHERE pattern_id = ?
+	`, patternID)
+}
+
+// queryPatterns scans a single detected_patterns row
+func (l *LifecycleDB) queryPatterns(query string, arg string) (map[string]interface{}, error) {
+	var patternID, sourcePath, patternType, patternJSON string
+	var confidence float64
+	var detectedAt int64
+
+	err := l.db.QueryRow(query, arg).Scan(&patternID, &sourcePath, &patternType, &patternJSON, &confidence, &detectedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"pattern_id":       patternID,
+		"source_path":      sourcePath,
+		"pattern_type":     patternType,
+		"pattern_json":     patternJSON,
+		"confidence_score": confidence,
+		"detected_at":      detectedAt,
+	}, nil
+}
+
 // IsProcessed checks if an operation was already processed
 func (l *LifecycleDB) IsProcessed(hash string) (bool, error) {
 	var count int
@@ -201,12 +885,125 @@ func (l *LifecycleDB) MarkProcessed(hash, operation string, resultJSON string) e
 	return err
 }
 
-// RecordCerebrasUsage records API usage metrics
-func (l *LifecycleDB) RecordCerebrasUsage(requestID, operation, model string, temperature float64, tokensPrompt, tokensCompletion, latencyMs int) error {
+// RecordCerebrasUsage records API usage metrics, including the provider endpoint that served the call
+// and the block it was made for
+func (l *LifecycleDB) RecordCerebrasUsage(requestID, blockID, operation, provider, model string, temperature float64, tokensPrompt, tokensCompletion, latencyMs int) error {
 	_, err := l.db.Exec(`
 		INSERT INTO cerebras_usage
-		(request_id, operation, model, temperature, tokens_prompt, tokens_completion, latency_ms, timestamp)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, requestID, operation, model, temperature, tokensPrompt, tokensCompletion, latencyMs, time.Now().Unix())
+		(request_id, block_id, operation, provider, model, temperature, tokens_prompt, tokens_completion, latency_ms, timestamp)
+		VALUES (?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?)
+	`, requestID, blockID, operation, provider, model, temperature, tokensPrompt, tokensCompletion, latencyMs, time.Now().Unix())
+	return err
+}
+
+// GetSessionUsage returns the usage records of the calls made for a session's blocks, oldest first
+func (l *LifecycleDB) GetSessionUsage(sessionID string) ([]map[string]interface{}, error) {
+	rows, err := l.db.Query(`
+		SELECT u.request_id, u.block_id, u.operation, COALESCE(u.provider, ''), u.model, u.temperature,
+		       COALESCE(u.tokens_prompt, 0), COALESCE(u.tokens_completion, 0), COALESCE(u.latency_ms, 0), u.timestamp
+		FROM cerebras_usage u
+		JOIN session_blocks b ON b.block_id = u.block_id
+		WHERE b.session_id = ?
+		ORDER BY u.timestamp ASC, u.rowid ASC
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var requestID, blockID, operation, provider, model string
+		var temperature float64
+		var tokensPrompt, tokensCompletion, latencyMs int
+		var timestamp int64
+
+		if err := rows.Scan(&requestID, &blockID, &operation, &provider, &model, &temperature,
+			&tokensPrompt, &tokensCompletion, &latencyMs, &timestamp); err != nil {
+			return nil, err
+		}
+
+		results = append(results, map[string]interface{}{
+			"request_id":        requestID,
+			"block_id":          blockID,
+			"operation":         operation,
+			"provider":          provider,
+			"model":             model,
+			"temperature":       temperature,
+			"tokens_prompt":     tokensPrompt,
+			"tokens_completion": tokensCompletion,
+			"latency_ms":        latencyMs,
+			"timestamp":         timestamp,
+		})
+	}
+
+	return results, rows.Err()
+}
+
+// RecordSessionEvent appends an event to a session's log; blockID is empty
+// for an event of the whole session
+func (l *LifecycleDB) RecordSessionEvent(sessionID, blockID, kind, actor, detail string, temperature float64, tokens int) error {
+	_, err := l.db.Exec(`
+		INSERT INTO session_events (session_id, block_id, kind, actor, detail, temperature, tokens, timestamp)
+		VALUES (?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, 0), ?)
+	`, sessionID, blockID, kind, actor, detail, temperature, tokens, time.Now().Unix())
+	return err
+}
+
+// GetSessionEvents returns the events of a session in the order they were recorded
+func (l *LifecycleDB) GetSessionEvents(sessionID string) ([]map[string]interface{}, error) {
+	rows, err := l.db.Query(`
+		SELECT event_id, COALESCE(block_id, ''), kind, COALESCE(actor, ''), COALESCE(detail, ''),
+		       COALESCE(temperature, 0), COALESCE(tokens, 0), timestamp
+		FROM session_events
+		WHERE session_id = ?
+		ORDER BY event_id ASC
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var eventID, timestamp int64
+		var blockID, kind, actor, detail string
+		var temperature float64
+		var tokens int
+
+		if err := rows.Scan(&eventID, &blockID, &kind, &actor, &detail, &temperature, &tokens, &timestamp); err != nil {
+			return nil, err
+		}
+
+		results = append(results, map[string]interface{}{
+			"event_id":    eventID,
+			"session_id":  sessionID,
+			"block_id":    blockID,
+			"kind":        kind,
+			"actor":       actor,
+			"detail":      detail,
+			"temperature": temperature,
+			"tokens":      tokens,
+			"timestamp":   timestamp,
+		})
+	}
+
+	return results, rows.Err()
+}
+
+// GetConfig retrieves a runtime configuration value
+func (l *LifecycleDB) GetConfig(key string) (string, error) {
+	var value string
+	err := l.db.QueryRow(`
+		SELECT value FROM config WHERE key = ?
+	`, key).Scan(&value)
+	return value, err
+}
+
+// SetConfig stores a runtime configuration value
+func (l *LifecycleDB) SetConfig(key, value string) error {
+	_, err := l.db.Exec(`
+		INSERT OR REPLACE INTO config (key, value) VALUES (?, ?)
+	`, key, value)
 	return err
 }
@@ -30,19 +30,86 @@ func (m *MetadataDB) GetSecret(secretName string) (string, error) {
 	return secretValue, nil
 }
 
-// SetSecret stores or updates a secret
+// SetSecret stores or updates a secret. Updating a secret counts as a
+// rotation and re-enables it if it had been disabled.
 func (m *MetadataDB) SetSecret(secretName, secretValue string) error {
 	now := time.Now().Unix()
 
 	_, err := m.db.Exec(`
-		INSERT OR REPLACE INTO secrets
-		(secret_name, secret_value, created_at, last_rotated)
-		VALUES (?, ?, COALESCE((SELECT created_at FROM secrets WHERE secret_name = ?), ?), ?)
-	`, secretName, secretValue, secretName, now, now)
+		INSERT INTO secrets (secret_name, secret_value, created_at, last_rotated)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(secret_name) DO UPDATE SET
+			secret_value = excluded.secret_value,
+			last_rotated = excluded.last_rotated,
+			disabled = 0,
+			disabled_at = NULL,
+			disabled_reason = NULL
+	`, secretName, secretValue, now, now)
 
 	return err
 }
 
+// SetAPIKey stores or rotates a named API key in a provider's key pool
+func (m *MetadataDB) SetAPIKey(provider, secretName, secretValue string) error {
+	if err := m.SetSecret(secretName, secretValue); err != nil {
+		return err
+	}
+
+	_, err := m.db.Exec(`
+		UPDATE secrets SET provider = ? WHERE secret_name = ?
+	`, provider, secretName)
+	return err
+}
+
+// ListAPIKeys retrieves every key of a provider's pool, ordered by name
+func (m *MetadataDB) ListAPIKeys(provider string) ([]map[string]interface{}, error) {
+	rows, err := m.db.Query(`
+		SELECT secret_name, secret_value, last_rotated, disabled, disabled_reason
+		FROM secrets
+		WHERE provider = ?
+		ORDER BY secret_name ASC
+	`, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var name, value string
+		var lastRotated sql.NullInt64
+		var disabled sql.NullInt64
+		var reason sql.NullString
+
+		if err := rows.Scan(&name, &value, &lastRotated, &disabled, &reason); err != nil {
+			return nil, err
+		}
+
+		key := map[string]interface{}{
+			"secret_name":  name,
+			"secret_value": value,
+			"last_rotated": lastRotated.Int64,
+			"disabled":     disabled.Int64 == 1,
+		}
+		if reason.Valid {
+			key["disabled_reason"] = reason.String
+		}
+
+		results = append(results, key)
+	}
+
+	return results, rows.Err()
+}
+
+// DisableSecret marks a secret as disabled until it is rotated
+func (m *MetadataDB) DisableSecret(secretName, reason string) error {
+	_, err := m.db.Exec(`
+		UPDATE secrets SET disabled = 1, disabled_at = ?, disabled_reason = ?
+		WHERE secret_name = ?
+	`, time.Now().Unix(), reason, secretName)
+	return err
+}
+
 // RecordTelemetryEvent records a telemetry event
 func (m *MetadataDB) RecordTelemetryEvent(eventType, description string) error {
 	_, err := m.db.Exec(`
@@ -15,10 +15,11 @@ func NewOutputDB(db *sql.DB) *OutputDB {
 	return &OutputDB{db: db}
 }
 
-// PublishResult publishes a final result (committed session)
+// PublishResult publishes a final result (committed session), replacing the
+// previous one of a session committed again after a rollback
 func (o *OutputDB) PublishResult(hash, sessionID string, blocksCommitted int, dataJSON string) error {
 	_, err := o.db.Exec(`
-		INSERT INTO results (hash, session_id, blocks_committed, data_json, created_at)
+		INSERT OR REPLACE INTO results (hash, session_id, blocks_committed, data_json, created_at)
 		VALUES (?, ?, ?, ?, ?)
 	`, hash, sessionID, blocksCommitted, dataJSON, time.Now().Unix())
 	return err
@@ -160,3 +161,63 @@ func (o *OutputDB) GetAggregatedMetrics(since int64) (map[string]interface{}, er
 
 	return results, rows.Err()
 }
+
+// UpsertHealthCheck records the outcome of a health check
+func (o *OutputDB) UpsertHealthCheck(checkName, status string, failed bool, details string) error {
+	errorIncrement := 0
+	if failed {
+		errorIncrement = 1
+	}
+
+	_, err := o.db.Exec(`
+		INSERT INTO health_checks (check_name, status, last_check, check_count, error_count, details)
+		VALUES (?, ?, ?, 1, ?, ?)
+		ON CONFLICT(check_name) DO UPDATE SET
+			status = excluded.status,
+			last_check = excluded.last_check,
+			check_count = health_checks.check_count + 1,
+			error_count = health_checks.error_count + excluded.error_count,
+			details = excluded.details
+	`, checkName, status, time.Now().Unix(), errorIncrement, details)
+	return err
+}
+
+// GetHealthChecks retrieves all health checks
+func (o *OutputDB) GetHealthChecks() ([]map[string]interface{}, error) {
+	rows, err := o.db.Query(`
+		SELECT check_name, status, last_check, check_count, error_count, details
+		FROM health_checks
+		ORDER BY check_name ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var name, status string
+		var lastCheck int64
+		var checkCount, errorCount int
+		var details sql.NullString
+
+		if err := rows.Scan(&name, &status, &lastCheck, &checkCount, &errorCount, &details); err != nil {
+			return nil, err
+		}
+
+		check := map[string]interface{}{
+			"check_name":  name,
+			"status":      status,
+			"last_check":  lastCheck,
+			"check_count": checkCount,
+			"error_count": errorCount,
+		}
+		if details.Valid {
+			check["details"] = details.String
+		}
+
+		results = append(results, check)
+	}
+
+	return results, rows.Err()
+}
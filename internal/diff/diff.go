@@ -0,0 +1,251 @@
+// Package diff computes line diffs and renders them in unified format.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpKind is the kind of a diff operation
+type OpKind int
+
+const (
+	// Equal is a line present in both texts
+	Equal OpKind = iota
+	// Insert is a line only present in the new text
+	Insert
+	// Delete is a line only present in the old text
+	Delete
+)
+
+// Op is a single line of an edit script
+type Op struct {
+	Kind OpKind
+	Line string
+}
+
+// Stats counts changed lines
+type Stats struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// DefaultContext is the number of unchanged lines shown around each hunk
+const DefaultContext = 3
+
+// SplitLines splits text into lines without their terminators. A trailing
+// newline does not produce an empty last line.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Lines returns the shortest edit script turning a into b (Myers' algorithm)
+func Lines(a, b []string) []Op {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace back from (n, m) to (0, 0)
+	var ops []Op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		vd := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && vd[offset+k-1] < vd[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := vd[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, Op{Kind: Equal, Line: a[x-1]})
+			x--
+			y--
+		}
+
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, Op{Kind: Insert, Line: b[y-1]})
+			} else {
+				ops = append(ops, Op{Kind: Delete, Line: a[x-1]})
+			}
+		}
+
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
+
+// Stat counts the lines added and removed between two texts
+func Stat(oldText, newText string) Stats {
+	var stats Stats
+	for _, op := range Lines(SplitLines(oldText), SplitLines(newText)) {
+		switch op.Kind {
+		case Insert:
+			stats.Added++
+		case Delete:
+			stats.Removed++
+		}
+	}
+	return stats
+}
+
+// Unified renders the diff between two texts in unified format with the
+// given number of context lines. It returns "" when the texts are equal.
+func Unified(oldName, newName, oldText, newText string, context int) string {
+	if oldText == newText {
+		return ""
+	}
+	if context < 0 {
+		context = DefaultContext
+	}
+
+	ops := Lines(SplitLines(oldText), SplitLines(newText))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for _, h := range hunks(ops, context) {
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(h.oldStart, h.oldLines), hunkRange(h.newStart, h.newLines))
+		for _, op := range ops[h.from:h.to] {
+			switch op.Kind {
+			case Equal:
+				out.WriteString(" ")
+			case Insert:
+				out.WriteString("+")
+			case Delete:
+				out.WriteString("-")
+			}
+			out.WriteString(op.Line)
+			out.WriteString("\n")
+		}
+	}
+
+	return out.String()
+}
+
+// hunk is a range of ops with its position in both texts (1-based)
+type hunk struct {
+	from, to           int
+	oldStart, oldLines int
+	newStart, newLines int
+}
+
+// hunks groups changes separated by at most 2*context equal lines
+func hunks(ops []Op, context int) []hunk {
+	var result []hunk
+
+	// Line numbers before each op
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if op.Kind != Insert {
+			oldLine[i+1]++
+		}
+		if op.Kind != Delete {
+			newLine[i+1]++
+		}
+	}
+
+	i := 0
+	for i < len(ops) {
+		if ops[i].Kind == Equal {
+			i++
+			continue
+		}
+
+		from := i - context
+		if from < 0 {
+			from = 0
+		}
+
+		// Extend while the next change is close enough to share context
+		to := i
+		for to < len(ops) {
+			if ops[to].Kind != Equal {
+				to++
+				continue
+			}
+			run := to
+			for run < len(ops) && ops[run].Kind == Equal {
+				run++
+			}
+			if run == len(ops) || run-to > 2*context {
+				break
+			}
+			to = run
+		}
+		end := to + context
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		result = append(result, hunk{
+			from:     from,
+			to:       end,
+			oldStart: oldLine[from] + 1,
+			oldLines: oldLine[end] - oldLine[from],
+			newStart: newLine[from] + 1,
+			newLines: newLine[end] - newLine[from],
+		})
+
+		i = end
+	}
+
+	return result
+}
+
+// hunkRange formats a hunk range; an empty range points at the line before it
+func hunkRange(start, lines int) string {
+	if lines == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
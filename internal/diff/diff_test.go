@@ -0,0 +1,81 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLinesRoundTrip(t *testing.T) {
+	cases := []struct{ a, b string }{
+		{"", "a\nb\n"},
+		{"a\nb\n", ""},
+		{"a\nb\nc\n", "a\nc\n"},
+		{"a\nb\nc\n", "a\nx\nb\nc\ny\n"},
+		{"one\ntwo\nthree\nfour\n", "zero\ntwo\nthree\nfive\n"},
+	}
+
+	for _, tc := range cases {
+		ops := Lines(SplitLines(tc.a), SplitLines(tc.b))
+
+		var oldLines, newLines []string
+		for _, op := range ops {
+			if op.Kind != Insert {
+				oldLines = append(oldLines, op.Line)
+			}
+			if op.Kind != Delete {
+				newLines = append(newLines, op.Line)
+			}
+		}
+
+		if strings.Join(oldLines, "\n") != strings.Join(SplitLines(tc.a), "\n") ||
+			strings.Join(newLines, "\n") != strings.Join(SplitLines(tc.b), "\n") {
+			t.Errorf("Edit script for %q -> %q does not reproduce both texts: %+v", tc.a, tc.b, ops)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	oldText := "package main\n\nfunc main() {\n\tprintln(1)\n}\n"
+	newText := "package main\n\nfunc main() {\n\tprintln(2)\n}\n"
+
+	expected := "--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -1,5 +1,5 @@\n" +
+		" package main\n" +
+		" \n" +
+		" func main() {\n" +
+		"-\tprintln(1)\n" +
+		"+\tprintln(2)\n" +
+		" }\n"
+	if got := Unified("a/main.go", "b/main.go", oldText, newText, 3); got != expected {
+		t.Errorf("Unexpected diff:\n%s", got)
+	}
+
+	if got := Unified("a", "b", oldText, oldText, 3); got != "" {
+		t.Errorf("Expected empty diff for equal texts, got %q", got)
+	}
+}
+
+func TestUnifiedSeparateHunks(t *testing.T) {
+	var oldLines, newLines []string
+	for i := 0; i < 20; i++ {
+		line := string(rune('a' + i))
+		oldLines = append(oldLines, line)
+		newLines = append(newLines, line)
+	}
+	newLines[1] = "B"
+	newLines[18] = "S"
+
+	got := Unified("old", "new", strings.Join(oldLines, "\n"), strings.Join(newLines, "\n"), 2)
+	if strings.Count(got, "@@ -") != 2 {
+		t.Errorf("Expected 2 hunks, got:\n%s", got)
+	}
+	if !strings.Contains(got, "@@ -1,4 +1,4 @@") || !strings.Contains(got, "@@ -17,4 +17,4 @@") {
+		t.Errorf("Unexpected hunk headers:\n%s", got)
+	}
+
+	stats := Stat(strings.Join(oldLines, "\n"), strings.Join(newLines, "\n"))
+	if stats.Added != 2 || stats.Removed != 2 {
+		t.Errorf("Expected 2 added / 2 removed, got %+v", stats)
+	}
+}
@@ -0,0 +1,366 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Patch formats accepted by ParsePatch
+const (
+	FormatUnified       = "unified"
+	FormatSearchReplace = "search_replace"
+)
+
+// maxFuzz is the number of context lines that may be dropped at each end of a
+// hunk when its full context cannot be found
+const maxFuzz = 2
+
+// Hunk is one change of a patch. Equal ops are context, Delete ops the lines
+// replaced and Insert ops the replacement.
+type Hunk struct {
+	OldStart int // 1-based line of the hunk in the original text; 0 when unknown
+	Ops      []Op
+}
+
+// Patch is a parsed set of hunks
+type Patch struct {
+	Format string
+	Hunks  []Hunk
+}
+
+// HunkResult describes where a hunk was applied
+type HunkResult struct {
+	Line  int  `json:"line"`            // 1-based line where the hunk starts in the patched text
+	Fuzz  int  `json:"fuzz,omitempty"`  // context lines dropped at each end to find it
+	Loose bool `json:"loose,omitempty"` // matched ignoring leading and trailing whitespace
+}
+
+// Conflict is a hunk that could not be applied
+type Conflict struct {
+	Hunk   int    `json:"hunk"` // 1-based
+	Reason string `json:"reason"`
+}
+
+// ConflictError reports the hunks of a patch that do not apply
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	parts := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		parts[i] = fmt.Sprintf("hunk %d: %s", c.Hunk, c.Reason)
+	}
+	return "patch does not apply (" + strings.Join(parts, "; ") + ")"
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// ParsePatch parses a unified diff or SEARCH/REPLACE blocks:
+//
+//	<<<<<<< SEARCH
+//	lines to find
+//	=======
+//	replacement
+//	>>>>>>> REPLACE
+//
+// Text outside hunks (file headers, prose) is ignored.
+func ParsePatch(text string) (*Patch, error) {
+	if strings.Contains(text, "<<<<<<< SEARCH") {
+		return parseSearchReplace(text)
+	}
+	return parseUnified(text)
+}
+
+// parseUnified parses the hunks of a unified diff
+func parseUnified(text string) (*Patch, error) {
+	patch := &Patch{Format: FormatUnified}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var current *Hunk
+	for i, line := range lines {
+		if m := hunkHeader.FindStringSubmatch(line); m != nil {
+			start, _ := strconv.Atoi(m[1])
+			patch.Hunks = append(patch.Hunks, Hunk{OldStart: start})
+			current = &patch.Hunks[len(patch.Hunks)-1]
+			continue
+		}
+
+		// A file header ends the current hunk
+		if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") ||
+			strings.HasPrefix(line, "+++ ") && current == nil || strings.HasPrefix(line, "diff ") {
+			current = nil
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+"):
+			current.Ops = append(current.Ops, Op{Kind: Insert, Line: line[1:]})
+		case strings.HasPrefix(line, "-"):
+			current.Ops = append(current.Ops, Op{Kind: Delete, Line: line[1:]})
+		case strings.HasPrefix(line, " "):
+			current.Ops = append(current.Ops, Op{Kind: Equal, Line: line[1:]})
+		case line == "":
+			// Blank context lines often lose their leading space
+			current.Ops = append(current.Ops, Op{Kind: Equal, Line: ""})
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file"
+		default:
+			current = nil
+		}
+	}
+
+	for i := range patch.Hunks {
+		patch.Hunks[i].Ops = trimTrailingBlank(patch.Hunks[i].Ops)
+	}
+	if len(patch.Hunks) == 0 {
+		return nil, fmt.Errorf("no hunks found in unified diff")
+	}
+
+	return patch, nil
+}
+
+// parseSearchReplace parses SEARCH/REPLACE blocks
+func parseSearchReplace(text string) (*Patch, error) {
+	patch := &Patch{Format: FormatSearchReplace}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	const (
+		outside = iota
+		search
+		replace
+	)
+	state := outside
+	var current Hunk
+
+	for _, line := range lines {
+		marker := strings.TrimSpace(line)
+		switch {
+		case state == outside && strings.HasPrefix(marker, "<<<<<<< SEARCH"):
+			state = search
+			current = Hunk{}
+		case state == search && marker == "=======":
+			state = replace
+		case state == replace && strings.HasPrefix(marker, ">>>>>>> REPLACE"):
+			if len(current.Ops) == 0 || current.Ops[0].Kind != Delete {
+				return nil, fmt.Errorf("block %d: empty SEARCH section", len(patch.Hunks)+1)
+			}
+			patch.Hunks = append(patch.Hunks, current)
+			state = outside
+		case state == search:
+			current.Ops = append(current.Ops, Op{Kind: Delete, Line: line})
+		case state == replace:
+			current.Ops = append(current.Ops, Op{Kind: Insert, Line: line})
+		}
+	}
+
+	if state != outside {
+		return nil, fmt.Errorf("unterminated SEARCH/REPLACE block")
+	}
+	if len(patch.Hunks) == 0 {
+		return nil, fmt.Errorf("no SEARCH/REPLACE blocks found")
+	}
+
+	return patch, nil
+}
+
+// trimTrailingBlank drops blank context lines picked up after the last change
+func trimTrailingBlank(ops []Op) []Op {
+	for len(ops) > 0 && ops[len(ops)-1].Kind == Equal && ops[len(ops)-1].Line == "" {
+		ops = ops[:len(ops)-1]
+	}
+	return ops
+}
+
+// oldLines returns the lines a hunk expects to find
+func (h Hunk) oldLines() []string {
+	var lines []string
+	for _, op := range h.Ops {
+		if op.Kind != Insert {
+			lines = append(lines, op.Line)
+		}
+	}
+	return lines
+}
+
+// newLines returns the lines a hunk leaves in place
+func (h Hunk) newLines() []string {
+	var lines []string
+	for _, op := range h.Ops {
+		if op.Kind != Delete {
+			lines = append(lines, op.Line)
+		}
+	}
+	return lines
+}
+
+// trimContext drops up to n context lines at each end of the hunk
+func (h Hunk) trimContext(n int) (Hunk, int) {
+	ops := h.Ops
+	lead := 0
+	for lead < n && len(ops) > 0 && ops[0].Kind == Equal {
+		ops = ops[1:]
+		lead++
+	}
+	for trail := 0; trail < n && len(ops) > 0 && ops[len(ops)-1].Kind == Equal; trail++ {
+		ops = ops[:len(ops)-1]
+	}
+	return Hunk{OldStart: h.OldStart + lead, Ops: ops}, lead
+}
+
+// Apply applies a patch to text. Each hunk is located exactly first, then
+// ignoring surrounding whitespace, then with up to maxFuzz context lines
+// dropped at each end; when several places match, the one closest to the
+// hunk's line number wins. Hunks that cannot be located, or that match several
+// places equally well, are reported as conflicts and nothing is applied.
+func Apply(text string, patch *Patch) (string, []HunkResult, error) {
+	lines := SplitLines(text)
+	results := make([]HunkResult, 0, len(patch.Hunks))
+	var conflicts []Conflict
+
+	offset := 0
+	for i, hunk := range patch.Hunks {
+		pos, result, reason := locate(lines, hunk, offset)
+		if reason != "" {
+			conflicts = append(conflicts, Conflict{Hunk: i + 1, Reason: reason})
+			continue
+		}
+
+		applied, _ := hunk.trimContext(result.Fuzz)
+		old, replacement := applied.oldLines(), applied.newLines()
+
+		updated := make([]string, 0, len(lines)-len(old)+len(replacement))
+		updated = append(updated, lines[:pos]...)
+		updated = append(updated, replacement...)
+		updated = append(updated, lines[pos+len(old):]...)
+		lines = updated
+
+		result.Line = pos + 1
+		results = append(results, result)
+		offset += len(replacement) - len(old)
+	}
+
+	if len(conflicts) > 0 {
+		return "", nil, &ConflictError{Conflicts: conflicts}
+	}
+
+	patched := strings.Join(lines, "\n")
+	if len(lines) > 0 && (text == "" || strings.HasSuffix(text, "\n")) {
+		patched += "\n"
+	}
+	return patched, results, nil
+}
+
+// locate finds where a hunk applies, returning a 0-based line index
+func locate(lines []string, hunk Hunk, offset int) (int, HunkResult, string) {
+	// Pure insertion: only the line number tells where; "@@ -n,0" inserts after line n
+	if len(hunk.oldLines()) == 0 {
+		pos := hunk.OldStart + offset
+		if pos < 0 {
+			pos = 0
+		}
+		if pos > len(lines) {
+			pos = len(lines)
+		}
+		return pos, HunkResult{}, ""
+	}
+
+	expected := -1
+	if hunk.OldStart > 0 {
+		expected = hunk.OldStart - 1 + offset
+	}
+
+	for fuzz := 0; fuzz <= maxFuzz; fuzz++ {
+		trimmed, lead := hunk.trimContext(fuzz)
+		old := trimmed.oldLines()
+		if len(old) == 0 || (fuzz > 0 && len(trimmed.Ops) == len(hunk.Ops)) {
+			break
+		}
+
+		hint := expected
+		if hint >= 0 {
+			hint += lead
+		}
+
+		for _, loose := range []bool{false, true} {
+			if fuzz > 0 && !loose {
+				continue
+			}
+
+			matches := findAll(lines, old, loose)
+			if len(matches) == 0 {
+				continue
+			}
+
+			pos, ambiguous := closest(matches, hint)
+			if ambiguous {
+				return 0, HunkResult{}, fmt.Sprintf("ambiguous: the lines to replace appear %d times", len(matches))
+			}
+			return pos, HunkResult{Fuzz: fuzz, Loose: loose}, ""
+		}
+	}
+
+	return 0, HunkResult{}, fmt.Sprintf("lines to replace not found (starting with %q)", firstLine(hunk.oldLines()))
+}
+
+// findAll returns every index where needle occurs in lines
+func findAll(lines, needle []string, loose bool) []int {
+	var matches []int
+	for i := 0; i+len(needle) <= len(lines); i++ {
+		match := true
+		for j, want := range needle {
+			got := lines[i+j]
+			if loose {
+				got, want = strings.TrimSpace(got), strings.TrimSpace(want)
+			}
+			if got != want {
+				match = false
+				break
+			}
+		}
+		if match {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// closest picks the match nearest to hint; without a hint a single match is required
+func closest(matches []int, hint int) (int, bool) {
+	if len(matches) == 1 {
+		return matches[0], false
+	}
+	if hint < 0 {
+		return 0, true
+	}
+
+	best, bestDistance, tie := matches[0], -1, false
+	for _, m := range matches {
+		distance := m - hint
+		if distance < 0 {
+			distance = -distance
+		}
+		switch {
+		case bestDistance < 0 || distance < bestDistance:
+			best, bestDistance, tie = m, distance, false
+		case distance == bestDistance:
+			tie = true
+		}
+	}
+	return best, tie
+}
+
+// firstLine returns the first non-blank line, for conflict messages
+func firstLine(lines []string) string {
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
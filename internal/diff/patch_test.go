@@ -0,0 +1,110 @@
+package diff
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const source = `package store
+
+import "database/sql"
+
+// Open opens the database
+func Open(path string) (*sql.DB, error) {
+	return sql.Open("sqlite", path)
+}
+
+// Close closes the database
+func Close(db *sql.DB) error {
+	return db.Close()
+}
+`
+
+func TestApplyUnifiedRoundTrip(t *testing.T) {
+	edited := strings.Replace(source, `return sql.Open("sqlite", path)`, "db, err := sql.Open(\"sqlite\", path)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n\treturn db, nil", 1)
+	edited = strings.Replace(edited, "// Close closes the database", "// Close closes the database handle", 1)
+
+	patch, err := ParsePatch(Unified("a/store.go", "b/store.go", source, edited, DefaultContext))
+	if err != nil {
+		t.Fatalf("ParsePatch failed: %v", err)
+	}
+	if patch.Format != FormatUnified || len(patch.Hunks) != 1 {
+		t.Fatalf("Expected one unified hunk, got %+v", patch)
+	}
+
+	got, results, err := Apply(source, patch)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if got != edited {
+		t.Errorf("Patched text differs:\n%s", got)
+	}
+	if results[0].Fuzz != 0 || results[0].Loose {
+		t.Errorf("Expected an exact match, got %+v", results[0])
+	}
+}
+
+func TestApplyUnifiedWithShiftedLinesAndFuzz(t *testing.T) {
+	// Line numbers are off and the last context line no longer matches
+	patch, err := ParsePatch(`--- a/store.go
++++ b/store.go
+@@ -40,4 +40,4 @@
+ // Close closes the database
+ func Close(db *sql.DB) error {
+-	return db.Close()
++	return fmt.Errorf("close: %w", db.Close())
+ } // end
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, results, err := Apply(source, patch)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !strings.Contains(got, `return fmt.Errorf("close: %w", db.Close())`) || results[0].Fuzz != 1 {
+		t.Errorf("Expected a fuzzy application, got %+v:\n%s", results, got)
+	}
+}
+
+func TestApplySearchReplace(t *testing.T) {
+	patch, err := ParsePatch("Here is the change:\n\n<<<<<<< SEARCH\n  func Close(db *sql.DB) error {\n=======\nfunc Close(db *sql.DB) (err error) {\n>>>>>>> REPLACE\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, results, err := Apply(source, patch)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !strings.Contains(got, "func Close(db *sql.DB) (err error) {") || !results[0].Loose {
+		t.Errorf("Expected a whitespace-insensitive match, got %+v:\n%s", results, got)
+	}
+}
+
+func TestApplyConflicts(t *testing.T) {
+	patch, _ := ParsePatch("<<<<<<< SEARCH\nfunc Missing() {}\n=======\n>>>>>>> REPLACE\n<<<<<<< SEARCH\n}\n=======\n};\n>>>>>>> REPLACE\n")
+
+	_, _, err := Apply(source, patch)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || len(conflict.Conflicts) != 2 {
+		t.Fatalf("Expected two conflicts, got %v", err)
+	}
+	if !strings.Contains(conflict.Conflicts[0].Reason, "not found") || !strings.Contains(conflict.Conflicts[1].Reason, "ambiguous") {
+		t.Errorf("Unexpected conflict reasons: %+v", conflict.Conflicts)
+	}
+}
+
+func TestParsePatchErrors(t *testing.T) {
+	for _, text := range []string{
+		"no patch here",
+		"<<<<<<< SEARCH\nfoo\n=======\nbar\n",
+		"<<<<<<< SEARCH\n=======\nbar\n>>>>>>> REPLACE\n",
+	} {
+		if _, err := ParsePatch(text); err == nil {
+			t.Errorf("Expected an error for %q", text)
+		}
+	}
+}
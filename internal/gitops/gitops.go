@@ -0,0 +1,257 @@
+// Package gitops records files in a local git repository through the git CLI.
+// It never fetches or pushes.
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// commandTimeout bounds every git invocation
+const commandTimeout = 30 * time.Second
+
+// BranchPrefix prefixes the branch of every session
+const BranchPrefix = "brainloop/"
+
+// ErrNotRepository is returned when a path is not inside a git work tree
+var ErrNotRepository = errors.New("not inside a git work tree")
+
+// Repo is a git work tree
+type Repo struct {
+	Root string // top-level directory, symlinks resolved
+}
+
+// SessionBranch returns the branch the commits of a session go to
+func SessionBranch(sessionID string) string {
+	return BranchPrefix + sessionID
+}
+
+// Available reports whether the git CLI is installed
+func Available() bool {
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
+// Open returns the work tree containing path, which may not exist yet
+func Open(path string) (*Repo, error) {
+	dir, err := existingDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := run(dir, nil, "", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotRepository)
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repo{Root: root}, nil
+}
+
+// Rel returns the slash-separated path of a file relative to the work tree
+func (r *Repo) Rel(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir, err := existingDir(abs)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	rest, _ := filepath.Rel(dir, abs)
+
+	rel, err := filepath.Rel(r.Root, filepath.Join(resolved, rest))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the work tree %s", path, r.Root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Head returns the commit HEAD points to, or "" when the repository has no commit yet
+func (r *Repo) Head() string {
+	head, err := run(r.Root, nil, "", "rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	if err != nil {
+		return ""
+	}
+	return head
+}
+
+// ShowHead returns the content of a file at HEAD; false when HEAD does not have it
+func (r *Repo) ShowHead(path string) (string, bool, error) {
+	rel, err := r.Rel(path)
+	if err != nil {
+		return "", false, err
+	}
+	if r.Head() == "" {
+		return "", false, nil
+	}
+	if entry, err := run(r.Root, nil, "", "ls-tree", "HEAD", "--", rel); err != nil || entry == "" {
+		return "", false, err
+	}
+
+	content, err := output(r.Root, nil, "", "cat-file", "blob", "HEAD:"+rel)
+	if err != nil {
+		return "", false, err
+	}
+	return content, true, nil
+}
+
+// CommitFiles records the current content of paths in a commit on branch,
+// created from HEAD when it does not exist. The work tree, the index and the
+// checked-out branch are left alone: the commit is built in a temporary
+// index. Files with an execute bit are recorded as executable and paths that
+// no longer exist are removed from the branch. It returns "" and creates
+// nothing when the content is already recorded.
+func (r *Repo) CommitFiles(branch, message string, paths []string) (string, error) {
+	ref := "refs/heads/" + branch
+	parent, _ := run(r.Root, nil, "", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	previous := parent
+	if parent == "" {
+		parent = r.Head()
+	}
+
+	index, err := os.CreateTemp("", "brainloop-index-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary index: %w", err)
+	}
+	index.Close()
+	os.Remove(index.Name()) // git refuses an empty index file
+	defer os.Remove(index.Name())
+	env := []string{"GIT_INDEX_FILE=" + index.Name()}
+
+	if parent != "" {
+		_, err = run(r.Root, env, "", "read-tree", parent)
+	} else {
+		_, err = run(r.Root, env, "", "read-tree", "--empty")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range paths {
+		rel, err := r.Rel(path)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			if _, err := run(r.Root, env, "", "update-index", "--force-remove", "--", rel); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		mode := "100644"
+		if info.Mode().Perm()&0111 != 0 {
+			mode = "100755"
+		}
+		blob, err := run(r.Root, nil, "", "hash-object", "-w", "--", rel)
+		if err != nil {
+			return "", err
+		}
+		if _, err := run(r.Root, env, "", "update-index", "--add", "--cacheinfo", mode+","+blob+","+rel); err != nil {
+			return "", err
+		}
+	}
+
+	tree, err := run(r.Root, env, "", "write-tree")
+	if err != nil {
+		return "", err
+	}
+	if parent != "" {
+		if parentTree, _ := run(r.Root, nil, "", "rev-parse", parent+"^{tree}"); parentTree == tree {
+			return "", nil
+		}
+	}
+
+	args := []string{"commit-tree", tree, "-F", "-"}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	commit, err := run(r.Root, nil, message, args...)
+	if err != nil {
+		return "", err
+	}
+
+	// Only move the branch from where it was read, so a concurrent commit is not lost
+	old := previous
+	if old == "" {
+		old = strings.Repeat("0", len(commit))
+	}
+	if _, err := run(r.Root, nil, "", "update-ref", "-m", "brainloop: "+firstLine(message), ref, commit, old); err != nil {
+		return "", err
+	}
+
+	return commit, nil
+}
+
+// run executes git in dir and returns its trimmed output
+func run(dir string, env []string, stdin string, args ...string) (string, error) {
+	out, err := output(dir, env, stdin, args...)
+	return strings.TrimSpace(out), err
+}
+
+// output executes git in dir and returns its raw output
+func output(dir string, env []string, stdin string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		detail := strings.TrimSpace(stderr.String())
+		if detail == "" {
+			detail = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], detail)
+	}
+	return stdout.String(), nil
+}
+
+// existingDir returns the closest existing directory containing path
+func existingDir(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, nil
+		}
+		if dir == filepath.Dir(dir) {
+			return "", fmt.Errorf("no existing directory contains %s", path)
+		}
+	}
+}
+
+// firstLine returns the first line of text
+func firstLine(text string) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
@@ -0,0 +1,140 @@
+package gitops
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newRepo initialises a work tree with one commit of a.txt
+func newRepo(t *testing.T) string {
+	t.Helper()
+	if !Available() {
+		t.Skip("git not installed")
+	}
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "brainloop")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "brainloop@localhost")
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\n"), 0644)
+	git(t, dir, "init", "-q", "-b", "main")
+	git(t, dir, "add", "a.txt")
+	git(t, dir, "commit", "-q", "-m", "initial")
+	return dir
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestCommitFilesOnBranch(t *testing.T) {
+	dir := newRepo(t)
+	repo, err := Open(filepath.Join(dir, "sub", "new.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a2\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "sub", "new.txt"), []byte("new\n"), 0644)
+
+	branch := SessionBranch("s1")
+	first, err := repo.CommitFiles(branch, "Add new\n\nBrainloop-Session: s1\n", []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub", "new.txt")})
+	if err != nil || first == "" {
+		t.Fatalf("Expected a commit, got %q, %v", first, err)
+	}
+
+	// The checked-out branch, the index and the work tree are untouched
+	if current := git(t, dir, "rev-parse", "--abbrev-ref", "HEAD"); current != "main" {
+		t.Errorf("Expected main still checked out, got %s", current)
+	}
+	if staged := git(t, dir, "diff", "--cached", "--name-only"); staged != "" {
+		t.Errorf("Expected nothing staged, got %q", staged)
+	}
+	if status := git(t, dir, "status", "--porcelain"); !strings.Contains(status, "M a.txt") || !strings.Contains(status, "?? sub/") {
+		t.Errorf("Expected the work tree changes left in place, got %q", status)
+	}
+
+	if parent := git(t, dir, "rev-parse", branch+"^"); parent != repo.Head() {
+		t.Errorf("Expected the branch created from HEAD, got parent %s", parent)
+	}
+	if files := git(t, dir, "show", "--name-only", "--format=", branch); files != "a.txt\nsub/new.txt" {
+		t.Errorf("Expected both files in the commit, got %q", files)
+	}
+	if trailer := git(t, dir, "log", "-1", "--format=%(trailers:key=Brainloop-Session,valueonly)", branch); trailer != "s1" {
+		t.Errorf("Expected the session trailer, got %q", trailer)
+	}
+
+	// Nothing changed: no commit; a change stacks on the branch
+	if again, err := repo.CommitFiles(branch, "again", []string{filepath.Join(dir, "a.txt")}); err != nil || again != "" {
+		t.Errorf("Expected no commit for unchanged content, got %q, %v", again, err)
+	}
+	os.Remove(filepath.Join(dir, "sub", "new.txt"))
+	second, err := repo.CommitFiles(branch, "Remove new", []string{filepath.Join(dir, "sub", "new.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent := git(t, dir, "rev-parse", branch+"^"); parent != first || second != git(t, dir, "rev-parse", branch) {
+		t.Errorf("Expected the second commit on top of the first, got parent %s", parent)
+	}
+	if files := git(t, dir, "ls-tree", "-r", "--name-only", branch); files != "a.txt" {
+		t.Errorf("Expected the removed file dropped from the branch, got %q", files)
+	}
+}
+
+func TestCommitFilesKeepsExecutableMode(t *testing.T) {
+	dir := newRepo(t)
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\necho hi\n"), 0755)
+	os.Chmod(filepath.Join(dir, "run.sh"), 0755) // the umask may have dropped the execute bits
+	branch := SessionBranch("s1")
+	if _, err := repo.CommitFiles(branch, "Add scripts", []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "run.sh")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry := git(t, dir, "ls-tree", branch, "run.sh"); !strings.HasPrefix(entry, "100755 ") {
+		t.Errorf("Expected run.sh recorded as executable, got %q", entry)
+	}
+	if entry := git(t, dir, "ls-tree", branch, "a.txt"); !strings.HasPrefix(entry, "100644 ") {
+		t.Errorf("Expected a.txt recorded as a regular file, got %q", entry)
+	}
+}
+
+func TestShowHead(t *testing.T) {
+	dir := newRepo(t)
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed\n"), 0644)
+	if content, ok, err := repo.ShowHead(filepath.Join(dir, "a.txt")); err != nil || !ok || content != "a\n" {
+		t.Errorf("Expected the committed content, got %q %v %v", content, ok, err)
+	}
+	if _, ok, err := repo.ShowHead(filepath.Join(dir, "missing.txt")); err != nil || ok {
+		t.Errorf("Expected a file absent from HEAD, got %v %v", ok, err)
+	}
+	if _, err := repo.Rel(filepath.Join(filepath.Dir(dir), "other.txt")); err == nil {
+		t.Error("Expected a path outside the work tree to be refused")
+	}
+
+	if _, err := Open(t.TempDir()); !errors.Is(err, ErrNotRepository) {
+		t.Errorf("Expected ErrNotRepository outside a work tree, got %v", err)
+	}
+}
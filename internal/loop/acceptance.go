@@ -0,0 +1,312 @@
+package loop
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"brainloop/internal/bash"
+)
+
+// Acceptance test kinds
+const (
+	testGo    = "go"    // body of a _test.go file placed next to the target, run with go test
+	testShell = "shell" // one-line command run at the root of the scratch workspace
+)
+
+// Acceptance test statuses
+const (
+	testPassed = "passed"
+	testFailed = "failed"
+	testError  = "error" // the scratch workspace could not be prepared
+)
+
+const (
+	// acceptanceTestFile is the name of the test file written for go tests
+	acceptanceTestFile = "brainloop_acceptance_test.go"
+
+	// maxScratchFiles bounds the size of a workspace copied for a test run
+	maxScratchFiles = 5000
+)
+
+// scratchSkipDirs are not copied into a scratch workspace
+var scratchSkipDirs = map[string]bool{".git": true, "node_modules": true, defaultBackupDir: true}
+
+// AcceptanceTest is a test a block's code must pass. Root is the workspace
+// copied for each run; by default the nearest directory with a go.mod above
+// the target, else the target's directory.
+type AcceptanceTest struct {
+	Kind string `json:"kind"` // 'go' | 'shell'
+	Code string `json:"code"`
+	Root string `json:"root,omitempty"`
+}
+
+// TestResult is the outcome of an acceptance test run
+type TestResult struct {
+	Status     string `json:"status"` // 'passed' | 'failed' | 'error'
+	ExitCode   int    `json:"exit_code"`
+	Output     string `json:"output,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	TimedOut   bool   `json:"timed_out,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	CodeHash   string `json:"code_hash"` // hash of the code the test ran against
+	TestedAt   int64  `json:"tested_at"`
+}
+
+// checkAcceptanceTest validates a block's acceptance test before it is stored
+func checkAcceptanceTest(test *AcceptanceTest, blockType string) error {
+	switch test.Kind {
+	case testGo:
+		if blockType == "sql" {
+			return fmt.Errorf("go acceptance tests need a go, edit, code or files block")
+		}
+	case testShell:
+		if strings.ContainsAny(test.Code, "\r\n") {
+			return fmt.Errorf("shell acceptance tests must be a single line")
+		}
+	default:
+		return fmt.Errorf("unknown acceptance test kind %q: expected go or shell", test.Kind)
+	}
+	if strings.TrimSpace(test.Code) == "" {
+		return fmt.Errorf("acceptance test code is empty")
+	}
+	return nil
+}
+
+// runAcceptanceTest writes the block's code, after its uncommitted
+// dependencies, to a scratch copy of the workspace, runs its acceptance test
+// through the bash executor and stores the result on the block
+func (m *Manager) runAcceptanceTest(block Block, dependencies []Block) (*TestResult, error) {
+	if block.Test == nil {
+		return nil, nil
+	}
+
+	result := executeAcceptanceTest(block, dependencies)
+	result.CodeHash = block.CodeHash
+	result.TestedAt = time.Now().Unix()
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal test result: %w", err)
+	}
+	if err := m.lifecycleDB.SetBlockTestResult(block.BlockID, string(resultJSON)); err != nil {
+		return nil, fmt.Errorf("failed to store test result: %w", err)
+	}
+
+	return result, nil
+}
+
+// executeAcceptanceTest prepares the scratch workspace and runs the test
+func executeAcceptanceTest(block Block, dependencies []Block) *TestResult {
+	root, err := testRoot(block)
+	if err != nil {
+		return &TestResult{Status: testError, Output: err.Error()}
+	}
+
+	scratch, err := os.MkdirTemp("", "brainloop-test-")
+	if err != nil {
+		return &TestResult{Status: testError, Output: fmt.Sprintf("failed to create scratch workspace: %v", err)}
+	}
+	defer os.RemoveAll(scratch)
+
+	command, err := prepareScratch(root, scratch, block, dependencies)
+	if err != nil {
+		return &TestResult{Status: testError, Output: err.Error()}
+	}
+
+	run := bash.NewExecutor().WithWorkingDir(scratch).Execute(command)
+
+	result := &TestResult{
+		Status:     testPassed,
+		ExitCode:   run.ExitCode,
+		DurationMs: run.DurationMs,
+		TimedOut:   run.WasTimeout,
+		Truncated:  run.WasTruncated,
+		Output:     strings.TrimSpace(strings.ReplaceAll(run.Stdout+run.Stderr, scratch, "<workspace>")),
+	}
+	if run.ExitCode != 0 || run.Error != "" {
+		result.Status = testFailed
+		if run.Error != "" {
+			result.Output = strings.TrimSpace(result.Output + "\n" + run.Error)
+		}
+	}
+
+	return result
+}
+
+// testRoot returns the workspace a block's test runs in
+func testRoot(block Block) (string, error) {
+	if block.Test.Root != "" {
+		return filepath.Abs(block.Test.Root)
+	}
+	return projectRoot(block.Type, block.Target)
+}
+
+// projectRoot returns the nearest directory with a go.mod above a block's
+// target, else the target's directory
+func projectRoot(blockType, target string) (string, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(target)
+	if blockType == "files" {
+		dir = target
+	}
+
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d, nil
+		}
+		if filepath.Dir(d) == d {
+			return dir, nil
+		}
+	}
+}
+
+// prepareScratch copies the workspace, stages the candidate code into the
+// copy and returns the command running the test
+func prepareScratch(root, scratch string, block Block, dependencies []Block) (string, error) {
+	if err := copyTree(root, scratch); err != nil {
+		return "", fmt.Errorf("failed to copy workspace %s: %w", root, err)
+	}
+
+	stage := newSessionStage()
+	for _, dep := range dependencies {
+		if dep.Status != "committed" && dep.Code != "" {
+			if err := stage.add(dep); err != nil {
+				return "", fmt.Errorf("failed to stage dependency %s: %w", dep.BlockID, err)
+			}
+		}
+	}
+	if err := stage.add(block); err != nil {
+		return "", fmt.Errorf("failed to stage candidate: %w", err)
+	}
+
+	for _, path := range stage.fileOrder {
+		dst, err := scratchPath(root, scratch, path)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(dst, []byte(stage.files[path]), 0644); err != nil {
+			return "", err
+		}
+	}
+	for _, dbPath := range stage.dbOrder {
+		dst, err := scratchPath(root, scratch, dbPath)
+		if err != nil {
+			dst = filepath.Join(scratch, filepath.Base(dbPath))
+		}
+		if _, err := os.Stat(dbPath); err == nil {
+			if err := snapshotDatabase(dbPath, dst); err != nil {
+				return "", fmt.Errorf("failed to copy %s: %w", dbPath, err)
+			}
+		}
+		for _, script := range stage.scripts[dbPath] {
+			if err := executeScript(dst, script); err != nil {
+				return "", fmt.Errorf("candidate SQL failed on the copy of %s: %w", dbPath, err)
+			}
+		}
+	}
+
+	if block.Test.Kind == testShell {
+		return block.Test.Code, nil
+	}
+	return writeGoTest(root, scratch, block, stage)
+}
+
+// writeGoTest writes the test file next to the target and returns the go test command
+func writeGoTest(root, scratch string, block Block, stage *sessionStage) (string, error) {
+	target, err := filepath.Abs(block.Target)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(target)
+	if block.Type == "files" {
+		dir = target
+	}
+
+	testDir, err := scratchPath(root, scratch, dir)
+	if err != nil {
+		return "", err
+	}
+	rel, _ := filepath.Rel(scratch, testDir)
+
+	code := block.Test.Code
+	if !strings.HasPrefix(strings.TrimSpace(code), "package ") {
+		pkg := packageName(stage.files[target])
+		if pkg == "" {
+			return "", fmt.Errorf("go acceptance test has no package clause and the target package is unknown")
+		}
+		code = "package " + pkg + "\n\n" + code
+	}
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(testDir, acceptanceTestFile), []byte(code), 0644); err != nil {
+		return "", err
+	}
+
+	return "go test -count=1 ./" + filepath.ToSlash(rel), nil
+}
+
+// scratchPath maps a workspace path to its copy
+func scratchPath(root, scratch, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the test workspace %s", path, root)
+	}
+	return filepath.Join(scratch, rel), nil
+}
+
+// packageName returns the package clause of Go source
+func packageName(src string) string {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	return file.Name.Name
+}
+
+// copyTree copies the regular files of a directory tree
+func copyTree(src, dst string) error {
+	count := 0
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel != "." && scratchSkipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		count++
+		if count > maxScratchFiles {
+			return fmt.Errorf("more than %d files: set the test root to a smaller directory", maxScratchFiles)
+		}
+		return copyFile(path, filepath.Join(dst, rel))
+	})
+}
+
+// testFeedback describes a failed acceptance test for the next refine
+func testFeedback(result *TestResult) string {
+	return fmt.Sprintf("The acceptance test %s (exit code %d). Make it pass. Test output:\n%s", result.Status, result.ExitCode, result.Output)
+}
@@ -0,0 +1,315 @@
+package loop
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultAuditPrompt is the audit prompt of audit_code and of the auto mode
+const DefaultAuditPrompt = "Analyze this code for: bugs, security issues, performance problems, code quality, best practices violations, and potential improvements. Provide detailed feedback."
+
+// defaultAutoIterations is the number of refines per block of an auto run
+const defaultAutoIterations = 3
+
+// Stop reasons of an auto run
+const (
+	stopConverged     = "converged"
+	stopMaxIterations = "max_iterations"
+	stopTokenBudget   = "token_budget"
+)
+
+// findingsInstruction asks the auditor for a machine-readable list of findings
+const findingsInstruction = "End your audit with a JSON array of findings in a ```json fence, each as " +
+	`{"severity": "critical|high|medium|low", "issue": "...", "fix": "..."}` +
+	". Use an empty array when nothing needs fixing."
+
+// Auto runs the audit-refine cycle on the uncommitted blocks of a session, in
+// dependency order. Each round runs the validation gate and an LLM audit of
+// the block; the block is refined with the findings until the gate and the
+// block's acceptance test pass and the audit reports no critical or
+// high-severity finding, or until MaxIterations refines or the token budget
+// are spent. Nothing is committed. Refines store their code like Refine, so a
+// block changed by another caller during the run ends it with a conflict.
+func (m *Manager) Auto(req AutoRequest) (*AutoResponse, error) {
+	if err := m.requireActiveSession(req.SessionID); err != nil {
+		return nil, err
+	}
+	if req.MaxIterations <= 0 {
+		req.MaxIterations = defaultAutoIterations
+	}
+	if req.AuditPrompt == "" {
+		req.AuditPrompt = DefaultAuditPrompt
+	}
+
+	blocks, err := m.autoBlocks(req)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &AutoResponse{SessionID: req.SessionID}
+	for _, blockID := range blocks {
+		result, err := m.autoBlock(blockID, req, response)
+		if err != nil {
+			return nil, err
+		}
+		response.Blocks = append(response.Blocks, result)
+	}
+
+	converged := 0
+	for _, b := range response.Blocks {
+		if b.Converged {
+			converged++
+		}
+	}
+	response.Message = fmt.Sprintf("%d of %d block(s) converged in %d round(s); review each block and commit it with its code_hash",
+		converged, len(response.Blocks), len(response.Rounds))
+
+	return response, nil
+}
+
+// autoBlocks returns the uncommitted blocks of an auto run, dependencies first
+func (m *Manager) autoBlocks(req AutoRequest) ([]string, error) {
+	blocks, err := m.storage.GetSessionBlocks(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]BlockInput, len(blocks))
+	for i, b := range blocks {
+		inputs[i] = BlockInput{ID: b.BlockID, DependsOn: b.DependsOn}
+	}
+	levels, err := dependencyLevels(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, level := range levels {
+		for _, i := range level {
+			b := blocks[i]
+			if b.Status == "committed" || (req.BlockID != "" && b.BlockID != req.BlockID) {
+				continue
+			}
+			if b.Status == "failed" {
+				if req.BlockID != "" {
+					return nil, failedBlock(b)
+				}
+				continue
+			}
+			ids = append(ids, b.BlockID)
+		}
+	}
+
+	if req.BlockID != "" && len(ids) == 0 {
+		return nil, fmt.Errorf("block %s is not an uncommitted block of session %s", req.BlockID, req.SessionID)
+	}
+
+	return ids, nil
+}
+
+// autoBlock audits and refines one block, appending its rounds to the response
+func (m *Manager) autoBlock(blockID string, req AutoRequest, response *AutoResponse) (AutoBlockResult, error) {
+	result := AutoBlockResult{BlockID: blockID}
+
+	for round := 1; ; round++ {
+		block, err := m.getBlock(blockID)
+		if err != nil {
+			return result, err
+		}
+		result.CodeHash = block.CodeHash
+
+		if req.TokenBudget > 0 && response.TokensUsed >= req.TokenBudget {
+			result.StopReason = stopTokenBudget
+			return result, nil
+		}
+
+		report, err := m.runGate(block, block.Code)
+		if err != nil {
+			return result, err
+		}
+
+		audit, tokens, err := m.auditBlock(block, req.AuditPrompt)
+		if err != nil {
+			return result, fmt.Errorf("failed to audit block %s: %w", blockID, err)
+		}
+		response.TokensUsed += tokens
+
+		findings, parsed := parseFindings(audit)
+		m.recordEvent(req.SessionID, blockID, eventAudited, auditDetail(findings, parsed), 0.3, tokens)
+		entry := AutoRound{
+			BlockID:    blockID,
+			Round:      round,
+			CodeHash:   block.CodeHash,
+			Valid:      report.Valid,
+			GateErrors: gateErrors(report.Report),
+			Findings:   findings,
+			Unparsed:   !parsed,
+			Tokens:     tokens,
+		}
+		if block.TestResult != nil && block.TestResult.CodeHash == block.CodeHash {
+			entry.TestStatus = block.TestResult.Status
+		}
+
+		// A stale block was built on older dependency code and is refined at least once
+		if report.Valid && parsed && !block.Stale && testPasses(block) && !hasBlockingFinding(findings) {
+			response.Rounds = append(response.Rounds, entry)
+			result.Converged, result.StopReason = true, stopConverged
+			return result, nil
+		}
+		if result.Refines >= req.MaxIterations {
+			response.Rounds = append(response.Rounds, entry)
+			result.StopReason = stopMaxIterations
+			return result, nil
+		}
+		if req.TokenBudget > 0 && response.TokensUsed >= req.TokenBudget {
+			response.Rounds = append(response.Rounds, entry)
+			result.StopReason = stopTokenBudget
+			return result, nil
+		}
+
+		var unparsedAudit string
+		if !parsed {
+			unparsedAudit = audit
+		}
+		refined, err := m.Refine(RefineRequest{
+			SessionID:     req.SessionID,
+			BlockID:       blockID,
+			AuditFeedback: autoFeedback(findings, entry.GateErrors, unparsedAudit, block.Stale),
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to refine block %s: %w", blockID, err)
+		}
+
+		entry.Refined = true
+		entry.Tokens += refined.TokensUsed
+		response.TokensUsed += refined.TokensUsed
+		response.Rounds = append(response.Rounds, entry)
+		result.Refines++
+	}
+}
+
+// auditBlock asks the LLM auditor to review a block's code
+func (m *Manager) auditBlock(block Block, auditPrompt string) (string, int, error) {
+	prompt := fmt.Sprintf("%s\n\nBlock: %s\nTarget: %s\n\n```%s\n%s\n```\n\n%s",
+		auditPrompt, block.Description, block.Target, fenceLanguage(block.Type), block.Code, findingsInstruction)
+
+	release := m.acquireGeneration()
+	result, err := m.cerebras.ForAction("audit_code").GenerateCodeWithTemperature(prompt, "markdown", nil, 0.3)
+	release()
+	if err != nil {
+		return "", 0, err
+	}
+
+	m.recordUsage("audit_code", block.BlockID, result)
+
+	return result.Content, result.PromptTokens + result.CompletionTokens, nil
+}
+
+// parseFindings extracts the findings list that ends an audit, from its last
+// json fence or, failing that, from the last JSON array of findings in the
+// text. Unfenced, only an array of objects with a severity counts: prose such
+// as []byte is not an empty findings list.
+func parseFindings(audit string) ([]AuditFinding, bool) {
+	var findings []AuditFinding
+	parsed := false
+
+	if start := strings.LastIndex(audit, "```json"); start >= 0 {
+		raw := audit[start+len("```json"):]
+		if end := strings.Index(raw, "```"); end >= 0 {
+			raw = raw[:end]
+		}
+		parsed = json.Unmarshal([]byte(strings.TrimSpace(raw)), &findings) == nil
+	} else if end := strings.LastIndex(audit, "]"); end >= 0 {
+		// Widen from the last opening bracket until the text is an array
+		for start := strings.LastIndex(audit[:end], "["); start >= 0 && !parsed; start = strings.LastIndex(audit[:start], "[") {
+			findings = nil
+			parsed = isFindingsArray(audit[start:end+1]) && json.Unmarshal([]byte(audit[start:end+1]), &findings) == nil
+		}
+	}
+	if !parsed {
+		return nil, false
+	}
+
+	if findings == nil {
+		findings = []AuditFinding{}
+	}
+	for i := range findings {
+		findings[i].Severity = strings.ToLower(strings.TrimSpace(findings[i].Severity))
+	}
+
+	return findings, true
+}
+
+// isFindingsArray reports whether raw is a non-empty JSON array of objects
+// that each have a severity
+func isFindingsArray(raw string) bool {
+	var elements []map[string]json.RawMessage
+	if json.Unmarshal([]byte(raw), &elements) != nil || len(elements) == 0 {
+		return false
+	}
+	for _, e := range elements {
+		if _, ok := e["severity"]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// testPasses reports whether a block without acceptance test, or whose test
+// passed on its current code, can converge
+func testPasses(block Block) bool {
+	if block.Test == nil {
+		return true
+	}
+	result := block.TestResult
+	return result != nil && result.Status == testPassed && result.CodeHash == block.CodeHash
+}
+
+// hasBlockingFinding reports whether a finding is critical or high severity
+func hasBlockingFinding(findings []AuditFinding) bool {
+	for _, f := range findings {
+		if f.Severity == "critical" || f.Severity == "high" {
+			return true
+		}
+	}
+	return false
+}
+
+// auditDetail summarises an automatic audit for the event log
+func auditDetail(findings []AuditFinding, parsed bool) string {
+	if !parsed {
+		return "automatic audit, findings not parsed"
+	}
+	if len(findings) == 0 {
+		return "automatic audit: no findings"
+	}
+	issues := make([]string, len(findings))
+	for i, f := range findings {
+		issues[i] = fmt.Sprintf("[%s] %s", f.Severity, f.Issue)
+	}
+	return "automatic audit: " + strings.Join(issues, "; ")
+}
+
+// autoFeedback turns a round's findings into refine feedback
+func autoFeedback(findings []AuditFinding, gateErrors []string, unparsedAudit string, stale bool) string {
+	var b strings.Builder
+	b.WriteString("An automatic review of the code found issues. Fix them:")
+	if stale {
+		b.WriteString("\n- a dependency changed since this block was generated: update the code to match its current version")
+	}
+	for _, e := range gateErrors {
+		fmt.Fprintf(&b, "\n- validation %s", e)
+	}
+	for _, f := range findings {
+		fmt.Fprintf(&b, "\n- [%s] %s", f.Severity, f.Issue)
+		if f.Fix != "" {
+			fmt.Fprintf(&b, " (fix: %s)", f.Fix)
+		}
+	}
+	if unparsedAudit != "" {
+		b.WriteString("\n\nAudit:\n")
+		b.WriteString(unparsedAudit)
+	}
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package loop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFindings(t *testing.T) {
+	audit := "## Audit\n\nThe loop [0..n] is off by one.\n\n```json\n" +
+		`[{"severity": "High", "issue": "off by one", "fix": "use <"}, {"severity": "low", "issue": "naming"}]` +
+		"\n```\n"
+
+	findings, ok := parseFindings(audit)
+	if !ok || len(findings) != 2 {
+		t.Fatalf("Expected 2 findings, got %v (parsed %v)", findings, ok)
+	}
+	if findings[0].Severity != "high" || findings[0].Fix != "use <" {
+		t.Errorf("Expected a normalized high finding, got %+v", findings[0])
+	}
+	if !hasBlockingFinding(findings) {
+		t.Error("Expected a high finding to block")
+	}
+}
+
+func TestParseFindingsWithoutFence(t *testing.T) {
+	findings, ok := parseFindings("Looks fine, see [1].\n\nFindings: [{\"severity\": \"medium\", \"issue\": \"no docs\"}]")
+	if !ok || len(findings) != 1 || findings[0].Severity != "medium" {
+		t.Fatalf("Expected the trailing array, got %v (parsed %v)", findings, ok)
+	}
+	if hasBlockingFinding(findings) {
+		t.Error("Expected a medium finding not to block")
+	}
+
+	if findings, ok := parseFindings("```json\n[]\n```"); !ok || findings == nil || len(findings) != 0 {
+		t.Errorf("Expected an empty findings list, got %v (parsed %v)", findings, ok)
+	}
+	if _, ok := parseFindings("The code has a race condition."); ok {
+		t.Error("Expected an audit without findings list to be unparsed")
+	}
+	if _, ok := parseFindings("Critical: Write copies the []byte it is given.\nReturn []string instead."); ok {
+		t.Error("Expected Go slice types in prose not to parse as an empty findings list")
+	}
+}
+
+func TestAutoFeedback(t *testing.T) {
+	feedback := autoFeedback(
+		[]AuditFinding{{Severity: "high", Issue: "nil map write", Fix: "make the map"}},
+		[]string{"types: undefined: x"},
+		"",
+		true,
+	)
+
+	for _, want := range []string{"dependency changed", "validation types: undefined: x", "[high] nil map write (fix: make the map)"} {
+		if !strings.Contains(feedback, want) {
+			t.Errorf("Expected feedback to contain %q, got %q", want, feedback)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package loop
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"brainloop/internal/workspace"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// backupDirConfig is the config key of the directory holding commit backups
+	backupDirConfig = "backup_dir"
+
+	// defaultBackupDir holds commit backups next to the databases
+	defaultBackupDir = "brainloop.backups"
+)
+
+// Backup kinds
+const (
+	backupFile   = "file"   // prior bytes of a file
+	backupSQLite = "sqlite" // VACUUM INTO snapshot of a database
+	backupAbsent = "absent" // the target did not exist before the commit
+)
+
+// commitTarget is a path a commit is about to modify
+type commitTarget struct {
+	Path     string
+	Database bool
+}
+
+// Backup is the prior state of a commit target
+type Backup struct {
+	BackupID   string `json:"backup_id"`
+	CommitID   string `json:"commit_id"`
+	BlockID    string `json:"block_id"`
+	Target     string `json:"target"`
+	Kind       string `json:"kind"` // 'file' | 'sqlite' | 'absent'
+	BackupPath string `json:"backup_path,omitempty"`
+	BackupHash string `json:"backup_hash,omitempty"`
+
+	committedHash string
+}
+
+// Rollback restores the state before the commits of a block, or of every block
+// of the session when no block is given. Targets are restored newest commit
+// first. Before anything is touched, every backup is verified against its
+// hash (and SQLite snapshots with PRAGMA integrity_check), and every target
+// must still be in the state its commit left it in, unless Force is set.
+func (m *Manager) Rollback(req RollbackRequest) (*RollbackResponse, error) {
+	defer m.lockSession(req.SessionID)()
+
+	if req.BlockID != "" {
+		block, err := m.getBlock(req.BlockID)
+		if err != nil {
+			return nil, err
+		}
+		if block.SessionID != req.SessionID {
+			return nil, fmt.Errorf("block %s does not belong to session %s", req.BlockID, req.SessionID)
+		}
+	}
+
+	rows, err := m.lifecycleDB.GetCommitBackups(req.SessionID, req.BlockID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve backups: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("nothing to roll back: no committed changes with backups")
+	}
+
+	backups := make([]Backup, len(rows))
+	for i, row := range rows {
+		backups[i] = mapToBackup(row)
+	}
+
+	// Integrity checks, simulating the restores in order
+	expected := make(map[string]string)
+	for _, b := range backups {
+		current, ok := expected[b.Target]
+		if !ok {
+			if current, _, err = hashFile(b.Target); err != nil {
+				return nil, fmt.Errorf("failed to hash %s: %w", b.Target, err)
+			}
+		}
+		if current != b.committedHash && !req.Force {
+			return nil, fmt.Errorf("%s changed since block %s committed it; roll back later commits first or use force", b.Target, b.BlockID)
+		}
+		if err := verifyBackup(b); err != nil {
+			return nil, fmt.Errorf("backup of %s for block %s is unusable: %w", b.Target, b.BlockID, err)
+		}
+		expected[b.Target] = b.BackupHash
+	}
+
+	response := &RollbackResponse{SessionID: req.SessionID}
+	for _, b := range backups {
+		if err := restoreBackup(b); err != nil {
+			return nil, m.rollbackFailed(req.SessionID, backups, response,
+				fmt.Errorf("failed to restore %s (%d of %d targets restored): %w", b.Target, len(response.Restored), len(backups), err))
+		}
+
+		// The restored target must be exactly the backed up state
+		restored, _, err := hashFile(b.Target)
+		if err != nil || restored != b.BackupHash {
+			return nil, m.rollbackFailed(req.SessionID, backups, response, fmt.Errorf("restored %s does not match its backup", b.Target))
+		}
+
+		if err := m.lifecycleDB.MarkBackupRestored(b.BackupID); err != nil {
+			return nil, m.rollbackFailed(req.SessionID, backups, response, fmt.Errorf("failed to mark backup restored: %w", err))
+		}
+		response.Restored = append(response.Restored, b)
+	}
+
+	if err := m.uncommitRestored(req.SessionID, backups, response); err != nil {
+		return nil, err
+	}
+
+	if err := m.syncSessionStatus(req.SessionID); err != nil {
+		return nil, err
+	}
+
+	response.Success = true
+	response.Message = fmt.Sprintf("Restored %d target(s) of %d block(s)", len(response.Restored), len(response.Blocks))
+	return response, nil
+}
+
+// uncommitRestored returns every block whose backups were all restored to
+// the pending state, and lists them in the response
+func (m *Manager) uncommitRestored(sessionID string, backups []Backup, response *RollbackResponse) error {
+	restored := make(map[string]bool, len(response.Restored))
+	for _, b := range response.Restored {
+		restored[b.BackupID] = true
+	}
+	complete := make(map[string]bool)
+	var order []string
+	for _, b := range backups {
+		if _, seen := complete[b.BlockID]; !seen {
+			complete[b.BlockID] = true
+			order = append(order, b.BlockID)
+		}
+		complete[b.BlockID] = complete[b.BlockID] && restored[b.BackupID]
+	}
+
+	for _, blockID := range order {
+		if !complete[blockID] {
+			continue
+		}
+		block, err := m.getBlock(blockID)
+		if err != nil {
+			return err
+		}
+		if err := m.lifecycleDB.UncommitBlock(blockID, calculateHash(sessionID, blockID, block.Code)); err != nil {
+			return fmt.Errorf("failed to reset block %s: %w", blockID, err)
+		}
+		response.Blocks = append(response.Blocks, blockID)
+
+		var targets []string
+		for _, b := range response.Restored {
+			if b.BlockID == blockID {
+				targets = append(targets, b.Target)
+			}
+		}
+		m.recordEvent(sessionID, blockID, eventRolledBack, "restored "+strings.Join(targets, ", "), 0, 0)
+	}
+	return nil
+}
+
+// rollbackFailed resets the blocks a failed rollback fully restored, so a
+// retry only has the remaining blocks left, and returns cause
+func (m *Manager) rollbackFailed(sessionID string, backups []Backup, response *RollbackResponse, cause error) error {
+	if err := m.uncommitRestored(sessionID, backups, response); err != nil {
+		return fmt.Errorf("%w; %v", cause, err)
+	}
+	if err := m.syncSessionStatus(sessionID); err != nil {
+		return fmt.Errorf("%w; %v", cause, err)
+	}
+	if len(response.Blocks) > 0 {
+		return fmt.Errorf("%w; block(s) %s were fully restored and are pending again", cause, strings.Join(response.Blocks, ", "))
+	}
+	return cause
+}
+
+// restoreCommit undoes a commit whose writes landed but could not be
+// recorded: every target goes back to its backed up state
+func (m *Manager) restoreCommit(backups []Backup) {
+	touched := make(map[string]bool, len(backups))
+	for _, b := range backups {
+		touched[b.Target] = true
+	}
+	m.undoSessionCommit(backups, touched)
+}
+
+// commitTargets lists the paths a commit of code to block will modify
+func commitTargets(block Block, code string) ([]commitTarget, error) {
+	switch block.Type {
+	case "sql":
+		return []commitTarget{{Path: block.Target, Database: true}}, nil
+	case "files":
+		files, err := blockFiles(code)
+		if err != nil {
+			return nil, err
+		}
+		targets := make([]commitTarget, 0, len(files))
+		for _, f := range files {
+			path, err := workspace.Resolve(block.Target, f.Path)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, commitTarget{Path: path})
+		}
+		return targets, nil
+	default:
+		return []commitTarget{{Path: block.Target}}, nil
+	}
+}
+
+// backupTargets stores the prior state of every commit target before it is modified
+func (m *Manager) backupTargets(block Block, targets []commitTarget) ([]Backup, error) {
+	dir, _ := m.lifecycleDB.GetConfig(backupDirConfig)
+	if dir == "" {
+		dir = defaultBackupDir
+	}
+	dir = filepath.Join(dir, block.SessionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	commitID := uuid.New().String()
+	backups := make([]Backup, 0, len(targets))
+	for _, target := range targets {
+		b := Backup{BackupID: uuid.New().String(), CommitID: commitID, BlockID: block.BlockID, Target: target.Path}
+
+		_, exists, err := hashFile(target.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", target.Path, err)
+		}
+
+		switch {
+		case !exists:
+			b.Kind = backupAbsent
+		case target.Database:
+			b.Kind = backupSQLite
+			b.BackupPath = filepath.Join(dir, b.BackupID+".db")
+			if err := snapshotDatabase(target.Path, b.BackupPath); err != nil {
+				return nil, fmt.Errorf("failed to snapshot %s: %w", target.Path, err)
+			}
+		default:
+			b.Kind = backupFile
+			b.BackupPath = filepath.Join(dir, b.BackupID+".bak")
+			if err := copyFile(target.Path, b.BackupPath); err != nil {
+				return nil, fmt.Errorf("failed to back up %s: %w", target.Path, err)
+			}
+		}
+
+		if b.BackupPath != "" {
+			if b.BackupHash, _, err = hashFile(b.BackupPath); err != nil {
+				return nil, fmt.Errorf("failed to hash backup of %s: %w", target.Path, err)
+			}
+		}
+
+		if err := m.lifecycleDB.AddCommitBackup(b.BackupID, commitID, block.SessionID, block.BlockID, b.Target, b.Kind, b.BackupPath, b.BackupHash); err != nil {
+			return nil, fmt.Errorf("failed to record backup: %w", err)
+		}
+		backups = append(backups, b)
+	}
+
+	return backups, nil
+}
+
+// recordCommittedHashes stores the state each target was left in by the commit
+func (m *Manager) recordCommittedHashes(backups []Backup) error {
+	for _, b := range backups {
+		hash, _, err := hashFile(b.Target)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", b.Target, err)
+		}
+		if err := m.lifecycleDB.SetBackupCommittedHash(b.BackupID, hash); err != nil {
+			return fmt.Errorf("failed to record committed hash: %w", err)
+		}
+	}
+	return nil
+}
+
+// discardBackups undoes whatever a failed commit changed and retires its
+// backups. SQL runs in a transaction, so databases are left as they are.
+func (m *Manager) discardBackups(backups []Backup) {
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		if b.Kind == backupSQLite {
+			m.lifecycleDB.MarkBackupRestored(b.BackupID)
+			continue
+		}
+		if current, _, err := hashFile(b.Target); err == nil && current != b.BackupHash {
+			restoreBackup(b)
+		}
+		m.lifecycleDB.MarkBackupRestored(b.BackupID)
+	}
+}
+
+// verifyBackup checks a backup before it is restored
+func verifyBackup(b Backup) error {
+	if b.Kind == backupAbsent {
+		return nil
+	}
+
+	hash, exists, err := hashFile(b.BackupPath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("backup file %s is missing", b.BackupPath)
+	}
+	if hash != b.BackupHash {
+		return fmt.Errorf("backup file %s does not match its recorded hash", b.BackupPath)
+	}
+
+	if b.Kind == backupSQLite {
+		return integrityCheck(b.BackupPath)
+	}
+	return nil
+}
+
+// restoreBackup puts a target back in its backed up state
+func restoreBackup(b Backup) error {
+	switch b.Kind {
+	case backupAbsent:
+		for _, path := range []string{b.Target, b.Target + "-wal", b.Target + "-shm"} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+
+	case backupSQLite:
+		// Stale WAL frames would be replayed over the restored snapshot
+		for _, path := range []string{b.Target + "-wal", b.Target + "-shm"} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	data, err := os.ReadFile(b.BackupPath)
+	if err != nil {
+		return err
+	}
+	return workspace.WriteFileAtomic(b.Target, data, 0644)
+}
+
+// snapshotDatabase writes a consistent copy of a SQLite database
+func snapshotDatabase(src, dst string) error {
+	db, err := sql.Open("sqlite", src)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`VACUUM INTO ?`, dst)
+	return err
+}
+
+// integrityCheck runs PRAGMA integrity_check on a database file
+func integrityCheck(path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
+
+// copyFile copies a file's bytes
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+// hashFile returns the hex SHA256 of a file, or "" when it does not exist
+func hashFile(path string) (string, bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", true, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true, nil
+}
+
+// mapToBackup converts map to Backup struct
+func mapToBackup(data map[string]interface{}) Backup {
+	return Backup{
+		BackupID:      data["backup_id"].(string),
+		CommitID:      data["commit_id"].(string),
+		BlockID:       data["block_id"].(string),
+		Target:        data["target"].(string),
+		Kind:          data["kind"].(string),
+		BackupPath:    data["backup_path"].(string),
+		BackupHash:    data["backup_hash"].(string),
+		committedHash: data["committed_hash"].(string),
+	}
+}
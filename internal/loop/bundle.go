@@ -0,0 +1,595 @@
+package loop
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// bundleFormat identifies session bundles
+	bundleFormat = "brainloop-session"
+
+	// bundleVersion is bumped when a bundle can no longer be read by older versions
+	bundleVersion = 1
+
+	// bundleEntry is the bundle inside a tar.gz archive; the other entries are
+	// the code of each block, for reading
+	bundleEntry = "bundle.json"
+)
+
+// SessionBundle is a session with everything needed to replay it on another
+// worker: its blocks, their full refinement history, validation and test
+// results, the usage of the calls made for them and its event log
+type SessionBundle struct {
+	Format     string         `json:"format"`
+	Version    int            `json:"version"`
+	ExportedAt int64          `json:"exported_at"`
+	Session    BundleSession  `json:"session"`
+	Blocks     []BundleBlock  `json:"blocks"`
+	Usage      []BundleUsage  `json:"usage"`
+	Events     []SessionEvent `json:"events,omitempty"`
+}
+
+// BundleSession is the session row of a bundle
+type BundleSession struct {
+	SessionID    string `json:"session_id"`
+	Status       string `json:"status"`
+	CreatedAt    int64  `json:"created_at"`
+	CompletedAt  int64  `json:"completed_at,omitempty"`
+	ProjectRoot  string `json:"project_root,omitempty"`
+	ImportedFrom string `json:"imported_from,omitempty"`
+}
+
+// BundleBlock is a block of a bundle, with the stored validation and test
+// reports as they are
+type BundleBlock struct {
+	BlockID       string          `json:"block_id"`
+	Description   string          `json:"description"`
+	Type          string          `json:"type"`
+	Target        string          `json:"target"`
+	Code          string          `json:"code,omitempty"`
+	InitialCode   string          `json:"initial_code,omitempty"`
+	PendingCode   string          `json:"pending_code,omitempty"`
+	Iterations    int             `json:"iterations"`
+	Version       int             `json:"version"`
+	Status        string          `json:"status"`
+	Error         string          `json:"error,omitempty"`
+	Stale         bool            `json:"stale,omitempty"`
+	PatternID     string          `json:"pattern_id,omitempty"`
+	GeneratedAt   int64           `json:"generated_at"`
+	LastRefinedAt int64           `json:"last_refined_at,omitempty"`
+	CommittedAt   int64           `json:"committed_at,omitempty"`
+	DependsOn     []string        `json:"depends_on,omitempty"`
+	Validation    json.RawMessage `json:"validation,omitempty"`
+	Test          json.RawMessage `json:"test,omitempty"`
+	TestResult    json.RawMessage `json:"test_result,omitempty"`
+	Refinements   []Refinement    `json:"refinements,omitempty"`
+}
+
+// BundleUsage is a usage record of a call made for a block of the bundle
+type BundleUsage struct {
+	RequestID        string  `json:"request_id"`
+	BlockID          string  `json:"block_id"`
+	Operation        string  `json:"operation"`
+	Provider         string  `json:"provider,omitempty"`
+	Model            string  `json:"model"`
+	Temperature      float64 `json:"temperature"`
+	TokensPrompt     int     `json:"tokens_prompt"`
+	TokensCompletion int     `json:"tokens_completion"`
+	LatencyMs        int     `json:"latency_ms"`
+	Timestamp        int64   `json:"timestamp"`
+}
+
+// Export serialises a session into a bundle, written to path or returned
+// inline. Commit backups stay behind: they are files of this worker.
+func (m *Manager) Export(req ExportRequest) (*ExportResponse, error) {
+	format, err := exportFormat(req.Format, req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := m.sessionBundle(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	if format == "tar.gz" {
+		if data, err = archiveBundle(bundle, data); err != nil {
+			return nil, err
+		}
+	}
+
+	response := &ExportResponse{
+		SessionID: req.SessionID,
+		Format:    format,
+		Path:      req.Path,
+		Size:      len(data),
+		Blocks:    len(bundle.Blocks),
+		Usage:     len(bundle.Usage),
+	}
+	for _, block := range bundle.Blocks {
+		response.Refinements += len(block.Refinements)
+	}
+
+	if req.Path == "" {
+		response.Bundle = bundle
+		return response, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(req.Path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", req.Path, err)
+	}
+	if err := os.WriteFile(req.Path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	return response, nil
+}
+
+// exportFormat resolves the bundle format, from the path extension when not given
+func exportFormat(format, path string) (string, error) {
+	if format == "" {
+		format = "json"
+		if strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") {
+			format = "tar.gz"
+		}
+	}
+	switch format {
+	case "json":
+	case "tar.gz":
+		if path == "" {
+			return "", fmt.Errorf("a tar.gz bundle needs a path to be written to")
+		}
+	default:
+		return "", fmt.Errorf("unknown bundle format %q: expected json or tar.gz", format)
+	}
+	return format, nil
+}
+
+// sessionBundle reads a session and its history into a bundle
+func (m *Manager) sessionBundle(sessionID string) (*SessionBundle, error) {
+	defer m.lockSession(sessionID)()
+
+	session, err := m.storage.LoadSession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve session: %w", err)
+	}
+
+	bundle := &SessionBundle{
+		Format:     bundleFormat,
+		Version:    bundleVersion,
+		ExportedAt: time.Now().Unix(),
+		Session: BundleSession{
+			SessionID:    session.SessionID,
+			Status:       session.Status,
+			CreatedAt:    session.CreatedAt,
+			CompletedAt:  session.CompletedAt,
+			ProjectRoot:  session.ProjectRoot,
+			ImportedFrom: session.ImportedFrom,
+		},
+		Blocks: []BundleBlock{},
+		Usage:  []BundleUsage{},
+	}
+
+	blockIDs, err := m.lifecycleDB.GetSessionBlockIDs(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocks: %w", err)
+	}
+	for _, blockID := range blockIDs {
+		block, err := m.bundleBlock(blockID)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Blocks = append(bundle.Blocks, block)
+	}
+
+	if bundle.Events, err = m.sessionEvents(sessionID); err != nil {
+		return nil, err
+	}
+
+	usage, err := m.lifecycleDB.GetSessionUsage(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve usage: %w", err)
+	}
+	for _, row := range usage {
+		bundle.Usage = append(bundle.Usage, mapToUsage(row))
+	}
+
+	return bundle, nil
+}
+
+// bundleBlock reads a block, its dependencies and refinements
+func (m *Manager) bundleBlock(blockID string) (BundleBlock, error) {
+	data, err := m.lifecycleDB.GetBlock(blockID)
+	if err != nil {
+		return BundleBlock{}, fmt.Errorf("failed to retrieve block %s: %w", blockID, err)
+	}
+
+	text := func(key string) string {
+		value, _ := data[key].(string)
+		return value
+	}
+	raw := func(key string) json.RawMessage {
+		if value := text(key); value != "" && json.Valid([]byte(value)) {
+			return json.RawMessage(value)
+		}
+		return nil
+	}
+
+	block := BundleBlock{
+		BlockID:     blockID,
+		Description: text("description"),
+		Type:        text("type"),
+		Target:      text("target"),
+		Code:        text("code"),
+		InitialCode: text("initial_code"),
+		PendingCode: text("pending_code"),
+		Iterations:  data["iterations"].(int),
+		Version:     data["version"].(int),
+		Status:      text("status"),
+		Error:       text("generation_error"),
+		Stale:       data["stale"].(bool),
+		PatternID:   text("pattern_id"),
+		GeneratedAt: data["generated_at"].(int64),
+		Validation:  raw("validation_json"),
+		Test:        raw("test_json"),
+		TestResult:  raw("test_result_json"),
+	}
+	block.LastRefinedAt, _ = data["last_refined_at"].(int64)
+	block.CommittedAt, _ = data["committed_at"].(int64)
+
+	if block.DependsOn, err = m.lifecycleDB.GetBlockDependencies(blockID); err != nil {
+		return BundleBlock{}, fmt.Errorf("failed to retrieve dependencies of block %s: %w", blockID, err)
+	}
+	if block.Refinements, err = m.getRefinements(blockID); err != nil {
+		return BundleBlock{}, fmt.Errorf("failed to retrieve refinements of block %s: %w", blockID, err)
+	}
+
+	return block, nil
+}
+
+// archiveBundle packs the bundle and the code of each block into a tar.gz
+func archiveBundle(bundle *SessionBundle, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	write := func(name string, content []byte) error {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(content)),
+			ModTime: time.Unix(bundle.ExportedAt, 0),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+
+	if err := write(bundleEntry, data); err != nil {
+		return nil, fmt.Errorf("failed to archive bundle: %w", err)
+	}
+	for _, block := range bundle.Blocks {
+		if block.Code == "" {
+			continue
+		}
+		name := "blocks/" + strings.ReplaceAll(block.BlockID, "/", "_") + codeExtension(block.Type)
+		if err := write(name, []byte(block.Code)); err != nil {
+			return nil, fmt.Errorf("failed to archive block %s: %w", block.BlockID, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to archive bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress bundle: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// codeExtension is the file extension of the code of a block type
+func codeExtension(blockType string) string {
+	switch blockType {
+	case "go", "sql":
+		return "." + blockType
+	case "python":
+		return ".py"
+	case "edit":
+		return ".diff"
+	}
+	return ".txt"
+}
+
+// Import creates a session from a bundle, with a new session ID. Block IDs
+// are kept unless already taken on this worker, in which case they get a
+// random suffix. Committed blocks return to 'pending', so the session can be
+// replayed here. A session already imported, or exported from this worker,
+// is refused unless on_conflict is 'copy'.
+func (m *Manager) Import(req ImportRequest) (*ImportResponse, error) {
+	switch req.OnConflict {
+	case "", "fail", "copy":
+	default:
+		return nil, fmt.Errorf("unknown on_conflict %q: expected fail or copy", req.OnConflict)
+	}
+
+	var data []byte
+	switch {
+	case req.Path != "":
+		var err error
+		if data, err = os.ReadFile(req.Path); err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+	case len(req.Bundle) > 0:
+		data = req.Bundle
+	default:
+		return nil, fmt.Errorf("missing path or bundle")
+	}
+
+	bundle, err := readBundle(data)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := bundle.Session.SessionID
+	defer m.lockSession(origin)()
+
+	if req.OnConflict != "copy" {
+		existing, err := m.lifecycleDB.FindImportedSessions(origin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up session %s: %w", origin, err)
+		}
+		if len(existing) > 0 {
+			return nil, fmt.Errorf("session %s is already on this worker as %s; import it again with on_conflict copy",
+				origin, strings.Join(existing, ", "))
+		}
+	}
+
+	response := &ImportResponse{
+		SessionID:    uuid.New().String(),
+		ImportedFrom: origin,
+		BlockIDs:     make(map[string]string, len(bundle.Blocks)),
+		Blocks:       len(bundle.Blocks),
+		Usage:        len(bundle.Usage),
+	}
+	for _, block := range bundle.Blocks {
+		taken, err := m.lifecycleDB.BlockExists(block.BlockID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up block %s: %w", block.BlockID, err)
+		}
+		response.BlockIDs[block.BlockID] = block.BlockID
+		if taken {
+			response.BlockIDs[block.BlockID] = fmt.Sprintf("%s-%s", block.BlockID, uuid.New().String()[:8])
+		}
+	}
+
+	session, blocks, dependencies, refinements, usage, events := importRows(bundle, response)
+	if err := m.lifecycleDB.ImportSession(session, blocks, dependencies, refinements, usage, events); err != nil {
+		return nil, fmt.Errorf("failed to import session %s: %w", origin, err)
+	}
+	m.recordEvent(response.SessionID, "", eventImported, fmt.Sprintf("from session %s", origin), 0, 0)
+
+	response.Refinements = len(refinements)
+	response.Message = fmt.Sprintf("imported session %s as %s: %d block(s), %d refinement(s), %d usage record(s)",
+		origin, response.SessionID, response.Blocks, response.Refinements, response.Usage)
+	if len(response.Reopened) > 0 {
+		response.Message += fmt.Sprintf("; %d committed block(s) back to pending, commit them to replay the session", len(response.Reopened))
+	}
+
+	return response, nil
+}
+
+// readBundle decodes a json or tar.gz bundle and checks it is consistent
+func readBundle(data []byte) (*SessionBundle, error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		var err error
+		if data, err = unarchiveBundle(data); err != nil {
+			return nil, err
+		}
+	}
+
+	var bundle SessionBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	if bundle.Format != bundleFormat {
+		return nil, fmt.Errorf("not a session bundle: format %q", bundle.Format)
+	}
+	if bundle.Version < 1 || bundle.Version > bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d: this worker reads up to version %d", bundle.Version, bundleVersion)
+	}
+	if bundle.Session.SessionID == "" {
+		return nil, fmt.Errorf("invalid bundle: missing session_id")
+	}
+
+	blocks := make(map[string]bool, len(bundle.Blocks))
+	for _, block := range bundle.Blocks {
+		if block.BlockID == "" || blocks[block.BlockID] {
+			return nil, fmt.Errorf("invalid bundle: missing or duplicate block_id %q", block.BlockID)
+		}
+		blocks[block.BlockID] = true
+	}
+	for _, block := range bundle.Blocks {
+		for _, dep := range block.DependsOn {
+			if !blocks[dep] {
+				return nil, fmt.Errorf("invalid bundle: block %s depends on unknown block %s", block.BlockID, dep)
+			}
+		}
+	}
+	for _, usage := range bundle.Usage {
+		if !blocks[usage.BlockID] {
+			return nil, fmt.Errorf("invalid bundle: usage record %s is for unknown block %s", usage.RequestID, usage.BlockID)
+		}
+	}
+	for _, event := range bundle.Events {
+		if event.BlockID != "" && !blocks[event.BlockID] {
+			return nil, fmt.Errorf("invalid bundle: event %d is for unknown block %s", event.EventID, event.BlockID)
+		}
+	}
+
+	return &bundle, nil
+}
+
+// unarchiveBundle extracts the bundle from a tar.gz archive
+func unarchiveBundle(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("invalid bundle archive: no %s", bundleEntry)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle archive: %w", err)
+		}
+		if header.Name == bundleEntry {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// importRows converts a bundle into the rows of the imported session, with
+// the IDs of response; committed blocks are recorded in response.Reopened
+func importRows(bundle *SessionBundle, response *ImportResponse) (session map[string]interface{}, blocks, dependencies, refinements, usage, events []map[string]interface{}) {
+	status := bundle.Session.Status
+	var completedAt interface{}
+	if bundle.Session.CompletedAt > 0 {
+		completedAt = bundle.Session.CompletedAt
+	}
+	if status != "abandoned" {
+		status, completedAt = "pending_audit", nil
+	}
+	session = map[string]interface{}{
+		"session_id":    response.SessionID,
+		"status":        status,
+		"created_at":    bundle.Session.CreatedAt,
+		"completed_at":  completedAt,
+		"project_root":  nullable(bundle.Session.ProjectRoot),
+		"imported_from": bundle.Session.SessionID,
+	}
+
+	for _, b := range bundle.Blocks {
+		blockID := response.BlockIDs[b.BlockID]
+		row := map[string]interface{}{
+			"block_id":         blockID,
+			"session_id":       response.SessionID,
+			"description":      b.Description,
+			"type":             b.Type,
+			"target":           b.Target,
+			"code":             nullable(b.Code),
+			"initial_code":     nullable(b.InitialCode),
+			"pending_code":     nullable(b.PendingCode),
+			"stale":            b.Stale,
+			"validation_json":  nullable(string(b.Validation)),
+			"test_json":        nullable(string(b.Test)),
+			"test_result_json": nullable(string(b.TestResult)),
+			"pattern_id":       nullable(b.PatternID),
+			"iterations":       b.Iterations,
+			"version":          b.Version,
+			"status":           b.Status,
+			"generation_error": nullable(b.Error),
+			"generated_at":     b.GeneratedAt,
+		}
+		if b.LastRefinedAt > 0 {
+			row["last_refined_at"] = b.LastRefinedAt
+		}
+		if b.Status == "committed" {
+			// Its files were written on the worker that committed it
+			row["status"] = "pending"
+			response.Reopened = append(response.Reopened, blockID)
+		}
+		blocks = append(blocks, row)
+
+		for _, dep := range b.DependsOn {
+			dependencies = append(dependencies, map[string]interface{}{
+				"session_id": response.SessionID,
+				"block_id":   blockID,
+				"depends_on": response.BlockIDs[dep],
+			})
+		}
+		for _, r := range b.Refinements {
+			refinements = append(refinements, map[string]interface{}{
+				"refinement_id": uuid.New().String(),
+				"block_id":      blockID,
+				"feedback":      r.Feedback,
+				"temperature":   r.Temperature,
+				"refined_code":  r.RefinedCode,
+				"created_at":    r.CreatedAt,
+			})
+		}
+	}
+
+	for _, u := range bundle.Usage {
+		usage = append(usage, map[string]interface{}{
+			"request_id":        uuid.New().String(),
+			"block_id":          response.BlockIDs[u.BlockID],
+			"operation":         u.Operation,
+			"provider":          nullable(u.Provider),
+			"model":             u.Model,
+			"temperature":       u.Temperature,
+			"tokens_prompt":     u.TokensPrompt,
+			"tokens_completion": u.TokensCompletion,
+			"latency_ms":        u.LatencyMs,
+			"timestamp":         u.Timestamp,
+		})
+	}
+
+	for _, e := range bundle.Events {
+		events = append(events, map[string]interface{}{
+			"session_id":  response.SessionID,
+			"block_id":    nullable(response.BlockIDs[e.BlockID]),
+			"kind":        e.Kind,
+			"actor":       nullable(e.Actor),
+			"detail":      nullable(e.Detail),
+			"temperature": e.Temperature,
+			"tokens":      e.Tokens,
+			"timestamp":   e.Timestamp,
+		})
+	}
+
+	return session, blocks, dependencies, refinements, usage, events
+}
+
+// mapToUsage converts a cerebras_usage row to a BundleUsage
+func mapToUsage(data map[string]interface{}) BundleUsage {
+	return BundleUsage{
+		RequestID:        data["request_id"].(string),
+		BlockID:          data["block_id"].(string),
+		Operation:        data["operation"].(string),
+		Provider:         data["provider"].(string),
+		Model:            data["model"].(string),
+		Temperature:      data["temperature"].(float64),
+		TokensPrompt:     data["tokens_prompt"].(int),
+		TokensCompletion: data["tokens_completion"].(int),
+		LatencyMs:        data["latency_ms"].(int),
+		Timestamp:        data["timestamp"].(int64),
+	}
+}
+
+// nullable stores an empty string as NULL
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package loop
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReadBundleArchive(t *testing.T) {
+	bundle := &SessionBundle{
+		Format:  bundleFormat,
+		Version: bundleVersion,
+		Session: BundleSession{SessionID: "s1", Status: "pending_audit"},
+		Blocks: []BundleBlock{
+			{BlockID: "a", Type: "go", Code: "package a"},
+			{BlockID: "b", Type: "code", DependsOn: []string{"a"}},
+		},
+		Usage: []BundleUsage{{RequestID: "r1", BlockID: "a"}},
+	}
+	data, _ := json.Marshal(bundle)
+
+	archive, err := archiveBundle(bundle, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := readBundle(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Session.SessionID != "s1" || len(read.Blocks) != 2 || read.Blocks[1].DependsOn[0] != "a" {
+		t.Errorf("Expected the bundle read back from the archive, got %+v", read)
+	}
+
+	bundle.Blocks[1].DependsOn = []string{"missing"}
+	data, _ = json.Marshal(bundle)
+	if _, err := readBundle(data); err == nil || !strings.Contains(err.Error(), "unknown block missing") {
+		t.Errorf("Expected a dependency outside the bundle to be refused, got %v", err)
+	}
+
+	bundle.Format = "other"
+	data, _ = json.Marshal(bundle)
+	if _, err := readBundle(data); err == nil || !strings.Contains(err.Error(), "not a session bundle") {
+		t.Errorf("Expected another format to be refused, got %v", err)
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	for _, tc := range []struct {
+		format, path, want string
+	}{
+		{"", "", "json"},
+		{"", "out/session.json", "json"},
+		{"", "out/session.tgz", "tar.gz"},
+		{"json", "out/session.tar.gz", "json"},
+	} {
+		if got, err := exportFormat(tc.format, tc.path); err != nil || got != tc.want {
+			t.Errorf("exportFormat(%q, %q) = %q, %v; want %q", tc.format, tc.path, got, err, tc.want)
+		}
+	}
+	if _, err := exportFormat("tar.gz", ""); err == nil {
+		t.Error("Expected a tar.gz bundle without a path to be refused")
+	}
+	if _, err := exportFormat("zip", "x.zip"); err == nil {
+		t.Error("Expected an unknown format to be refused")
+	}
+}
@@ -0,0 +1,96 @@
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"brainloop/internal/database"
+)
+
+const (
+	// maxGenerationsConfig is the config key bounding concurrent LLM generations
+	maxGenerationsConfig = "max_concurrent_generations"
+
+	// defaultMaxGenerations is the generation pool size when not configured
+	defaultMaxGenerations = 4
+)
+
+// sessionLocks holds one mutex per session. Writes to a session's blocks are
+// serialised; sessions never wait on each other. A session's mutex is dropped
+// once nobody holds or waits for it.
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+// sessionLock is a session's mutex and the number of callers holding or waiting for it
+type sessionLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks a session and returns the function unlocking it
+func (l *sessionLocks) lock(sessionID string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sessionLock)
+	}
+	lock, ok := l.locks[sessionID]
+	if !ok {
+		lock = &sessionLock{}
+		l.locks[sessionID] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, sessionID)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// lockSession locks a session's blocks against concurrent writes. LLM calls
+// are made without it: a refine checks the block version when storing its code.
+func (m *Manager) lockSession(sessionID string) func() {
+	return m.sessions.lock(sessionID)
+}
+
+// generationPool returns the semaphore bounding concurrent generations, sized
+// by the max_concurrent_generations config key
+func generationPool(lifecycleDB *database.LifecycleDB) chan struct{} {
+	size := defaultMaxGenerations
+	if value, err := lifecycleDB.GetConfig(maxGenerationsConfig); err == nil {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			size = n
+		}
+	}
+	return make(chan struct{}, size)
+}
+
+// acquireGeneration waits for a slot of the generation pool and returns the
+// function releasing it
+func (m *Manager) acquireGeneration() func() {
+	m.generations <- struct{}{}
+	return func() { <-m.generations }
+}
+
+// poolSize is the number of generations that may run at once
+func (m *Manager) poolSize() int {
+	return cap(m.generations)
+}
+
+// versionConflict explains a generation that lost a race against another write
+func versionConflict(blockID string, err error) error {
+	if errors.Is(err, database.ErrVersionConflict) {
+		return fmt.Errorf("block %s was modified while its code was being generated; audit it and try again: %w", blockID, err)
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package loop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionLocks(t *testing.T) {
+	var locks sessionLocks
+
+	unlockA := locks.lock("a")
+
+	// Another session is not held up
+	done := make(chan struct{})
+	go func() {
+		locks.lock("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected session b to lock while session a is locked")
+	}
+
+	// The same session waits for the holder
+	acquired := make(chan struct{})
+	go func() {
+		locks.lock("a")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected session a to wait for its holder")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlockA()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected session a to lock once released")
+	}
+}
+
+func TestSessionLocksReleaseUnusedSessions(t *testing.T) {
+	var locks sessionLocks
+
+	unlock := locks.lock("a")
+	waited := make(chan struct{})
+	go func() {
+		locks.lock("a")()
+		close(waited)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	unlock()
+	<-waited
+
+	locks.lock("b")()
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("Expected no mutex left once every session is released, got %d", len(locks.locks))
+	}
+}
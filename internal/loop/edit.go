@@ -0,0 +1,81 @@
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"brainloop/internal/diff"
+	"brainloop/internal/validation"
+)
+
+// PatchPreview shows what an edit block's patch does to the current target file
+type PatchPreview struct {
+	Format    string            `json:"format,omitempty"` // 'unified' | 'search_replace'
+	Diff      string            `json:"diff,omitempty"`   // normalized unified diff against the current file
+	Stats     diff.Stats        `json:"stats"`
+	Hunks     []diff.HunkResult `json:"hunks,omitempty"`
+	Conflicts []diff.Conflict   `json:"conflicts,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// blockRequirement builds the generation prompt of a block: its description,
+// its dependencies' code and, for edit blocks, the current target file
+func blockRequirement(description, blockType, target string, dependencies []Block) (string, error) {
+	requirement := withDependencies(description, dependencies)
+	if blockType != "edit" {
+		return requirement, nil
+	}
+
+	content, err := os.ReadFile(target)
+	if err != nil {
+		return "", fmt.Errorf("edit block target must be an existing file: %w", err)
+	}
+
+	return fmt.Sprintf("%s\n\nEdit the existing file %s. Its current content is:\n```%s\n%s\n```\nReturn only the changes as a unified diff or SEARCH/REPLACE blocks.",
+		requirement, target, fenceLanguage(validation.TypeForPath(target)), string(content)), nil
+}
+
+// applyEdit applies an edit block's patch to the current content of its target
+func applyEdit(target, patch string) (string, string, []diff.HunkResult, error) {
+	original, err := os.ReadFile(target)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read %s: %w", target, err)
+	}
+
+	parsed, err := diff.ParsePatch(patch)
+	if err != nil {
+		return string(original), "", nil, err
+	}
+
+	patched, results, err := diff.Apply(string(original), parsed)
+	if err != nil {
+		return string(original), "", nil, err
+	}
+
+	return string(original), patched, results, nil
+}
+
+// previewPatch applies an edit block's patch in memory for review
+func previewPatch(block Block) *PatchPreview {
+	preview := &PatchPreview{}
+	if parsed, err := diff.ParsePatch(block.Code); err == nil {
+		preview.Format = parsed.Format
+	}
+
+	original, patched, results, err := applyEdit(block.Target, block.Code)
+	if err != nil {
+		var conflict *diff.ConflictError
+		if errors.As(err, &conflict) {
+			preview.Conflicts = conflict.Conflicts
+		}
+		preview.Error = err.Error()
+		return preview
+	}
+
+	preview.Diff = diff.Unified("a/"+block.Target, "b/"+block.Target, original, patched, diff.DefaultContext)
+	preview.Stats = diff.Stat(original, patched)
+	preview.Hunks = results
+
+	return preview
+}
@@ -0,0 +1,87 @@
+package loop
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Session event kinds
+const (
+	eventCreated    = "created"
+	eventGenerated  = "generated"
+	eventFailed     = "failed"
+	eventAudited    = "audited"
+	eventRefined    = "refined"
+	eventReverted   = "reverted"
+	eventValidated  = "validated"
+	eventCommitted  = "committed"
+	eventRolledBack = "rolled_back"
+	eventAbandoned  = "abandoned"
+	eventResumed    = "resumed"
+	eventImported   = "imported"
+)
+
+// SessionEvent is an entry of a session's event log
+type SessionEvent struct {
+	EventID     int64   `json:"event_id"`
+	BlockID     string  `json:"block_id,omitempty"` // empty for an event of the whole session
+	Kind        string  `json:"kind"`
+	Actor       string  `json:"actor,omitempty"` // the MCP client that made the call
+	Detail      string  `json:"detail,omitempty"`
+	Temperature float64 `json:"temperature,omitempty"` // of the LLM call the event made, if any
+	Tokens      int     `json:"tokens,omitempty"`
+	Timestamp   int64   `json:"timestamp"`
+}
+
+// SetActor names the client whose calls are recorded on session events from now on
+func (m *Manager) SetActor(name string) {
+	m.actor.Store(name)
+}
+
+// currentActor returns the name set by SetActor, if any
+func (m *Manager) currentActor() string {
+	name, _ := m.actor.Load().(string)
+	return name
+}
+
+// recordEvent appends an event to a session's log. The log is a record of
+// what happened, not a part of it: a failure to write it is ignored.
+func (m *Manager) recordEvent(sessionID, blockID, kind, detail string, temperature float64, tokens int) {
+	m.lifecycleDB.RecordSessionEvent(sessionID, blockID, kind, m.currentActor(), detail, temperature, tokens)
+}
+
+// sessionEvents returns the event log of a session, oldest first
+func (m *Manager) sessionEvents(sessionID string) ([]SessionEvent, error) {
+	rows, err := m.lifecycleDB.GetSessionEvents(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve events: %w", err)
+	}
+
+	events := make([]SessionEvent, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, mapToSessionEvent(row))
+	}
+	return events, nil
+}
+
+// validationDetail summarises a validation report for the event log
+func validationDetail(report *BlockValidation) string {
+	if report.Valid {
+		return fmt.Sprintf("gate passed (score %.2f)", report.Score)
+	}
+	return fmt.Sprintf("gate failed: %s", strings.Join(gateErrors(report.Report), "; "))
+}
+
+// mapToSessionEvent converts a session_events row to a SessionEvent
+func mapToSessionEvent(data map[string]interface{}) SessionEvent {
+	return SessionEvent{
+		EventID:     data["event_id"].(int64),
+		BlockID:     data["block_id"].(string),
+		Kind:        data["kind"].(string),
+		Actor:       data["actor"].(string),
+		Detail:      data["detail"].(string),
+		Temperature: data["temperature"].(float64),
+		Tokens:      data["tokens"].(int),
+		Timestamp:   data["timestamp"].(int64),
+	}
+}
@@ -0,0 +1,147 @@
+package loop
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"brainloop/internal/validation"
+	"brainloop/internal/workspace"
+)
+
+// pythonInterpreterConfig is the config key naming the interpreter used for py_compile
+const pythonInterpreterConfig = "python_interpreter"
+
+// Validate runs the validation gate on a block's current code and stores the report
+func (m *Manager) Validate(req ValidateRequest) (*ValidateResponse, error) {
+	defer m.lockSession(req.SessionID)()
+
+	block, err := m.getBlock(req.BlockID)
+	if err != nil {
+		return nil, err
+	}
+	if block.SessionID != req.SessionID {
+		return nil, fmt.Errorf("block %s does not belong to session %s", req.BlockID, req.SessionID)
+	}
+
+	report, err := m.runGate(block, block.Code)
+	if err != nil {
+		return nil, err
+	}
+	block.Validation = report
+
+	return &ValidateResponse{Block: block, Validation: report}, nil
+}
+
+// runGate validates code against the block's target and stores the report on the block
+func (m *Manager) runGate(block Block, code string) (*BlockValidation, error) {
+	return m.runGateWith(block, code, validation.GateOptions{})
+}
+
+// runGateWith runs the gate with the staged changes of opts
+func (m *Manager) runGateWith(block Block, code string, opts validation.GateOptions) (*BlockValidation, error) {
+	opts.Target = block.Target
+	opts.Python, _ = m.lifecycleDB.GetConfig(pythonInterpreterConfig)
+	if block.Type == "files" {
+		opts.SplitFiles = func(code string) (map[string]string, error) {
+			return stagedFiles(block.Target, code)
+		}
+	}
+
+	report := &BlockValidation{
+		Report:      validation.Gate(code, block.Type, opts),
+		CodeHash:    workspace.HashContent(code),
+		ValidatedAt: time.Now().Unix(),
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal validation report: %w", err)
+	}
+	if err := m.lifecycleDB.SetBlockValidation(block.BlockID, string(reportJSON)); err != nil {
+		return nil, fmt.Errorf("failed to store validation report: %w", err)
+	}
+	m.recordEvent(block.SessionID, block.BlockID, eventValidated, validationDetail(report), 0, 0)
+
+	return report, nil
+}
+
+// stagedFiles splits the code of a 'files' block into its files by absolute path
+func stagedFiles(root, code string) (map[string]string, error) {
+	files, err := blockFiles(code)
+	if err != nil {
+		return nil, err
+	}
+
+	staged := make(map[string]string, len(files))
+	for _, f := range files {
+		path, err := workspace.Resolve(root, f.Path)
+		if err != nil {
+			return nil, err
+		}
+		if path, err = filepath.Abs(path); err != nil {
+			return nil, err
+		}
+		staged[path] = f.Content
+	}
+	return staged, nil
+}
+
+// rejectCommit answers a commit whose code failed the validation gate
+func rejectCommit(block Block, report *BlockValidation) *CommitResponse {
+	return &CommitResponse{
+		Block:      block,
+		Success:    false,
+		Validation: report,
+		Message:    fmt.Sprintf("Commit blocked by the validation gate: %v", report.Err()),
+	}
+}
+
+// rejected reports whether the validation gate blocked the commit
+func (r *CommitResponse) rejected() bool {
+	return !r.Success && r.Validation != nil && !r.Validation.Valid
+}
+
+// refineRejected refines a block whose commit was rejected, with the errors as
+// feedback; the refined code still has to be reviewed and committed with its
+// new code hash
+func (m *Manager) refineRejected(response *CommitResponse, req CommitRequest) (*CommitResponse, error) {
+	report := response.Validation
+	refined, err := m.Refine(RefineRequest{
+		SessionID:     req.SessionID,
+		BlockID:       req.BlockID,
+		AuditFeedback: gateFeedback(report.Report),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("validation failed (%v) and automatic refine failed: %w", report.Err(), err)
+	}
+
+	response.Block = refined.Block
+	response.AutoRefined = true
+	response.Message += "; the block was refined with the errors as feedback, review it and commit with its new code_hash"
+
+	return response, nil
+}
+
+// gateFeedback turns the failed checks of a report into refine feedback
+func gateFeedback(report validation.Report) string {
+	var b strings.Builder
+	b.WriteString("The code failed validation before commit. Fix these errors:")
+	for _, e := range gateErrors(report) {
+		b.WriteString("\n- " + e)
+	}
+	return b.String()
+}
+
+// gateErrors lists the failed required checks of a validation report
+func gateErrors(report validation.Report) []string {
+	var errs []string
+	for _, c := range report.Checks {
+		if c.Required && !c.Passed {
+			errs = append(errs, fmt.Sprintf("%s: %s", c.Name, c.Detail))
+		}
+	}
+	return errs
+}
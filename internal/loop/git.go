@@ -0,0 +1,214 @@
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"brainloop/internal/diff"
+	"brainloop/internal/gitops"
+)
+
+// gitCommitsConfig is the config key turning on git commits of committed blocks
+const gitCommitsConfig = "git_commits"
+
+// gitEnabled reports whether committed blocks are recorded in git
+func (m *Manager) gitEnabled() bool {
+	value, err := m.lifecycleDB.GetConfig(gitCommitsConfig)
+	if err != nil {
+		return false
+	}
+	enabled, _ := strconv.ParseBool(value)
+	return enabled && gitops.Available()
+}
+
+// recordGit commits the files just written for blocks on the session branch
+// of every work tree they belong to. Files outside a work tree and databases
+// are not recorded. The blocks are committed whatever happens here, so a
+// failure is reported on its GitCommit instead of failing the commit.
+func (m *Manager) recordGit(sessionID string, blocks []Block, targets []commitTarget) []GitCommit {
+	if !m.gitEnabled() {
+		return nil
+	}
+
+	var repos []*gitops.Repo
+	files := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		if target.Database || seen[target.Path] {
+			continue
+		}
+		seen[target.Path] = true
+		repo, err := gitops.Open(target.Path)
+		if err != nil {
+			continue
+		}
+		if _, ok := files[repo.Root]; !ok {
+			repos = append(repos, repo)
+		}
+		files[repo.Root] = append(files[repo.Root], target.Path)
+	}
+	if len(repos) == 0 {
+		return nil
+	}
+
+	branch := gitops.SessionBranch(sessionID)
+	message := m.gitMessage(sessionID, blocks)
+	commits := make([]GitCommit, 0, len(repos))
+	for _, repo := range repos {
+		commit := GitCommit{Repository: repo.Root, Branch: branch}
+		for _, path := range files[repo.Root] {
+			rel, _ := repo.Rel(path)
+			commit.Files = append(commit.Files, rel)
+		}
+
+		hash, err := repo.CommitFiles(branch, message, files[repo.Root])
+		if err != nil {
+			commit.Error = err.Error()
+		}
+		commit.Commit = hash
+		commits = append(commits, commit)
+	}
+
+	return commits
+}
+
+// gitMessage builds the commit message of committed blocks from their
+// descriptions and audit feedback, with the session and block IDs as trailers
+func (m *Manager) gitMessage(sessionID string, blocks []Block) string {
+	var b strings.Builder
+
+	subject := oneLine(blocks[0].Description)
+	if len(blocks) > 1 {
+		subject = fmt.Sprintf("%d blocks: %s", len(blocks), subject)
+	}
+	b.WriteString(truncate(subject, 72))
+	b.WriteString("\n\n")
+
+	for _, block := range blocks {
+		fmt.Fprintf(&b, "- %s (%s): %s\n", block.BlockID, block.Type, truncate(oneLine(block.Description), 200))
+		refinements, err := m.getRefinements(block.BlockID)
+		if err != nil {
+			continue
+		}
+		for _, r := range refinements {
+			fmt.Fprintf(&b, "  Audit: %s\n", truncate(oneLine(r.Feedback), 200))
+		}
+	}
+
+	fmt.Fprintf(&b, "\nBrainloop-Session: %s\n", sessionID)
+	for _, block := range blocks {
+		fmt.Fprintf(&b, "Brainloop-Block: %s\n", block.BlockID)
+	}
+
+	return b.String()
+}
+
+// oneLine collapses whitespace, newlines included
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// GitDiff shows what committing the uncommitted blocks of a session, or only
+// block_id, would change against HEAD of the git work tree of each file.
+// Files outside a work tree are diffed against the disk. Nothing is written.
+func (m *Manager) GitDiff(req GitDiffRequest) (*GitDiffResponse, error) {
+	blocks, err := m.storage.GetSessionBlocks(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]BlockInput, len(blocks))
+	for i, b := range blocks {
+		inputs[i] = BlockInput{ID: b.BlockID, DependsOn: b.DependsOn}
+	}
+	levels, err := dependencyLevels(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stage the pending blocks in commit order, as commit_session would
+	stage := newSessionStage()
+	staged := 0
+	for _, level := range levels {
+		for _, i := range level {
+			block := blocks[i]
+			if block.Status == "committed" || (req.BlockID != "" && block.BlockID != req.BlockID) {
+				continue
+			}
+			if block.Status == "failed" || block.Code == "" {
+				if req.BlockID != "" {
+					return nil, fmt.Errorf("block %s has no code to commit", block.BlockID)
+				}
+				continue
+			}
+			if err := stage.add(block); err != nil {
+				return nil, fmt.Errorf("failed to stage block %s: %w", block.BlockID, err)
+			}
+			staged++
+		}
+	}
+	if staged == 0 {
+		if req.BlockID != "" {
+			return nil, fmt.Errorf("block %s is not an uncommitted block of session %s", req.BlockID, req.SessionID)
+		}
+		return nil, fmt.Errorf("session %s has no uncommitted blocks", req.SessionID)
+	}
+
+	response := &GitDiffResponse{
+		SessionID: req.SessionID,
+		Branch:    gitops.SessionBranch(req.SessionID),
+		Databases: stage.dbOrder,
+	}
+	for _, path := range stage.fileOrder {
+		file, err := gitFileDiff(path, stage.files[path])
+		if err != nil {
+			return nil, err
+		}
+		if file.Diff != "" {
+			response.Files = append(response.Files, file)
+		}
+	}
+	response.Message = fmt.Sprintf("%d block(s) change %d file(s)", staged, len(response.Files))
+	if len(stage.dbOrder) > 0 {
+		response.Message += fmt.Sprintf(" and run SQL on %d database(s), not shown", len(stage.dbOrder))
+	}
+
+	return response, nil
+}
+
+// gitFileDiff diffs the pending content of a file against HEAD, or against
+// the disk outside a work tree
+func gitFileDiff(path, content string) (GitFileDiff, error) {
+	file := GitFileDiff{Path: path}
+	oldName := path
+
+	var current string
+	repo, err := gitops.Open(path)
+	switch {
+	case err == nil:
+		file.Repository = repo.Root
+		rel, err := repo.Rel(path)
+		if err != nil {
+			return file, err
+		}
+		oldName = rel
+		if current, _, err = repo.ShowHead(path); err != nil {
+			return file, err
+		}
+	case errors.Is(err, gitops.ErrNotRepository):
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return file, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		current = string(data)
+	default:
+		return file, err
+	}
+
+	file.Diff = diff.Unified("a/"+oldName, "b/"+oldName, current, content, diff.DefaultContext)
+	file.Stats = diff.Stat(current, content)
+	return file, nil
+}
@@ -0,0 +1,112 @@
+package loop
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dependencyLevels groups proposed blocks into generation levels: every block
+// only depends on blocks of earlier levels. It returns indexes into inputs and
+// fails on unknown dependencies and cycles.
+func dependencyLevels(inputs []BlockInput) ([][]int, error) {
+	index := make(map[string]int, len(inputs))
+	for i, input := range inputs {
+		if input.ID == "" {
+			continue
+		}
+		if _, exists := index[input.ID]; exists {
+			return nil, fmt.Errorf("duplicate block id %s", input.ID)
+		}
+		index[input.ID] = i
+	}
+
+	pending := make([]int, len(inputs))
+	dependants := make([][]int, len(inputs))
+	for i, input := range inputs {
+		seen := make(map[string]bool)
+		for _, dep := range input.DependsOn {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("block %s depends on unknown block %s", input.ID, dep)
+			}
+			if j == i {
+				return nil, fmt.Errorf("block %s depends on itself", input.ID)
+			}
+			pending[i]++
+			dependants[j] = append(dependants[j], i)
+		}
+	}
+
+	// Kahn's algorithm, one level at a time, keeping input order within a level
+	var levels [][]int
+	var current []int
+	for i := range inputs {
+		if pending[i] == 0 {
+			current = append(current, i)
+		}
+	}
+
+	placed := 0
+	for len(current) > 0 {
+		levels = append(levels, current)
+		placed += len(current)
+
+		var next []int
+		for _, i := range current {
+			for _, j := range dependants[i] {
+				pending[j]--
+				if pending[j] == 0 {
+					next = append(next, j)
+				}
+			}
+		}
+		sort.Ints(next)
+		current = next
+	}
+
+	if placed != len(inputs) {
+		var cyclic []string
+		for i, input := range inputs {
+			if pending[i] > 0 {
+				cyclic = append(cyclic, input.ID)
+			}
+		}
+		return nil, fmt.Errorf("dependency cycle between blocks: %s", strings.Join(cyclic, ", "))
+	}
+
+	return levels, nil
+}
+
+// withDependencies appends the code of a block's dependencies to its
+// description, so generation builds on them instead of redefining them
+func withDependencies(description string, dependencies []Block) string {
+	if len(dependencies) == 0 {
+		return description
+	}
+
+	var b strings.Builder
+	b.WriteString(description)
+	b.WriteString("\n\nThis block depends on the following blocks. Use their definitions as-is; do not redefine them.")
+	for _, dep := range dependencies {
+		fmt.Fprintf(&b, "\n\n### Block %s (%s, %s): %s\n```%s\n%s\n```", dep.BlockID, dep.Type, dep.Target, dep.Description, fenceLanguage(dep.Type), dep.Code)
+	}
+
+	return b.String()
+}
+
+// fenceLanguage returns the code fence language for a block type
+func fenceLanguage(blockType string) string {
+	switch blockType {
+	case "sql", "go", "python":
+		return blockType
+	case "edit":
+		return "diff"
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package loop
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDependencyLevels(t *testing.T) {
+	inputs := []BlockInput{
+		{ID: "handler", DependsOn: []string{"repo"}},
+		{ID: "schema"},
+		{ID: "repo", DependsOn: []string{"schema", "schema"}},
+		{ID: "config"},
+	}
+
+	levels, err := dependencyLevels(inputs)
+	if err != nil {
+		t.Fatalf("dependencyLevels failed: %v", err)
+	}
+
+	expected := [][]int{{1, 3}, {2}, {0}}
+	if !reflect.DeepEqual(levels, expected) {
+		t.Errorf("Expected levels %v, got %v", expected, levels)
+	}
+}
+
+func TestDependencyLevelsErrors(t *testing.T) {
+	cases := map[string][]BlockInput{
+		"unknown": {{ID: "a", DependsOn: []string{"missing"}}},
+		"itself":  {{ID: "a", DependsOn: []string{"a"}}},
+		"cycle": {
+			{ID: "a", DependsOn: []string{"b"}},
+			{ID: "b", DependsOn: []string{"a"}},
+			{ID: "c"},
+		},
+		"duplicate": {{ID: "a"}, {ID: "a"}},
+	}
+
+	for name, inputs := range cases {
+		if _, err := dependencyLevels(inputs); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	_, err := dependencyLevels(cases["cycle"])
+	if !strings.Contains(err.Error(), "a, b") {
+		t.Errorf("Expected the cycle members in the error, got %v", err)
+	}
+}
+
+func TestWithDependencies(t *testing.T) {
+	if got := withDependencies("users repository", nil); got != "users repository" {
+		t.Errorf("Expected the description unchanged without dependencies, got %q", got)
+	}
+
+	got := withDependencies("users repository", []Block{{
+		BlockID:     "schema",
+		Type:        "sql",
+		Target:      "app.db",
+		Description: "Users table",
+		Code:        "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+	}})
+	if !strings.HasPrefix(got, "users repository\n\n") ||
+		!strings.Contains(got, "### Block schema (sql, app.db): Users table\n```sql\nCREATE TABLE users (id INTEGER PRIMARY KEY);\n```") {
+		t.Errorf("Expected the dependency code as context, got %q", got)
+	}
+}
@@ -0,0 +1,113 @@
+package loop
+
+import (
+	"fmt"
+	"strings"
+
+	"brainloop/internal/cerebras"
+)
+
+const (
+	// maxHistoryChars is the conversation size above which older turns are summarised
+	maxHistoryChars = 24000
+
+	// maxSummaryFeedbackChars caps each feedback entry in a summary
+	maxSummaryFeedbackChars = 600
+)
+
+// refineHistory is the message history rebuilt for a refinement
+type refineHistory struct {
+	Messages             []cerebras.Message
+	SummarizedIterations int
+}
+
+// buildRefineHistory rebuilds a multi-turn conversation from a block's initial
+// code and refinement rows, ending with the new feedback. When the history
+// exceeds maxHistoryChars, the oldest exchanges are collapsed into a summary
+// that keeps every feedback item but drops superseded code.
+func buildRefineHistory(description, initialCode string, refinements []Refinement, feedback string) refineHistory {
+	// Summarise progressively more iterations until the history fits
+	for summarized := 0; summarized <= len(refinements); summarized++ {
+		messages := assembleHistory(description, initialCode, refinements, feedback, summarized, maxSummaryFeedbackChars)
+		if historyLength(messages) <= maxHistoryChars || summarized == len(refinements) {
+			if summarized == len(refinements) && historyLength(messages) > maxHistoryChars {
+				// Last resort: shorten each summarised feedback entry
+				messages = assembleHistory(description, initialCode, refinements, feedback, summarized, maxSummaryFeedbackChars/4)
+			}
+			return refineHistory{Messages: messages, SummarizedIterations: summarized}
+		}
+	}
+
+	// Unreachable: the loop always returns on its last iteration
+	return refineHistory{}
+}
+
+// assembleHistory builds the messages, summarising the first `summarized` refinements
+func assembleHistory(description, initialCode string, refinements []Refinement, feedback string, summarized, feedbackLimit int) []cerebras.Message {
+	var messages []cerebras.Message
+
+	opening := fmt.Sprintf("Requirement: %s\n\nGenerate the code.", description)
+
+	// Code the model last produced before the first full exchange
+	lastCode := initialCode
+	if summarized > 0 {
+		var summary strings.Builder
+		summary.WriteString("\n\nEarlier audit feedback, already addressed in the current code (do not reintroduce these issues):")
+		for i := 0; i < summarized; i++ {
+			fmt.Fprintf(&summary, "\n- Iteration %d: %s", i+1, truncate(refinements[i].Feedback, feedbackLimit))
+		}
+		opening += summary.String()
+		lastCode = refinements[summarized-1].RefinedCode
+	}
+
+	messages = append(messages, cerebras.Message{Role: "user", Content: opening})
+	if lastCode != "" {
+		messages = append(messages, cerebras.Message{Role: "assistant", Content: lastCode})
+	}
+
+	for _, r := range refinements[summarized:] {
+		messages = append(messages,
+			cerebras.Message{Role: "user", Content: fmt.Sprintf("Audit feedback: %s\n\nGenerate improved code addressing the feedback.", r.Feedback)},
+			cerebras.Message{Role: "assistant", Content: r.RefinedCode},
+		)
+	}
+
+	messages = append(messages, cerebras.Message{
+		Role:    "user",
+		Content: fmt.Sprintf("Audit feedback: %s\n\nGenerate the complete improved code addressing this feedback, without reintroducing issues fixed in earlier iterations.", feedback),
+	})
+
+	return mergeConsecutive(messages)
+}
+
+// mergeConsecutive joins consecutive messages with the same role, which occur
+// when a legacy block has no recorded initial code
+func mergeConsecutive(messages []cerebras.Message) []cerebras.Message {
+	var merged []cerebras.Message
+	for _, msg := range messages {
+		if n := len(merged); n > 0 && merged[n-1].Role == msg.Role {
+			merged[n-1].Content += "\n\n" + msg.Content
+			continue
+		}
+		merged = append(merged, msg)
+	}
+	return merged
+}
+
+// historyLength returns the total content size of messages
+func historyLength(messages []cerebras.Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += len(msg.Content)
+	}
+	return total
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,76 @@
+package loop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildRefineHistoryFullTrail(t *testing.T) {
+	refinements := []Refinement{
+		{Feedback: "add error handling", RefinedCode: "v1"},
+		{Feedback: "close rows", RefinedCode: "v2"},
+	}
+
+	history := buildRefineHistory("user repository", "v0", refinements, "use prepared statements")
+
+	roles := []string{"user", "assistant", "user", "assistant", "user", "assistant", "user"}
+	if len(history.Messages) != len(roles) {
+		t.Fatalf("Expected %d messages, got %d", len(roles), len(history.Messages))
+	}
+	for i, role := range roles {
+		if history.Messages[i].Role != role {
+			t.Errorf("Message %d: expected role %s, got %s", i, role, history.Messages[i].Role)
+		}
+	}
+
+	if history.Messages[1].Content != "v0" || history.Messages[5].Content != "v2" {
+		t.Error("Expected initial and latest code as assistant turns")
+	}
+	if !strings.Contains(history.Messages[6].Content, "use prepared statements") {
+		t.Error("Expected new feedback in the last message")
+	}
+	if history.SummarizedIterations != 0 {
+		t.Errorf("Expected no summarisation, got %d", history.SummarizedIterations)
+	}
+}
+
+func TestBuildRefineHistorySummarisesOldTurns(t *testing.T) {
+	bigCode := strings.Repeat("x", maxHistoryChars/3)
+	var refinements []Refinement
+	for i := 0; i < 6; i++ {
+		refinements = append(refinements, Refinement{Feedback: "fix issue " + string(rune('A'+i)), RefinedCode: bigCode})
+	}
+
+	history := buildRefineHistory("worker", bigCode, refinements, "final feedback")
+
+	if history.SummarizedIterations == 0 {
+		t.Fatal("Expected older iterations to be summarised")
+	}
+	if historyLength(history.Messages) > maxHistoryChars {
+		t.Errorf("History still too long: %d", historyLength(history.Messages))
+	}
+
+	// Every feedback item must remain visible
+	all := ""
+	for _, msg := range history.Messages {
+		all += msg.Content
+	}
+	for _, r := range refinements {
+		if !strings.Contains(all, r.Feedback) {
+			t.Errorf("Feedback %q missing from history", r.Feedback)
+		}
+	}
+}
+
+func TestBuildRefineHistoryWithoutInitialCode(t *testing.T) {
+	refinements := []Refinement{{Feedback: "first", RefinedCode: "v1"}}
+
+	history := buildRefineHistory("legacy block", "", refinements, "second")
+
+	if history.Messages[0].Role != "user" || history.Messages[1].Role != "assistant" {
+		t.Fatalf("Expected alternating roles, got %s then %s", history.Messages[0].Role, history.Messages[1].Role)
+	}
+	if !strings.Contains(history.Messages[0].Content, "first") {
+		t.Error("Expected first feedback merged into the opening user message")
+	}
+}
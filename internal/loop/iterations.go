@@ -0,0 +1,256 @@
+package loop
+
+import (
+	"fmt"
+	"os"
+
+	"brainloop/internal/diff"
+	"brainloop/internal/workspace"
+
+	"github.com/google/uuid"
+)
+
+// Iteration is one version of a block's code: iteration 0 is the proposed
+// code, iteration n the code of its n-th refinement
+type Iteration struct {
+	Iteration    int        `json:"iteration"`
+	RefinementID string     `json:"refinement_id,omitempty"`
+	Feedback     string     `json:"feedback,omitempty"`
+	CodeHash     string     `json:"code_hash"`
+	Stats        diff.Stats `json:"stats"` // lines changed from the previous iteration
+	CreatedAt    int64      `json:"created_at"`
+	Current      bool       `json:"current,omitempty"` // the block's code is this iteration
+
+	code string
+}
+
+// History lists the iterations of a block
+func (m *Manager) History(req HistoryRequest) (*HistoryResponse, error) {
+	block, err := m.sessionBlock(req.SessionID, req.BlockID)
+	if err != nil {
+		return nil, err
+	}
+
+	iterations, err := m.blockIterations(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HistoryResponse{BlockID: block.BlockID, CodeHash: block.CodeHash, Iterations: iterations}, nil
+}
+
+// Diff returns a unified diff between two iterations of a block, or between
+// an iteration and the block's target file on disk. To defaults to the
+// latest iteration and From to the one before it. Iteration 0 has no
+// predecessor, so by default it is diffed against the file on disk, or
+// against itself for blocks without a single target file.
+func (m *Manager) Diff(req DiffRequest) (*DiffResponse, error) {
+	block, err := m.sessionBlock(req.SessionID, req.BlockID)
+	if err != nil {
+		return nil, err
+	}
+
+	iterations, err := m.blockIterations(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.AgainstDisk {
+		return diffAgainstDisk(block, iterations, req.From)
+	}
+
+	to := len(iterations) - 1
+	if req.To != nil {
+		to = *req.To
+	}
+	from := to - 1
+	if req.From != nil {
+		from = *req.From
+	} else if to == 0 {
+		switch block.Type {
+		case "sql", "files":
+			from = 0
+		default:
+			return diffAgainstDisk(block, iterations, &to)
+		}
+	}
+	if err := checkIteration(iterations, from); err != nil {
+		return nil, err
+	}
+	if err := checkIteration(iterations, to); err != nil {
+		return nil, err
+	}
+
+	oldName, newName := fmt.Sprintf("iteration-%d", from), fmt.Sprintf("iteration-%d", to)
+	oldCode, newCode := iterations[from].code, iterations[to].code
+
+	return &DiffResponse{
+		BlockID: block.BlockID,
+		From:    oldName,
+		To:      newName,
+		Diff:    diff.Unified(oldName, newName, oldCode, newCode, diff.DefaultContext),
+		Stats:   diff.Stat(oldCode, newCode),
+	}, nil
+}
+
+// Revert makes an earlier iteration the block's code again. The revert is
+// recorded as a new iteration, so nothing in the history is lost.
+func (m *Manager) Revert(req RevertRequest) (*RevertResponse, error) {
+	defer m.lockSession(req.SessionID)()
+
+	block, err := m.sessionBlock(req.SessionID, req.BlockID)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.requireActiveSession(req.SessionID); err != nil {
+		return nil, err
+	}
+	if block.Status == "committed" {
+		return nil, committedBlock(block)
+	}
+
+	iterations, err := m.blockIterations(block)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIteration(iterations, req.Iteration); err != nil {
+		return nil, err
+	}
+	code := iterations[req.Iteration].code
+	if code == block.Code {
+		return nil, fmt.Errorf("block %s already has the code of iteration %d", req.BlockID, req.Iteration)
+	}
+
+	feedback := fmt.Sprintf("Reverted to iteration %d", req.Iteration)
+	if err := m.lifecycleDB.ApplyRefinement(uuid.New().String(), req.BlockID, feedback, code, 0, block.Version); err != nil {
+		return nil, fmt.Errorf("failed to record revert: %w", err)
+	}
+
+	// Blocks built on the replaced code must be refined again
+	staleDependants, err := m.lifecycleDB.MarkDependantsStale(req.BlockID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark dependants stale: %w", err)
+	}
+
+	updatedBlock, err := m.getBlock(req.BlockID)
+	if err != nil {
+		return nil, err
+	}
+	m.recordEvent(req.SessionID, req.BlockID, eventReverted, feedback, 0, 0)
+
+	return &RevertResponse{
+		Block:           updatedBlock,
+		Iteration:       len(iterations),
+		RevertedTo:      req.Iteration,
+		StaleDependants: staleDependants,
+	}, nil
+}
+
+// sessionBlock returns a block after checking it belongs to the session
+func (m *Manager) sessionBlock(sessionID, blockID string) (Block, error) {
+	block, err := m.getBlock(blockID)
+	if err != nil {
+		return Block{}, err
+	}
+	if block.SessionID != sessionID {
+		return Block{}, fmt.Errorf("block %s does not belong to session %s", blockID, sessionID)
+	}
+	return block, nil
+}
+
+// blockIterations rebuilds the versions of a block's code from its initial
+// code and refinements
+func (m *Manager) blockIterations(block Block) ([]Iteration, error) {
+	blockData, err := m.lifecycleDB.GetBlock(block.BlockID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve block: %w", err)
+	}
+	refinements, err := m.getRefinements(block.BlockID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve refinements: %w", err)
+	}
+
+	initialCode, _ := blockData["initial_code"].(string)
+	if initialCode == "" && len(refinements) == 0 {
+		initialCode = block.Code
+	}
+
+	iterations := make([]Iteration, 0, len(refinements)+1)
+	iterations = append(iterations, Iteration{
+		CodeHash:  workspace.HashContent(initialCode),
+		CreatedAt: block.GeneratedAt,
+		code:      initialCode,
+	})
+	for i, r := range refinements {
+		previous := iterations[len(iterations)-1].code
+		iterations = append(iterations, Iteration{
+			Iteration:    i + 1,
+			RefinementID: r.RefinementID,
+			Feedback:     r.Feedback,
+			CodeHash:     workspace.HashContent(r.RefinedCode),
+			Stats:        diff.Stat(previous, r.RefinedCode),
+			CreatedAt:    r.CreatedAt,
+			code:         r.RefinedCode,
+		})
+	}
+
+	// The latest iteration with the block's code is the current one
+	for i := len(iterations) - 1; i >= 0; i-- {
+		if iterations[i].CodeHash == block.CodeHash {
+			iterations[i].Current = true
+			break
+		}
+	}
+
+	return iterations, nil
+}
+
+// checkIteration validates an iteration number
+func checkIteration(iterations []Iteration, n int) error {
+	if n < 0 || n >= len(iterations) {
+		return fmt.Errorf("iteration %d does not exist: the block has iterations 0 to %d", n, len(iterations)-1)
+	}
+	return nil
+}
+
+// diffAgainstDisk diffs the target file on disk with an iteration, by default
+// the block's current code. For edit blocks the iteration's patch is applied
+// to the file, so the diff shows what committing it would change.
+func diffAgainstDisk(block Block, iterations []Iteration, from *int) (*DiffResponse, error) {
+	switch block.Type {
+	case "sql", "files":
+		return nil, fmt.Errorf("%s blocks have no single target file to diff against", block.Type)
+	}
+
+	name := "current"
+	code := block.Code
+	if from != nil {
+		if err := checkIteration(iterations, *from); err != nil {
+			return nil, err
+		}
+		name, code = fmt.Sprintf("iteration-%d", *from), iterations[*from].code
+	}
+
+	var onDisk, proposed string
+	if block.Type == "edit" {
+		original, patched, _, err := applyEdit(block.Target, code)
+		if err != nil {
+			return nil, err
+		}
+		onDisk, proposed = original, patched
+	} else {
+		content, err := os.ReadFile(block.Target)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", block.Target, err)
+		}
+		onDisk, proposed = string(content), code
+	}
+
+	return &DiffResponse{
+		BlockID: block.BlockID,
+		From:    block.Target,
+		To:      name,
+		Diff:    diff.Unified("a/"+block.Target, "b/"+block.Target, onDisk, proposed, diff.DefaultContext),
+		Stats:   diff.Stat(onDisk, proposed),
+	}, nil
+}
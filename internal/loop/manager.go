@@ -7,10 +7,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"brainloop/internal/cerebras"
 	"brainloop/internal/database"
+	"brainloop/internal/diff"
+	"brainloop/internal/patterns"
+	"brainloop/internal/workspace"
 
 	"github.com/google/uuid"
 )
@@ -20,87 +25,291 @@ type Manager struct {
 	lifecycleDB *database.LifecycleDB
 	outputDB    *database.OutputDB
 	cerebras    *cerebras.Client
-	mu          sync.Mutex
+	storage     *Storage
+	extractor   *patterns.Extractor
+	tools       func(root string) *cerebras.ToolRegistry // builds the generation tools, see SetGenerationTools
+	sessions    sessionLocks
+	generations chan struct{} // semaphore bounding concurrent LLM generations
+	actor       atomic.Value  // name of the client recorded on session events, see SetActor
 }
 
 // NewManager creates a new loop manager
 func NewManager(lifecycleDBConn *sql.DB, outputDBConn *sql.DB, cerebrasClient *cerebras.Client) *Manager {
+	lifecycleDB := database.NewLifecycleDB(lifecycleDBConn)
+	outputDB := database.NewOutputDB(outputDBConn)
 	return &Manager{
-		lifecycleDB: database.NewLifecycleDB(lifecycleDBConn),
-		outputDB:    database.NewOutputDB(outputDBConn),
+		lifecycleDB: lifecycleDB,
+		outputDB:    outputDB,
 		cerebras:    cerebrasClient,
+		storage:     NewStorage(lifecycleDB, outputDB),
+		extractor:   patterns.NewExtractor(lifecycleDBConn),
+		generations: generationPool(lifecycleDB),
 	}
 }
 
-// Propose creates a new session and generates initial code for all blocks
+// Propose creates a new session and generates initial code for all blocks.
+// The session is not visible to other callers until Propose returns, so it
+// takes no session lock.
 func (m *Manager) Propose(req ProposeRequest) (*ProposeResponse, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	// Recipe blocks come first; the request's blocks may depend on them
+	var inputs []BlockInput
+	var recipes []RecipeBlock
+	if req.Recipe != "" {
+		var err error
+		if inputs, recipes, err = m.recipeBlocks(req.Recipe, req.RecipeVersion, req.Variables); err != nil {
+			return nil, err
+		}
+	}
+
+	// Anonymous blocks get an ID up front; they cannot be depended upon
+	inputs = append(inputs, withRecipeDependencies(req.Blocks, recipes)...)
+	for i := range inputs {
+		if inputs[i].ID == "" {
+			inputs[i].ID = uuid.New().String()
+		}
+	}
+
+	levels, err := dependencyLevels(inputs)
+	if err != nil {
+		return nil, err
+	}
+	for _, input := range inputs {
+		if input.Test != nil {
+			if err := checkAcceptanceTest(input.Test, input.Type); err != nil {
+				return nil, fmt.Errorf("block %s: %w", input.ID, err)
+			}
+		}
+	}
+	root, err := sessionProjectRoot(req, inputs)
+	if err != nil {
+		return nil, err
+	}
+	if req.UseTools && m.tools == nil {
+		return nil, errNoTools
+	}
 
 	// Create session
 	sessionID := uuid.New().String()
 	if err := m.lifecycleDB.CreateSession(sessionID, "pending_audit"); err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
+	if err := m.lifecycleDB.SetSessionProjectRoot(sessionID, root); err != nil {
+		return nil, fmt.Errorf("failed to bind session to %s: %w", root, err)
+	}
+	created := fmt.Sprintf("%d block(s) proposed for %s", len(inputs), root)
+	if req.Recipe != "" {
+		created += fmt.Sprintf(", from recipe %s", req.Recipe)
+	}
+	m.recordEvent(sessionID, "", eventCreated, created, 0, 0)
 
-	// Generate code for each block in parallel
-	var wg sync.WaitGroup
-	blocks := make([]Block, len(req.Blocks))
-	errors := make([]error, len(req.Blocks))
-
-	for i, blockInput := range req.Blocks {
-		wg.Add(1)
-		go func(idx int, input BlockInput) {
-			defer wg.Done()
+	// Every generation of the session follows the project's patterns
+	pattern, err := m.sessionPatterns(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	tools, err := m.sessionTools(sessionID, req.UseTools, req.MaxToolSteps)
+	if err != nil {
+		return nil, err
+	}
 
-			blockID := input.ID
-			if blockID == "" {
-				blockID = uuid.New().String()
+	for _, input := range inputs {
+		if err := m.lifecycleDB.CreateBlock(input.ID, sessionID, input.Description, input.Type, input.Target); err != nil {
+			return nil, fmt.Errorf("failed to create block %s: %w", input.ID, err)
+		}
+		if input.Test != nil {
+			testJSON, _ := json.Marshal(input.Test)
+			if err := m.lifecycleDB.SetBlockTest(input.ID, string(testJSON)); err != nil {
+				return nil, fmt.Errorf("failed to store acceptance test of block %s: %w", input.ID, err)
 			}
-
-			// Create block record
-			if err := m.lifecycleDB.CreateBlock(blockID, sessionID, input.Description, input.Type, input.Target); err != nil {
-				errors[idx] = fmt.Errorf("failed to create block %s: %w", blockID, err)
-				return
+		}
+	}
+	for _, input := range inputs {
+		for _, dep := range input.DependsOn {
+			if err := m.lifecycleDB.AddBlockDependency(sessionID, input.ID, dep); err != nil {
+				return nil, fmt.Errorf("failed to record dependency %s -> %s: %w", input.ID, dep, err)
 			}
+		}
+	}
 
-			// Generate initial code
-			code, err := m.generateCode(input.Description, input.Type, 0.6, nil)
-			if err != nil {
-				errors[idx] = fmt.Errorf("failed to generate code for block %s: %w", blockID, err)
-				return
-			}
+	// A block that fails to generate is recorded as failed and can be retried;
+	// the other blocks are kept
+	selected := make(map[string]int, len(inputs))
+	for _, input := range inputs {
+		selected[input.ID] = 0
+	}
+	blocks, err := m.generateBlocks(inputs, levels, selected, nil, pattern, tools)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ProposeResponse{
+		SessionID:   sessionID,
+		ProjectRoot: root,
+		Blocks:      blocks,
+		Recipes:     recipes,
+	}
+	if promptPatterns(pattern) != nil {
+		response.PatternID = pattern.PatternID
+	}
+	response.Generated, response.Failed, response.Message = generationSummary(blocks)
+
+	return response, nil
+}
 
-			// Update block with code
-			if err := m.lifecycleDB.UpdateBlockCode(blockID, code); err != nil {
-				errors[idx] = fmt.Errorf("failed to update block code %s: %w", blockID, err)
-				return
+// generateBlocks generates the selected blocks level by level in topological
+// order, each from the version it maps to; blocks of a level only depend on
+// earlier levels and are generated in parallel by at most one worker per slot
+// of the generation pool, with tools when not nil. Blocks that are not
+// selected are taken from current.
+// A block whose generation fails, or whose dependency has no code, is
+// recorded as failed; only storage errors abort the run. It returns the
+// blocks in input order.
+func (m *Manager) generateBlocks(inputs []BlockInput, levels [][]int, selected map[string]int, current map[string]Block, pattern *patterns.Pattern, tools *toolUse) ([]Block, error) {
+	blocks := make([]Block, len(inputs))
+	generated := make(map[string]Block, len(inputs))
+	for id, block := range current {
+		generated[id] = block
+	}
+
+	for _, level := range levels {
+		var jobs []int
+		for _, idx := range level {
+			if _, ok := selected[inputs[idx].ID]; ok {
+				jobs = append(jobs, idx)
+			} else {
+				blocks[idx] = generated[inputs[idx].ID]
 			}
+		}
+
+		var wg sync.WaitGroup
+		errors := make([]error, len(jobs))
+
+		slots := make(chan int, len(jobs))
+		for slot := range jobs {
+			slots <- slot
+		}
+		close(slots)
+
+		workers := m.poolSize()
+		if workers > len(jobs) {
+			workers = len(jobs)
+		}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(jobs []int) {
+				defer wg.Done()
+				for slot := range slots {
+					input := inputs[jobs[slot]]
+					blocks[jobs[slot]], errors[slot] = m.proposeBlock(input, generated, selected[input.ID], pattern, tools)
+				}
+			}(jobs)
+		}
+
+		wg.Wait()
 
-			// Retrieve complete block
-			blockData, err := m.lifecycleDB.GetBlock(blockID)
+		// Check for errors
+		for _, err := range errors {
 			if err != nil {
-				errors[idx] = fmt.Errorf("failed to retrieve block %s: %w", blockID, err)
-				return
+				return nil, err
 			}
+		}
 
-			blocks[idx] = mapToBlock(blockData)
-		}(i, blockInput)
+		for _, idx := range jobs {
+			generated[inputs[idx].ID] = blocks[idx]
+		}
 	}
 
-	wg.Wait()
+	return blocks, nil
+}
 
-	// Check for errors
-	for _, err := range errors {
-		if err != nil {
-			return nil, err
+// proposeBlock generates the code of a block from version with its
+// dependencies' code as context and runs its acceptance test. A failed
+// generation is recorded on the block, which is returned with its error.
+func (m *Manager) proposeBlock(input BlockInput, generated map[string]Block, version int, pattern *patterns.Pattern, tools *toolUse) (Block, error) {
+	var dependencies []Block
+	for _, dep := range input.DependsOn {
+		block := generated[dep]
+		if block.Status == "failed" || block.Code == "" {
+			return m.failBlock(input.ID, fmt.Errorf("dependency %s has no code: retry it first", dep))
 		}
+		dependencies = append(dependencies, block)
 	}
 
-	return &ProposeResponse{
-		SessionID: sessionID,
-		Blocks:    blocks,
-	}, nil
+	prompt, err := blockRequirement(input.Description, input.Type, input.Target, dependencies)
+	if err != nil {
+		return m.failBlock(input.ID, err)
+	}
+	code, tokens, err := m.generateCode(input.ID, prompt, input.Type, 0.6, promptPatterns(pattern), tools)
+	if err != nil {
+		return m.failBlock(input.ID, fmt.Errorf("failed to generate code: %w", err))
+	}
+
+	// Update block with code
+	if err := m.lifecycleDB.SetGeneratedCode(input.ID, code, version); err != nil {
+		return Block{}, fmt.Errorf("failed to update block code %s: %w", input.ID, versionConflict(input.ID, err))
+	}
+	if err := m.recordPatterns(input.ID, pattern); err != nil {
+		return Block{}, err
+	}
+
+	// Retrieve complete block
+	block, err := m.getBlock(input.ID)
+	if err != nil {
+		return Block{}, err
+	}
+
+	// Run the acceptance test against the candidate
+	if block.TestResult, err = m.runAcceptanceTest(block, dependencies); err != nil {
+		return Block{}, err
+	}
+	detail := fmt.Sprintf("%d line(s) of %s", strings.Count(code, "\n")+1, block.Type)
+	if block.TestResult != nil {
+		detail += fmt.Sprintf(", acceptance test %s", block.TestResult.Status)
+	}
+	m.recordEvent(block.SessionID, block.BlockID, eventGenerated, detail, 0.6, tokens)
+
+	return block, nil
+}
+
+// failBlock records a block's generation error and returns the failed block
+func (m *Manager) failBlock(blockID string, cause error) (Block, error) {
+	if err := m.lifecycleDB.FailBlock(blockID, cause.Error()); err != nil {
+		return Block{}, fmt.Errorf("failed to record generation error of block %s (%v): %w", blockID, cause, err)
+	}
+	block, err := m.getBlock(blockID)
+	if err != nil {
+		return Block{}, err
+	}
+	m.recordEvent(block.SessionID, blockID, eventFailed, cause.Error(), 0, 0)
+	return block, nil
+}
+
+// failedBlock refuses to work on a block that failed to generate
+func failedBlock(block Block) error {
+	return fmt.Errorf("block %s failed to generate (%s): retry it first", block.BlockID, block.Error)
+}
+
+// committedBlock refuses to change a block whose code was already written
+func committedBlock(block Block) error {
+	return fmt.Errorf("block %s is already committed: roll it back first", block.BlockID)
+}
+
+// generationSummary counts generated and failed blocks
+func generationSummary(blocks []Block) (int, int, string) {
+	generated, failed := 0, 0
+	for _, b := range blocks {
+		if b.Status == "failed" {
+			failed++
+		} else {
+			generated++
+		}
+	}
+
+	message := fmt.Sprintf("%d block(s) generated", generated)
+	if failed > 0 {
+		message = fmt.Sprintf("%d of %d block(s) generated, %d failed; retry them with mode retry", generated, len(blocks), failed)
+	}
+	return generated, failed, message
 }
 
 // Audit retrieves a block for audit
@@ -116,17 +325,46 @@ func (m *Manager) Audit(req AuditRequest) (*AuditResponse, error) {
 	}
 
 	block := mapToBlock(blockData)
+	if block.DependsOn, err = m.lifecycleDB.GetBlockDependencies(req.BlockID); err != nil {
+		return nil, fmt.Errorf("failed to retrieve dependencies: %w", err)
+	}
 
-	return &AuditResponse{
-		Block: block,
-	}, nil
+	if block.Refinements, err = m.getRefinements(req.BlockID); err != nil {
+		return nil, fmt.Errorf("failed to retrieve refinements: %w", err)
+	}
+	history, err := m.blockIterations(block)
+	if err != nil {
+		return nil, err
+	}
+
+	m.recordEvent(req.SessionID, req.BlockID, eventAudited,
+		fmt.Sprintf("reviewed iteration %d (version %d)", len(history)-1, block.Version), 0, 0)
+
+	response := &AuditResponse{
+		Block:   block,
+		History: history,
+	}
+	if block.Type == "edit" {
+		response.Patch = previewPatch(block)
+	}
+	if block.PatternID != "" {
+		pattern, err := m.extractor.GetPatternByID(block.PatternID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve patterns of block %s: %w", req.BlockID, err)
+		}
+		if pattern != nil {
+			response.Patterns = pattern.PatternData
+		}
+	}
+
+	return response, nil
 }
 
-// Refine regenerates code for a block based on audit feedback
+// Refine regenerates code for a block based on audit feedback. The generation runs without the session lock; the
+// refined code is stored only if the block is still at the version it was
+// generated from, so a concurrent write is reported as a conflict instead of
+// being overwritten.
 func (m *Manager) Refine(req RefineRequest) (*RefineResponse, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	// Get current block
 	blockData, err := m.lifecycleDB.GetBlock(req.BlockID)
 	if err != nil {
@@ -139,48 +377,133 @@ func (m *Manager) Refine(req RefineRequest) (*RefineResponse, error) {
 	if block.SessionID != req.SessionID {
 		return nil, fmt.Errorf("block %s does not belong to session %s", req.BlockID, req.SessionID)
 	}
+	if err := m.requireActiveSession(req.SessionID); err != nil {
+		return nil, err
+	}
+	if block.Status == "failed" {
+		return nil, failedBlock(block)
+	}
+	if block.Status == "committed" {
+		return nil, committedBlock(block)
+	}
+	if req.Version > 0 && req.Version != block.Version {
+		return nil, fmt.Errorf("block %s is at version %d, not %d: it changed since it was reviewed, audit it again: %w",
+			req.BlockID, block.Version, req.Version, database.ErrVersionConflict)
+	}
 
-	// Build refined prompt with feedback
-	refinedPrompt := fmt.Sprintf("Original requirement: %s\n\nCurrent code:\n%s\n\nFeedback: %s\n\nGenerate improved code addressing the feedback.",
-		block.Description, block.Code, req.AuditFeedback)
+	// Rebuild the conversation from every earlier refinement
+	refinements, err := m.getRefinements(req.BlockID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve refinements: %w", err)
+	}
 
-	// Generate refined code with lower temperature
-	refinedCode, err := m.generateCode(refinedPrompt, block.Type, 0.3, nil)
+	initialCode, _ := blockData["initial_code"].(string)
+	if initialCode == "" && len(refinements) == 0 {
+		initialCode = block.Code
+	}
+
+	// Dependencies' current code is part of the requirement
+	dependencies, err := m.dependencyBlocks(req.BlockID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate refined code: %w", err)
+		return nil, err
+	}
+	requirement, err := blockRequirement(block.Description, block.Type, block.Target, dependencies)
+	if err != nil {
+		return nil, err
 	}
+	// A failing acceptance test of the current code is part of the feedback
+	feedback := req.AuditFeedback
+	if result := block.TestResult; result != nil && result.Status != testPassed && result.CodeHash == block.CodeHash {
+		feedback += "\n\n" + testFeedback(result)
+	}
+	history := buildRefineHistory(requirement, initialCode, refinements, feedback)
 
-	// Record refinement
-	refinementID := uuid.New().String()
-	if err := m.lifecycleDB.AddRefinement(refinementID, req.BlockID, req.AuditFeedback, refinedCode, 0.3); err != nil {
-		return nil, fmt.Errorf("failed to record refinement: %w", err)
+	pattern, err := m.sessionPatterns(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	tools, err := m.sessionTools(req.SessionID, req.UseTools, req.MaxToolSteps)
+	if err != nil {
+		return nil, err
 	}
 
-	// Update block code
-	if err := m.lifecycleDB.UpdateBlockCode(req.BlockID, refinedCode); err != nil {
-		return nil, fmt.Errorf("failed to update block code: %w", err)
+	// Generate refined code with lower temperature
+	refinedCode, tokens, err := m.generateConversation(block.BlockID, block.Type, history.Messages, 0.3, promptPatterns(pattern), tools)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refined code: %w", err)
 	}
 
-	// Get updated block
-	blockData, err = m.lifecycleDB.GetBlock(req.BlockID)
+	// Store the refinement unless the block changed during the generation
+	unlock := m.lockSession(req.SessionID)
+	staleDependants, err := m.storeRefinement(block, feedback, refinedCode, pattern)
+	unlock()
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve updated block: %w", err)
+		return nil, err
 	}
+	m.recordEvent(req.SessionID, req.BlockID, eventRefined, req.AuditFeedback, 0.3, tokens)
 
-	updatedBlock := mapToBlock(blockData)
+	// Get updated block
+	updatedBlock, err := m.getBlock(req.BlockID)
+	if err != nil {
+		return nil, err
+	}
+	if updatedBlock.TestResult, err = m.runAcceptanceTest(updatedBlock, dependencies); err != nil {
+		return nil, err
+	}
 
 	return &RefineResponse{
-		Block:       updatedBlock,
-		RefinedCode: refinedCode,
-		Iterations:  updatedBlock.Iterations,
+		Block:                updatedBlock,
+		RefinedCode:          refinedCode,
+		Iterations:           updatedBlock.Iterations,
+		HistoryMessages:      len(history.Messages),
+		SummarizedIterations: history.SummarizedIterations,
+		StaleDependants:      staleDependants,
+		TokensUsed:           tokens,
 	}, nil
 }
 
-// Commit finalizes a block (executes SQL or writes file)
+// storeRefinement records refined code generated from block's version; the
+// caller holds the session lock
+func (m *Manager) storeRefinement(block Block, feedback, refinedCode string, pattern *patterns.Pattern) ([]string, error) {
+	if err := m.requireActiveSession(block.SessionID); err != nil {
+		return nil, err
+	}
+
+	refinementID := uuid.New().String()
+	if err := m.lifecycleDB.ApplyRefinement(refinementID, block.BlockID, feedback, refinedCode, 0.3, block.Version); err != nil {
+		return nil, fmt.Errorf("failed to store refinement: %w", versionConflict(block.BlockID, err))
+	}
+	if err := m.recordPatterns(block.BlockID, pattern); err != nil {
+		return nil, err
+	}
+
+	// Blocks built on the previous code must be refined again
+	staleDependants, err := m.lifecycleDB.MarkDependantsStale(block.BlockID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark dependants stale: %w", err)
+	}
+
+	return staleDependants, nil
+}
+
+// Commit finalizes a block (executes SQL or writes file). It writes exactly
+// the reviewed code, identified by the code hash the client echoes back. With
+// Regenerate, a final generation pass is stored as pending and returned as a
+// diff; committing again with its hash confirms it.
 func (m *Manager) Commit(req CommitRequest) (*CommitResponse, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	unlock := m.lockSession(req.SessionID)
+	response, err := m.commit(req)
+	unlock()
+	if err != nil || !req.AutoRefine || !response.rejected() {
+		return response, err
+	}
 
+	// The refine takes the session lock to store its code
+	return m.refineRejected(response, req)
+}
+
+// commit implements Commit; the caller holds the session lock
+func (m *Manager) commit(req CommitRequest) (*CommitResponse, error) {
 	// Get block
 	blockData, err := m.lifecycleDB.GetBlock(req.BlockID)
 	if err != nil {
@@ -194,84 +517,408 @@ func (m *Manager) Commit(req CommitRequest) (*CommitResponse, error) {
 		return nil, fmt.Errorf("block %s does not belong to session %s", req.BlockID, req.SessionID)
 	}
 
-	// Final generation with very low temperature (deterministic)
-	finalCode, err := m.generateCode(block.Description, block.Type, 0.1, nil)
+	if err := m.requireActiveSession(req.SessionID); err != nil {
+		return nil, err
+	}
+
+	if block.Status == "failed" {
+		return nil, failedBlock(block)
+	}
+	if block.Status == "committed" {
+		return nil, committedBlock(block)
+	}
+	if block.Code == "" {
+		return nil, fmt.Errorf("block %s has no code to commit", req.BlockID)
+	}
+	if req.CodeHash == "" {
+		return nil, fmt.Errorf("missing code_hash: echo the code_hash of the reviewed block")
+	}
+
+	// Commit order follows the dependency graph
+	if block.Stale {
+		return nil, fmt.Errorf("block %s is stale: a dependency changed since it was generated, refine it before committing", req.BlockID)
+	}
+	dependencies, err := m.dependencyBlocks(req.BlockID)
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range dependencies {
+		if dep.Status != "committed" {
+			return nil, fmt.Errorf("block %s depends on %s, which must be committed first", req.BlockID, dep.BlockID)
+		}
+	}
+
+	if req.Regenerate {
+		return m.regenerateForCommit(block, dependencies, req)
+	}
+
+	// Commit the reviewed code, or confirmed regenerated code
+	finalCode := block.Code
+	confirmed := false
+	if req.CodeHash != block.CodeHash {
+		pendingCode, _ := blockData["pending_code"].(string)
+		if pendingCode == "" || req.CodeHash != workspace.HashContent(pendingCode) {
+			return nil, fmt.Errorf("code_hash mismatch: block %s changed since it was reviewed (current code_hash %s)", req.BlockID, block.CodeHash)
+		}
+		finalCode = pendingCode
+		confirmed = true
+	}
+
+	// The same code is never written twice
+	hash := calculateHash(req.SessionID, req.BlockID, finalCode)
+	if processed, err := m.lifecycleDB.IsProcessed(hash); err != nil {
+		return nil, fmt.Errorf("failed to check processed log: %w", err)
+	} else if processed {
+		return nil, fmt.Errorf("block %s was already committed with this code", req.BlockID)
+	}
+
+	// Nothing is written or executed unless the code passes the validation gate
+	report, err := m.runGate(block, finalCode)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Valid {
+		return rejectCommit(block, report), nil
+	}
+
+	// Back up every target before it is modified
+	targets, err := commitTargets(block, finalCode)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := m.backupTargets(block, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	outputPath, files, err := m.writeBlock(block, finalCode)
+	if err != nil {
+		m.discardBackups(backups)
+		return nil, err
+	}
+	if err := m.recordCommittedHashes(backups); err != nil {
+		m.restoreCommit(backups)
+		return nil, fmt.Errorf("commit failed, every target was restored: %w", err)
+	}
+
+	// Confirmed regenerated code becomes the block's code, as an iteration of its own
+	if confirmed {
+		if err := m.lifecycleDB.ApplyRefinement(uuid.New().String(), req.BlockID, regeneratedFeedback, finalCode, regenerateTemperature, block.Version); err != nil {
+			m.restoreCommit(backups)
+			return nil, fmt.Errorf("commit failed, every target was restored: failed to store the regenerated code: %w", err)
+		}
+	}
+
+	// Mark as processed and committed together, or undo the write
+	resultJSON, _ := json.Marshal(map[string]interface{}{
+		"block_id":    req.BlockID,
+		"output_path": outputPath,
+		"type":        block.Type,
+		"files":       files,
+	})
+	if err := m.lifecycleDB.CommitBlocks([]map[string]string{{
+		"block_id": req.BlockID, "hash": hash, "result_json": string(resultJSON),
+	}}); err != nil {
+		m.restoreCommit(backups)
+		return nil, fmt.Errorf("commit failed, every target was restored: %w", err)
+	}
+
+	if confirmed && finalCode != block.Code {
+		if _, err := m.lifecycleDB.MarkDependantsStale(req.BlockID); err != nil {
+			return nil, fmt.Errorf("failed to mark dependants stale: %w", err)
+		}
+	}
+
+	// The session is complete once its last block is committed
+	if err := m.syncSessionStatus(req.SessionID); err != nil {
+		return nil, err
+	}
+
+	// Get final block
+	committedBlock, err := m.getBlock(req.BlockID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate final code: %w", err)
+		return nil, err
 	}
+	m.recordEvent(req.SessionID, req.BlockID, eventCommitted, fmt.Sprintf("written to %s", outputPath), 0, 0)
+
+	return &CommitResponse{
+		Block:      committedBlock,
+		Success:    true,
+		Message:    fmt.Sprintf("Block committed successfully to %s", outputPath),
+		OutputPath: outputPath,
+		Files:      files,
+		Backups:    backups,
+		Git:        m.recordGit(req.SessionID, []Block{committedBlock}, targets),
+		Validation: report,
+	}, nil
+}
 
-	// Execute based on type
+// writeBlock executes SQL or writes the files of a block
+func (m *Manager) writeBlock(block Block, finalCode string) (string, []workspace.ManifestEntry, error) {
 	var outputPath string
+	var files []workspace.ManifestEntry
+	var err error
 	switch block.Type {
 	case "sql":
 		// Execute SQL (assuming target is a database path)
 		if err := m.executeSQL(block.Target, finalCode); err != nil {
-			return nil, fmt.Errorf("failed to execute SQL: %w", err)
+			return "", nil, fmt.Errorf("failed to execute SQL: %w", err)
+		}
+		outputPath = block.Target
+
+	case "edit":
+		// Apply the reviewed patch to the file as it is now
+		_, patched, _, err := applyEdit(block.Target, finalCode)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to apply patch to %s: %w", block.Target, err)
+		}
+		if err := os.WriteFile(block.Target, []byte(patched), 0644); err != nil {
+			return "", nil, fmt.Errorf("failed to write file %s: %w", block.Target, err)
 		}
 		outputPath = block.Target
 
 	case "go", "python", "code":
 		// Write file
 		if err := os.WriteFile(block.Target, []byte(finalCode), 0644); err != nil {
-			return nil, fmt.Errorf("failed to write file %s: %w", block.Target, err)
+			return "", nil, fmt.Errorf("failed to write file %s: %w", block.Target, err)
+		}
+		outputPath = block.Target
+
+	case "files":
+		// Write every annotated file under the target directory
+		files, err = m.writeFiles(block.Target, finalCode)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to write files under %s: %w", block.Target, err)
 		}
 		outputPath = block.Target
 
 	default:
-		return nil, fmt.Errorf("unsupported block type: %s", block.Type)
+		return "", nil, fmt.Errorf("unsupported block type: %s", block.Type)
 	}
 
-	// Calculate hash for idempotence
-	hash := calculateHash(req.SessionID, req.BlockID, finalCode)
+	return outputPath, files, nil
+}
 
-	// Mark as processed
-	resultJSON, _ := json.Marshal(map[string]interface{}{
-		"block_id":    req.BlockID,
-		"output_path": outputPath,
-		"type":        block.Type,
-	})
-	if err := m.lifecycleDB.MarkProcessed(hash, "commit", string(resultJSON)); err != nil {
-		return nil, fmt.Errorf("failed to mark processed: %w", err)
+const (
+	// regenerateTemperature keeps the final generation pass near deterministic
+	regenerateTemperature = 0.1
+
+	// regeneratedFeedback is the refinement recorded for regenerated code confirmed at commit
+	regeneratedFeedback = "Regenerated at commit"
+)
+
+// regenerateForCommit runs a final generation pass for a reviewed block and
+// stores the result as pending. Nothing is written until the client commits
+// again with the pending code hash.
+func (m *Manager) regenerateForCommit(block Block, dependencies []Block, req CommitRequest) (*CommitResponse, error) {
+	if req.CodeHash != block.CodeHash {
+		return nil, fmt.Errorf("code_hash mismatch: block %s changed since it was reviewed (current code_hash %s)", req.BlockID, block.CodeHash)
 	}
 
-	// Update block status
-	if err := m.lifecycleDB.CommitBlock(req.BlockID); err != nil {
-		return nil, fmt.Errorf("failed to commit block: %w", err)
+	// Final generation with very low temperature (deterministic), or the best
+	// locally validated of several candidates
+	var finalCode string
+	var bestOfN *cerebras.BestOfNResult
+	prompt, err := blockRequirement(block.Description, block.Type, block.Target, dependencies)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := m.sessionPatterns(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Candidates > 1 {
+		bestOfN, err = m.generateBestOfN(block.BlockID, prompt, block.Type, regenerateTemperature, req.Candidates, promptPatterns(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate final code: %w", err)
+		}
+		finalCode = bestOfN.Code
+	} else {
+		finalCode, _, err = m.generateCode(block.BlockID, prompt, block.Type, regenerateTemperature, promptPatterns(pattern), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate final code: %w", err)
+		}
 	}
 
-	// Update block code one last time
-	if err := m.lifecycleDB.UpdateBlockCode(req.BlockID, finalCode); err != nil {
-		return nil, fmt.Errorf("failed to update final code: %w", err)
+	if err := m.lifecycleDB.SetBlockPendingCode(req.BlockID, finalCode); err != nil {
+		return nil, fmt.Errorf("failed to store regenerated code: %w", err)
+	}
+	if err := m.recordPatterns(req.BlockID, pattern); err != nil {
+		return nil, err
 	}
 
-	// Get final block
-	blockData, err = m.lifecycleDB.GetBlock(req.BlockID)
+	stats := diff.Stat(block.Code, finalCode)
+	response := &CommitResponse{
+		Block:                block,
+		Success:              false,
+		ConfirmationRequired: true,
+		PendingCodeHash:      workspace.HashContent(finalCode),
+		Diff:                 diff.Unified("reviewed/"+block.Target, "regenerated/"+block.Target, block.Code, finalCode, diff.DefaultContext),
+		DiffStats:            &stats,
+		Message:              "Regenerated code differs from the reviewed code; commit again with code_hash set to pending_code_hash to write it, or with the reviewed code_hash to keep the reviewed code",
+	}
+	if response.Diff == "" {
+		response.Message = "Regenerated code is identical to the reviewed code; commit again with code_hash to write it"
+	}
+	if bestOfN != nil {
+		response.SelectedCandidate = &bestOfN.Selected
+		response.Candidates = bestOfN.Candidates
+	}
+
+	return response, nil
+}
+
+// getBlock retrieves a block with its dependency IDs
+func (m *Manager) getBlock(blockID string) (Block, error) {
+	blockData, err := m.lifecycleDB.GetBlock(blockID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve committed block: %w", err)
+		return Block{}, fmt.Errorf("failed to retrieve block %s: %w", blockID, err)
 	}
 
-	committedBlock := mapToBlock(blockData)
+	block := mapToBlock(blockData)
+	if block.DependsOn, err = m.lifecycleDB.GetBlockDependencies(blockID); err != nil {
+		return Block{}, fmt.Errorf("failed to retrieve dependencies of %s: %w", blockID, err)
+	}
 
-	return &CommitResponse{
-		Block:      committedBlock,
-		Success:    true,
-		Message:    fmt.Sprintf("Block committed successfully to %s", outputPath),
-		OutputPath: outputPath,
-	}, nil
+	return block, nil
+}
+
+// dependencyBlocks retrieves the blocks a block depends on, with their current code
+func (m *Manager) dependencyBlocks(blockID string) ([]Block, error) {
+	ids, err := m.lifecycleDB.GetBlockDependencies(blockID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve dependencies of %s: %w", blockID, err)
+	}
+
+	var dependencies []Block
+	for _, id := range ids {
+		blockData, err := m.lifecycleDB.GetBlock(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve dependency %s: %w", id, err)
+		}
+		dependencies = append(dependencies, mapToBlock(blockData))
+	}
+
+	return dependencies, nil
+}
+
+// writeFiles writes the path-annotated code blocks of a "files" block under root
+func (m *Manager) writeFiles(root, content string) ([]workspace.ManifestEntry, error) {
+	files, err := blockFiles(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return workspace.WriteFiles(root, files)
 }
 
-// generateCode generates code using Cerebras
-func (m *Manager) generateCode(prompt, codeType string, temperature float64, patterns interface{}) (string, error) {
-	result, err := m.cerebras.GenerateCodeWithTemperature(prompt, codeType, patterns, temperature)
+// blockFiles extracts the path-annotated files of a "files" block
+func blockFiles(content string) ([]workspace.File, error) {
+	blocks, err := cerebras.ExtractFileBlocks(content)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	files := make([]workspace.File, 0, len(blocks))
+	for _, b := range blocks {
+		files = append(files, workspace.File{
+			Path:     b.Path,
+			Language: b.Language,
+			Content:  strings.TrimSpace(b.Content) + "\n",
+		})
 	}
 
+	return files, nil
+}
+
+// generateCode generates code using Cerebras, within the generation pool and
+// with tools when not nil, and returns it with the tokens spent
+func (m *Manager) generateCode(blockID, prompt, codeType string, temperature float64, patterns interface{}, tools *toolUse) (string, int, error) {
+	release := m.acquireGeneration()
+	var result *cerebras.GenerationResult
+	var err error
+	if tools != nil {
+		_, result, err = m.cerebras.GenerateCodeWithTools(prompt, codeType, patterns, temperature, tools.registry, tools.maxSteps)
+	} else {
+		result, err = m.cerebras.GenerateCodeWithTemperature(prompt, codeType, patterns, temperature)
+	}
+	release()
+	if err != nil {
+		return "", 0, err
+	}
+
+	m.recordUsage("generate_code", blockID, result)
+
+	return cleanBlockCode(result.Content, codeType), result.PromptTokens + result.CompletionTokens, nil
+}
+
+// generateBestOfN generates n candidates and keeps the best validated one
+func (m *Manager) generateBestOfN(blockID, prompt, codeType string, temperature float64, n int, patterns interface{}) (*cerebras.BestOfNResult, error) {
+	// Each candidate is a generation of its own
+	result, err := m.cerebras.GenerateBestOfNWithSlots(prompt, codeType, patterns, temperature, n, m.acquireGeneration)
+	if err != nil {
+		return nil, err
+	}
+
+	m.recordUsage("generate_best_of_n", blockID, result.Usage)
+
+	return result, nil
+}
+
+// generateConversation generates code from a multi-turn message history, with
+// tools when not nil, and returns it with the tokens spent
+func (m *Manager) generateConversation(blockID, codeType string, history []cerebras.Message, temperature float64, patterns interface{}, tools *toolUse) (string, int, error) {
+	release := m.acquireGeneration()
+	var result *cerebras.GenerationResult
+	var err error
+	if tools != nil {
+		result, err = m.cerebras.GenerateConversationWithTools(codeType, patterns, history, temperature, tools.registry, tools.maxSteps)
+	} else {
+		result, err = m.cerebras.GenerateConversation(codeType, patterns, history, temperature)
+	}
+	release()
+	if err != nil {
+		return "", 0, err
+	}
+
+	m.recordUsage("refine_code", blockID, result)
+
+	return cleanBlockCode(result.Content, codeType), result.PromptTokens + result.CompletionTokens, nil
+}
+
+// cleanBlockCode strips markdown fences so the stored code is exactly what
+// gets committed; multi-file blocks keep their path-annotated fences
+func cleanBlockCode(content, codeType string) string {
+	if codeType == "files" {
+		return content
+	}
+	return cerebras.CleanCode(content, codeType)
+}
+
+// getRefinements returns the refinements of a block, oldest first
+func (m *Manager) getRefinements(blockID string) ([]Refinement, error) {
+	rows, err := m.lifecycleDB.GetBlockRefinements(blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	refinements := make([]Refinement, 0, len(rows))
+	for _, row := range rows {
+		refinements = append(refinements, mapToRefinement(row))
+	}
+
+	return refinements, nil
+}
+
+// recordUsage records API usage and metrics for a generation made for a block
+func (m *Manager) recordUsage(operation, blockID string, result *cerebras.GenerationResult) {
 	// Record usage
 	requestID := uuid.New().String()
 	m.lifecycleDB.RecordCerebrasUsage(
 		requestID,
-		"generate_code",
+		blockID,
+		operation,
+		result.Provider,
 		result.Model,
 		result.Temperature,
 		result.PromptTokens,
@@ -283,12 +930,15 @@ func (m *Manager) generateCode(prompt, codeType string, temperature float64, pat
 	m.outputDB.RecordMetric("cerebras_tokens_prompt", float64(result.PromptTokens))
 	m.outputDB.RecordMetric("cerebras_tokens_completion", float64(result.CompletionTokens))
 	m.outputDB.RecordMetric("cerebras_latency_ms", float64(result.LatencyMs))
-
-	return result.Content, nil
 }
 
 // executeSQL executes SQL in a transaction
 func (m *Manager) executeSQL(dbPath, sqlCode string) error {
+	return executeScript(dbPath, sqlCode)
+}
+
+// executeScript executes SQL on a database in a transaction
+func executeScript(dbPath, sqlCode string) error {
 	// Open target database
 	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
@@ -336,6 +986,7 @@ func mapToBlock(data map[string]interface{}) Block {
 
 	if code, ok := data["code"].(string); ok {
 		block.Code = code
+		block.CodeHash = workspace.HashContent(code)
 	}
 	if lastRefined, ok := data["last_refined_at"].(int64); ok {
 		block.LastRefinedAt = lastRefined
@@ -343,6 +994,48 @@ func mapToBlock(data map[string]interface{}) Block {
 	if committed, ok := data["committed_at"].(int64); ok {
 		block.CommittedAt = committed
 	}
+	if stale, ok := data["stale"].(bool); ok {
+		block.Stale = stale
+	}
+	if patternID, ok := data["pattern_id"].(string); ok {
+		block.PatternID = patternID
+	}
+	if version, ok := data["version"].(int); ok {
+		block.Version = version
+	}
+	if generationError, ok := data["generation_error"].(string); ok {
+		block.Error = generationError
+	}
+	if raw, ok := data["test_json"].(string); ok && raw != "" {
+		var test AcceptanceTest
+		if json.Unmarshal([]byte(raw), &test) == nil {
+			block.Test = &test
+		}
+	}
+	if raw, ok := data["test_result_json"].(string); ok && raw != "" {
+		var result TestResult
+		if json.Unmarshal([]byte(raw), &result) == nil {
+			block.TestResult = &result
+		}
+	}
+	if raw, ok := data["validation_json"].(string); ok && raw != "" {
+		var report BlockValidation
+		if json.Unmarshal([]byte(raw), &report) == nil {
+			block.Validation = &report
+		}
+	}
 
 	return block
 }
+
+// mapToRefinement converts map to Refinement struct
+func mapToRefinement(data map[string]interface{}) Refinement {
+	return Refinement{
+		RefinementID: data["refinement_id"].(string),
+		BlockID:      data["block_id"].(string),
+		Feedback:     data["feedback"].(string),
+		Temperature:  data["temperature"].(float64),
+		RefinedCode:  data["refined_code"].(string),
+		CreatedAt:    data["created_at"].(int64),
+	}
+}
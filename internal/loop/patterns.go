@@ -0,0 +1,110 @@
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"brainloop/internal/cerebras"
+	"brainloop/internal/patterns"
+)
+
+// errNoTools refuses use_tools when the manager has no generation tools
+var errNoTools = errors.New("use_tools: no generation tools are configured")
+
+// toolUse lets the model call tools during a generation
+type toolUse struct {
+	registry *cerebras.ToolRegistry
+	maxSteps int
+}
+
+// sessionProjectRoot resolves the project a proposed session is bound to: the
+// requested root, or the project of its first block's target
+func sessionProjectRoot(req ProposeRequest, inputs []BlockInput) (string, error) {
+	if req.ProjectRoot == "" {
+		return projectRoot(inputs[0].Type, inputs[0].Target)
+	}
+
+	root, err := filepath.Abs(req.ProjectRoot)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("project root %s is not a directory", req.ProjectRoot)
+	}
+	return root, nil
+}
+
+// sessionPatterns returns the patterns of a session's project, extracted again
+// only when its Go or SQL files changed since they were cached. Sessions
+// without a project root, or whose project does not exist yet, have none.
+func (m *Manager) sessionPatterns(sessionID string) (*patterns.Pattern, error) {
+	session, err := m.lifecycleDB.GetSession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve session %s: %w", sessionID, err)
+	}
+	root, _ := session["project_root"].(string)
+	if root == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		// Nothing written to the project yet
+		return nil, nil
+	}
+
+	pattern, err := m.extractor.ExtractCached(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract patterns of %s: %w", root, err)
+	}
+	return pattern, nil
+}
+
+// promptPatterns returns the patterns injected into a generation prompt; a
+// project without Go or SQL files has nothing to follow
+func promptPatterns(pattern *patterns.Pattern) interface{} {
+	if pattern == nil || pattern.PatternType == "project" {
+		return nil
+	}
+	return pattern.PatternData
+}
+
+// recordPatterns records on a block the pattern set injected into its latest generation
+func (m *Manager) recordPatterns(blockID string, pattern *patterns.Pattern) error {
+	patternID := ""
+	if promptPatterns(pattern) != nil {
+		patternID = pattern.PatternID
+	}
+	if err := m.lifecycleDB.SetBlockPatterns(blockID, patternID); err != nil {
+		return fmt.Errorf("failed to record patterns of block %s: %w", blockID, err)
+	}
+	return nil
+}
+
+// SetGenerationTools sets how the tools the model may call during a
+// generation are built; their paths are confined to the root they are built for
+func (m *Manager) SetGenerationTools(build func(root string) *cerebras.ToolRegistry) {
+	m.tools = build
+}
+
+// sessionTools returns the generation tools confined to a session's project
+// root, or nil when the request does not use them
+func (m *Manager) sessionTools(sessionID string, useTools bool, maxSteps int) (*toolUse, error) {
+	if !useTools {
+		return nil, nil
+	}
+	if m.tools == nil {
+		return nil, errNoTools
+	}
+
+	session, err := m.lifecycleDB.GetSession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve session %s: %w", sessionID, err)
+	}
+	root, _ := session["project_root"].(string)
+	if root == "" {
+		return nil, fmt.Errorf("use_tools: session %s has no project root to confine the tools to", sessionID)
+	}
+
+	return &toolUse{registry: m.tools(root), maxSteps: maxSteps}, nil
+}
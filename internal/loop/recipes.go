@@ -0,0 +1,362 @@
+package loop
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// recipeName is the form of recipe names, which prefix block IDs
+	recipeName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+	// templateVariable matches a {{variable}} placeholder
+	templateVariable = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// blockTypes are the block types a recipe may produce
+var blockTypes = map[string]bool{"sql": true, "edit": true, "go": true, "python": true, "code": true, "files": true}
+
+// RecipeBlock is a block proposed from a recipe
+type RecipeBlock struct {
+	Recipe  string `json:"recipe"`
+	Version int    `json:"version"`
+	BlockID string `json:"block_id"`
+}
+
+// Recipe is a named, versioned block template. Its description, target and
+// test hold {{variable}} placeholders filled in when it is proposed.
+type Recipe struct {
+	Name        string          `json:"name"`
+	Version     int             `json:"version"`
+	Description string          `json:"description"`
+	Type        string          `json:"type"`
+	Target      string          `json:"target"`
+	DependsOn   []string        `json:"depends_on,omitempty"` // recipes proposed with this one, as its dependencies
+	Test        *AcceptanceTest `json:"test,omitempty"`
+	Note        string          `json:"note,omitempty"`
+	Variables   []string        `json:"variables"` // placeholders of this recipe, not of its dependencies
+	CreatedAt   int64           `json:"created_at"`
+}
+
+// CreateRecipe stores version 1 of a new recipe
+func (m *Manager) CreateRecipe(req RecipeRequest) (*Recipe, error) {
+	existing, err := m.lifecycleDB.GetRecipe(req.Name, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve recipe %s: %w", req.Name, err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("recipe %s already exists; use the version action to change it", req.Name)
+	}
+
+	recipe := Recipe{
+		Name:        req.Name,
+		Version:     1,
+		Description: req.Description,
+		Type:        req.Type,
+		Target:      req.Target,
+		DependsOn:   req.DependsOn,
+		Test:        req.Test,
+		Note:        req.Note,
+	}
+	return m.storeRecipe(recipe)
+}
+
+// VersionRecipe stores a new version of a recipe; fields left empty, and
+// depends_on when nil, keep the value of the latest version
+func (m *Manager) VersionRecipe(req RecipeRequest) (*Recipe, error) {
+	latest, err := m.getRecipe(req.Name, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	recipe := latest
+	recipe.Version = latest.Version + 1
+	recipe.Note = req.Note
+	if req.Description != "" {
+		recipe.Description = req.Description
+	}
+	if req.Type != "" {
+		recipe.Type = req.Type
+	}
+	if req.Target != "" {
+		recipe.Target = req.Target
+	}
+	if req.DependsOn != nil {
+		recipe.DependsOn = req.DependsOn
+	}
+	if req.Test != nil {
+		recipe.Test = req.Test
+	}
+
+	if recipe.Description == latest.Description && recipe.Type == latest.Type && recipe.Target == latest.Target &&
+		strings.Join(recipe.DependsOn, ",") == strings.Join(latest.DependsOn, ",") && reflect.DeepEqual(recipe.Test, latest.Test) {
+		return nil, fmt.Errorf("recipe %s version %d already has this content", req.Name, latest.Version)
+	}
+
+	return m.storeRecipe(recipe)
+}
+
+// ListRecipes returns the latest version of every recipe, or every version of
+// one recipe when a name is given
+func (m *Manager) ListRecipes(req RecipeListRequest) (*RecipeListResponse, error) {
+	rows, err := m.lifecycleDB.ListRecipes(req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipes: %w", err)
+	}
+	if req.Name != "" && len(rows) == 0 {
+		return nil, fmt.Errorf("unknown recipe %s", req.Name)
+	}
+
+	response := &RecipeListResponse{Recipes: make([]Recipe, 0, len(rows))}
+	for _, row := range rows {
+		response.Recipes = append(response.Recipes, mapToRecipe(row))
+	}
+	response.Count = len(response.Recipes)
+
+	return response, nil
+}
+
+// storeRecipe validates a recipe and stores it
+func (m *Manager) storeRecipe(recipe Recipe) (*Recipe, error) {
+	if !recipeName.MatchString(recipe.Name) {
+		return nil, fmt.Errorf("invalid recipe name %q: use letters, digits, '.', '_' and '-'", recipe.Name)
+	}
+	if recipe.Description == "" || recipe.Target == "" {
+		return nil, fmt.Errorf("recipe %s needs a description and a target", recipe.Name)
+	}
+	if !blockTypes[recipe.Type] {
+		return nil, fmt.Errorf("recipe %s: unsupported block type %q", recipe.Name, recipe.Type)
+	}
+	if recipe.Test != nil {
+		if err := checkAcceptanceTest(recipe.Test, recipe.Type); err != nil {
+			return nil, fmt.Errorf("recipe %s: %w", recipe.Name, err)
+		}
+	}
+	if err := m.checkRecipeDependencies(recipe.Name, recipe.DependsOn); err != nil {
+		return nil, err
+	}
+
+	dependsOnJSON, _ := json.Marshal(recipe.DependsOn)
+	var testJSON []byte
+	if recipe.Test != nil {
+		testJSON, _ = json.Marshal(recipe.Test)
+	}
+	if err := m.lifecycleDB.InsertRecipe(recipe.Name, recipe.Version, recipe.Description, recipe.Type, recipe.Target,
+		string(dependsOnJSON), string(testJSON), recipe.Note); err != nil {
+		return nil, fmt.Errorf("failed to store recipe %s version %d: %w", recipe.Name, recipe.Version, err)
+	}
+
+	stored, err := m.getRecipe(recipe.Name, recipe.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// checkRecipeDependencies refuses unknown recipes and dependency cycles
+// through the latest version of each recipe
+func (m *Manager) checkRecipeDependencies(name string, dependsOn []string) error {
+	visited := make(map[string]bool)
+	var visit func(dep string, path []string) error
+	visit = func(dep string, path []string) error {
+		path = append(path, dep)
+		if dep == name {
+			return fmt.Errorf("recipe dependency cycle: %s", strings.Join(path, " -> "))
+		}
+		if visited[dep] {
+			return nil
+		}
+		visited[dep] = true
+
+		recipe, err := m.getRecipe(dep, 0)
+		if err != nil {
+			return fmt.Errorf("recipe %s depends on %s: %w", name, dep, err)
+		}
+		for _, next := range recipe.DependsOn {
+			if err := visit(next, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, dep := range dependsOn {
+		if err := visit(dep, []string{name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recipeBlocks expands a recipe, and the latest version of every recipe it
+// depends on, into blocks, dependencies first. Block IDs are unique to the
+// proposal: the recipe name and a random suffix.
+func (m *Manager) recipeBlocks(name string, version int, variables map[string]string) ([]BlockInput, []RecipeBlock, error) {
+	var inputs []BlockInput
+	var used []RecipeBlock
+	missing := make(map[string]bool)
+	ids := make(map[string]string)
+
+	var expand func(name string, version int) error
+	expand = func(name string, version int) error {
+		if _, ok := ids[name]; ok {
+			return nil
+		}
+
+		recipe, err := m.getRecipe(name, version)
+		if err != nil {
+			return err
+		}
+		ids[name] = fmt.Sprintf("%s-%s", recipe.Name, uuid.New().String()[:8])
+		for _, dep := range recipe.DependsOn {
+			if err := expand(dep, 0); err != nil {
+				return err
+			}
+		}
+
+		block := recipe.block(variables, missing)
+		block.ID = ids[name]
+		block.DependsOn = make([]string, len(recipe.DependsOn))
+		for i, dep := range recipe.DependsOn {
+			block.DependsOn[i] = ids[dep]
+		}
+		inputs = append(inputs, block)
+		used = append(used, RecipeBlock{Recipe: recipe.Name, Version: recipe.Version, BlockID: block.ID})
+		return nil
+	}
+
+	if err := expand(name, version); err != nil {
+		return nil, nil, err
+	}
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for v := range missing {
+			names = append(names, v)
+		}
+		sort.Strings(names)
+		return nil, nil, fmt.Errorf("recipe %s needs variables: %s", name, strings.Join(names, ", "))
+	}
+
+	return inputs, used, nil
+}
+
+// withRecipeDependencies returns blocks whose dependencies on a recipe name
+// point at the block proposed from that recipe; blocks are not modified
+func withRecipeDependencies(blocks []BlockInput, recipes []RecipeBlock) []BlockInput {
+	ids := make(map[string]string, len(recipes))
+	for _, r := range recipes {
+		ids[r.Recipe] = r.BlockID
+	}
+	for _, b := range blocks {
+		delete(ids, b.ID) // an explicit block of the same name wins
+	}
+
+	result := make([]BlockInput, len(blocks))
+	for i, b := range blocks {
+		result[i] = b
+		result[i].DependsOn = make([]string, len(b.DependsOn))
+		for j, dep := range b.DependsOn {
+			if id, ok := ids[dep]; ok {
+				dep = id
+			}
+			result[i].DependsOn[j] = dep
+		}
+	}
+	return result
+}
+
+// block fills in the recipe's placeholders, recording the missing variables;
+// the block's ID and dependencies are left to the caller
+func (r Recipe) block(variables map[string]string, missing map[string]bool) BlockInput {
+	input := BlockInput{
+		Description: renderTemplate(r.Description, variables, missing),
+		Type:        r.Type,
+		Target:      renderTemplate(r.Target, variables, missing),
+	}
+	if r.Test != nil {
+		input.Test = &AcceptanceTest{
+			Kind: r.Test.Kind,
+			Code: renderTemplate(r.Test.Code, variables, missing),
+			Root: renderTemplate(r.Test.Root, variables, missing),
+		}
+	}
+	return input
+}
+
+// getRecipe retrieves a version of a recipe, the latest when version is 0
+func (m *Manager) getRecipe(name string, version int) (Recipe, error) {
+	data, err := m.lifecycleDB.GetRecipe(name, version)
+	if err != nil {
+		return Recipe{}, fmt.Errorf("failed to retrieve recipe %s: %w", name, err)
+	}
+	if data == nil {
+		if version > 0 {
+			return Recipe{}, fmt.Errorf("recipe %s has no version %d", name, version)
+		}
+		return Recipe{}, fmt.Errorf("unknown recipe %s", name)
+	}
+	return mapToRecipe(data), nil
+}
+
+// renderTemplate replaces {{variable}} placeholders, recording the variables
+// that have no value
+func renderTemplate(text string, variables map[string]string, missing map[string]bool) string {
+	return templateVariable.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := templateVariable.FindStringSubmatch(placeholder)[1]
+		value, ok := variables[name]
+		if !ok {
+			missing[name] = true
+			return placeholder
+		}
+		return value
+	})
+}
+
+// templateVariables lists the placeholders of templates in order of appearance
+func templateVariables(templates ...string) []string {
+	seen := make(map[string]bool)
+	variables := []string{}
+	for _, text := range templates {
+		for _, match := range templateVariable.FindAllStringSubmatch(text, -1) {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				variables = append(variables, match[1])
+			}
+		}
+	}
+	return variables
+}
+
+// mapToRecipe converts a block_recipes row to a Recipe
+func mapToRecipe(data map[string]interface{}) Recipe {
+	recipe := Recipe{
+		Name:        data["name"].(string),
+		Version:     data["version"].(int),
+		Description: data["description_template"].(string),
+		Type:        data["type"].(string),
+		Target:      data["target_pattern"].(string),
+		Note:        data["note"].(string),
+		CreatedAt:   data["created_at"].(int64),
+	}
+	if raw := data["depends_on_json"].(string); raw != "" {
+		json.Unmarshal([]byte(raw), &recipe.DependsOn)
+	}
+	if raw := data["test_json"].(string); raw != "" {
+		recipe.Test = &AcceptanceTest{}
+		json.Unmarshal([]byte(raw), recipe.Test)
+	}
+
+	templates := []string{recipe.Description, recipe.Target}
+	if recipe.Test != nil {
+		templates = append(templates, recipe.Test.Code, recipe.Test.Root)
+	}
+	recipe.Variables = templateVariables(templates...)
+
+	return recipe
+}
@@ -0,0 +1,53 @@
+package loop
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecipeBlock(t *testing.T) {
+	recipe := Recipe{
+		Name:        "worker",
+		Description: "Worker {{ name }} with a heartbeat every {{interval}}",
+		Type:        "go",
+		Target:      "cmd/{{name}}/main.go",
+		Test:        &AcceptanceTest{Kind: "shell", Code: "test -f cmd/{{name}}/main.go", Root: "{{root}}"},
+	}
+
+	missing := make(map[string]bool)
+	block := recipe.block(map[string]string{"name": "ingest", "interval": "30s"}, missing)
+
+	if block.Description != "Worker ingest with a heartbeat every 30s" || block.Target != "cmd/ingest/main.go" {
+		t.Errorf("Expected the placeholders filled in, got %+v", block)
+	}
+	if block.Type != "go" || block.Test.Kind != "shell" || block.Test.Code != "test -f cmd/ingest/main.go" {
+		t.Errorf("Expected the test rendered, got %+v", block.Test)
+	}
+	if !reflect.DeepEqual(missing, map[string]bool{"root": true}) {
+		t.Errorf("Expected root reported missing, got %v", missing)
+	}
+	if recipe.Test.Code != "test -f cmd/{{name}}/main.go" {
+		t.Error("Expected the recipe's own test left untouched")
+	}
+
+	if variables := templateVariables(recipe.Description, recipe.Target, "{{x}} {{ name}}"); !reflect.DeepEqual(variables, []string{"name", "interval", "x"}) {
+		t.Errorf("Expected the variables in order of appearance, got %v", variables)
+	}
+}
+
+func TestWithRecipeDependencies(t *testing.T) {
+	recipes := []RecipeBlock{{Recipe: "schemas", Version: 2, BlockID: "schemas-1a2b3c4d"}, {Recipe: "worker", Version: 1, BlockID: "worker-5e6f7a8b"}}
+	blocks := []BlockInput{
+		{ID: "handler", DependsOn: []string{"schemas", "worker", "config"}},
+		{ID: "worker"},
+	}
+
+	result := withRecipeDependencies(blocks, recipes)
+
+	if want := []string{"schemas-1a2b3c4d", "worker", "config"}; !reflect.DeepEqual(result[0].DependsOn, want) {
+		t.Errorf("Expected recipe names mapped to their blocks unless a block has the name, got %v", result[0].DependsOn)
+	}
+	if blocks[0].DependsOn[0] != "schemas" {
+		t.Error("Expected the request's blocks left untouched")
+	}
+}
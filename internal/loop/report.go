@@ -0,0 +1,321 @@
+package loop
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"brainloop/internal/diff"
+)
+
+// sessionReport is everything a report is rendered from
+type sessionReport struct {
+	Session Session
+	Blocks  []blockReport
+	Usage   []BundleUsage
+	Events  []SessionEvent
+}
+
+// reportCost adds up the usage records of a block or an operation
+type reportCost struct {
+	calls, prompt, completion, latencyMs int
+}
+
+// blockReport is a block with its iterations and the refinements that produced them
+type blockReport struct {
+	Block       Block
+	Iterations  []Iteration
+	Refinements []Refinement // refinement i produced iteration i+1
+}
+
+// Report renders the history of a session as a Markdown document suitable for
+// a pull request description: a summary, then for every block the diffs
+// between its iterations with the feedback behind each one, its validation
+// and cost, and finally the event timeline. With a path the document is
+// written there instead of being returned.
+func (m *Manager) Report(req ReportRequest) (*ReportResponse, error) {
+	report, err := m.sessionReport(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	markdown := renderReport(report)
+	response := &ReportResponse{
+		SessionID: req.SessionID,
+		Blocks:    len(report.Blocks),
+		Events:    len(report.Events),
+	}
+	for _, u := range report.Usage {
+		response.Tokens += u.TokensPrompt + u.TokensCompletion
+	}
+
+	if req.Path == "" {
+		response.Markdown = markdown
+		return response, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(req.Path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", req.Path, err)
+	}
+	if err := os.WriteFile(req.Path, []byte(markdown), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write report: %w", err)
+	}
+	response.Path = req.Path
+
+	return response, nil
+}
+
+// sessionReport reads a session, its blocks' iterations, usage and events
+func (m *Manager) sessionReport(sessionID string) (sessionReport, error) {
+	defer m.lockSession(sessionID)()
+
+	session, err := m.storage.LoadSession(sessionID)
+	if err != nil {
+		return sessionReport{}, fmt.Errorf("failed to retrieve session: %w", err)
+	}
+	report := sessionReport{Session: *session}
+
+	blocks, err := m.storage.GetSessionBlocks(sessionID)
+	if err != nil {
+		return sessionReport{}, err
+	}
+	for _, block := range blocks {
+		entry := blockReport{Block: block}
+		if block.Status != "failed" || block.Code != "" {
+			if entry.Iterations, err = m.blockIterations(block); err != nil {
+				return sessionReport{}, err
+			}
+		}
+		if entry.Refinements, err = m.getRefinements(block.BlockID); err != nil {
+			return sessionReport{}, fmt.Errorf("failed to retrieve refinements: %w", err)
+		}
+		report.Blocks = append(report.Blocks, entry)
+	}
+
+	usage, err := m.lifecycleDB.GetSessionUsage(sessionID)
+	if err != nil {
+		return sessionReport{}, fmt.Errorf("failed to retrieve usage: %w", err)
+	}
+	for _, row := range usage {
+		report.Usage = append(report.Usage, mapToUsage(row))
+	}
+
+	if report.Events, err = m.sessionEvents(sessionID); err != nil {
+		return sessionReport{}, err
+	}
+
+	return report, nil
+}
+
+// renderReport renders a session report as Markdown
+func renderReport(r sessionReport) string {
+	var b strings.Builder
+
+	// Cost per block and, within a block, per operation
+	perBlock := make(map[string]*reportCost)
+	perOperation := make(map[string]map[string]*reportCost)
+	var total reportCost
+	for _, u := range r.Usage {
+		if perOperation[u.BlockID] == nil {
+			perOperation[u.BlockID] = make(map[string]*reportCost)
+		}
+		for _, c := range []*reportCost{&total, costOf(perBlock, u.BlockID), costOf(perOperation[u.BlockID], u.Operation)} {
+			c.calls++
+			c.prompt += u.TokensPrompt
+			c.completion += u.TokensCompletion
+			c.latencyMs += u.LatencyMs
+		}
+	}
+
+	fmt.Fprintf(&b, "# Session `%s`\n\n", r.Session.SessionID)
+	fmt.Fprintf(&b, "- Status: **%s**\n", r.Session.Status)
+	fmt.Fprintf(&b, "- Created: %s", formatTime(r.Session.CreatedAt))
+	if r.Session.CompletedAt > 0 {
+		fmt.Fprintf(&b, ", completed: %s", formatTime(r.Session.CompletedAt))
+	}
+	b.WriteString("\n")
+	if r.Session.ProjectRoot != "" {
+		fmt.Fprintf(&b, "- Project: `%s`\n", r.Session.ProjectRoot)
+	}
+	if r.Session.ImportedFrom != "" {
+		fmt.Fprintf(&b, "- Imported from session `%s`\n", r.Session.ImportedFrom)
+	}
+	statuses := make(map[string]int)
+	for _, block := range r.Blocks {
+		statuses[block.Block.Status]++
+	}
+	fmt.Fprintf(&b, "- Blocks: %d (%d committed, %d pending, %d failed)\n",
+		len(r.Blocks), statuses["committed"], statuses["pending"], statuses["failed"])
+	fmt.Fprintf(&b, "- LLM usage: %d call(s), %d tokens (%d prompt, %d completion), %.1fs\n\n",
+		total.calls, total.prompt+total.completion, total.prompt, total.completion, float64(total.latencyMs)/1000)
+
+	if len(r.Blocks) > 0 {
+		b.WriteString("| Block | Type | Target | Status | Iterations | Validation | Tokens |\n")
+		b.WriteString("|---|---|---|---|---|---|---|\n")
+		for _, entry := range r.Blocks {
+			block := entry.Block
+			c := costOf(perBlock, block.BlockID)
+			fmt.Fprintf(&b, "| `%s` | %s | `%s` | %s | %d | %s | %d |\n", cell(block.BlockID), block.Type, cell(block.Target),
+				block.Status, len(entry.Iterations), cell(validationSummary(block)), c.prompt+c.completion)
+		}
+		b.WriteString("\n")
+	}
+
+	for _, entry := range r.Blocks {
+		block := entry.Block
+		fmt.Fprintf(&b, "## `%s`: %s\n\n", block.BlockID, truncate(oneLine(block.Description), 200))
+		fmt.Fprintf(&b, "- Target: `%s` (%s)\n", block.Target, block.Type)
+		fmt.Fprintf(&b, "- Status: %s", block.Status)
+		if block.CommittedAt > 0 {
+			fmt.Fprintf(&b, " on %s", formatTime(block.CommittedAt))
+		}
+		b.WriteString("\n")
+		if len(block.DependsOn) > 0 {
+			fmt.Fprintf(&b, "- Depends on: `%s`\n", strings.Join(block.DependsOn, "`, `"))
+		}
+		if block.Error != "" {
+			fmt.Fprintf(&b, "- Generation error: %s\n", oneLine(block.Error))
+		}
+		fmt.Fprintf(&b, "- Validation: %s\n", validationSummary(block))
+		if block.TestResult != nil {
+			fmt.Fprintf(&b, "- Acceptance test: %s", block.TestResult.Status)
+			if block.TestResult.CodeHash != block.CodeHash {
+				b.WriteString(" (on an earlier iteration)")
+			}
+			b.WriteString("\n")
+		}
+
+		c := costOf(perBlock, block.BlockID)
+		fmt.Fprintf(&b, "- Cost: %d call(s), %d tokens", c.calls, c.prompt+c.completion)
+		operations := perOperation[block.BlockID]
+		names := make([]string, 0, len(operations))
+		for name := range operations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			sep := ", "
+			if i == 0 {
+				sep = ": "
+			}
+			op := operations[name]
+			fmt.Fprintf(&b, "%s%s ×%d (%d tokens)", sep, name, op.calls, op.prompt+op.completion)
+		}
+		b.WriteString("\n\n")
+
+		for i, it := range entry.Iterations {
+			current := ""
+			if it.Current {
+				current = " (current)"
+			}
+			if i == 0 {
+				fmt.Fprintf(&b, "### Iteration 0: proposed%s\n\n", current)
+				b.WriteString(fence(fenceLanguage(block.Type), it.code))
+				continue
+			}
+
+			heading := "refined"
+			if i-1 < len(entry.Refinements) && entry.Refinements[i-1].Temperature > 0 {
+				heading += fmt.Sprintf(" at temperature %.1f", entry.Refinements[i-1].Temperature)
+			}
+			fmt.Fprintf(&b, "### Iteration %d: %s, +%d -%d%s\n\n", i, heading, it.Stats.Added, it.Stats.Removed, current)
+			if it.Feedback != "" {
+				b.WriteString(quote(it.Feedback))
+			}
+			previous := entry.Iterations[i-1]
+			patch := diff.Unified(fmt.Sprintf("iteration-%d", i-1), fmt.Sprintf("iteration-%d", i), previous.code, it.code, diff.DefaultContext)
+			if patch == "" {
+				b.WriteString("No change.\n\n")
+			} else {
+				b.WriteString(fence("diff", patch))
+			}
+		}
+	}
+
+	if len(r.Events) > 0 {
+		b.WriteString("## Timeline\n\n")
+		b.WriteString("| Time | Block | Event | Actor | Detail | Temperature | Tokens |\n")
+		b.WriteString("|---|---|---|---|---|---|---|\n")
+		for _, e := range r.Events {
+			blockID, temperature, tokens := "", "", ""
+			if e.BlockID != "" {
+				blockID = "`" + cell(e.BlockID) + "`"
+			}
+			if e.Temperature > 0 {
+				temperature = fmt.Sprintf("%.1f", e.Temperature)
+			}
+			if e.Tokens > 0 {
+				tokens = fmt.Sprintf("%d", e.Tokens)
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s |\n", formatTime(e.Timestamp), blockID, e.Kind,
+				cell(e.Actor), cell(truncate(oneLine(e.Detail), 200)), temperature, tokens)
+		}
+	}
+
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+// validationSummary describes the validation report of a block's current code
+func validationSummary(block Block) string {
+	report := block.Validation
+	switch {
+	case report == nil:
+		return "not run"
+	case report.CodeHash != block.CodeHash:
+		return "not run on the current code"
+	case report.Valid:
+		return fmt.Sprintf("passed (score %.2f)", report.Score)
+	}
+	return "failed: " + strings.Join(gateErrors(report.Report), "; ")
+}
+
+// costOf returns the cost of key, created on first use
+func costOf(costs map[string]*reportCost, key string) *reportCost {
+	c, ok := costs[key]
+	if !ok {
+		c = &reportCost{}
+		costs[key] = c
+	}
+	return c
+}
+
+// fence wraps content in a code fence longer than any backtick run it contains
+func fence(language, content string) string {
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	marker := strings.Repeat("`", max(3, longest+1))
+	return fmt.Sprintf("%s%s\n%s\n%s\n\n", marker, language, strings.TrimRight(content, "\n"), marker)
+}
+
+// quote renders text as a Markdown blockquote
+func quote(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n") + "\n\n"
+}
+
+// cell escapes text for a Markdown table cell
+func cell(text string) string {
+	return strings.ReplaceAll(oneLine(text), "|", `\|`)
+}
+
+// formatTime renders a Unix time in UTC
+func formatTime(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+}
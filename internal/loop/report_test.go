@@ -0,0 +1,46 @@
+package loop
+
+import (
+	"strings"
+	"testing"
+
+	"brainloop/internal/workspace"
+)
+
+func TestRenderReport(t *testing.T) {
+	code := "x := \"```\"\n"
+	report := sessionReport{
+		Session: Session{SessionID: "s1", Status: "pending_audit", CreatedAt: 1},
+		Blocks: []blockReport{
+			{
+				Block: Block{BlockID: "a", Description: "Quote\nfences", Type: "go", Target: "a.go", Status: "pending", CodeHash: workspace.HashContent(code)},
+				Iterations: []Iteration{
+					{CodeHash: workspace.HashContent(code), Current: true, code: code},
+				},
+			},
+			{Block: Block{BlockID: "b", Type: "code", Target: "b|c.txt", Status: "failed", Error: "context too long"}},
+		},
+		Usage:  []BundleUsage{{BlockID: "a", Operation: "generate_code", TokensPrompt: 10, TokensCompletion: 5}},
+		Events: []SessionEvent{{BlockID: "b", Kind: eventFailed, Detail: "context\ntoo | long", Timestamp: 1}},
+	}
+
+	markdown := renderReport(report)
+	for _, want := range []string{
+		"## `a`: Quote fences",
+		"### Iteration 0: proposed (current)\n\n````go\nx := \"```\"\n````",
+		"- Cost: 1 call(s), 15 tokens: generate_code ×1 (15 tokens)",
+		"- Generation error: context too long",
+		"| `b` | code | `b\\|c.txt` | failed | 0 | not run | 0 |",
+		"| failed |  | context too \\| long |",
+	} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("Expected %q in the report, got:\n%s", want, markdown)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := quote("first\n\nsecond\n"); got != "> first\n>\n> second\n\n" {
+		t.Errorf("Unexpected blockquote %q", got)
+	}
+}
@@ -0,0 +1,66 @@
+package loop
+
+import (
+	"fmt"
+)
+
+// Retry generates the failed blocks of a session again, dependencies first,
+// with the generated blocks as context. Blocks that fail again keep their
+// new error and can be retried later.
+func (m *Manager) Retry(req RetryRequest) (*RetryResponse, error) {
+	if err := m.requireActiveSession(req.SessionID); err != nil {
+		return nil, err
+	}
+
+	blocks, err := m.storage.GetSessionBlocks(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]BlockInput, len(blocks))
+	current := make(map[string]Block, len(blocks))
+	selected := make(map[string]int)
+	for i, b := range blocks {
+		inputs[i] = BlockInput{ID: b.BlockID, Description: b.Description, Type: b.Type, Target: b.Target, DependsOn: b.DependsOn}
+		current[b.BlockID] = b
+		if b.Status == "failed" && (req.BlockID == "" || b.BlockID == req.BlockID) {
+			selected[b.BlockID] = b.Version
+		}
+	}
+
+	if len(selected) == 0 {
+		if req.BlockID != "" {
+			return nil, fmt.Errorf("block %s is not a failed block of session %s", req.BlockID, req.SessionID)
+		}
+		return nil, fmt.Errorf("session %s has no failed blocks", req.SessionID)
+	}
+
+	levels, err := dependencyLevels(inputs)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := m.sessionPatterns(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tools, err := m.sessionTools(req.SessionID, req.UseTools, req.MaxToolSteps)
+	if err != nil {
+		return nil, err
+	}
+
+	generated, err := m.generateBlocks(inputs, levels, selected, current, pattern, tools)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &RetryResponse{SessionID: req.SessionID}
+	for i, b := range generated {
+		if _, ok := selected[inputs[i].ID]; ok {
+			response.Blocks = append(response.Blocks, b)
+		}
+	}
+	response.Generated, response.Failed, response.Message = generationSummary(response.Blocks)
+
+	return response, nil
+}
@@ -1,28 +1,67 @@
 package loop
 
+import (
+	"encoding/json"
+
+	"brainloop/internal/cerebras"
+	"brainloop/internal/diff"
+	"brainloop/internal/validation"
+	"brainloop/internal/workspace"
+)
+
 // Session represents a cerebras_loop session
 type Session struct {
-	SessionID   string  `json:"session_id"`
-	Status      string  `json:"status"` // 'pending_audit' | 'committed' | 'abandoned'
-	Blocks      []Block `json:"blocks"`
-	CreatedAt   int64   `json:"created_at"`
-	CompletedAt int64   `json:"completed_at,omitempty"`
+	SessionID    string  `json:"session_id"`
+	Status       string  `json:"status"` // 'pending_audit' | 'committed' | 'abandoned'
+	Blocks       []Block `json:"blocks"`
+	CreatedAt    int64   `json:"created_at"`
+	CompletedAt  int64   `json:"completed_at,omitempty"`
+	ProjectRoot  string  `json:"project_root,omitempty"`  // project whose patterns are injected into generations
+	ImportedFrom string  `json:"imported_from,omitempty"` // session of the bundle this session was imported from
+}
+
+// SessionSummary is a session as listed by the list mode
+type SessionSummary struct {
+	SessionID       string `json:"session_id"`
+	Status          string `json:"status"`
+	CreatedAt       int64  `json:"created_at"`
+	CompletedAt     int64  `json:"completed_at,omitempty"`
+	LastActivity    int64  `json:"last_activity"`
+	Blocks          int    `json:"blocks"`
+	BlocksCommitted int    `json:"blocks_committed"`
 }
 
 // Block represents a code block in a session
 type Block struct {
-	BlockID        string        `json:"block_id"`
-	SessionID      string        `json:"session_id"`
-	Description    string        `json:"description"`
-	Type           string        `json:"type"`   // 'sql' | 'go' | 'python' | 'code'
-	Target         string        `json:"target"` // file_path or db_path
-	Code           string        `json:"code,omitempty"`
-	Iterations     int           `json:"iterations"`
-	Status         string        `json:"status"` // 'pending' | 'committed'
-	GeneratedAt    int64         `json:"generated_at"`
-	LastRefinedAt  int64         `json:"last_refined_at,omitempty"`
-	CommittedAt    int64         `json:"committed_at,omitempty"`
-	Refinements    []Refinement  `json:"refinements,omitempty"`
+	BlockID       string           `json:"block_id"`
+	SessionID     string           `json:"session_id"`
+	Description   string           `json:"description"`
+	Type          string           `json:"type"`                // 'sql' | 'go' | 'python' | 'code' | 'files' | 'edit'
+	Target        string           `json:"target"`              // file_path, db_path, or workspace root for 'files'
+	Code          string           `json:"code,omitempty"`      // unified diff or SEARCH/REPLACE blocks for 'edit'
+	CodeHash      string           `json:"code_hash,omitempty"` // sha256 of Code, echoed back on commit
+	Iterations    int              `json:"iterations"`
+	Status        string           `json:"status"` // 'pending' | 'committed' | 'failed'
+	GeneratedAt   int64            `json:"generated_at"`
+	LastRefinedAt int64            `json:"last_refined_at,omitempty"`
+	CommittedAt   int64            `json:"committed_at,omitempty"`
+	Refinements   []Refinement     `json:"refinements,omitempty"`
+	DependsOn     []string         `json:"depends_on,omitempty"`
+	Stale         bool             `json:"stale,omitempty"` // a dependency changed since this code was generated
+	Validation    *BlockValidation `json:"validation,omitempty"`
+	LastFeedback  string           `json:"last_feedback,omitempty"` // latest audit feedback, in session status
+	Test          *AcceptanceTest  `json:"test,omitempty"`
+	TestResult    *TestResult      `json:"test_result,omitempty"`
+	PatternID     string           `json:"pattern_id,omitempty"` // project patterns injected into the latest generation
+	Version       int              `json:"version"`              // incremented by every write, see RefineRequest.Version
+	Error         string           `json:"error,omitempty"`      // why a 'failed' block has no code; retry it
+}
+
+// BlockValidation is the validation gate report stored on a block
+type BlockValidation struct {
+	validation.Report
+	CodeHash    string `json:"code_hash"` // hash of the code the report is for
+	ValidatedAt int64  `json:"validated_at"`
 }
 
 // Refinement represents an audit refinement for a block
@@ -37,15 +76,54 @@ type Refinement struct {
 
 // BlockInput represents input for creating a block
 type BlockInput struct {
-	ID          string `json:"id"`
-	Description string `json:"description"`
-	Type        string `json:"type"`
-	Target      string `json:"target"`
+	ID          string          `json:"id"`
+	Description string          `json:"description"`
+	Type        string          `json:"type"`
+	Target      string          `json:"target"`
+	DependsOn   []string        `json:"depends_on,omitempty"` // block IDs generated (and committed) before this one
+	Test        *AcceptanceTest `json:"test,omitempty"`       // run against every candidate in a scratch workspace
 }
 
 // ProposeRequest represents a request to propose a session
 type ProposeRequest struct {
-	Blocks []BlockInput `json:"blocks"`
+	Blocks      []BlockInput `json:"blocks"`
+	ProjectRoot string       `json:"project_root,omitempty"` // default: the project of the first block's target
+
+	// A recipe and the recipes it depends on are proposed before Blocks,
+	// which may depend on their blocks by recipe name
+	Recipe        string            `json:"recipe,omitempty"`
+	RecipeVersion int               `json:"recipe_version,omitempty"` // default: the latest
+	Variables     map[string]string `json:"variables,omitempty"`      // values of the recipes' {{variable}} placeholders
+
+	// The model may call the generation tools under the project root
+	UseTools     bool `json:"use_tools,omitempty"`
+	MaxToolSteps int  `json:"max_tool_steps,omitempty"` // default 5
+}
+
+// RecipeRequest represents a request to create a recipe or a new version of one
+type RecipeRequest struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Type        string          `json:"type"`
+	Target      string          `json:"target"`
+	DependsOn   []string        `json:"depends_on,omitempty"` // nil keeps the dependencies of the previous version
+	Test        *AcceptanceTest `json:"test,omitempty"`
+	Note        string          `json:"note,omitempty"`
+}
+
+// RecipeListRequest represents a request to list recipes
+type RecipeListRequest struct {
+	Name string `json:"name,omitempty"` // every version of this recipe; default the latest of each
+}
+
+// RetryRequest represents a request to generate the failed blocks of a session again
+type RetryRequest struct {
+	SessionID string `json:"session_id"`
+	BlockID   string `json:"block_id,omitempty"` // every failed block when empty
+
+	// The model may call the generation tools under the project root, as in Propose
+	UseTools     bool `json:"use_tools,omitempty"`
+	MaxToolSteps int  `json:"max_tool_steps,omitempty"` // default 5
 }
 
 // AuditRequest represents a request to audit a block
@@ -59,36 +137,346 @@ type RefineRequest struct {
 	SessionID     string `json:"session_id"`
 	BlockID       string `json:"block_id"`
 	AuditFeedback string `json:"audit_feedback"`
+	Version       int    `json:"version,omitempty"`        // version of the reviewed block; refused if the block changed since
+	UseTools      bool   `json:"use_tools,omitempty"`      // the model may call the generation tools under the project root
+	MaxToolSteps  int    `json:"max_tool_steps,omitempty"` // default 5
 }
 
 // CommitRequest represents a request to commit a block
 type CommitRequest struct {
+	SessionID  string `json:"session_id"`
+	BlockID    string `json:"block_id"`
+	CodeHash   string `json:"code_hash"`             // hash of the reviewed code (or of confirmed regenerated code)
+	Regenerate bool   `json:"regenerate,omitempty"`  // run a final generation pass, returned as a diff to confirm
+	Candidates int    `json:"candidates,omitempty"`  // best-of-N when regenerating and > 1
+	AutoRefine bool   `json:"auto_refine,omitempty"` // refine with the errors as feedback when the validation gate fails
+}
+
+// CommitSessionRequest represents a request to commit every uncommitted block of a session at once
+type CommitSessionRequest struct {
+	SessionID  string            `json:"session_id"`
+	CodeHashes map[string]string `json:"code_hashes"` // block ID -> hash of the reviewed code
+}
+
+// AutoRequest represents a request to run the audit-refine cycle unattended
+type AutoRequest struct {
+	SessionID     string `json:"session_id"`
+	BlockID       string `json:"block_id,omitempty"`       // every uncommitted block when empty
+	MaxIterations int    `json:"max_iterations,omitempty"` // refines per block, default 3
+	TokenBudget   int    `json:"token_budget,omitempty"`   // tokens for the whole run; 0 means no budget
+	AuditPrompt   string `json:"audit_prompt,omitempty"`
+}
+
+// HistoryRequest represents a request for the iterations of a block
+type HistoryRequest struct {
+	SessionID string `json:"session_id"`
+	BlockID   string `json:"block_id"`
+}
+
+// DiffRequest represents a request to diff two iterations of a block, or an
+// iteration against the target file on disk
+type DiffRequest struct {
+	SessionID   string `json:"session_id"`
+	BlockID     string `json:"block_id"`
+	From        *int   `json:"from,omitempty"` // default: the iteration before To; with AgainstDisk, the current code
+	To          *int   `json:"to,omitempty"`   // default: the latest iteration
+	AgainstDisk bool   `json:"against_disk,omitempty"`
+}
+
+// RevertRequest represents a request to revert a block to an earlier iteration
+type RevertRequest struct {
+	SessionID string `json:"session_id"`
+	BlockID   string `json:"block_id"`
+	Iteration int    `json:"iteration"`
+}
+
+// GitDiffRequest represents a request for the pending diff of a session against HEAD
+type GitDiffRequest struct {
+	SessionID string `json:"session_id"`
+	BlockID   string `json:"block_id,omitempty"` // every uncommitted block when empty
+}
+
+// ExportRequest represents a request to export a session as a bundle
+type ExportRequest struct {
+	SessionID string `json:"session_id"`
+	Format    string `json:"format,omitempty"` // 'json' | 'tar.gz'; from the path extension when empty
+	Path      string `json:"path,omitempty"`   // file to write; a json bundle is returned inline when empty
+}
+
+// ImportRequest represents a request to import a session bundle, from a file or inline
+type ImportRequest struct {
+	Path       string          `json:"path,omitempty"`
+	Bundle     json.RawMessage `json:"bundle,omitempty"`
+	OnConflict string          `json:"on_conflict,omitempty"` // 'fail' (default) | 'copy': import a session already imported again
+}
+
+// ReportRequest represents a request for the Markdown report of a session
+type ReportRequest struct {
+	SessionID string `json:"session_id"`
+	Path      string `json:"path,omitempty"` // file to write; the report is returned when empty
+}
+
+// ListRequest represents a request to list sessions
+type ListRequest struct {
+	Status      string  `json:"status,omitempty"`        // 'pending_audit' | 'committed' | 'abandoned'
+	MaxAgeHours float64 `json:"max_age_hours,omitempty"` // only sessions created within this many hours
+	MinAgeHours float64 `json:"min_age_hours,omitempty"` // only sessions created at least this many hours ago
+	Limit       int     `json:"limit,omitempty"`
+}
+
+// SessionRequest represents a request about a whole session (status, abandon, resume)
+type SessionRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+// RollbackRequest represents a request to undo the commits of a block or session
+type RollbackRequest struct {
+	SessionID string `json:"session_id"`
+	BlockID   string `json:"block_id,omitempty"` // whole session when empty
+	Force     bool   `json:"force,omitempty"`    // restore even if targets changed since commit
+}
+
+// ValidateRequest represents a request to run the validation gate on a block
+type ValidateRequest struct {
 	SessionID string `json:"session_id"`
 	BlockID   string `json:"block_id"`
 }
 
 // ProposeResponse represents the response from a propose operation
 type ProposeResponse struct {
+	SessionID   string        `json:"session_id"`
+	ProjectRoot string        `json:"project_root"`
+	PatternID   string        `json:"pattern_id,omitempty"` // patterns injected into every generation, empty when the project has none
+	Blocks      []Block       `json:"blocks"`               // failed blocks carry their error and no code
+	Recipes     []RecipeBlock `json:"recipes,omitempty"`    // blocks proposed from recipes
+	Generated   int           `json:"generated"`
+	Failed      int           `json:"failed"`
+	Message     string        `json:"message"`
+}
+
+// RecipeListResponse represents a list of recipes
+type RecipeListResponse struct {
+	Recipes []Recipe `json:"recipes"`
+	Count   int      `json:"count"`
+}
+
+// RetryResponse represents the response from a retry of failed blocks
+type RetryResponse struct {
 	SessionID string  `json:"session_id"`
-	Blocks    []Block `json:"blocks"`
+	Blocks    []Block `json:"blocks"` // the retried blocks
+	Generated int     `json:"generated"`
+	Failed    int     `json:"failed"`
+	Message   string  `json:"message"`
 }
 
 // AuditResponse represents the response from an audit operation
 type AuditResponse struct {
-	Block Block `json:"block"`
+	Block    Block                  `json:"block"`
+	Patch    *PatchPreview          `json:"patch,omitempty"` // edit blocks: the patch applied to the current file
+	History  []Iteration            `json:"history"`
+	Patterns map[string]interface{} `json:"patterns,omitempty"` // the patterns recorded on the block
 }
 
 // RefineResponse represents the response from a refine operation
 type RefineResponse struct {
-	Block       Block  `json:"block"`
-	RefinedCode string `json:"refined_code"`
-	Iterations  int    `json:"iterations"`
+	Block                Block    `json:"block"`
+	RefinedCode          string   `json:"refined_code"`
+	Iterations           int      `json:"iterations"`
+	HistoryMessages      int      `json:"history_messages"`
+	SummarizedIterations int      `json:"summarized_iterations,omitempty"`
+	StaleDependants      []string `json:"stale_dependants,omitempty"`
+	TokensUsed           int      `json:"tokens_used"`
+}
+
+// ValidateResponse represents the response from a validate operation
+type ValidateResponse struct {
+	Block      Block            `json:"block"`
+	Validation *BlockValidation `json:"validation"`
+}
+
+// AuditFinding is one issue reported by the automatic audit
+type AuditFinding struct {
+	Severity string `json:"severity"` // 'critical' | 'high' | 'medium' | 'low'
+	Issue    string `json:"issue"`
+	Fix      string `json:"fix,omitempty"`
+}
+
+// AutoRound is one audit of a block, and the refine it led to
+type AutoRound struct {
+	BlockID    string         `json:"block_id"`
+	Round      int            `json:"round"`
+	CodeHash   string         `json:"code_hash"` // code that was audited
+	Valid      bool           `json:"valid"`     // validation gate result
+	GateErrors []string       `json:"gate_errors,omitempty"`
+	TestStatus string         `json:"test_status,omitempty"` // acceptance test result of the audited code
+	Findings   []AuditFinding `json:"findings"`
+	Unparsed   bool           `json:"unparsed,omitempty"` // the audit had no findings list; its text was used as feedback
+	Refined    bool           `json:"refined"`
+	Tokens     int            `json:"tokens"`
+}
+
+// AutoBlockResult is where the cycle left a block
+type AutoBlockResult struct {
+	BlockID    string `json:"block_id"`
+	Converged  bool   `json:"converged"`
+	StopReason string `json:"stop_reason"` // 'converged' | 'max_iterations' | 'token_budget'
+	Refines    int    `json:"refines"`
+	CodeHash   string `json:"code_hash"`
+}
+
+// AutoResponse represents the transcript of an auto run. Nothing is
+// committed: each block still has to be committed with its code_hash.
+type AutoResponse struct {
+	SessionID  string            `json:"session_id"`
+	Rounds     []AutoRound       `json:"rounds"`
+	Blocks     []AutoBlockResult `json:"blocks"`
+	TokensUsed int               `json:"tokens_used"`
+	Message    string            `json:"message"`
+}
+
+// CommitSessionResponse represents the response from a session commit
+type CommitSessionResponse struct {
+	SessionID  string                      `json:"session_id"`
+	Success    bool                        `json:"success"`
+	Message    string                      `json:"message"`
+	Blocks     []Block                     `json:"blocks,omitempty"`
+	Validation map[string]*BlockValidation `json:"validation"`          // per block
+	Files      []string                    `json:"files,omitempty"`     // files written
+	Databases  []string                    `json:"databases,omitempty"` // databases updated
+	Backups    []Backup                    `json:"backups,omitempty"`
+	Git        []GitCommit                 `json:"git,omitempty"` // when git_commits is on
+}
+
+// HistoryResponse represents the iterations of a block
+type HistoryResponse struct {
+	BlockID    string      `json:"block_id"`
+	CodeHash   string      `json:"code_hash"`
+	Iterations []Iteration `json:"iterations"`
+}
+
+// DiffResponse represents the response from a diff operation
+type DiffResponse struct {
+	BlockID string     `json:"block_id"`
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+	Diff    string     `json:"diff"`
+	Stats   diff.Stats `json:"stats"`
+}
+
+// GitFileDiff is the pending change of one file
+type GitFileDiff struct {
+	Path       string     `json:"path"`
+	Repository string     `json:"repository,omitempty"` // empty outside a git work tree: diffed against the disk
+	Diff       string     `json:"diff"`
+	Stats      diff.Stats `json:"stats"`
+}
+
+// GitDiffResponse represents the pending diff of a session against HEAD
+type GitDiffResponse struct {
+	SessionID string        `json:"session_id"`
+	Branch    string        `json:"branch"` // where commits are recorded when git_commits is on
+	Files     []GitFileDiff `json:"files"`
+	Databases []string      `json:"databases,omitempty"` // SQL targets, not diffed
+	Message   string        `json:"message"`
+}
+
+// GitCommit is a git commit recording committed blocks on the session branch
+type GitCommit struct {
+	Repository string   `json:"repository"`
+	Branch     string   `json:"branch"`
+	Commit     string   `json:"commit,omitempty"` // empty when the branch already had the content
+	Files      []string `json:"files"`
+	Error      string   `json:"error,omitempty"` // the blocks stay committed when recording them fails
+}
+
+// ExportResponse represents the response from an export operation
+type ExportResponse struct {
+	SessionID   string         `json:"session_id"`
+	Format      string         `json:"format"`
+	Path        string         `json:"path,omitempty"`
+	Size        int            `json:"size"` // bytes written, or of the inline bundle
+	Bundle      *SessionBundle `json:"bundle,omitempty"`
+	Blocks      int            `json:"blocks"`
+	Refinements int            `json:"refinements"`
+	Usage       int            `json:"usage"`
+}
+
+// ImportResponse represents the response from an import operation
+type ImportResponse struct {
+	SessionID    string            `json:"session_id"`
+	ImportedFrom string            `json:"imported_from"`
+	BlockIDs     map[string]string `json:"block_ids"` // bundle block ID -> imported block ID
+	Blocks       int               `json:"blocks"`
+	Refinements  int               `json:"refinements"`
+	Usage        int               `json:"usage"`
+	Reopened     []string          `json:"reopened,omitempty"` // committed blocks back to 'pending', to commit on this worker
+	Message      string            `json:"message"`
+}
+
+// ReportResponse represents the response from a report operation
+type ReportResponse struct {
+	SessionID string `json:"session_id"`
+	Markdown  string `json:"markdown,omitempty"` // empty when written to Path
+	Path      string `json:"path,omitempty"`
+	Blocks    int    `json:"blocks"`
+	Events    int    `json:"events"`
+	Tokens    int    `json:"tokens"`
+}
+
+// RevertResponse represents the response from a revert operation
+type RevertResponse struct {
+	Block           Block    `json:"block"`
+	Iteration       int      `json:"iteration"` // the new iteration holding the reverted code
+	RevertedTo      int      `json:"reverted_to"`
+	StaleDependants []string `json:"stale_dependants,omitempty"`
+}
+
+// ListResponse represents the response from a list operation
+type ListResponse struct {
+	Sessions []SessionSummary `json:"sessions"`
+}
+
+// SessionStatusResponse represents a session with all its blocks; block code
+// is omitted, use audit to review it
+type SessionStatusResponse struct {
+	Session Session `json:"session"`
+	Message string  `json:"message,omitempty"`
+}
+
+// RollbackResponse represents the response from a rollback operation
+type RollbackResponse struct {
+	SessionID string   `json:"session_id"`
+	Success   bool     `json:"success"`
+	Message   string   `json:"message"`
+	Restored  []Backup `json:"restored"`
+	Blocks    []string `json:"blocks"` // blocks returned to 'pending'
 }
 
 // CommitResponse represents the response from a commit operation
 type CommitResponse struct {
-	Block       Block  `json:"block"`
-	Success     bool   `json:"success"`
-	Message     string `json:"message"`
-	OutputPath  string `json:"output_path,omitempty"`
+	Block      Block                     `json:"block"`
+	Success    bool                      `json:"success"`
+	Message    string                    `json:"message"`
+	OutputPath string                    `json:"output_path,omitempty"`
+	Files      []workspace.ManifestEntry `json:"files,omitempty"`
+
+	// Regeneration awaiting confirmation: nothing was written
+	ConfirmationRequired bool        `json:"confirmation_required,omitempty"`
+	PendingCodeHash      string      `json:"pending_code_hash,omitempty"`
+	Diff                 string      `json:"diff,omitempty"`
+	DiffStats            *diff.Stats `json:"diff_stats,omitempty"`
+
+	// Prior state of every target, restorable with the rollback mode
+	Backups []Backup `json:"backups,omitempty"`
+
+	// Commits on the session branch, when git_commits is on
+	Git []GitCommit `json:"git,omitempty"`
+
+	// Validation gate report; a failed gate blocks the commit
+	Validation  *BlockValidation `json:"validation,omitempty"`
+	AutoRefined bool             `json:"auto_refined,omitempty"`
+
+	// Best-of-N selection, when requested
+	SelectedCandidate *int                 `json:"selected_candidate,omitempty"`
+	Candidates        []cerebras.Candidate `json:"candidates,omitempty"`
 }
@@ -0,0 +1,367 @@
+package loop
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"brainloop/internal/diff"
+	"brainloop/internal/validation"
+	"brainloop/internal/workspace"
+)
+
+// sessionStage holds the writes of a session commit before any of them lands
+type sessionStage struct {
+	files     map[string]string // absolute path -> content
+	fileOrder []string
+	scripts   map[string][]string // database path -> scripts in commit order
+	dbOrder   []string
+	outputs   map[string][]workspace.ManifestEntry // block ID -> files of 'files' blocks
+}
+
+func newSessionStage() *sessionStage {
+	return &sessionStage{
+		files:   make(map[string]string),
+		scripts: make(map[string][]string),
+		outputs: make(map[string][]workspace.ManifestEntry),
+	}
+}
+
+// CommitSession commits every uncommitted block of a session at once. All
+// blocks pass the validation gate against the staged result of the blocks
+// before them; then files are staged in temp files, SQL runs in one
+// transaction per target database, and the temp files are renamed. If any
+// step fails, everything already applied is restored from the commit backups:
+// either every block lands or none does.
+func (m *Manager) CommitSession(req CommitSessionRequest) (*CommitSessionResponse, error) {
+	defer m.lockSession(req.SessionID)()
+
+	if err := m.requireActiveSession(req.SessionID); err != nil {
+		return nil, err
+	}
+
+	blocks, err := m.sessionCommitBlocks(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Gate and stage every block in dependency order
+	response := &CommitSessionResponse{SessionID: req.SessionID, Validation: make(map[string]*BlockValidation)}
+	stage := newSessionStage()
+	var failed []string
+	for _, block := range blocks {
+		report, err := m.runGateWith(block, block.Code, validation.GateOptions{
+			Overlay:   stage.files,
+			SQLBefore: stage.scripts[block.Target],
+		})
+		if err != nil {
+			return nil, err
+		}
+		response.Validation[block.BlockID] = report
+		if !report.Valid {
+			failed = append(failed, block.BlockID)
+			continue
+		}
+		if err := stage.add(block); err != nil {
+			return nil, fmt.Errorf("failed to stage block %s: %w", block.BlockID, err)
+		}
+	}
+	if len(failed) > 0 {
+		response.Message = fmt.Sprintf("Session commit blocked by the validation gate (%s); nothing was written", strings.Join(failed, ", "))
+		return response, nil
+	}
+
+	// Back up every target before anything is modified
+	var backups []Backup
+	var targets []commitTarget
+	for _, block := range blocks {
+		blockTargets, err := commitTargets(block, block.Code)
+		if err != nil {
+			m.discardBackups(backups)
+			return nil, err
+		}
+		blockBackups, err := m.backupTargets(block, blockTargets)
+		if err != nil {
+			m.discardBackups(backups)
+			return nil, err
+		}
+		backups = append(backups, blockBackups...)
+		targets = append(targets, blockTargets...)
+	}
+
+	touched, err := stage.apply()
+	if err != nil {
+		m.undoSessionCommit(backups, touched)
+		return nil, fmt.Errorf("session commit failed, every target was restored: %w", err)
+	}
+	if err := m.recordCommittedHashes(backups); err != nil {
+		m.undoSessionCommit(backups, touched)
+		return nil, fmt.Errorf("session commit failed, every target was restored: %w", err)
+	}
+
+	// The blocks are recorded as committed together, or the writes are undone
+	commits := make([]map[string]string, len(blocks))
+	for i, block := range blocks {
+		resultJSON, _ := json.Marshal(map[string]interface{}{
+			"block_id":    block.BlockID,
+			"output_path": block.Target,
+			"type":        block.Type,
+			"files":       stage.outputs[block.BlockID],
+			"session":     true,
+		})
+		commits[i] = map[string]string{
+			"block_id":    block.BlockID,
+			"hash":        calculateHash(req.SessionID, block.BlockID, block.Code),
+			"result_json": string(resultJSON),
+		}
+	}
+	if err := m.lifecycleDB.CommitBlocks(commits); err != nil {
+		m.undoSessionCommit(backups, touched)
+		return nil, fmt.Errorf("session commit failed, every target was restored: %w", err)
+	}
+
+	// Every block is committed: the session completes and is published
+	if err := m.syncSessionStatus(req.SessionID); err != nil {
+		return nil, err
+	}
+
+	for _, block := range blocks {
+		committed, err := m.getBlock(block.BlockID)
+		if err != nil {
+			return nil, err
+		}
+		response.Blocks = append(response.Blocks, committed)
+		m.recordEvent(req.SessionID, block.BlockID, eventCommitted, fmt.Sprintf("written to %s with the session", block.Target), 0, 0)
+	}
+	response.Success = true
+	response.Files = stage.fileOrder
+	response.Databases = stage.dbOrder
+	response.Backups = backups
+	response.Git = m.recordGit(req.SessionID, response.Blocks, targets)
+	response.Message = fmt.Sprintf("Committed %d block(s): %d file(s) written, %d database(s) updated",
+		len(blocks), len(stage.fileOrder), len(stage.dbOrder))
+
+	return response, nil
+}
+
+// sessionCommitBlocks returns the uncommitted blocks of a session, in
+// dependency order, after checking each one can be committed
+func (m *Manager) sessionCommitBlocks(req CommitSessionRequest) ([]Block, error) {
+	all, err := m.storage.GetSessionBlocks(req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]BlockInput, len(all))
+	for i, b := range all {
+		inputs[i] = BlockInput{ID: b.BlockID, DependsOn: b.DependsOn}
+	}
+	levels, err := dependencyLevels(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []Block
+	for _, level := range levels {
+		for _, i := range level {
+			block := all[i]
+			if block.Status == "committed" {
+				continue
+			}
+
+			switch hash := req.CodeHashes[block.BlockID]; {
+			case block.Status == "failed":
+				return nil, failedBlock(block)
+			case block.Code == "":
+				return nil, fmt.Errorf("block %s has no code to commit", block.BlockID)
+			case block.Stale:
+				return nil, fmt.Errorf("block %s is stale: a dependency changed since it was generated, refine it before committing", block.BlockID)
+			case hash == "":
+				return nil, fmt.Errorf("missing code_hash for block %s: echo the code_hash of every reviewed block", block.BlockID)
+			case hash != block.CodeHash:
+				return nil, fmt.Errorf("code_hash mismatch: block %s changed since it was reviewed (current code_hash %s)", block.BlockID, block.CodeHash)
+			}
+
+			// The same code is never written twice
+			if processed, err := m.lifecycleDB.IsProcessed(calculateHash(req.SessionID, block.BlockID, block.Code)); err != nil {
+				return nil, fmt.Errorf("failed to check processed log: %w", err)
+			} else if processed {
+				return nil, fmt.Errorf("block %s was already committed with this code", block.BlockID)
+			}
+			blocks = append(blocks, block)
+		}
+	}
+
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("session %s has no uncommitted blocks", req.SessionID)
+	}
+
+	return blocks, nil
+}
+
+// add stages the writes of a block on top of the blocks staged before it
+func (s *sessionStage) add(block Block) error {
+	switch block.Type {
+	case "sql":
+		if _, ok := s.scripts[block.Target]; !ok {
+			s.dbOrder = append(s.dbOrder, block.Target)
+		}
+		s.scripts[block.Target] = append(s.scripts[block.Target], block.Code)
+
+	case "edit":
+		path, err := filepath.Abs(block.Target)
+		if err != nil {
+			return err
+		}
+		original, err := s.read(path)
+		if err != nil {
+			return err
+		}
+		parsed, err := diff.ParsePatch(block.Code)
+		if err != nil {
+			return err
+		}
+		patched, _, err := diff.Apply(original, parsed)
+		if err != nil {
+			return fmt.Errorf("failed to apply patch to %s: %w", block.Target, err)
+		}
+		s.stageFile(path, patched)
+
+	case "go", "python", "code":
+		path, err := filepath.Abs(block.Target)
+		if err != nil {
+			return err
+		}
+		s.stageFile(path, block.Code)
+
+	case "files":
+		files, err := blockFiles(block.Code)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			path, err := workspace.Resolve(block.Target, f.Path)
+			if err != nil {
+				return err
+			}
+			s.stageFile(path, f.Content)
+			s.outputs[block.BlockID] = append(s.outputs[block.BlockID], workspace.NewManifestEntry(f))
+		}
+
+	default:
+		return fmt.Errorf("unsupported block type: %s", block.Type)
+	}
+
+	return nil
+}
+
+// read returns the staged content of a file, or its content on disk
+func (s *sessionStage) read(path string) (string, error) {
+	if content, ok := s.files[path]; ok {
+		return content, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+// stageFile records the final content of a file
+func (s *sessionStage) stageFile(path, content string) {
+	if _, ok := s.files[path]; !ok {
+		s.fileOrder = append(s.fileOrder, path)
+	}
+	s.files[path] = content
+}
+
+// apply writes the staged files to temp files, runs the SQL of each database
+// in a transaction, then commits the transactions and renames the temp files.
+// It returns the targets it modified, to be restored when it fails.
+func (s *sessionStage) apply() (map[string]bool, error) {
+	touched := make(map[string]bool)
+
+	temps := make(map[string]string, len(s.fileOrder))
+	defer func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}()
+	for _, path := range s.fileOrder {
+		tmp, err := workspace.StageFile(path, []byte(s.files[path]), 0644)
+		if err != nil {
+			return touched, err
+		}
+		temps[path] = tmp
+	}
+
+	type openTx struct {
+		db *sql.DB
+		tx *sql.Tx
+	}
+	var txs []openTx
+	defer func() {
+		for _, t := range txs {
+			t.tx.Rollback()
+			t.db.Close()
+		}
+	}()
+	for _, dbPath := range s.dbOrder {
+		// Opening a missing database creates it
+		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+			touched[dbPath] = true
+		}
+
+		db, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			return touched, fmt.Errorf("failed to open database %s: %w", dbPath, err)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			db.Close()
+			return touched, fmt.Errorf("failed to begin transaction on %s: %w", dbPath, err)
+		}
+		txs = append(txs, openTx{db: db, tx: tx})
+
+		for _, script := range s.scripts[dbPath] {
+			if _, err := tx.Exec(script); err != nil {
+				return touched, fmt.Errorf("failed to execute SQL on %s: %w", dbPath, err)
+			}
+		}
+	}
+
+	for i, t := range txs {
+		touched[s.dbOrder[i]] = true
+		if err := t.tx.Commit(); err != nil {
+			return touched, fmt.Errorf("failed to commit transaction on %s: %w", s.dbOrder[i], err)
+		}
+	}
+
+	for _, path := range s.fileOrder {
+		touched[path] = true
+		if err := os.Rename(temps[path], path); err != nil {
+			return touched, fmt.Errorf("failed to rename temp file to %s: %w", path, err)
+		}
+		delete(temps, path)
+	}
+
+	return touched, nil
+}
+
+// undoSessionCommit restores every target a failed session commit modified
+// and retires its backups. Targets backed up by several blocks are restored
+// from the oldest backup, which holds the state before the commit.
+func (m *Manager) undoSessionCommit(backups []Backup, touched map[string]bool) {
+	restored := make(map[string]bool)
+	for _, b := range backups {
+		path, _ := filepath.Abs(b.Target)
+		if (touched[path] || touched[b.Target]) && !restored[path] {
+			restored[path] = true
+			restoreBackup(b)
+		}
+		m.lifecycleDB.MarkBackupRestored(b.BackupID)
+	}
+}
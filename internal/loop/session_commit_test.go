@@ -0,0 +1,75 @@
+package loop
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestSessionStageApplyIsAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "app.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	stage := newSessionStage()
+	for _, block := range []Block{
+		{BlockID: "a", Type: "go", Target: filepath.Join(dir, "a.go"), Code: "package main\n"},
+		{BlockID: "b", Type: "sql", Target: dbPath, Code: "INSERT INTO users (id) VALUES (1);"},
+		{BlockID: "c", Type: "sql", Target: dbPath, Code: "INSERT INTO missing (id) VALUES (1);"},
+	} {
+		if err := stage.add(block); err != nil {
+			t.Fatalf("failed to stage %s: %v", block.BlockID, err)
+		}
+	}
+
+	if _, err := stage.apply(); err == nil {
+		t.Fatal("Expected the failing script to fail the commit")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected no file written and no temp file left, got %d entries", len(entries))
+	}
+	db, err = sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var rows int
+	db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&rows)
+	if rows != 0 {
+		t.Errorf("Expected the first script rolled back, got %d rows", rows)
+	}
+}
+
+func TestSessionStageEditsStagedContent(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "main.go")
+
+	stage := newSessionStage()
+	if err := stage.add(Block{BlockID: "new", Type: "go", Target: target, Code: "package main\n\nfunc main() {}\n"}); err != nil {
+		t.Fatal(err)
+	}
+	patch := "<<<<<<< SEARCH\nfunc main() {}\n=======\nfunc main() { println(1) }\n>>>>>>> REPLACE\n"
+	if err := stage.add(Block{BlockID: "edit", Type: "edit", Target: target, Code: patch}); err != nil {
+		t.Fatalf("Expected the edit to apply to the staged file: %v", err)
+	}
+
+	if _, err := stage.apply(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(target)
+	if string(data) != "package main\n\nfunc main() { println(1) }\n" {
+		t.Errorf("Expected the edited file, got %q", data)
+	}
+}
@@ -0,0 +1,197 @@
+package loop
+
+import (
+	"fmt"
+	"time"
+)
+
+// List returns sessions, newest first, filtered by status and age
+func (m *Manager) List(req ListRequest) (*ListResponse, error) {
+	switch req.Status {
+	case "", "pending_audit", "committed", "abandoned":
+	default:
+		return nil, fmt.Errorf("unknown status %q: expected pending_audit, committed or abandoned", req.Status)
+	}
+
+	now := time.Now()
+	var createdAfter, createdBefore int64
+	if req.MaxAgeHours > 0 {
+		createdAfter = now.Add(-hours(req.MaxAgeHours)).Unix()
+	}
+	if req.MinAgeHours > 0 {
+		createdBefore = now.Add(-hours(req.MinAgeHours)).Unix()
+	}
+
+	sessions, err := m.storage.ListSessions(req.Status, createdAfter, createdBefore, req.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return &ListResponse{Sessions: sessions}, nil
+}
+
+// Status returns a session with every block, its iterations and last audit
+// feedback. Block code is left out; audit returns it.
+func (m *Manager) Status(req SessionRequest) (*SessionStatusResponse, error) {
+	session, err := m.storage.LoadSession(req.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve session: %w", err)
+	}
+
+	if session.Blocks, err = m.storage.GetSessionBlocks(req.SessionID); err != nil {
+		return nil, err
+	}
+
+	committed := 0
+	for i := range session.Blocks {
+		session.Blocks[i].Code = ""
+		if session.Blocks[i].Status == "committed" {
+			committed++
+		}
+	}
+
+	return &SessionStatusResponse{
+		Session: *session,
+		Message: fmt.Sprintf("%d of %d block(s) committed", committed, len(session.Blocks)),
+	}, nil
+}
+
+// Abandon marks a session abandoned; its blocks can no longer be refined or committed
+func (m *Manager) Abandon(req SessionRequest) (*SessionStatusResponse, error) {
+	defer m.lockSession(req.SessionID)()
+
+	session, err := m.storage.LoadSession(req.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve session: %w", err)
+	}
+	switch session.Status {
+	case "committed":
+		return nil, fmt.Errorf("session %s is committed; roll it back before abandoning it", req.SessionID)
+	case "abandoned":
+		return nil, fmt.Errorf("session %s is already abandoned", req.SessionID)
+	}
+
+	if err := m.storage.DeleteSession(req.SessionID); err != nil {
+		return nil, fmt.Errorf("failed to abandon session: %w", err)
+	}
+	m.recordEvent(req.SessionID, "", eventAbandoned, "", 0, 0)
+
+	return &SessionStatusResponse{
+		Session: Session{SessionID: req.SessionID, Status: "abandoned", CreatedAt: session.CreatedAt, CompletedAt: time.Now().Unix()},
+		Message: "Session abandoned; resume it to continue",
+	}, nil
+}
+
+// Resume puts an abandoned session back in pending_audit
+func (m *Manager) Resume(req SessionRequest) (*SessionStatusResponse, error) {
+	defer m.lockSession(req.SessionID)()
+
+	session, err := m.storage.LoadSession(req.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve session: %w", err)
+	}
+	if session.Status != "abandoned" {
+		return nil, fmt.Errorf("session %s is %s, only abandoned sessions can be resumed", req.SessionID, session.Status)
+	}
+
+	if err := m.lifecycleDB.UpdateSessionStatus(req.SessionID, "pending_audit"); err != nil {
+		return nil, fmt.Errorf("failed to resume session: %w", err)
+	}
+	m.recordEvent(req.SessionID, "", eventResumed, "", 0, 0)
+
+	return &SessionStatusResponse{
+		Session: Session{SessionID: req.SessionID, Status: "pending_audit", CreatedAt: session.CreatedAt},
+		Message: "Session resumed",
+	}, nil
+}
+
+// ExpireSessions abandons pending_audit sessions idle for longer than maxIdle
+func (m *Manager) ExpireSessions(maxIdle time.Duration) ([]string, error) {
+	cutoff := time.Now().Add(-maxIdle).Unix()
+	candidates, err := m.lifecycleDB.IdleSessions(cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []string
+	for _, sessionID := range candidates {
+		// Activity may have happened since the candidates were listed: each
+		// session is checked again under its lock
+		ok, err := m.expireSession(sessionID, cutoff)
+		if err != nil {
+			return expired, err
+		}
+		if ok {
+			m.lifecycleDB.RecordSessionEvent(sessionID, "", eventAbandoned, "", fmt.Sprintf("expired after %s without activity", maxIdle), 0, 0)
+			expired = append(expired, sessionID)
+		}
+	}
+	return expired, nil
+}
+
+// expireSession abandons a session if it is still idle since cutoff
+func (m *Manager) expireSession(sessionID string, cutoff int64) (bool, error) {
+	defer m.lockSession(sessionID)()
+	return m.lifecycleDB.ExpireSession(sessionID, cutoff)
+}
+
+// SessionStats returns the number of sessions per status
+func (m *Manager) SessionStats() (*SessionStats, error) {
+	return m.storage.GetSessionStats()
+}
+
+// requireActiveSession refuses changes to blocks of an abandoned session
+func (m *Manager) requireActiveSession(sessionID string) error {
+	session, err := m.lifecycleDB.GetSession(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve session: %w", err)
+	}
+	if session["status"].(string) == "abandoned" {
+		return fmt.Errorf("session %s is abandoned; resume it first", sessionID)
+	}
+	return nil
+}
+
+// syncSessionStatus marks a session committed, and publishes it, once all
+// its blocks are committed; a committed session with a rolled back block
+// returns to pending_audit
+func (m *Manager) syncSessionStatus(sessionID string) error {
+	session, err := m.storage.LoadSession(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve session: %w", err)
+	}
+	if session.Blocks, err = m.storage.GetSessionBlocks(sessionID); err != nil {
+		return err
+	}
+
+	complete := len(session.Blocks) > 0
+	for _, block := range session.Blocks {
+		if block.Status != "committed" {
+			complete = false
+			break
+		}
+	}
+
+	switch {
+	case complete && session.Status == "pending_audit":
+		session.Status = "committed"
+		if err := m.storage.SaveSession(session); err != nil {
+			return fmt.Errorf("failed to complete session: %w", err)
+		}
+		if err := m.storage.PublishSessionResult(session); err != nil {
+			return fmt.Errorf("failed to publish session: %w", err)
+		}
+	case !complete && session.Status == "committed":
+		session.Status = "pending_audit"
+		if err := m.storage.SaveSession(session); err != nil {
+			return fmt.Errorf("failed to reopen session: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// hours converts fractional hours to a duration
+func hours(h float64) time.Duration {
+	return time.Duration(h * float64(time.Hour))
+}
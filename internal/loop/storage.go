@@ -46,6 +46,12 @@ func (s *Storage) LoadSession(sessionID string) (*Session, error) {
 	if completedAt, ok := sessionData["completed_at"].(int64); ok {
 		session.CompletedAt = completedAt
 	}
+	if projectRoot, ok := sessionData["project_root"].(string); ok {
+		session.ProjectRoot = projectRoot
+	}
+	if importedFrom, ok := sessionData["imported_from"].(string); ok {
+		session.ImportedFrom = importedFrom
+	}
 
 	return session, nil
 }
@@ -73,11 +79,64 @@ func (s *Storage) PublishSessionResult(session *Session) error {
 	return s.outputDB.PublishResult(hash, session.SessionID, blocksCommitted, string(dataJSON))
 }
 
-// GetSessionBlocks retrieves all blocks for a session
+// GetSessionBlocks retrieves all blocks for a session with their dependencies
+// and latest audit feedback
 func (s *Storage) GetSessionBlocks(sessionID string) ([]Block, error) {
-	// This would require a query to get all blocks by session_id
-	// For now, return empty slice (blocks are loaded individually)
-	return []Block{}, nil
+	ids, err := s.lifecycleDB.GetSessionBlockIDs(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocks: %w", err)
+	}
+
+	blocks := make([]Block, 0, len(ids))
+	for _, id := range ids {
+		blockData, err := s.lifecycleDB.GetBlock(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve block %s: %w", id, err)
+		}
+		block := mapToBlock(blockData)
+
+		if block.DependsOn, err = s.lifecycleDB.GetBlockDependencies(id); err != nil {
+			return nil, fmt.Errorf("failed to retrieve dependencies of %s: %w", id, err)
+		}
+
+		refinements, err := s.lifecycleDB.GetBlockRefinements(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve refinements of %s: %w", id, err)
+		}
+		if len(refinements) > 0 {
+			block.LastFeedback = refinements[len(refinements)-1]["feedback"].(string)
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// ListSessions retrieves session summaries, newest first
+func (s *Storage) ListSessions(status string, createdAfter, createdBefore int64, limit int) ([]SessionSummary, error) {
+	rows, err := s.lifecycleDB.ListSessions(status, createdAfter, createdBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]SessionSummary, 0, len(rows))
+	for _, row := range rows {
+		summary := SessionSummary{
+			SessionID:       row["session_id"].(string),
+			Status:          row["status"].(string),
+			CreatedAt:       row["created_at"].(int64),
+			Blocks:          row["blocks"].(int),
+			BlocksCommitted: row["blocks_committed"].(int),
+			LastActivity:    row["last_activity"].(int64),
+		}
+		if completedAt, ok := row["completed_at"].(int64); ok {
+			summary.CompletedAt = completedAt
+		}
+		sessions = append(sessions, summary)
+	}
+
+	return sessions, nil
 }
 
 // DeleteSession marks a session as abandoned
@@ -93,24 +152,33 @@ func hashSession(sessionID string) string {
 
 // SessionStats represents statistics for sessions
 type SessionStats struct {
-	TotalSessions      int     `json:"total_sessions"`
-	PendingAudit       int     `json:"pending_audit"`
-	Committed          int     `json:"committed"`
-	Abandoned          int     `json:"abandoned"`
+	TotalSessions       int     `json:"total_sessions"`
+	PendingAudit        int     `json:"pending_audit"`
+	Committed           int     `json:"committed"`
+	Abandoned           int     `json:"abandoned"`
 	AvgBlocksPerSession float64 `json:"avg_blocks_per_session"`
 }
 
 // GetSessionStats retrieves statistics about sessions
 func (s *Storage) GetSessionStats() (*SessionStats, error) {
-	// This would require custom queries
-	// Placeholder implementation
-	return &SessionStats{
-		TotalSessions:       0,
-		PendingAudit:        0,
-		Committed:           0,
-		Abandoned:           0,
-		AvgBlocksPerSession: 0.0,
-	}, nil
+	counts, blocks, err := s.lifecycleDB.CountSessionsByStatus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sessions: %w", err)
+	}
+
+	stats := &SessionStats{
+		PendingAudit: counts["pending_audit"],
+		Committed:    counts["committed"],
+		Abandoned:    counts["abandoned"],
+	}
+	for _, count := range counts {
+		stats.TotalSessions += count
+	}
+	if stats.TotalSessions > 0 {
+		stats.AvgBlocksPerSession = float64(blocks) / float64(stats.TotalSessions)
+	}
+
+	return stats, nil
 }
 
 // CleanupExpiredCache removes expired cache entries
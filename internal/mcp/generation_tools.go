@@ -0,0 +1,137 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"brainloop/internal/cerebras"
+	"brainloop/internal/patterns"
+	"brainloop/internal/readers"
+	"brainloop/internal/workspace"
+)
+
+// newGenerationTools registers the reader and pattern functions the model may
+// call while generating code. The model chooses their paths: every path is
+// resolved against root and refused if it is absolute or escapes it.
+func newGenerationTools(hub *readers.Hub, extractor *patterns.Extractor, root string) *cerebras.ToolRegistry {
+	registry := cerebras.NewToolRegistry()
+
+	registry.Register("read_code",
+		"Return an architectural digest of a source file (package, imports, functions, types)",
+		objectSchema(map[string]interface{}{
+			"file_path": stringProperty("Path of the source file to read, relative to the project root"),
+		}, "file_path"),
+		func(args map[string]interface{}) (string, error) {
+			if err := resolveToolPath(root, args, "file_path"); err != nil {
+				return "", err
+			}
+			return hub.ReadCode(args)
+		})
+
+	registry.Register("read_sqlite",
+		"Return a digest of a SQLite database (tables, columns, schemas, indexes, pragmas)",
+		objectSchema(map[string]interface{}{
+			"db_path":         stringProperty("Path of the SQLite database, relative to the project root"),
+			"max_sample_rows": map[string]interface{}{"type": "integer", "description": "Sample rows per table (default 5)"},
+		}, "db_path"),
+		func(args map[string]interface{}) (string, error) {
+			if err := resolveToolPath(root, args, "db_path"); err != nil {
+				return "", err
+			}
+			return hub.ReadSQLite(args)
+		})
+
+	registry.Register("read_markdown",
+		"Return a digest of a markdown document (sections, code blocks, key concepts)",
+		objectSchema(map[string]interface{}{
+			"file_path": stringProperty("Path of the markdown file, relative to the project root"),
+		}, "file_path"),
+		func(args map[string]interface{}) (string, error) {
+			if err := resolveToolPath(root, args, "file_path"); err != nil {
+				return "", err
+			}
+			return hub.ReadMarkdown(args)
+		})
+
+	registry.Register("extract_patterns",
+		"Detect project conventions (naming, imports, error handling, SQL pragmas) from a directory or a list of files",
+		objectSchema(map[string]interface{}{
+			"project_path": stringProperty("Directory to scan recursively, relative to the project root (\".\" for the whole project)"),
+			"file_paths": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Specific .go/.sql files to scan instead of a directory, relative to the project root",
+			},
+		}),
+		func(args map[string]interface{}) (string, error) {
+			var detected map[string]interface{}
+			var err error
+
+			if rawPaths, ok := args["file_paths"].([]interface{}); ok && len(rawPaths) > 0 {
+				var paths []string
+				for _, p := range rawPaths {
+					if path, ok := p.(string); ok {
+						resolved, err := workspace.Resolve(root, path)
+						if err != nil {
+							return "", err
+						}
+						paths = append(paths, resolved)
+					}
+				}
+				detected, err = extractor.ExtractFromFiles(paths)
+			} else if projectPath, ok := args["project_path"].(string); ok {
+				var dir string
+				if dir, err = workspace.Resolve(root, projectPath); err != nil {
+					return "", err
+				}
+				detected, err = extractor.ExtractForProject(dir)
+			} else {
+				return "", fmt.Errorf("project_path or file_paths is required")
+			}
+			if err != nil {
+				return "", err
+			}
+
+			patternsJSON, err := json.Marshal(detected)
+			if err != nil {
+				return "", fmt.Errorf("failed to marshal patterns: %w", err)
+			}
+			return string(patternsJSON), nil
+		})
+
+	return registry
+}
+
+// resolveToolPath replaces the path argument key with its absolute path under root
+func resolveToolPath(root string, args map[string]interface{}, key string) error {
+	path, ok := args[key].(string)
+	if !ok {
+		return fmt.Errorf("%s is required", key)
+	}
+	resolved, err := workspace.Resolve(root, path)
+	if err != nil {
+		return err
+	}
+	args[key] = resolved
+	return nil
+}
+
+// objectSchema builds a JSON schema object with the given properties
+func objectSchema(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// stringProperty builds a JSON schema string property
+func stringProperty(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "string",
+		"description": description,
+	}
+}
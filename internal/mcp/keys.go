@@ -0,0 +1,84 @@
+package mcp
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"brainloop/internal/cerebras"
+	"brainloop/internal/database"
+)
+
+// keyReloadInterval is how often the secrets table is polled for rotated keys
+const keyReloadInterval = 30 * time.Second
+
+// keyProviders lists the key pools loaded from the secrets table
+var keyProviders = []string{"cerebras"}
+
+// loadAPIKeys loads a provider's keys from the secrets table into the client.
+// It returns the number of keys and whether the pool changed.
+func loadAPIKeys(client *cerebras.Client, metaDB *database.MetadataDB, provider string) (int, bool, error) {
+	rows, err := metaDB.ListAPIKeys(provider)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to list %s API keys: %w", provider, err)
+	}
+
+	keys := make([]cerebras.APIKeyConfig, 0, len(rows))
+	for _, row := range rows {
+		key := cerebras.APIKeyConfig{
+			Name:      row["secret_name"].(string),
+			Value:     row["secret_value"].(string),
+			RotatedAt: row["last_rotated"].(int64),
+			Disabled:  row["disabled"].(bool),
+		}
+		if reason, ok := row["disabled_reason"].(string); ok {
+			key.DisabledReason = reason
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		// No pool: the client keeps using the key it was created with
+		return 0, false, nil
+	}
+
+	return len(keys), client.SetAPIKeys(provider, keys), nil
+}
+
+// watchAPIKeys reloads rotated, added or removed keys until the server stops
+func (s *Server) watchAPIKeys() {
+	metaDB := database.NewMetadataDB(s.metadataDB)
+
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, provider := range keyProviders {
+				count, changed, err := loadAPIKeys(s.cerebrasClient, metaDB, provider)
+				if err != nil {
+					log.Printf("Failed to reload API keys: %v", err)
+					continue
+				}
+				if changed {
+					log.Printf("Reloaded %d %s API key(s)", count, provider)
+					metaDB.RecordTelemetryEvent("api_keys_reloaded", fmt.Sprintf("%s: %d key(s)", provider, count))
+				}
+			}
+		}
+	}
+}
+
+// disableAPIKey persists a key disabled after an authentication failure
+func disableAPIKey(metaDB *database.MetadataDB, provider, name, reason string) {
+	if err := metaDB.DisableSecret(name, reason); err != nil {
+		log.Printf("Failed to disable %s API key %s: %v", provider, name, err)
+		return
+	}
+
+	log.Printf("Disabled %s API key %s: %s", provider, name, reason)
+	metaDB.RecordTelemetryEvent("api_key_disabled", fmt.Sprintf("%s/%s: %s", provider, name, reason))
+}
@@ -18,16 +18,16 @@ import (
 
 // Server represents an MCP server
 type Server struct {
-	lifecycleDB     *sql.DB
-	outputDB        *sql.DB
-	metadataDB      *sql.DB
-	cerebrasClient  *cerebras.Client
-	loopManager     *loop.Manager
-	readersHub      *readers.Hub
+	lifecycleDB      *sql.DB
+	outputDB         *sql.DB
+	metadataDB       *sql.DB
+	cerebrasClient   *cerebras.Client
+	loopManager      *loop.Manager
+	readersHub       *readers.Hub
 	patternExtractor *patterns.Extractor
-	bashHandler     *BashHandler
-	ctx             context.Context
-	cancel          context.CancelFunc
+	bashHandler      *BashHandler
+	ctx              context.Context
+	cancel           context.CancelFunc
 }
 
 // NewServer creates a new MCP server
@@ -35,18 +35,47 @@ func NewServer(lifecycleDB, outputDB, metadataDB *sql.DB) (*Server, error) {
 	// Get Cerebras API key from metadata DB
 	metaDB := database.NewMetadataDB(metadataDB)
 	apiKey, err := metaDB.GetSecret("CEREBRAS_API_KEY")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get Cerebras API key: %w", err)
-	}
 
 	// Initialize Cerebras client
 	cerebrasClient := cerebras.NewClient(apiKey)
 
+	// Named keys per provider, spread round-robin with a limiter each
+	pooledKeys := 0
+	for _, provider := range keyProviders {
+		count, _, loadErr := loadAPIKeys(cerebrasClient, metaDB, provider)
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		pooledKeys += count
+	}
+	if err != nil && pooledKeys == 0 {
+		return nil, fmt.Errorf("failed to get Cerebras API key: %w", err)
+	}
+
+	// Keys rejected with 401 stay disabled until rotated
+	cerebrasClient.SetKeyObserver(func(provider, name, reason string) {
+		disableAPIKey(metaDB, provider, name, reason)
+	})
+
+	// Optional provider fallback chain, stored as JSON in lifecycle config
+	if err := configureProviders(cerebrasClient, database.NewLifecycleDB(lifecycleDB), metaDB); err != nil {
+		return nil, err
+	}
+
+	// Mirror breaker state into health_checks
+	outDB := database.NewOutputDB(outputDB)
+	cerebrasClient.SetBreakerObserver(func(endpoint string, success bool, snapshot cerebras.BreakerSnapshot) {
+		details, _ := json.Marshal(snapshot)
+		if err := outDB.UpsertHealthCheck("provider:"+endpoint, breakerHealthStatus(snapshot.State), !success, string(details)); err != nil {
+			log.Printf("Failed to record provider health for %s: %v", endpoint, err)
+		}
+	})
+
 	// Initialize loop manager
-	loopManager := loop.NewManager(lifecycleDB, outputDB, cerebrasClient)
+	loopManager := loop.NewManager(lifecycleDB, outputDB, cerebrasClient.ForAction("loop"))
 
 	// Initialize readers hub
-	readersHub := readers.NewHub(lifecycleDB, outputDB, cerebrasClient)
+	readersHub := readers.NewHub(lifecycleDB, outputDB, cerebrasClient.ForAction("read"))
 
 	// Initialize pattern extractor
 	patternExtractor := patterns.NewExtractor(lifecycleDB)
@@ -59,7 +88,12 @@ func NewServer(lifecycleDB, outputDB, metadataDB *sql.DB) (*Server, error) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Server{
+	// Local tools the generator may call mid-generation, confined to a project root
+	loopManager.SetGenerationTools(func(root string) *cerebras.ToolRegistry {
+		return newGenerationTools(readersHub, patternExtractor, root)
+	})
+
+	server := &Server{
 		lifecycleDB:      lifecycleDB,
 		outputDB:         outputDB,
 		metadataDB:       metadataDB,
@@ -70,7 +104,49 @@ func NewServer(lifecycleDB, outputDB, metadataDB *sql.DB) (*Server, error) {
 		bashHandler:      bashHandler,
 		ctx:              ctx,
 		cancel:           cancel,
-	}, nil
+	}
+
+	// Pick up rotated keys without a restart
+	go server.watchAPIKeys()
+
+	// Abandon pending_audit sessions nobody came back to
+	go server.watchSessionExpiry()
+
+	return server, nil
+}
+
+// configureProviders loads the "provider_chain" config entry, if any, and applies it
+func configureProviders(client *cerebras.Client, lifecycleDB *database.LifecycleDB, metaDB *database.MetadataDB) error {
+	raw, err := lifecycleDB.GetConfig("provider_chain")
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read provider chain: %w", err)
+	}
+
+	cfg, err := cerebras.ParseProviderConfig(raw)
+	if err != nil {
+		return err
+	}
+
+	if err := client.ConfigureProviders(cfg, metaDB.GetSecret); err != nil {
+		return fmt.Errorf("failed to configure providers: %w", err)
+	}
+
+	return nil
+}
+
+// breakerHealthStatus maps a breaker state to a health_checks status
+func breakerHealthStatus(state cerebras.BreakerState) string {
+	switch state {
+	case cerebras.BreakerOpen:
+		return "unhealthy"
+	case cerebras.BreakerHalfOpen:
+		return "degraded"
+	default:
+		return "healthy"
+	}
 }
 
 // JSONRPCRequest represents a JSON-RPC 2.0 request
@@ -152,6 +228,21 @@ func (s *Server) handleRequest(req *JSONRPCRequest) *JSONRPCResponse {
 
 // handleInitialize handles initialization request
 func (s *Server) handleInitialize(req *JSONRPCRequest) *JSONRPCResponse {
+	// The client is recorded on the events of the loop sessions it works on
+	var params struct {
+		ClientInfo struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+	}
+	if json.Unmarshal(req.Params, &params) == nil && params.ClientInfo.Name != "" {
+		actor := params.ClientInfo.Name
+		if params.ClientInfo.Version != "" {
+			actor += " " + params.ClientInfo.Version
+		}
+		s.loopManager.SetActor(actor)
+	}
+
 	return &JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      req.ID,
@@ -181,7 +272,7 @@ func (s *Server) handleToolsList(req *JSONRPCRequest) *JSONRPCResponse {
 					"action": map[string]interface{}{
 						"type": "string",
 						"enum": []string{
-							"generate_file", "generate_sql", "explore", "loop",
+							"generate_file", "generate_files", "generate_sql", "explore", "loop", "recipe",
 							"read_sqlite", "read_markdown", "read_code", "read_config",
 							"list_actions", "get_schema", "get_stats",
 						},
@@ -0,0 +1,79 @@
+package mcp
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"brainloop/internal/database"
+)
+
+const (
+	// sessionExpiryConfig is the config key of the idle time, in hours, after
+	// which pending_audit sessions are abandoned; 0 disables expiry
+	sessionExpiryConfig = "session_expiry_hours"
+
+	// defaultSessionExpiry applies when the config key is not set
+	defaultSessionExpiry = 72 * time.Hour
+
+	// sessionExpiryInterval is how often idle sessions are looked for
+	sessionExpiryInterval = 10 * time.Minute
+)
+
+// sessionExpiry reads the configured idle time of pending_audit sessions
+func sessionExpiry(lifecycleDB *database.LifecycleDB) (time.Duration, error) {
+	raw, err := lifecycleDB.GetConfig(sessionExpiryConfig)
+	if err != nil || raw == "" {
+		return defaultSessionExpiry, nil
+	}
+
+	hours, err := strconv.ParseFloat(raw, 64)
+	if err != nil || hours < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a number of hours", sessionExpiryConfig, raw)
+	}
+
+	return time.Duration(hours * float64(time.Hour)), nil
+}
+
+// expireSessions abandons pending_audit sessions idle for longer than the configured expiry
+func (s *Server) expireSessions() {
+	lifecycleDB := database.NewLifecycleDB(s.lifecycleDB)
+
+	expiry, err := sessionExpiry(lifecycleDB)
+	if err != nil {
+		log.Printf("Session expiry disabled: %v", err)
+		return
+	}
+	if expiry == 0 {
+		return
+	}
+
+	expired, err := s.loopManager.ExpireSessions(expiry)
+	if err != nil {
+		log.Printf("Failed to expire sessions: %v", err)
+		return
+	}
+
+	if len(expired) > 0 {
+		log.Printf("Abandoned %d session(s) idle for more than %s", len(expired), expiry)
+		database.NewMetadataDB(s.metadataDB).RecordTelemetryEvent("sessions_expired", fmt.Sprintf("%d session(s): %v", len(expired), expired))
+	}
+}
+
+// watchSessionExpiry abandons idle sessions until the server stops
+func (s *Server) watchSessionExpiry() {
+	s.expireSessions()
+
+	ticker := time.NewTicker(sessionExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.expireSessions()
+		}
+	}
+}
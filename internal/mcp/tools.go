@@ -7,11 +7,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"brainloop/internal/cerebras"
 	"brainloop/internal/database"
 	"brainloop/internal/loop"
+	"brainloop/internal/workspace"
 )
 
 // dispatchAction routes actions to appropriate handlers
@@ -19,12 +22,16 @@ func (s *Server) dispatchAction(action string, params map[string]interface{}) (i
 	switch action {
 	case "generate_file":
 		return s.handleGenerateFile(params)
+	case "generate_files":
+		return s.handleGenerateFiles(params)
 	case "generate_sql":
 		return s.handleGenerateSQL(params)
 	case "explore":
 		return s.handleExplore(params)
 	case "loop":
 		return s.handleLoop(params)
+	case "recipe":
+		return s.handleRecipe(params)
 	case "read_sqlite":
 		return s.handleReadSQLite(params)
 	case "read_markdown":
@@ -72,10 +79,49 @@ func (s *Server) handleGenerateFile(params map[string]interface{}) (interface{},
 		patterns = p
 	}
 
-	// Generate code
-	code, err := s.cerebrasClient.GenerateCode(verifiedPrompt, codeType, patterns)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate code: %w", err)
+	candidates := 1
+	if n, ok := params["candidates"].(float64); ok && n > 1 {
+		candidates = int(n)
+	}
+
+	// Generate code, optionally letting the model call local reader tools
+	var code string
+	var toolCalls []cerebras.ToolCallRecord
+	var bestOfN *cerebras.BestOfNResult
+	if useTools, _ := params["use_tools"].(bool); useTools {
+		maxSteps := 0
+		if steps, ok := params["max_tool_steps"].(float64); ok {
+			maxSteps = int(steps)
+		}
+
+		// The tools only read under the project root, by default the output's directory
+		root := getString(params, "project_root")
+		if root == "" {
+			root = filepath.Dir(outputPath)
+		}
+		tools := newGenerationTools(s.readersHub, s.patternExtractor, root)
+
+		var result *cerebras.GenerationResult
+		var err error
+		code, result, err = s.cerebrasClient.ForAction("generate_file").GenerateCodeWithTools(verifiedPrompt, codeType, patterns, 0.1, tools, maxSteps)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate code: %w", err)
+		}
+		toolCalls = result.ToolCalls
+	} else if candidates > 1 {
+		// Best-of-N: generate several candidates and keep the best validated one
+		var err error
+		bestOfN, err = s.cerebrasClient.ForAction("generate_file").GenerateBestOfN(verifiedPrompt, codeType, patterns, 0.1, candidates)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate code: %w", err)
+		}
+		code = bestOfN.Code
+	} else {
+		var err error
+		code, err = s.cerebrasClient.ForAction("generate_file").GenerateCode(verifiedPrompt, codeType, patterns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate code: %w", err)
+		}
 	}
 
 	// Write file
@@ -95,12 +141,90 @@ func (s *Server) handleGenerateFile(params map[string]interface{}) (interface{},
 	})
 	db.MarkProcessed(hash, "generate_file", string(resultJSON))
 
-	return map[string]interface{}{
+	response := map[string]interface{}{
 		"success":     true,
 		"output_path": outputPath,
 		"code_type":   codeType,
 		"line_count":  len(strings.Split(code, "\n")),
 		"message":     fmt.Sprintf("File generated successfully: %s", outputPath),
+	}
+	if len(toolCalls) > 0 {
+		response["tool_calls"] = toolCalls
+	}
+	if bestOfN != nil {
+		response["selected_candidate"] = bestOfN.Selected
+		response["candidates"] = bestOfN.Candidates
+	}
+
+	return response, nil
+}
+
+// handleGenerateFiles generates several files from a single prompt
+func (s *Server) handleGenerateFiles(params map[string]interface{}) (interface{}, error) {
+	// Extract parameters
+	verifiedPrompt, ok := params["verified_prompt"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing verified_prompt")
+	}
+
+	workspaceRoot, ok := params["workspace_root"].(string)
+	if !ok || workspaceRoot == "" {
+		workspaceRoot = "."
+	}
+
+	// Extract patterns if provided
+	var patterns interface{}
+	if p, ok := params["patterns"]; ok {
+		patterns = p
+	}
+
+	// Generate files
+	blocks, result, err := s.cerebrasClient.ForAction("generate_files").GenerateFiles(verifiedPrompt, patterns, 0.1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate files: %w", err)
+	}
+
+	files := make([]workspace.File, 0, len(blocks))
+	for _, block := range blocks {
+		files = append(files, workspace.File{
+			Path:     block.Path,
+			Language: block.Language,
+			Content:  strings.TrimSpace(block.Content) + "\n",
+		})
+	}
+
+	// Write files atomically (all paths validated before the first write)
+	manifest, err := workspace.WriteFiles(workspaceRoot, files)
+	if err != nil {
+		if len(manifest) > 0 {
+			written := make([]string, len(manifest))
+			for i, entry := range manifest {
+				written[i] = entry.Path
+			}
+			return nil, fmt.Errorf("failed to write files after writing %d of %d (%s): %w",
+				len(manifest), len(files), strings.Join(written, ", "), err)
+		}
+		return nil, fmt.Errorf("failed to write files: %w", err)
+	}
+
+	// Calculate hash and mark processed
+	manifestJSON, _ := json.Marshal(manifest)
+	hash := hashString(verifiedPrompt + workspaceRoot + string(manifestJSON))
+	lifecycleDB := database.NewLifecycleDB(s.lifecycleDB)
+
+	resultJSON, _ := json.Marshal(map[string]interface{}{
+		"workspace_root": workspaceRoot,
+		"files":          manifest,
+	})
+	lifecycleDB.MarkProcessed(hash, "generate_files", string(resultJSON))
+
+	return map[string]interface{}{
+		"success":        true,
+		"workspace_root": workspaceRoot,
+		"files":          manifest,
+		"file_count":     len(manifest),
+		"tokens":         result.PromptTokens + result.CompletionTokens,
+		"message":        fmt.Sprintf("%d files generated under %s", len(manifest), workspaceRoot),
 	}, nil
 }
 
@@ -118,7 +242,7 @@ func (s *Server) handleGenerateSQL(params map[string]interface{}) (interface{},
 	}
 
 	// Generate SQL
-	sqlCode, err := s.cerebrasClient.GenerateCode(verifiedPrompt, "sql", nil)
+	sqlCode, err := s.cerebrasClient.ForAction("generate_sql").GenerateCode(verifiedPrompt, "sql", nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate SQL: %w", err)
 	}
@@ -175,7 +299,7 @@ func (s *Server) handleExplore(params map[string]interface{}) (interface{}, erro
 	}
 
 	// Generate with creative temperature
-	result, err := s.cerebrasClient.GenerateCodeWithTemperature(description, codeType, nil, 0.6)
+	result, err := s.cerebrasClient.ForAction("explore").GenerateCodeWithTemperature(description, codeType, nil, 0.6)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate code: %w", err)
 	}
@@ -199,12 +323,44 @@ func (s *Server) handleLoop(params map[string]interface{}) (interface{}, error)
 	switch mode {
 	case "propose":
 		return s.handleLoopPropose(params)
+	case "retry":
+		return s.handleLoopRetry(params)
 	case "audit":
 		return s.handleLoopAudit(params)
 	case "refine":
 		return s.handleLoopRefine(params)
 	case "commit":
 		return s.handleLoopCommit(params)
+	case "commit_session":
+		return s.handleLoopCommitSession(params)
+	case "validate":
+		return s.handleLoopValidate(params)
+	case "auto":
+		return s.handleLoopAuto(params)
+	case "history":
+		return s.handleLoopHistory(params)
+	case "diff":
+		return s.handleLoopDiff(params)
+	case "revert":
+		return s.handleLoopRevert(params)
+	case "git_diff":
+		return s.handleLoopGitDiff(params)
+	case "export":
+		return s.handleLoopExport(params)
+	case "import":
+		return s.handleLoopImport(params)
+	case "report":
+		return s.handleLoopReport(params)
+	case "rollback":
+		return s.handleLoopRollback(params)
+	case "list":
+		return s.handleLoopList(params)
+	case "status":
+		return s.handleLoopStatus(params)
+	case "abandon":
+		return s.handleLoopAbandon(params)
+	case "resume":
+		return s.handleLoopResume(params)
 	default:
 		return nil, fmt.Errorf("unknown loop mode: %s", mode)
 	}
@@ -212,9 +368,9 @@ func (s *Server) handleLoop(params map[string]interface{}) (interface{}, error)
 
 // handleLoopPropose handles loop propose action
 func (s *Server) handleLoopPropose(params map[string]interface{}) (interface{}, error) {
-	// Extract blocks
+	// Extract blocks; a recipe may stand in for them
 	blocksRaw, ok := params["blocks"].([]interface{})
-	if !ok {
+	if !ok && getString(params, "recipe") == "" {
 		return nil, fmt.Errorf("missing blocks parameter")
 	}
 
@@ -232,11 +388,32 @@ func (s *Server) handleLoopPropose(params map[string]interface{}) (interface{},
 			Type:        getString(blockMap, "type"),
 			Target:      getString(blockMap, "target"),
 		}
+		block.DependsOn = getStrings(blockMap, "depends_on")
+		block.Test = getAcceptanceTest(blockMap)
 		blocks = append(blocks, block)
 	}
 
+	variables := make(map[string]string)
+	if raw, ok := params["variables"].(map[string]interface{}); ok {
+		for name, value := range raw {
+			variables[name] = fmt.Sprint(value)
+		}
+	}
+	recipeVersion, _ := params["recipe_version"].(float64)
+
+	useTools, _ := params["use_tools"].(bool)
+	maxToolSteps, _ := params["max_tool_steps"].(float64)
+
 	// Call loop manager
-	response, err := s.loopManager.Propose(loop.ProposeRequest{Blocks: blocks})
+	response, err := s.loopManager.Propose(loop.ProposeRequest{
+		Blocks:        blocks,
+		ProjectRoot:   getString(params, "project_root"),
+		Recipe:        getString(params, "recipe"),
+		RecipeVersion: int(recipeVersion),
+		Variables:     variables,
+		UseTools:      useTools,
+		MaxToolSteps:  int(maxToolSteps),
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -283,11 +460,17 @@ func (s *Server) handleLoopRefine(params map[string]interface{}) (interface{}, e
 	if !ok {
 		return nil, fmt.Errorf("missing audit_feedback")
 	}
+	version, _ := params["version"].(float64)
+	useTools, _ := params["use_tools"].(bool)
+	maxToolSteps, _ := params["max_tool_steps"].(float64)
 
 	response, err := s.loopManager.Refine(loop.RefineRequest{
 		SessionID:     sessionID,
 		BlockID:       blockID,
 		AuditFeedback: auditFeedback,
+		Version:       int(version),
+		UseTools:      useTools,
+		MaxToolSteps:  int(maxToolSteps),
 	})
 	if err != nil {
 		return nil, err
@@ -308,7 +491,192 @@ func (s *Server) handleLoopCommit(params map[string]interface{}) (interface{}, e
 		return nil, fmt.Errorf("missing block_id")
 	}
 
+	codeHash, ok := params["code_hash"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing code_hash (returned with the block by propose/audit/refine)")
+	}
+
+	regenerate, _ := params["regenerate"].(bool)
+	autoRefine, _ := params["auto_refine"].(bool)
+
+	candidates := 0
+	if n, ok := params["candidates"].(float64); ok {
+		candidates = int(n)
+	}
+
 	response, err := s.loopManager.Commit(loop.CommitRequest{
+		SessionID:  sessionID,
+		BlockID:    blockID,
+		CodeHash:   codeHash,
+		Regenerate: regenerate,
+		Candidates: candidates,
+		AutoRefine: autoRefine,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopCommitSession handles loop commit_session action
+func (s *Server) handleLoopCommitSession(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	rawHashes, ok := params["code_hashes"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("missing code_hashes (object mapping block_id to the reviewed code_hash)")
+	}
+	codeHashes := make(map[string]string, len(rawHashes))
+	for blockID, hash := range rawHashes {
+		if h, ok := hash.(string); ok {
+			codeHashes[blockID] = h
+		}
+	}
+
+	response, err := s.loopManager.CommitSession(loop.CommitSessionRequest{
+		SessionID:  sessionID,
+		CodeHashes: codeHashes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopGitDiff handles loop git_diff action
+func (s *Server) handleLoopGitDiff(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	response, err := s.loopManager.GitDiff(loop.GitDiffRequest{
+		SessionID: sessionID,
+		BlockID:   getString(params, "block_id"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopExport handles loop export action; without a path the bundle
+// itself is returned, as JSON ready to be imported
+func (s *Server) handleLoopExport(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	response, err := s.loopManager.Export(loop.ExportRequest{
+		SessionID: sessionID,
+		Format:    getString(params, "format"),
+		Path:      getString(params, "path"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Bundle != nil {
+		bundle, err := json.Marshal(response.Bundle)
+		if err != nil {
+			return nil, err
+		}
+		return string(bundle), nil
+	}
+
+	return response, nil
+}
+
+// handleLoopImport handles loop import action
+func (s *Server) handleLoopImport(params map[string]interface{}) (interface{}, error) {
+	var bundle []byte
+	switch b := params["bundle"].(type) {
+	case nil:
+	case string:
+		bundle = []byte(b)
+	default:
+		var err error
+		if bundle, err = json.Marshal(b); err != nil {
+			return nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+	}
+
+	response, err := s.loopManager.Import(loop.ImportRequest{
+		Path:       getString(params, "path"),
+		Bundle:     bundle,
+		OnConflict: getString(params, "on_conflict"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopReport handles loop report action; without a path the Markdown
+// document itself is returned
+func (s *Server) handleLoopReport(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	response, err := s.loopManager.Report(loop.ReportRequest{
+		SessionID: sessionID,
+		Path:      getString(params, "path"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Path == "" {
+		return response.Markdown, nil
+	}
+
+	return response, nil
+}
+
+// handleLoopRollback handles loop rollback action
+func (s *Server) handleLoopRollback(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	force, _ := params["force"].(bool)
+
+	response, err := s.loopManager.Rollback(loop.RollbackRequest{
+		SessionID: sessionID,
+		BlockID:   getString(params, "block_id"),
+		Force:     force,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopValidate handles loop validate action
+func (s *Server) handleLoopValidate(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	blockID, ok := params["block_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing block_id")
+	}
+
+	response, err := s.loopManager.Validate(loop.ValidateRequest{
 		SessionID: sessionID,
 		BlockID:   blockID,
 	})
@@ -319,6 +687,233 @@ func (s *Server) handleLoopCommit(params map[string]interface{}) (interface{}, e
 	return response, nil
 }
 
+// handleLoopAuto handles loop auto action
+func (s *Server) handleLoopAuto(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	maxIterations, _ := params["max_iterations"].(float64)
+	tokenBudget, _ := params["token_budget"].(float64)
+
+	response, err := s.loopManager.Auto(loop.AutoRequest{
+		SessionID:     sessionID,
+		BlockID:       getString(params, "block_id"),
+		MaxIterations: int(maxIterations),
+		TokenBudget:   int(tokenBudget),
+		AuditPrompt:   getString(params, "audit_prompt"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopRetry handles loop retry action
+func (s *Server) handleLoopRetry(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	useTools, _ := params["use_tools"].(bool)
+	maxToolSteps, _ := params["max_tool_steps"].(float64)
+
+	response, err := s.loopManager.Retry(loop.RetryRequest{
+		SessionID:    sessionID,
+		BlockID:      getString(params, "block_id"),
+		UseTools:     useTools,
+		MaxToolSteps: int(maxToolSteps),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopHistory handles loop history action
+func (s *Server) handleLoopHistory(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	blockID, ok := params["block_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing block_id")
+	}
+
+	response, err := s.loopManager.History(loop.HistoryRequest{
+		SessionID: sessionID,
+		BlockID:   blockID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopDiff handles loop diff action
+func (s *Server) handleLoopDiff(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	blockID, ok := params["block_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing block_id")
+	}
+
+	req := loop.DiffRequest{SessionID: sessionID, BlockID: blockID}
+	if from, ok := params["from"].(float64); ok {
+		n := int(from)
+		req.From = &n
+	}
+	if to, ok := params["to"].(float64); ok {
+		n := int(to)
+		req.To = &n
+	}
+	req.AgainstDisk, _ = params["against_disk"].(bool)
+
+	response, err := s.loopManager.Diff(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopRevert handles loop revert action
+func (s *Server) handleLoopRevert(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	blockID, ok := params["block_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing block_id")
+	}
+
+	iteration, ok := params["iteration"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("missing iteration")
+	}
+
+	response, err := s.loopManager.Revert(loop.RevertRequest{
+		SessionID: sessionID,
+		BlockID:   blockID,
+		Iteration: int(iteration),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopList handles loop list action
+func (s *Server) handleLoopList(params map[string]interface{}) (interface{}, error) {
+	maxAge, _ := params["max_age_hours"].(float64)
+	minAge, _ := params["min_age_hours"].(float64)
+	limit, _ := params["limit"].(float64)
+
+	response, err := s.loopManager.List(loop.ListRequest{
+		Status:      getString(params, "status"),
+		MaxAgeHours: maxAge,
+		MinAgeHours: minAge,
+		Limit:       int(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopStatus handles loop status action
+func (s *Server) handleLoopStatus(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	response, err := s.loopManager.Status(loop.SessionRequest{SessionID: sessionID})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopAbandon handles loop abandon action
+func (s *Server) handleLoopAbandon(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	response, err := s.loopManager.Abandon(loop.SessionRequest{SessionID: sessionID})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleLoopResume handles loop resume action
+func (s *Server) handleLoopResume(params map[string]interface{}) (interface{}, error) {
+	sessionID, ok := params["session_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing session_id")
+	}
+
+	response, err := s.loopManager.Resume(loop.SessionRequest{SessionID: sessionID})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// handleRecipe handles block recipe actions
+func (s *Server) handleRecipe(params map[string]interface{}) (interface{}, error) {
+	mode, ok := params["mode"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing mode parameter")
+	}
+
+	switch mode {
+	case "create", "version":
+		name, ok := params["name"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing name")
+		}
+		req := loop.RecipeRequest{
+			Name:        name,
+			Description: getString(params, "description"),
+			Type:        getString(params, "type"),
+			Target:      getString(params, "target"),
+			DependsOn:   getStrings(params, "depends_on"),
+			Test:        getAcceptanceTest(params),
+			Note:        getString(params, "note"),
+		}
+		if mode == "create" {
+			return s.loopManager.CreateRecipe(req)
+		}
+		return s.loopManager.VersionRecipe(req)
+	case "list":
+		return s.loopManager.ListRecipes(loop.RecipeListRequest{Name: getString(params, "name")})
+	default:
+		return nil, fmt.Errorf("unknown recipe mode: %s", mode)
+	}
+}
+
 // handleReadSQLite handles SQLite database reading
 func (s *Server) handleReadSQLite(params map[string]interface{}) (interface{}, error) {
 	digest, err := s.readersHub.ReadSQLite(params)
@@ -381,7 +976,12 @@ func (s *Server) handleListActions(params map[string]interface{}) (interface{},
 		{
 			"name":        "generate_file",
 			"description": "Generate a code file from prompt with pattern injection",
-			"parameters":  []string{"verified_prompt", "output_path", "code_type", "patterns (optional)"},
+			"parameters":  []string{"verified_prompt", "output_path", "code_type", "patterns (optional)", "use_tools (optional)", "max_tool_steps (optional)", "project_root (optional)", "candidates (optional)"},
+		},
+		{
+			"name":        "generate_files",
+			"description": "Generate several files from one prompt (model annotates each fenced block with its path); files are written atomically under workspace_root",
+			"parameters":  []string{"verified_prompt", "workspace_root (optional, default .)", "patterns (optional)"},
 		},
 		{
 			"name":        "generate_sql",
@@ -395,8 +995,13 @@ func (s *Server) handleListActions(params map[string]interface{}) (interface{},
 		},
 		{
 			"name":        "loop",
-			"description": "Iterative code generation workflow (propose/audit/refine/commit)",
-			"parameters":  []string{"mode", "session_id (audit/refine/commit)", "block_id (audit/refine/commit)", "blocks (propose)", "audit_feedback (refine)"},
+			"description": "Iterative code generation workflow (propose/retry/audit/refine/validate/git_diff/commit/commit_session/rollback), unattended audit-refine cycles (auto), iteration history (history/diff/revert) and session management (list/status/abandon/resume/export/import/report); every step is recorded in the session's event log, and report renders it as a Markdown document with per-block iteration diffs, audit feedback and cost, ready for a PR description; export writes a versioned json or tar.gz bundle of the session with its blocks, refinement history, validation results and usage, which import loads under a new session ID; git_diff shows the pending changes against git HEAD, and with the git_commits config key on, commits are recorded on the local branch brainloop/<session_id>",
+			"parameters":  []string{"mode", "session_id (retry/audit/refine/validate/git_diff/commit/commit_session/rollback/auto/history/diff/revert/status/abandon/resume/export/report)", "block_id (audit/refine/validate/commit/history/diff/revert; retry/git_diff/rollback/auto: optional, whole session when omitted)", "blocks (propose; each may list depends_on block ids, generated with their code as context and committed first, and a test {kind: go|shell, code, root} run against every candidate in a scratch copy of the workspace; blocks that fail to generate are returned with status failed and their error, retry them with mode retry)", "recipe / recipe_version / variables (propose, optional: a recipe, latest version by default, and its dependent recipes proposed before blocks with their {{variable}} placeholders filled in; blocks may then be omitted)", "project_root (propose, optional: project whose extracted patterns are injected into every generation; default the go.mod directory of the first block's target, else its directory)", "audit_feedback (refine)", "version (refine, optional: version of the reviewed block; refused if another write changed it since)", "use_tools / max_tool_steps (propose/refine/retry, optional: let the model call read_code, read_sqlite, read_markdown and extract_patterns under the session's project root before writing code, at most max_tool_steps rounds, default 5)", "code_hash (commit: hash of the reviewed code)", "code_hashes (commit_session: block_id -> reviewed code_hash for every uncommitted block; all land or none do)", "regenerate (commit, optional: final pass returned as a diff to confirm)", "candidates (commit with regenerate, optional best-of-N)", "auto_refine (commit, optional: refine with the errors when the validation gate fails)", "force (rollback, optional: restore even if targets changed since commit)", "status (list, optional: pending_audit/committed/abandoned)", "max_age_hours / min_age_hours (list, optional: session age bounds)", "limit (list, optional, default 50)", "max_iterations (auto, optional: refines per block, default 3)", "token_budget (auto, optional: stop once this many tokens are spent)", "audit_prompt (auto, optional)", "from / to (diff, optional: iteration numbers, 0 is the proposed code; default the latest two)", "against_disk (diff, optional: diff the target file with iteration from, default the current code)", "iteration (revert: iteration to restore, recorded as a new iteration)", "path (export, optional: bundle file to write, the bundle is returned when omitted; import: bundle file to read; report, optional: Markdown file to write, the report is returned when omitted)", "format (export, optional: json or tar.gz, default from the path extension)", "bundle (import: the bundle returned by export, when no path is given)", "on_conflict (import, optional: fail, the default, refuses a session already on this worker; copy imports it again)"},
+		},
+		{
+			"name":        "recipe",
+			"description": "Named, versioned block templates proposed with loop propose recipe (create/version/list)",
+			"parameters":  []string{"mode", "name (create/version; list: optional, every version of this recipe)", "description (create/version: template with {{variable}} placeholders)", "type (create/version)", "target (create/version: template)", "depends_on (create/version, optional: recipes proposed first as dependencies)", "test (create/version, optional: acceptance test, code and root are templates)", "note (create/version, optional: why this version)"},
 		},
 		{
 			"name":        "read_sqlite",
@@ -480,6 +1085,43 @@ func (s *Server) handleGetSchema(params map[string]interface{}) (interface{}, er
 				"required":    "false",
 				"description": "Project patterns for context injection",
 			},
+			"use_tools": map[string]string{
+				"type":        "boolean",
+				"required":    "false",
+				"description": "Let the model call read_code, read_sqlite, read_markdown and extract_patterns before writing code",
+			},
+			"max_tool_steps": map[string]string{
+				"type":        "integer",
+				"required":    "false",
+				"description": "Maximum tool-calling rounds before the final answer (default 5)",
+			},
+			"project_root": map[string]string{
+				"type":        "string",
+				"required":    "false",
+				"description": "Directory the tools may read under, with paths relative to it (default: the directory of output_path)",
+			},
+			"candidates": map[string]string{
+				"type":        "integer",
+				"required":    "false",
+				"description": "Best-of-N: generate N candidates (max 8), score each locally (Go: parse, go/types, gofmt; SQL: in-memory execution) and keep the best. Scores are returned in the response. Ignored with use_tools",
+			},
+		},
+		"generate_files": map[string]interface{}{
+			"verified_prompt": map[string]string{
+				"type":        "string",
+				"required":    "true",
+				"description": "The prompt describing the set of files to generate",
+			},
+			"workspace_root": map[string]string{
+				"type":        "string",
+				"required":    "false",
+				"description": "Directory under which all generated paths are resolved (default: current directory)",
+			},
+			"patterns": map[string]string{
+				"type":        "object",
+				"required":    "false",
+				"description": "Project patterns for context injection",
+			},
 		},
 		"audit_code": map[string]interface{}{
 			"file_path": map[string]string{
@@ -520,9 +1162,17 @@ func (s *Server) handleGetStats(params map[string]interface{}) (interface{}, err
 		return nil, fmt.Errorf("failed to get metrics: %w", err)
 	}
 
+	sessions, err := s.loopManager.SessionStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session stats: %w", err)
+	}
+
 	return map[string]interface{}{
 		"period_hours": 1,
 		"metrics":      metrics,
+		"sessions":     sessions,
+		"providers":    s.cerebrasClient.ProviderStatuses(),
+		"api_keys":     s.cerebrasClient.APIKeyStatuses(),
 		"timestamp":    time.Now().Unix(),
 	}, nil
 }
@@ -538,7 +1188,7 @@ func (s *Server) handleAuditCode(params map[string]interface{}) (interface{}, er
 	auditPrompt, ok := params["audit_prompt"].(string)
 	if !ok {
 		// Default audit prompt
-		auditPrompt = "Analyze this code for: bugs, security issues, performance problems, code quality, best practices violations, and potential improvements. Provide detailed feedback."
+		auditPrompt = loop.DefaultAuditPrompt
 	}
 
 	// Read file
@@ -552,7 +1202,7 @@ func (s *Server) handleAuditCode(params map[string]interface{}) (interface{}, er
 		auditPrompt, filePath, string(content))
 
 	// Generate audit using Cerebras (temperature 0.3 for consistent analysis)
-	result, err := s.cerebrasClient.GenerateCodeWithTemperature(completePrompt, "markdown", nil, 0.3)
+	result, err := s.cerebrasClient.ForAction("audit_code").GenerateCodeWithTemperature(completePrompt, "markdown", nil, 0.3)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate audit: %w", err)
 	}
@@ -586,6 +1236,33 @@ func getString(m map[string]interface{}, key string) string {
 	return ""
 }
 
+// getStrings returns nil when the key is absent and an empty list when it is empty
+func getStrings(m map[string]interface{}, key string) []string {
+	raw, ok := m[key].([]interface{})
+	if !ok {
+		return nil
+	}
+	values := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if str, ok := v.(string); ok {
+			values = append(values, str)
+		}
+	}
+	return values
+}
+
+func getAcceptanceTest(m map[string]interface{}) *loop.AcceptanceTest {
+	test, ok := m["test"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return &loop.AcceptanceTest{
+		Kind: getString(test, "kind"),
+		Code: getString(test, "code"),
+		Root: getString(test, "root"),
+	}
+}
+
 func hashString(s string) string {
 	hash := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(hash[:])
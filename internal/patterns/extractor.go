@@ -28,6 +28,36 @@ func NewExtractor(lifecycleDBConn *sql.DB) *Extractor {
 
 // ExtractForProject extracts patterns from a project directory
 func (e *Extractor) ExtractForProject(projectPath string) (map[string]interface{}, error) {
+	pattern, err := e.extract(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	return pattern.PatternData, nil
+}
+
+// ExtractCached returns the patterns of a project from cache, extracting them
+// again when a Go or SQL file was added, removed or modified since they were
+// detected
+func (e *Extractor) ExtractCached(projectPath string) (*Pattern, error) {
+	cached, err := e.GetPattern(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		fresh, err := e.unchangedSince(projectPath, cached)
+		if err != nil {
+			return nil, err
+		}
+		if fresh {
+			return cached, nil
+		}
+	}
+
+	return e.extract(projectPath)
+}
+
+// extract detects the patterns of a project and saves them
+func (e *Extractor) extract(projectPath string) (*Pattern, error) {
 	// Collect all Go and SQL files
 	goFiles, err := e.findFiles(projectPath, ".go")
 	if err != nil {
@@ -61,18 +91,50 @@ func (e *Extractor) ExtractForProject(projectPath string) (map[string]interface{
 	}
 
 	// Add metadata
+	detectedAt := time.Now().Unix()
 	patterns["project_path"] = projectPath
-	patterns["extracted_at"] = time.Now().Unix()
+	patterns["extracted_at"] = detectedAt
 	patterns["go_file_count"] = len(goFiles)
 	patterns["sql_file_count"] = len(sqlFiles)
 
-	// Save patterns to database
-	if err := e.savePatterns(projectPath, patterns); err != nil {
-		// Log but don't fail
-		fmt.Printf("Warning: failed to save patterns: %v\n", err)
+	pattern := &Pattern{
+		PatternID:       uuid.New().String(),
+		SourcePath:      projectPath,
+		PatternType:     patternType(patterns),
+		PatternData:     patterns,
+		ConfidenceScore: 0.8,
+		DetectedAt:      detectedAt,
 	}
 
-	return patterns, nil
+	// Save patterns to database; the cache is what keeps sessions from
+	// walking the project on every generation
+	if err := e.savePatterns(pattern); err != nil {
+		return nil, fmt.Errorf("failed to save patterns: %w", err)
+	}
+
+	return pattern, nil
+}
+
+// unchangedSince reports whether the Go and SQL files of a project are the
+// ones a pattern set was detected from
+func (e *Extractor) unchangedSince(projectPath string, pattern *Pattern) (bool, error) {
+	counts := map[string]string{".go": "go_file_count", ".sql": "sql_file_count"}
+	for extension, key := range counts {
+		files, err := e.findFiles(projectPath, extension)
+		if err != nil {
+			return false, err
+		}
+		if count, _ := pattern.PatternData[key].(float64); int(count) != len(files) {
+			return false, nil
+		}
+		for _, path := range files {
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Unix() > pattern.DetectedAt {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
 }
 
 // ExtractFromFiles extracts patterns from specific files
@@ -130,45 +192,73 @@ func (e *Extractor) findFiles(rootPath, extension string) ([]string, error) {
 	return files, err
 }
 
+// patternType names the languages a pattern set covers
+func patternType(patterns map[string]interface{}) string {
+	_, hasGo := patterns["go"]
+	_, hasSQL := patterns["sql"]
+	switch {
+	case hasGo && hasSQL:
+		return "mixed"
+	case hasGo:
+		return "go"
+	case hasSQL:
+		return "sql"
+	}
+	return "project"
+}
+
 // savePatterns saves detected patterns to database
-func (e *Extractor) savePatterns(sourcePath string, patterns map[string]interface{}) error {
+func (e *Extractor) savePatterns(pattern *Pattern) error {
 	// Serialize patterns
-	_, err := json.Marshal(patterns)
+	patternJSON, err := json.Marshal(pattern.PatternData)
 	if err != nil {
 		return fmt.Errorf("failed to marshal patterns: %w", err)
 	}
 
-	// Determine pattern type
-	_ = "project"
-	if _, hasGo := patterns["go"]; hasGo {
-		if _, hasSQL := patterns["sql"]; hasSQL {
-			_ = "mixed"
-		} else {
-			_ = "go"
-		}
-	} else if _, hasSQL := patterns["sql"]; hasSQL {
-		_ = "sql"
-	}
-
-	// Calculate confidence score (placeholder)
-	_ = 0.8
+	return e.lifecycleDB.SavePatterns(pattern.PatternID, pattern.SourcePath, pattern.PatternType,
+		string(patternJSON), pattern.ConfidenceScore, pattern.DetectedAt)
+}
 
-	// Insert into database
-	_ = uuid.New().String()
+// GetPatterns retrieves patterns for a project from cache
+func (e *Extractor) GetPatterns(projectPath string) (map[string]interface{}, error) {
+	pattern, err := e.GetPattern(projectPath)
+	if err != nil || pattern == nil {
+		return make(map[string]interface{}), err
+	}
+	return pattern.PatternData, nil
+}
 
-	// Use direct SQL since this is a specialized operation
-	_, err = e.lifecycleDB.GetCachedDigest("dummy") // Access underlying DB
-	// This is a workaround - in production, add a SavePattern method to LifecycleDB
+// GetPattern retrieves the pattern set last detected in a project, or nil
+func (e *Extractor) GetPattern(projectPath string) (*Pattern, error) {
+	row, err := e.lifecycleDB.GetLatestPatterns(projectPath)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return mapToPattern(row)
+}
 
-	// For now, just return nil
-	return nil
+// GetPatternByID retrieves a detected pattern set, or nil when it does not exist
+func (e *Extractor) GetPatternByID(patternID string) (*Pattern, error) {
+	row, err := e.lifecycleDB.GetPatterns(patternID)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return mapToPattern(row)
 }
 
-// GetPatterns retrieves patterns for a project from cache
-func (e *Extractor) GetPatterns(projectPath string) (map[string]interface{}, error) {
-	// Query detected_patterns table
-	// For now, return empty map
-	return make(map[string]interface{}), nil
+// mapToPattern converts a detected_patterns row to a Pattern
+func mapToPattern(row map[string]interface{}) (*Pattern, error) {
+	pattern := &Pattern{
+		PatternID:       row["pattern_id"].(string),
+		SourcePath:      row["source_path"].(string),
+		PatternType:     row["pattern_type"].(string),
+		ConfidenceScore: row["confidence_score"].(float64),
+		DetectedAt:      row["detected_at"].(int64),
+	}
+	if err := json.Unmarshal([]byte(row["pattern_json"].(string)), &pattern.PatternData); err != nil {
+		return nil, fmt.Errorf("failed to parse patterns %s: %w", pattern.PatternID, err)
+	}
+	return pattern, nil
 }
 
 // Pattern represents a detected pattern
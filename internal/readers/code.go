@@ -63,7 +63,7 @@ func (h *Hub) ReadCode(params map[string]interface{}) (string, error) {
 	}
 
 	// Generate digest using Cerebras
-	digest, err := h.generateDigest("code", string(analysisJSON))
+	digest, err := h.generateDigest("code", filePath, string(analysisJSON))
 	if err != nil {
 		return "", err
 	}
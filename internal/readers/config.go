@@ -66,7 +66,7 @@ func (h *Hub) ReadConfig(params map[string]interface{}) (string, error) {
 	}
 
 	// Generate digest using Cerebras
-	digest, err := h.generateDigest("config", string(analysisJSON))
+	digest, err := h.generateDigest("config", filePath, string(analysisJSON))
 	if err != nil {
 		return "", err
 	}
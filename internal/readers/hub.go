@@ -76,8 +76,10 @@ func (h *Hub) publishDigest(hash, sourceType, sourcePath, digest string) error {
 }
 
 // generateDigest generates a digest using Cerebras
-func (h *Hub) generateDigest(sourceType, sourceData string) (string, error) {
-	digest, err := h.cerebras.GenerateDigest(sourceType, sourceData)
+func (h *Hub) generateDigest(sourceType, sourcePath, sourceData string) (string, error) {
+	cache := &chunkCache{hub: h, sourcePath: sourcePath}
+
+	digest, err := h.cerebras.GenerateDigestWithCache(sourceType, sourceData, cache)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate digest: %w", err)
 	}
@@ -87,3 +89,29 @@ func (h *Hub) generateDigest(sourceType, sourceData string) (string, error) {
 
 	return digest, nil
 }
+
+// chunkCache stores chunk digests in reader_cache, keyed by chunk content hash
+type chunkCache struct {
+	hub        *Hub
+	sourcePath string
+}
+
+// GetChunkDigest returns a cached chunk digest
+func (c *chunkCache) GetChunkDigest(hash string) (string, bool) {
+	digest, found := c.hub.checkCache(chunkCacheKey(hash))
+	if found {
+		c.hub.outputDB.RecordMetric("reader_chunk_cache_hit", 1.0)
+	}
+	return digest, found
+}
+
+// SetChunkDigest caches a chunk digest
+func (c *chunkCache) SetChunkDigest(hash, sourceType, digest string) error {
+	// Chunk hashes are content-addressed, so they can outlive file-level entries (7 days)
+	return c.hub.lifecycleDB.SetCachedDigest(chunkCacheKey(hash), sourceType+"_chunk", c.sourcePath, digest, 7*24*3600)
+}
+
+// chunkCacheKey namespaces chunk hashes so they never collide with file-level keys
+func chunkCacheKey(hash string) string {
+	return "chunk:" + hash
+}
@@ -46,7 +46,7 @@ func (h *Hub) ReadMarkdown(params map[string]interface{}) (string, error) {
 	}
 
 	// Generate digest using Cerebras
-	digest, err := h.generateDigest("markdown", string(analysisJSON))
+	digest, err := h.generateDigest("markdown", filePath, string(analysisJSON))
 	if err != nil {
 		return "", err
 	}
@@ -111,8 +111,8 @@ func (h *Hub) extractMarkdownSections(lines []string) []map[string]interface{} {
 			title := matches[2]
 
 			sections = append(sections, map[string]interface{}{
-				"level":     level,
-				"title":     title,
+				"level":       level,
+				"title":       title,
 				"line_number": lineNum + 1,
 			})
 		}
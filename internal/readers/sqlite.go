@@ -55,7 +55,7 @@ func (h *Hub) ReadSQLite(params map[string]interface{}) (string, error) {
 	}
 
 	// Generate digest using Cerebras
-	digest, err := h.generateDigest("sqlite", string(analysisJSON))
+	digest, err := h.generateDigest("sqlite", dbPath, string(analysisJSON))
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,391 @@
+package validation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"brainloop/internal/diff"
+)
+
+// pythonCompileTimeout bounds a py_compile run
+const pythonCompileTimeout = 30 * time.Second
+
+// GateOptions describe where code is about to be committed
+type GateOptions struct {
+	Target string // file path for go/python/edit, database path for sql
+	Python string // interpreter for py_compile; "python3" from PATH when empty
+
+	// Staged changes of the same commit, checked as if already applied:
+	// Overlay maps absolute file paths to their staged content, and SQLBefore
+	// holds scripts that run on the target database before the code.
+	Overlay   map[string]string
+	SQLBefore []string
+
+	// SplitFiles splits the code of a 'files' block into its files, keyed by
+	// absolute path; each file is then gated as the language of its extension
+	SplitFiles func(code string) (map[string]string, error)
+}
+
+// Gate checks that code can be committed to its target. Unlike Validate, which
+// scores candidates in isolation, it checks the code in place: Go is
+// type-checked with the other files of the target package and local packages
+// imported from source, SQL runs against a throwaway copy of the target
+// database, and Python is byte-compiled by the interpreter when one is
+// available. Edit patches must apply to the target file, and the patched file
+// is checked as its language. Every check of a gate is required.
+func Gate(code, codeType string, opts GateOptions) Report {
+	nonEmpty := Check{Name: "non_empty", Required: true, Weight: 0}
+	if strings.TrimSpace(code) == "" {
+		nonEmpty.Detail = "code is empty"
+		return newReport(codeType, []Check{nonEmpty})
+	}
+	nonEmpty.Passed, nonEmpty.Score = true, 1
+
+	checks := []Check{nonEmpty}
+	switch codeType {
+	case "go":
+		checks = append(checks, gateGo(code, opts.Target, opts.Overlay)...)
+	case "sql":
+		checks = append(checks, gateSQL(code, opts.Target, opts.SQLBefore))
+	case "python":
+		checks = append(checks, gatePython(code, opts.Python))
+	case "edit":
+		checks = append(checks, gateEdit(code, opts)...)
+	case "files":
+		checks = append(checks, gateFiles(code, opts)...)
+	}
+
+	return newReport(codeType, checks)
+}
+
+// gateFiles gates every file of a multi-file block as its language, with the
+// other files of the block staged. SQL files run in path order on an empty
+// database, each after the ones before it.
+func gateFiles(code string, opts GateOptions) []Check {
+	split := Check{Name: "files", Required: true, Weight: 0}
+	if opts.SplitFiles == nil {
+		split.Detail = "no way to split the block into files"
+		return []Check{split}
+	}
+	files, err := opts.SplitFiles(code)
+	if err != nil {
+		split.Detail = err.Error()
+		return []Check{split}
+	}
+	split.Passed, split.Score = true, 1
+	split.Detail = fmt.Sprintf("%d file(s)", len(files))
+
+	overlay := make(map[string]string, len(opts.Overlay)+len(files))
+	for path, content := range opts.Overlay {
+		overlay[path] = content
+	}
+	paths := make([]string, 0, len(files))
+	for path, content := range files {
+		overlay[path] = content
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	checks := []Check{split}
+	var scripts []string
+	for _, path := range paths {
+		var fileChecks []Check
+		switch TypeForPath(path) {
+		case "go":
+			fileChecks = gateGo(files[path], path, overlay)
+		case "sql":
+			fileChecks = []Check{gateSQL(files[path], "", scripts)}
+			scripts = append(scripts, files[path])
+		case "python":
+			fileChecks = []Check{gatePython(files[path], opts.Python)}
+		}
+		for _, c := range fileChecks {
+			c.Detail = path + ": " + c.Detail
+			checks = append(checks, c)
+		}
+	}
+
+	return checks
+}
+
+// TypeForPath returns the code type of a file from its extension, or "code"
+func TypeForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		return "go"
+	case ".py":
+		return "python"
+	case ".sql":
+		return "sql"
+	}
+	return "code"
+}
+
+// gateEdit applies a patch to the target file and checks the patched content
+// as the target's language. SQL files are not executed: their target is a
+// file, not a database.
+func gateEdit(patch string, opts GateOptions) []Check {
+	applies := Check{Name: "patch_applies", Required: true, Weight: 0.5}
+
+	original, err := readFile(opts.Target, opts.Overlay)
+	if err != nil {
+		applies.Detail = fmt.Sprintf("failed to read %s: %v", opts.Target, err)
+		return []Check{applies}
+	}
+
+	parsed, err := diff.ParsePatch(patch)
+	if err != nil {
+		applies.Detail = err.Error()
+		return []Check{applies}
+	}
+	patched, _, err := diff.Apply(string(original), parsed)
+	if err != nil {
+		applies.Detail = err.Error()
+		return []Check{applies}
+	}
+	applies.Passed, applies.Score = true, 1
+	applies.Detail = fmt.Sprintf("%d hunk(s) applied", len(parsed.Hunks))
+
+	checks := []Check{applies}
+	switch TypeForPath(opts.Target) {
+	case "go":
+		checks = append(checks, gateGo(patched, opts.Target, opts.Overlay)...)
+	case "python":
+		checks = append(checks, gatePython(patched, opts.Python))
+	}
+
+	return checks
+}
+
+// readFile reads a file, or its staged content when the overlay has it
+func readFile(path string, overlay map[string]string) ([]byte, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		if content, ok := overlay[abs]; ok {
+			return []byte(content), nil
+		}
+	}
+	return os.ReadFile(path)
+}
+
+// gateGo parses the code and type-checks it as the target file of its package
+func gateGo(code, target string, overlay map[string]string) []Check {
+	parse := Check{Name: "parse", Required: true, Weight: 0.5}
+	typed := Check{Name: "types", Required: true, Weight: 0.5}
+
+	if target == "" {
+		target = "block.go"
+	}
+	target, _ = filepath.Abs(target)
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, target, code, parser.AllErrors)
+	if err != nil {
+		parse.Detail = err.Error()
+		typed.Detail = "skipped: source does not parse"
+		return []Check{parse, typed}
+	}
+	parse.Passed, parse.Score = true, 1
+
+	// The target replaces its current version among the package files
+	files := append([]*ast.File{file}, packageSiblings(fset, target, file.Name.Name, overlay)...)
+
+	imp := &lenientImporter{
+		base:    importer.Default(),
+		source:  importer.ForCompiler(fset, "source", nil),
+		missing: make(map[string]string),
+	}
+
+	var errs []string
+	conf := types.Config{
+		Importer: imp,
+		Error: func(err error) {
+			if !imp.isMissingMember(err.Error()) {
+				errs = append(errs, err.Error())
+			}
+		},
+	}
+	conf.Check(file.Name.Name, fset, files, nil)
+
+	typed.Passed = len(errs) == 0
+	if typed.Passed {
+		typed.Score = 1
+		if len(imp.missing) > 0 {
+			typed.Detail = "unresolved imports not checked: " + strings.Join(imp.missingPaths(), ", ")
+		}
+	} else {
+		typed.Detail = summarizeErrors(errs, maxReportedErrors)
+	}
+
+	return []Check{parse, typed}
+}
+
+// packageSiblings parses the other non-test files of the target's package,
+// staged files included
+func packageSiblings(fset *token.FileSet, target, pkgName string, overlay map[string]string) []*ast.File {
+	dir := filepath.Dir(target)
+	paths := make(map[string]bool)
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				paths[filepath.Join(dir, entry.Name())] = true
+			}
+		}
+	}
+	for path := range overlay {
+		if filepath.Dir(path) == dir {
+			paths[path] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var files []*ast.File
+	for _, path := range sorted {
+		name := filepath.Base(path)
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || path == target {
+			continue
+		}
+
+		src, err := readFile(path, overlay)
+		if err != nil {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, src, 0)
+		if err != nil || file.Name.Name != pkgName {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	return files
+}
+
+// gateSQL executes the script in a transaction on a copy of the target
+// database, after the scripts staged before it
+func gateSQL(code, target string, before []string) Check {
+	check := Check{Name: "executes_on_copy", Required: true, Weight: 1}
+
+	dir, err := os.MkdirTemp("", "brainloop-gate-")
+	if err != nil {
+		check.Detail = fmt.Sprintf("failed to create scratch directory: %v", err)
+		return check
+	}
+	defer os.RemoveAll(dir)
+
+	scratch := filepath.Join(dir, "copy.db")
+	if _, err := os.Stat(target); err == nil {
+		if err := copyDatabase(target, scratch); err != nil {
+			check.Detail = fmt.Sprintf("failed to copy %s: %v", target, err)
+			return check
+		}
+		check.Detail = "executed on a copy of " + target
+	} else {
+		check.Detail = "target database does not exist yet: executed on an empty database"
+	}
+
+	db, err := sql.Open("sqlite", scratch)
+	if err != nil {
+		check.Detail = fmt.Sprintf("failed to open scratch database: %v", err)
+		return check
+	}
+	defer db.Close()
+
+	for i, script := range before {
+		if _, err := db.Exec(script); err != nil {
+			check.Detail = fmt.Sprintf("staged script %d failed: %v", i+1, err)
+			return check
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		check.Detail = fmt.Sprintf("failed to begin transaction: %v", err)
+		return check
+	}
+	if _, err := tx.Exec(code); err != nil {
+		tx.Rollback()
+		check.Detail = err.Error()
+		return check
+	}
+	if err := tx.Commit(); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	check.Passed, check.Score = true, 1
+	return check
+}
+
+// copyDatabase writes a consistent copy of a SQLite database, WAL included
+func copyDatabase(src, dst string) error {
+	db, err := sql.Open("sqlite", src)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`VACUUM INTO ?`, dst)
+	return err
+}
+
+// gatePython byte-compiles the code with the interpreter's py_compile module
+func gatePython(code, interpreter string) Check {
+	check := Check{Name: "py_compile", Required: true, Weight: 1}
+
+	if interpreter == "" {
+		interpreter = "python3"
+	}
+	path, err := exec.LookPath(interpreter)
+	if err != nil {
+		// Nothing to check with: do not block the commit
+		check.Passed, check.Score = true, 1
+		check.Detail = fmt.Sprintf("skipped: interpreter %s not available", interpreter)
+		return check
+	}
+
+	dir, err := os.MkdirTemp("", "brainloop-gate-")
+	if err != nil {
+		check.Detail = fmt.Sprintf("failed to create scratch directory: %v", err)
+		return check
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "block.py")
+	if err := os.WriteFile(source, []byte(code), 0644); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pythonCompileTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path, "-m", "py_compile", source)
+	cmd.Env = append(os.Environ(), "PYTHONPYCACHEPREFIX="+dir)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		check.Detail = strings.TrimSpace(strings.ReplaceAll(string(output), source, "block.py"))
+		if check.Detail == "" {
+			check.Detail = err.Error()
+		}
+		return check
+	}
+
+	check.Passed, check.Score = true, 1
+	return check
+}
@@ -0,0 +1,173 @@
+package validation
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"sort"
+	"strings"
+)
+
+// maxReportedErrors caps the number of errors listed in a check detail
+const maxReportedErrors = 3
+
+// validateGo parses, type-checks and gofmt-compares Go source
+func validateGo(code string) []Check {
+	parse := Check{Name: "parse", Required: true, Weight: 0.4}
+	typed := Check{Name: "types", Weight: 0.4}
+	gofmt := Check{Name: "gofmt", Weight: 0.2}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "candidate.go", code, parser.AllErrors|parser.ParseComments)
+	if err != nil {
+		parse.Detail = err.Error()
+		typed.Detail = "skipped: source does not parse"
+		gofmt.Detail = "skipped: source does not parse"
+		return []Check{parse, typed, gofmt}
+	}
+	parse.Passed, parse.Score = true, 1
+
+	typed = typeCheck(fset, file)
+	gofmt = gofmtDistance(code)
+
+	return []Check{parse, typed, gofmt}
+}
+
+// typeCheck runs go/types on a single file. Imports that cannot be resolved
+// locally are replaced by empty packages and errors about their members are
+// ignored, so third-party dependencies do not count against the candidate.
+func typeCheck(fset *token.FileSet, file *ast.File) Check {
+	check := Check{Name: "types", Weight: 0.4}
+
+	imp := &lenientImporter{base: importer.Default(), missing: make(map[string]string)}
+
+	var errs []string
+	conf := types.Config{
+		Importer: imp,
+		Error: func(err error) {
+			if !imp.isMissingMember(err.Error()) {
+				errs = append(errs, err.Error())
+			}
+		},
+	}
+	conf.Check(file.Name.Name, fset, []*ast.File{file}, nil)
+
+	// Partial credit decreases with the number of type errors
+	check.Score = 1 / float64(1+len(errs))
+	check.Passed = len(errs) == 0
+	if len(errs) > 0 {
+		check.Detail = summarizeErrors(errs, maxReportedErrors)
+	} else if len(imp.missing) > 0 {
+		check.Detail = "unresolved imports not checked: " + strings.Join(imp.missingPaths(), ", ")
+	}
+
+	return check
+}
+
+// gofmtDistance scores how far the source is from its gofmt output
+func gofmtDistance(code string) Check {
+	check := Check{Name: "gofmt", Weight: 0.2}
+
+	formatted, err := format.Source([]byte(code))
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	original := strings.Split(strings.TrimRight(code, "\n"), "\n")
+	distance := lineDistance(original, strings.Split(strings.TrimRight(string(formatted), "\n"), "\n"))
+
+	check.Passed = distance == 0
+	check.Score = 1 - float64(distance)/float64(2*len(original))
+	if check.Score < 0 {
+		check.Score = 0
+	}
+	if distance > 0 {
+		check.Detail = fmt.Sprintf("%d line(s) differ from gofmt output", distance)
+	}
+
+	return check
+}
+
+// lineDistance returns the number of line insertions and deletions needed to turn a into b
+func lineDistance(a, b []string) int {
+	// Longest common subsequence, keeping a single row of the table
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i-1] == b[j-1]:
+				curr[j] = prev[j-1] + 1
+			case prev[j] >= curr[j-1]:
+				curr[j] = prev[j]
+			default:
+				curr[j] = curr[j-1]
+			}
+		}
+		prev, curr = curr, prev
+	}
+
+	return len(a) + len(b) - 2*prev[len(b)]
+}
+
+// lenientImporter resolves imports with the default importer, then with the
+// optional source importer, and substitutes an empty package for anything it
+// cannot find
+type lenientImporter struct {
+	base    types.Importer
+	source  types.Importer    // resolves local packages relative to the importing file
+	missing map[string]string // import path -> package name
+}
+
+func (i *lenientImporter) Import(importPath string) (*types.Package, error) {
+	return i.ImportFrom(importPath, "", 0)
+}
+
+func (i *lenientImporter) ImportFrom(importPath, dir string, mode types.ImportMode) (*types.Package, error) {
+	if pkg, err := i.base.Import(importPath); err == nil {
+		return pkg, nil
+	}
+	if from, ok := i.source.(types.ImporterFrom); ok && dir != "" {
+		if pkg, err := from.ImportFrom(importPath, dir, mode); err == nil {
+			return pkg, nil
+		}
+	}
+
+	name := path.Base(importPath)
+	if dot := strings.Index(name, "."); dot > 0 {
+		// gopkg.in/yaml.v3 -> yaml
+		name = name[:dot]
+	}
+	name = strings.TrimPrefix(name, "go-")
+
+	i.missing[importPath] = name
+	pkg := types.NewPackage(importPath, name)
+	pkg.MarkComplete()
+	return pkg, nil
+}
+
+// isMissingMember reports whether a type error is about a member of a substituted package
+func (i *lenientImporter) isMissingMember(msg string) bool {
+	for _, name := range i.missing {
+		if strings.Contains(msg, "undefined: "+name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// missingPaths returns the substituted import paths, sorted
+func (i *lenientImporter) missingPaths() []string {
+	paths := make([]string, 0, len(i.missing))
+	for p := range i.missing {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,55 @@
+package validation
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// validateSQL executes a script in a scratch in-memory database. Scripts that
+// depend on tables they do not create themselves fail the execution check.
+func validateSQL(code string) []Check {
+	executes := Check{Name: "executes", Required: true, Weight: 0.6}
+	creates := Check{Name: "creates_objects", Weight: 0.2}
+	rerun := Check{Name: "idempotent", Weight: 0.2}
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		executes.Detail = fmt.Sprintf("failed to open scratch database: %v", err)
+		return []Check{executes, creates, rerun}
+	}
+	defer db.Close()
+
+	// A single connection keeps the in-memory database alive between statements
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(code); err != nil {
+		executes.Detail = err.Error()
+		creates.Detail = "skipped: script does not execute"
+		rerun.Detail = "skipped: script does not execute"
+		return []Check{executes, creates, rerun}
+	}
+	executes.Passed, executes.Score = true, 1
+
+	var objects int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE name NOT LIKE 'sqlite_%'
+	`).Scan(&objects); err != nil {
+		creates.Detail = err.Error()
+	} else if objects == 0 {
+		creates.Detail = "script creates no tables, indexes, views or triggers"
+	} else {
+		creates.Passed, creates.Score = true, 1
+		creates.Detail = fmt.Sprintf("%d schema object(s)", objects)
+	}
+
+	// Running the script twice must not fail (IF NOT EXISTS, OR IGNORE, ...)
+	if _, err := db.Exec(code); err != nil {
+		rerun.Detail = err.Error()
+	} else {
+		rerun.Passed, rerun.Score = true, 1
+	}
+
+	return []Check{executes, creates, rerun}
+}
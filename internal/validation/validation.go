@@ -0,0 +1,120 @@
+package validation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Check is the outcome of a single validation step
+type Check struct {
+	Name     string  `json:"name"`
+	Passed   bool    `json:"passed"`
+	Score    float64 `json:"score"`  // 0..1, partial credit allowed
+	Weight   float64 `json:"weight"` // contribution to the report score
+	Required bool    `json:"required,omitempty"`
+	Detail   string  `json:"detail,omitempty"`
+}
+
+// Report is the local validation result for a piece of generated code
+type Report struct {
+	CodeType string  `json:"code_type"`
+	Valid    bool    `json:"valid"` // every required check passed
+	Score    float64 `json:"score"` // weighted average of check scores, 0..1
+	Checks   []Check `json:"checks"`
+}
+
+// Validate runs the local checks available for codeType. Go code is parsed,
+// type-checked and compared with its gofmt output; SQL is executed in an
+// in-memory SQLite database; other types only get the non-empty check.
+func Validate(code, codeType string) Report {
+	var checks []Check
+
+	nonEmpty := Check{Name: "non_empty", Required: true, Weight: 0}
+	if strings.TrimSpace(code) == "" {
+		nonEmpty.Detail = "generated code is empty"
+		return newReport(codeType, append(checks, nonEmpty))
+	}
+	nonEmpty.Passed, nonEmpty.Score = true, 1
+	checks = append(checks, nonEmpty)
+
+	switch codeType {
+	case "go":
+		checks = append(checks, validateGo(code)...)
+	case "sql":
+		checks = append(checks, validateSQL(code)...)
+	}
+
+	return newReport(codeType, checks)
+}
+
+// Err returns an error describing the failed required checks, or nil if the report is valid
+func (r Report) Err() error {
+	if r.Valid {
+		return nil
+	}
+
+	var failed []string
+	for _, c := range r.Checks {
+		if c.Required && !c.Passed {
+			failed = append(failed, fmt.Sprintf("%s: %s", c.Name, c.Detail))
+		}
+	}
+
+	return fmt.Errorf("validation failed (%s)", strings.Join(failed, "; "))
+}
+
+// Rank returns report indices ordered best first: valid before invalid,
+// then by score, then by original order
+func Rank(reports []Report) []int {
+	order := make([]int, len(reports))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		ra, rb := reports[order[a]], reports[order[b]]
+		if ra.Valid != rb.Valid {
+			return ra.Valid
+		}
+		return ra.Score > rb.Score
+	})
+
+	return order
+}
+
+// newReport computes the validity and weighted score of a set of checks
+func newReport(codeType string, checks []Check) Report {
+	report := Report{CodeType: codeType, Valid: true, Checks: checks}
+
+	var total, weights float64
+	for _, c := range checks {
+		if c.Required && !c.Passed {
+			report.Valid = false
+		}
+		total += c.Score * c.Weight
+		weights += c.Weight
+	}
+
+	switch {
+	case !report.Valid:
+		// Invalid code still gets its partial score so candidates can be compared
+		if weights > 0 {
+			report.Score = total / weights
+		}
+	case weights == 0:
+		report.Score = 1
+	default:
+		report.Score = total / weights
+	}
+
+	return report
+}
+
+// summarizeErrors joins the first few error messages
+func summarizeErrors(errs []string, limit int) string {
+	if len(errs) <= limit {
+		return strings.Join(errs, "; ")
+	}
+	return fmt.Sprintf("%s; ... (%d more)", strings.Join(errs[:limit], "; "), len(errs)-limit)
+}
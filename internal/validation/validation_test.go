@@ -0,0 +1,224 @@
+package validation
+
+import (
+	"database/sql"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateGo(t *testing.T) {
+	good := `package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// Open opens a database
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	return db, nil
+}
+`
+	report := Validate(good, "go")
+	if !report.Valid || report.Score != 1 {
+		t.Fatalf("Expected valid, perfect score, got %+v", report)
+	}
+
+	untyped := `package store
+
+func Count() int {
+	return "three"
+}
+`
+	report = Validate(untyped, "go")
+	if !report.Valid || report.Checks[2].Passed || report.Score >= 1 {
+		t.Errorf("Expected type error to lower score without invalidating, got %+v", report)
+	}
+
+	broken := "package store\n\nfunc Count( {\n"
+	report = Validate(broken, "go")
+	if report.Valid || report.Err() == nil {
+		t.Errorf("Expected parse failure to invalidate, got %+v", report)
+	}
+}
+
+func TestValidateGoIgnoresUnresolvedImports(t *testing.T) {
+	code := `package main
+
+import "example.com/acme/widgets"
+
+func main() {
+	widgets.Run()
+}
+`
+	report := Validate(code, "go")
+	if !report.Checks[2].Passed {
+		t.Errorf("Expected unresolved import members to be ignored, got %+v", report.Checks[2])
+	}
+}
+
+func TestValidateGofmtDistance(t *testing.T) {
+	code := "package main\n\nfunc main() {\nprintln(1)\n}\n"
+	report := Validate(code, "go")
+	gofmt := report.Checks[3]
+	if gofmt.Passed || gofmt.Score <= 0 || gofmt.Score >= 1 {
+		t.Errorf("Expected partial gofmt score, got %+v", gofmt)
+	}
+}
+
+func TestValidateSQL(t *testing.T) {
+	report := Validate("CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY);\nCREATE INDEX IF NOT EXISTS idx_users ON users(id);", "sql")
+	if !report.Valid || report.Score != 1 {
+		t.Fatalf("Expected valid idempotent schema, got %+v", report)
+	}
+
+	report = Validate("CREATE TABLE users (id INTEGER PRIMARY KEY);", "sql")
+	if !report.Valid || report.Checks[3].Passed {
+		t.Errorf("Expected non-idempotent schema to lose points, got %+v", report)
+	}
+
+	report = Validate("CREATE TABLE users (id INTEGER PRIMARY KEY,", "sql")
+	if report.Valid {
+		t.Errorf("Expected syntax error to invalidate, got %+v", report)
+	}
+}
+
+func TestRank(t *testing.T) {
+	reports := []Report{
+		{Valid: false, Score: 0.9},
+		{Valid: true, Score: 0.7},
+		{Valid: true, Score: 0.95},
+		{Valid: true, Score: 0.7},
+	}
+
+	order := Rank(reports)
+	expected := []int{2, 1, 3, 0}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Expected order %v, got %v", expected, order)
+		}
+	}
+}
+
+func TestGateGoChecksAgainstPackage(t *testing.T) {
+	dir := t.TempDir()
+	sibling := "package store\n\nconst tableName = \"users\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "names.go"), []byte(sibling), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "store.go")
+
+	report := Gate("package store\n\nfunc Table() string { return tableName }\n", "go", GateOptions{Target: target})
+	if !report.Valid {
+		t.Fatalf("Expected sibling declarations to resolve, got %+v", report)
+	}
+
+	report = Gate("package store\n\nfunc Table() int { return tableName }\n", "go", GateOptions{Target: target})
+	if report.Valid || report.Err() == nil {
+		t.Errorf("Expected type errors to fail the gate, got %+v", report)
+	}
+}
+
+func TestGateWithStagedChanges(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "store.go")
+	overlay := map[string]string{filepath.Join(dir, "names.go"): "package store\n\nconst tableName = \"users\"\n"}
+
+	code := "package store\n\nfunc Table() string { return tableName }\n"
+	if report := Gate(code, "go", GateOptions{Target: target}); report.Valid {
+		t.Fatal("Expected the unstaged sibling to be missing")
+	}
+	if report := Gate(code, "go", GateOptions{Target: target, Overlay: overlay}); !report.Valid {
+		t.Errorf("Expected the staged sibling to resolve, got %+v", report)
+	}
+
+	db := filepath.Join(dir, "app.db")
+	staged := []string{"CREATE TABLE users (id INTEGER PRIMARY KEY);"}
+	if report := Gate("INSERT INTO users (id) VALUES (1);", "sql", GateOptions{Target: db, SQLBefore: staged}); !report.Valid {
+		t.Errorf("Expected the staged table to exist, got %+v", report)
+	}
+	if _, err := os.Stat(db); !os.IsNotExist(err) {
+		t.Errorf("Expected the target database untouched, got %v", err)
+	}
+}
+
+func TestGateFilesChecksEachFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		filepath.Join(dir, "store", "names.go"):     "package store\n\nconst tableName = \"users\"\n",
+		filepath.Join(dir, "store", "store.go"):     "package store\n\nfunc Table() string { return tableName }\n",
+		filepath.Join(dir, "migrations", "001.sql"): "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		filepath.Join(dir, "migrations", "002.sql"): "INSERT INTO users (id) VALUES (1);",
+		filepath.Join(dir, "README.md"):             "# Store",
+	}
+	split := func(string) (map[string]string, error) { return files, nil }
+
+	if report := Gate("files", "files", GateOptions{Target: dir, SplitFiles: split}); !report.Valid {
+		t.Fatalf("Expected every file to pass with the others staged, got %+v", report)
+	}
+
+	files[filepath.Join(dir, "store", "store.go")] = "package store\n\nfunc Table() int { return tableName }\n"
+	report := Gate("files", "files", GateOptions{Target: dir, SplitFiles: split})
+	if report.Valid || !strings.Contains(report.Err().Error(), "store.go") {
+		t.Errorf("Expected the type error of store.go to fail the gate, got %+v", report)
+	}
+
+	if report := Gate("files", "files", GateOptions{Target: dir}); report.Valid {
+		t.Error("Expected a files block that cannot be split to fail the gate")
+	}
+}
+
+func TestGateSQLRunsOnCopy(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Depends on a table only the target database has
+	report := Gate("ALTER TABLE users ADD COLUMN email TEXT;", "sql", GateOptions{Target: target})
+	if !report.Valid {
+		t.Fatalf("Expected the script to run on the copy, got %+v", report)
+	}
+
+	db, _ = sql.Open("sqlite", target)
+	defer db.Close()
+	var columns int
+	db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users')`).Scan(&columns)
+	if columns != 1 {
+		t.Errorf("Expected the target database to be left untouched, got %d columns", columns)
+	}
+
+	if report := Gate("INSERT INTO missing VALUES (1);", "sql", GateOptions{Target: target}); report.Valid {
+		t.Errorf("Expected a failing script to fail the gate, got %+v", report)
+	}
+}
+
+func TestGatePython(t *testing.T) {
+	if report := Gate("print('ok')\n", "python", GateOptions{Python: "no-such-python"}); !report.Valid {
+		t.Errorf("Expected a missing interpreter to skip the check, got %+v", report)
+	}
+
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available")
+	}
+	if report := Gate("def broken(:\n", "python", GateOptions{}); report.Valid {
+		t.Errorf("Expected a syntax error to fail the gate, got %+v", report)
+	}
+	if report := Gate("print('ok')\n", "python", GateOptions{}); !report.Valid {
+		t.Errorf("Expected valid Python to pass, got %+v", report)
+	}
+}
@@ -0,0 +1,196 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File represents a file to be written under a workspace root
+type File struct {
+	Path     string // relative to the workspace root
+	Language string
+	Content  string
+}
+
+// ManifestEntry describes a file written to the workspace
+type ManifestEntry struct {
+	Path     string `json:"path"`
+	Language string `json:"language,omitempty"`
+	Bytes    int    `json:"bytes"`
+	Lines    int    `json:"lines"`
+	SHA256   string `json:"sha256"`
+}
+
+// Resolve joins a relative path to root and ensures it stays under root, also
+// once the symlinks of its existing part are followed
+func Resolve(root, rel string) (string, error) {
+	if rel == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if filepath.IsAbs(rel) {
+		return "", fmt.Errorf("path %s must be relative to the workspace root", rel)
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("invalid workspace root %s: %w", root, err)
+	}
+
+	full := filepath.Join(absRoot, filepath.Clean(rel))
+	if !within(full, absRoot) {
+		return "", fmt.Errorf("path %s escapes workspace root %s", rel, root)
+	}
+
+	// A symlink under root must not lead outside it
+	realRoot, err := realPath(absRoot)
+	if err != nil {
+		return "", fmt.Errorf("invalid workspace root %s: %w", root, err)
+	}
+	realFull, err := realPath(full)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", rel, err)
+	}
+	if !within(realFull, realRoot) {
+		return "", fmt.Errorf("path %s escapes workspace root %s through a symlink", rel, root)
+	}
+
+	return full, nil
+}
+
+// within reports whether path is root or under it
+func within(path, root string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// realPath follows the symlinks of the deepest existing ancestor of path,
+// path itself included, and appends the components that do not exist yet
+func realPath(path string) (string, error) {
+	existing, rest := path, ""
+	for {
+		_, err := os.Lstat(existing)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path, nil
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, rest), nil
+}
+
+// WriteFileAtomic writes data to a temp file in the target directory, then renames it
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath, err := StageFile(path, data, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename temp file to %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// StageFile writes data to a synced temp file next to path and returns its
+// name; renaming it to path completes the write
+func StageFile(path string, data []byte, perm os.FileMode) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to chmod temp file: %w", err)
+	}
+
+	return tmpPath, nil
+}
+
+// WriteFiles validates every path, then writes each file atomically under root.
+// No file is written if any path is invalid or duplicated; if a write fails,
+// the manifest of the files already written is returned with the error.
+func WriteFiles(root string, files []File) ([]ManifestEntry, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files to write")
+	}
+
+	resolved := make([]string, len(files))
+	seen := make(map[string]bool)
+	for i, f := range files {
+		full, err := Resolve(root, f.Path)
+		if err != nil {
+			return nil, err
+		}
+		if seen[full] {
+			return nil, fmt.Errorf("duplicate file path: %s", f.Path)
+		}
+		seen[full] = true
+		resolved[i] = full
+	}
+
+	manifest := make([]ManifestEntry, 0, len(files))
+	for i, f := range files {
+		if err := WriteFileAtomic(resolved[i], []byte(f.Content), 0644); err != nil {
+			return manifest, err
+		}
+		manifest = append(manifest, NewManifestEntry(f))
+	}
+
+	return manifest, nil
+}
+
+// NewManifestEntry builds the manifest entry for a file
+func NewManifestEntry(f File) ManifestEntry {
+	return ManifestEntry{
+		Path:     filepath.ToSlash(filepath.Clean(f.Path)),
+		Language: f.Language,
+		Bytes:    len(f.Content),
+		Lines:    len(strings.Split(f.Content, "\n")),
+		SHA256:   HashContent(f.Content),
+	}
+}
+
+// HashContent returns the hex SHA256 of content
+func HashContent(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
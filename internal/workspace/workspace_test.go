@@ -0,0 +1,50 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveRejectsSymlinkEscapes(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	os.MkdirAll(filepath.Join(root, "pkg"), 0755)
+	if err := os.Symlink(filepath.Join(root, "pkg"), filepath.Join(root, "inner")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rel := range []string{"link/secret.go", "link/new/dir/file.go", "link"} {
+		if _, err := Resolve(root, rel); err == nil || !strings.Contains(err.Error(), "symlink") {
+			t.Errorf("Expected %s to be refused, got %v", rel, err)
+		}
+	}
+	for _, rel := range []string{"inner/store.go", "pkg/store.go", "new/file.go"} {
+		if _, err := Resolve(root, rel); err != nil {
+			t.Errorf("Expected %s under the root, got %v", rel, err)
+		}
+	}
+}
+
+func TestWriteFilesReturnsPartialManifest(t *testing.T) {
+	root := t.TempDir()
+
+	// A directory where the second file should go makes its write fail
+	if err := os.MkdirAll(filepath.Join(root, "b.go"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := WriteFiles(root, []File{
+		{Path: "a.go", Content: "package a\n"},
+		{Path: "b.go", Content: "package b\n"},
+	})
+	if err == nil {
+		t.Fatal("Expected the second write to fail")
+	}
+	if len(manifest) != 1 || manifest[0].Path != "a.go" {
+		t.Errorf("Expected the manifest of the file already written, got %+v", manifest)
+	}
+}
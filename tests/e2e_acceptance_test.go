@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestE2ELoopAcceptanceTestOffline runs acceptance tests against candidates and feeds failures to refine
+func TestE2ELoopAcceptanceTestOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t,
+		"package calc\n\nfunc Add(a, b int) int { return a - b }",
+		"VERSION = '1'",
+		"package calc\n\nfunc Add(a, b int) int { return a + b }",
+	)
+	if err := os.WriteFile(filepath.Join(env.dir, "go.mod"), []byte("module demo\n\ngo 1.21\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(env.dir, "calc", "calc.go")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"id": "calc", "description": "Add two ints", "type": "go", "target": target,
+				"test": map[string]interface{}{
+					"kind": "go",
+					"code": "import \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\tif Add(2, 3) != 5 {\n\t\tt.Fatal(\"Add(2, 3) != 5\")\n\t}\n}\n",
+				},
+			},
+			map[string]interface{}{
+				"id": "version", "description": "Version", "type": "python", "target": filepath.Join(env.dir, "version.py"),
+				"depends_on": []interface{}{"calc"},
+				"test":       map[string]interface{}{"kind": "shell", "code": "grep -q VERSION version.py && test -f calc/calc.go"},
+			},
+		},
+	})
+	sessionID := env.sessionOf(t, "calc")
+
+	testStatus := func(blockID string) TestResultRow {
+		var raw string
+		env.lifecycleDB.QueryRow(`SELECT test_result_json FROM session_blocks WHERE block_id = ?`, blockID).Scan(&raw)
+		var row TestResultRow
+		json.Unmarshal([]byte(raw), &row)
+		return row
+	}
+
+	if result := testStatus("calc"); result.Status != "failed" || !strings.Contains(result.Output, "Add(2, 3) != 5") {
+		t.Fatalf("Expected the go test to fail on the proposed code, got %+v", result)
+	}
+	// The shell check sees the uncommitted dependency in the scratch workspace
+	if result := testStatus("version"); result.Status != "passed" {
+		t.Errorf("Expected the shell check to pass, got %+v", result)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Expected the candidate written only to the scratch workspace, got %v", err)
+	}
+
+	// The failure flows into the next refine
+	env.call(t, "loop", map[string]interface{}{"mode": "refine", "session_id": sessionID, "block_id": "calc", "audit_feedback": "fix it"})
+	requests := standIn.Requests()
+	refine := requests[len(requests)-1].Request.Messages
+	if last := refine[len(refine)-1].Content; !strings.Contains(last, "fix it") || !strings.Contains(last, "Add(2, 3) != 5") {
+		t.Errorf("Expected the test output in the refine feedback, got %q", last)
+	}
+	if result := testStatus("calc"); result.Status != "passed" || result.CodeHash != env.blockCodeHash(t, "calc") {
+		t.Errorf("Expected the refined code to pass, got %+v", result)
+	}
+}
+
+// TestResultRow is the stored acceptance test result of a block
+type TestResultRow struct {
+	Status   string `json:"status"`
+	Output   string `json:"output"`
+	CodeHash string `json:"code_hash"`
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestE2ELoopAutoOffline runs audit-refine rounds until the audit finds nothing to fix
+func TestE2ELoopAutoOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t,
+		"package main\n\nfunc main() {\n\tx := 1\n}",
+		"Unused variable.\n\n```json\n[{\"severity\": \"high\", \"issue\": \"x is never used\", \"fix\": \"remove it\"}]\n```",
+		"package main\n\nfunc main() {}",
+		"Nothing left to fix.\n\n```json\n[]\n```",
+	)
+	target := filepath.Join(env.dir, "main.go")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "main", "description": "Empty main", "type": "go", "target": target}},
+	})
+	sessionID := env.sessionOf(t, "main")
+
+	text := env.call(t, "loop", map[string]interface{}{"mode": "auto", "session_id": sessionID})
+	if !strings.Contains(text, "1 of 1 block(s) converged in 2 round(s)") {
+		t.Errorf("Expected convergence after one refine, got %s", text)
+	}
+
+	// The refine got both the audit findings and the gate errors
+	requests := standIn.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("Expected propose, audit, refine and audit requests, got %d", len(requests))
+	}
+	refine := requests[2].Request.Messages[len(requests[2].Request.Messages)-1].Content
+	if !strings.Contains(refine, "x is never used (fix: remove it)") || !strings.Contains(refine, "declared and not used") {
+		t.Errorf("Expected findings and gate errors as feedback, got %q", refine)
+	}
+
+	// Commit still needs explicit approval
+	var status string
+	var refinements int
+	env.lifecycleDB.QueryRow(`SELECT status FROM session_blocks WHERE block_id = 'main'`).Scan(&status)
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM block_refinements WHERE block_id = 'main'`).Scan(&refinements)
+	if status == "committed" || refinements != 1 {
+		t.Errorf("Expected one refine and no commit, got status %s after %d refinement(s)", status, refinements)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written, got %v", err)
+	}
+}
+
+// TestE2ELoopAutoTokenBudgetOffline stops the auto loop once its token budget is spent
+func TestE2ELoopAutoTokenBudgetOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t,
+		"package main\n\nfunc main() {}",
+		"```json\n[{\"severity\": \"critical\", \"issue\": \"no tests\"}]\n```",
+	)
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "main", "description": "Empty main", "type": "go", "target": filepath.Join(env.dir, "main.go")}},
+	})
+	sessionID := env.sessionOf(t, "main")
+
+	text := env.call(t, "loop", map[string]interface{}{"mode": "auto", "session_id": sessionID, "token_budget": 1})
+	if !strings.Contains(text, "token_budget") || !strings.Contains(text, "0 of 1 block(s) converged") {
+		t.Errorf("Expected the run stopped by the budget, got %s", text)
+	}
+	if n := len(standIn.Requests()); n != 2 {
+		t.Errorf("Expected no refine once the budget is spent, got %d requests", n)
+	}
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"brainloop/internal/loop"
+)
+
+// TestE2ELoopExportImportOffline moves a session to another worker through a bundle
+func TestE2ELoopExportImportOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t, "A = 1", "B = 1", "A = 2")
+	block := func(id string, dependsOn ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"id": id, "description": id, "type": "code", "target": filepath.Join(env.dir, id+".txt"), "depends_on": dependsOn}
+	}
+
+	env.call(t, "loop", map[string]interface{}{"mode": "propose", "blocks": []interface{}{block("a"), block("b", "a")}})
+	var sessionID string
+	env.lifecycleDB.QueryRow(`SELECT session_id FROM sessions`).Scan(&sessionID)
+	env.call(t, "loop", map[string]interface{}{"mode": "refine", "session_id": sessionID, "block_id": "a", "audit_feedback": "Use 2"})
+	env.call(t, "loop", map[string]interface{}{"mode": "commit", "session_id": sessionID, "block_id": "a", "code_hash": env.blockCodeHash(t, "a")})
+
+	// Without a path the json bundle itself is returned
+	inline := env.call(t, "loop", map[string]interface{}{"mode": "export", "session_id": sessionID})
+	var bundle loop.SessionBundle
+	if err := json.Unmarshal([]byte(inline), &bundle); err != nil {
+		t.Fatalf("Expected an inline json bundle, got %v: %s", err, inline)
+	}
+	if bundle.Format != "brainloop-session" || bundle.Version != 1 || len(bundle.Blocks) != 2 || len(bundle.Usage) != 3 {
+		t.Errorf("Expected a versioned bundle with 2 blocks and 3 usage records, got %+v", bundle)
+	}
+
+	path := filepath.Join(env.dir, "bundles", "session.tar.gz")
+	env.call(t, "loop", map[string]interface{}{"mode": "export", "session_id": sessionID, "path": path})
+
+	// The session is still on this worker: importing it needs on_conflict copy
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "import", "path": path}); err == nil || !strings.Contains(err.Error(), "on_conflict copy") {
+		t.Errorf("Expected the import of a session already here to be refused, got %v", err)
+	}
+	text := env.call(t, "loop", map[string]interface{}{"mode": "import", "path": path, "on_conflict": "copy"})
+	if !strings.Contains(text, "2 block(s), 1 refinement(s), 3 usage record(s)") {
+		t.Errorf("Expected the whole session imported, got %s", text)
+	}
+
+	var importedID string
+	env.lifecycleDB.QueryRow(`SELECT session_id FROM sessions WHERE imported_from = ?`, sessionID).Scan(&importedID)
+	if importedID == "" || importedID == sessionID {
+		t.Fatalf("Expected a new session imported from %s, got %q", sessionID, importedID)
+	}
+
+	rows, err := env.lifecycleDB.Query(`SELECT block_id, status, code FROM session_blocks WHERE session_id = ? ORDER BY generated_at, rowid`, importedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := make(map[string][2]string)
+	for rows.Next() {
+		var id, status, code string
+		rows.Scan(&id, &status, &code)
+		imported[id] = [2]string{status, code}
+	}
+	rows.Close()
+	var newA, newB string
+	for id, state := range imported {
+		switch {
+		case strings.HasPrefix(id, "a-"):
+			newA = id
+			if state != [2]string{"pending", "A = 2"} {
+				t.Errorf("Expected the committed block back to pending with its code, got %v", state)
+			}
+		case strings.HasPrefix(id, "b-"):
+			newB = id
+		}
+	}
+	if newA == "" || newB == "" {
+		t.Fatalf("Expected the taken block IDs renamed, got %v", imported)
+	}
+
+	var dependsOn, feedback string
+	var usage int
+	env.lifecycleDB.QueryRow(`SELECT depends_on FROM block_dependencies WHERE block_id = ?`, newB).Scan(&dependsOn)
+	env.lifecycleDB.QueryRow(`SELECT feedback FROM block_refinements WHERE block_id = ?`, newA).Scan(&feedback)
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM cerebras_usage WHERE block_id IN (?, ?)`, newA, newB).Scan(&usage)
+	if dependsOn != newA || feedback != "Use 2" || usage != 3 {
+		t.Errorf("Expected dependencies, refinements and usage on the new IDs, got %q %q %d", dependsOn, feedback, usage)
+	}
+
+	// The imported session is replayed on this worker
+	os.Remove(filepath.Join(env.dir, "a.txt"))
+	env.call(t, "loop", map[string]interface{}{"mode": "commit", "session_id": importedID, "block_id": newA, "code_hash": env.blockCodeHash(t, newA)})
+	if data, _ := os.ReadFile(filepath.Join(env.dir, "a.txt")); string(data) != "A = 2" {
+		t.Errorf("Expected the imported block committed again, got %q", data)
+	}
+
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "import", "bundle": `{"format": "brainloop-session", "version": 2, "session": {"session_id": "x"}}`}); err == nil || !strings.Contains(err.Error(), "unsupported bundle version") {
+		t.Errorf("Expected a newer bundle version to be refused, got %v", err)
+	}
+}
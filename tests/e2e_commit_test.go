@@ -0,0 +1,310 @@
+package tests
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"brainloop/internal/workspace"
+)
+
+// TestE2ELoopRegenerateOffline requires confirming a regenerated commit by its hash
+func TestE2ELoopRegenerateOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"package main\n\nfunc main() {}",
+		"package main\n\nfunc main() {\n\tprintln(\"final\")\n}",
+	)
+	target := filepath.Join(env.dir, "main.go")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{map[string]interface{}{
+			"id": "main", "description": "Main program", "type": "go", "target": target,
+		}},
+	})
+
+	sessionID := env.sessionOf(t, "main")
+	reviewedHash := env.blockCodeHash(t, "main")
+
+	// A stale hash is rejected
+	if _, err := env.tryCall("loop", map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "main", "code_hash": "stale",
+	}); err == nil {
+		t.Fatal("Expected commit with a stale code_hash to fail")
+	}
+
+	text := env.call(t, "loop", map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "main",
+		"code_hash": reviewedHash, "regenerate": true,
+	})
+	if !strings.Contains(text, `+\tprintln(\"final\")`) && !strings.Contains(text, "+\tprintln(\"final\")") {
+		t.Errorf("Expected the regeneration diff in the response, got %s", text)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing written before confirmation, got %v", err)
+	}
+
+	var pending string
+	env.lifecycleDB.QueryRow(`SELECT pending_code FROM session_blocks WHERE block_id = 'main'`).Scan(&pending)
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "main",
+		"code_hash": workspace.HashContent(pending),
+	})
+
+	data, err := os.ReadFile(target)
+	if err != nil || !strings.Contains(string(data), "final") {
+		t.Errorf("Expected confirmed regenerated code to be written, got %q (%v)", data, err)
+	}
+
+	// The written code is the block's latest iteration
+	var iterations, refinements int
+	env.lifecycleDB.QueryRow(`SELECT iterations FROM session_blocks WHERE block_id = 'main'`).Scan(&iterations)
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM block_refinements WHERE block_id = 'main' AND refined_code = ?`, pending).Scan(&refinements)
+	if iterations != 2 || refinements != 1 {
+		t.Errorf("Expected the regenerated code recorded as the second iteration, got %d iteration(s) and %d refinement(s)", iterations, refinements)
+	}
+	report := env.call(t, "loop", map[string]interface{}{"mode": "report", "session_id": sessionID})
+	if !strings.Contains(report, "### Iteration 1: refined at temperature 0.1, +3 -1 (current)") || !strings.Contains(report, "> Regenerated at commit") {
+		t.Errorf("Expected the regenerated iteration to be current in the report, got %s", report)
+	}
+}
+
+// TestE2ELoopValidationGateOffline blocks a commit that fails validation and refines it automatically
+func TestE2ELoopValidationGateOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t,
+		"INSERT INTO users (email) VALUES ('a@example.com');",
+		"CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY, email TEXT);\nINSERT INTO users (email) VALUES ('a@example.com');",
+	)
+	targetDB := filepath.Join(env.dir, "app.db")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{map[string]interface{}{
+			"id": "seed", "description": "Seed users", "type": "sql", "target": targetDB,
+		}},
+	})
+
+	sessionID := env.sessionOf(t, "seed")
+
+	text := env.call(t, "loop", map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "seed",
+		"code_hash": env.blockCodeHash(t, "seed"), "auto_refine": true,
+	})
+	if !strings.Contains(text, "validation gate") || !strings.Contains(text, "no such table: users") {
+		t.Errorf("Expected the gate failure in the response, got %s", text)
+	}
+	if _, err := os.Stat(targetDB); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing executed against the target, got %v", err)
+	}
+
+	// The errors were sent back as refine feedback
+	requests := standIn.Requests()
+	if len(requests) != 2 || !strings.Contains(requests[1].Request.Messages[len(requests[1].Request.Messages)-1].Content, "no such table: users") {
+		t.Fatalf("Expected an automatic refine with the gate errors, got %+v", requests)
+	}
+
+	var validationJSON string
+	env.lifecycleDB.QueryRow(`SELECT validation_json FROM session_blocks WHERE block_id = 'seed'`).Scan(&validationJSON)
+	if !strings.Contains(validationJSON, `"valid":false`) {
+		t.Errorf("Expected the failed report stored on the block, got %s", validationJSON)
+	}
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "seed", "code_hash": env.blockCodeHash(t, "seed"),
+	})
+
+	target, err := sql.Open("sqlite", targetDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	var count int
+	if err := target.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("Expected the refined script committed, got %d rows (%v)", count, err)
+	}
+}
+
+// TestE2ELoopCommitSessionOffline commits every block of a session at once
+func TestE2ELoopCommitSessionOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);",
+		"package main\n\nfunc greeting() string { return \"hi\" }",
+		"INSERT INTO users (id, name) VALUES (1, 'ada');",
+		"package main\n\nfunc main() { println(greeting()) }",
+	)
+	targetDB := filepath.Join(env.dir, "app.db")
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "schema", "description": "Users", "type": "sql", "target": targetDB},
+			map[string]interface{}{"id": "greeting", "description": "Greeting", "type": "go", "target": filepath.Join(env.dir, "greeting.go"), "depends_on": []interface{}{"schema"}},
+			map[string]interface{}{"id": "seed", "description": "Seed", "type": "sql", "target": targetDB, "depends_on": []interface{}{"schema", "greeting"}},
+			map[string]interface{}{"id": "main", "description": "Main", "type": "go", "target": filepath.Join(env.dir, "main.go"), "depends_on": []interface{}{"greeting", "seed"}},
+		},
+	})
+	sessionID := env.sessionOf(t, "main")
+
+	hashes := map[string]interface{}{}
+	for _, blockID := range []string{"schema", "greeting", "seed"} {
+		hashes[blockID] = env.blockCodeHash(t, blockID)
+	}
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "commit_session", "session_id": sessionID, "code_hashes": hashes}); err == nil {
+		t.Fatal("Expected a missing code_hash to be rejected")
+	}
+
+	// Each block is gated against the staged blocks before it
+	hashes["main"] = env.blockCodeHash(t, "main")
+	text := env.call(t, "loop", map[string]interface{}{"mode": "commit_session", "session_id": sessionID, "code_hashes": hashes})
+	if !strings.Contains(text, "Committed 4 block(s): 2 file(s) written, 1 database(s) updated") {
+		t.Fatalf("Expected every block committed, got %s", text)
+	}
+
+	db, err := sql.Open("sqlite", targetDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var name string
+	if err := db.QueryRow(`SELECT name FROM users WHERE id = 1`).Scan(&name); err != nil || name != "ada" {
+		t.Errorf("Expected both SQL blocks applied, got %q (%v)", name, err)
+	}
+
+	var status string
+	var completedAt sql.NullInt64
+	env.lifecycleDB.QueryRow(`SELECT status, completed_at FROM sessions WHERE session_id = ?`, sessionID).Scan(&status, &completedAt)
+	if status != "committed" || !completedAt.Valid {
+		t.Errorf("Expected the session committed with completed_at, got %s (%v)", status, completedAt)
+	}
+	var published int
+	env.outputDB.QueryRow(`SELECT blocks_committed FROM results WHERE session_id = ?`, sessionID).Scan(&published)
+	if published != 4 {
+		t.Errorf("Expected the session published with 4 blocks, got %d", published)
+	}
+}
+
+// TestE2ELoopCommitSessionGateFailureOffline writes nothing when one block of the session fails the gate
+func TestE2ELoopCommitSessionGateFailureOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"package main\n\nfunc main() {}",
+		"INSERT INTO missing (id) VALUES (1);",
+	)
+	targetGo := filepath.Join(env.dir, "main.go")
+	targetDB := filepath.Join(env.dir, "app.db")
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "main", "description": "Main", "type": "go", "target": targetGo},
+			map[string]interface{}{"id": "seed", "description": "Seed", "type": "sql", "target": targetDB, "depends_on": []interface{}{"main"}},
+		},
+	})
+	sessionID := env.sessionOf(t, "main")
+
+	text := env.call(t, "loop", map[string]interface{}{
+		"mode": "commit_session", "session_id": sessionID,
+		"code_hashes": map[string]interface{}{"main": env.blockCodeHash(t, "main"), "seed": env.blockCodeHash(t, "seed")},
+	})
+	if !strings.Contains(text, "blocked by the validation gate (seed)") {
+		t.Errorf("Expected the SQL block to fail the gate, got %s", text)
+	}
+
+	for _, path := range []string{targetGo, targetDB} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not written, got %v", path, err)
+		}
+	}
+	var committed int
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM session_blocks WHERE session_id = ? AND status = 'committed'`, sessionID).Scan(&committed)
+	if committed != 0 {
+		t.Errorf("Expected no block committed, got %d", committed)
+	}
+}
+
+// TestE2ELoopCommitTwiceOffline refuses to commit a block again before writing anything
+func TestE2ELoopCommitTwiceOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"package main\n\nfunc a() {}",
+		"package main\n\nfunc b() {}",
+	)
+	target := filepath.Join(env.dir, "a.go")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "a", "description": "A", "type": "go", "target": target},
+			map[string]interface{}{"id": "b", "description": "B", "type": "go", "target": filepath.Join(env.dir, "b.go")},
+		},
+	})
+	commit := map[string]interface{}{
+		"mode": "commit", "session_id": env.sessionOf(t, "a"), "block_id": "a", "code_hash": env.blockCodeHash(t, "a"),
+	}
+	env.call(t, "loop", commit)
+
+	// Edited on disk since: a second commit must not overwrite it
+	os.WriteFile(target, []byte("package main\n\nfunc edited() {}"), 0644)
+	if _, err := env.tryCall("loop", commit); err == nil || !strings.Contains(err.Error(), "already committed") {
+		t.Fatalf("Expected the second commit to be refused, got %v", err)
+	}
+
+	data, _ := os.ReadFile(target)
+	var backups int
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM commit_backups WHERE block_id = 'a'`).Scan(&backups)
+	if string(data) != "package main\n\nfunc edited() {}" || backups != 1 {
+		t.Errorf("Expected nothing backed up or written again, got %d backup(s) and %q", backups, data)
+	}
+}
+
+// TestE2ELoopCommitSessionBookkeepingFailureOffline restores every target when
+// the blocks cannot be recorded as committed after the writes
+func TestE2ELoopCommitSessionBookkeepingFailureOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"package main\n\nfunc a() {}",
+		"package main\n\nfunc b() {}",
+	)
+	targetA := filepath.Join(env.dir, "a.go")
+	targetB := filepath.Join(env.dir, "b.go")
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "a", "description": "A", "type": "go", "target": targetA},
+			map[string]interface{}{"id": "b", "description": "B", "type": "go", "target": targetB, "depends_on": []interface{}{"a"}},
+		},
+	})
+	sessionID := env.sessionOf(t, "a")
+
+	// The second block cannot be marked committed
+	if _, err := env.lifecycleDB.Exec(`
+		CREATE TRIGGER refuse_commit BEFORE UPDATE OF status ON session_blocks
+		WHEN NEW.block_id = 'b' AND NEW.status = 'committed'
+		BEGIN SELECT RAISE(ABORT, 'refused'); END
+	`); err != nil {
+		t.Fatal(err)
+	}
+	request := map[string]interface{}{
+		"mode": "commit_session", "session_id": sessionID,
+		"code_hashes": map[string]interface{}{"a": env.blockCodeHash(t, "a"), "b": env.blockCodeHash(t, "b")},
+	}
+	if _, err := env.tryCall("loop", request); err == nil || !strings.Contains(err.Error(), "every target was restored") {
+		t.Fatalf("Expected the session commit to be undone, got %v", err)
+	}
+
+	for _, path := range []string{targetA, targetB} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s removed, got %v", path, err)
+		}
+	}
+	var committed, processed int
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM session_blocks WHERE session_id = ? AND status = 'committed'`, sessionID).Scan(&committed)
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM processed_log`).Scan(&processed)
+	if committed != 0 || processed != 0 {
+		t.Errorf("Expected no block recorded as committed, got %d committed and %d processed", committed, processed)
+	}
+
+	// Nothing was recorded, so the session can be committed once the cause is gone
+	env.lifecycleDB.Exec(`DROP TRIGGER refuse_commit`)
+	if text := env.call(t, "loop", request); !strings.Contains(text, "Committed 2 block(s)") {
+		t.Errorf("Expected the session committed on retry, got %s", text)
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"brainloop/internal/gitops"
+)
+
+// TestE2ELoopGitOffline records committed blocks on the session branch
+func TestE2ELoopGitOffline(t *testing.T) {
+	if !gitops.Available() {
+		t.Skip("git not installed")
+	}
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "brainloop")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "brainloop@localhost")
+	}
+
+	env, _ := newScriptedEnv(t, "VERSION = 1", "VERSION = 2")
+	repo := filepath.Join(env.dir, "repo")
+	target := filepath.Join(repo, "version.txt")
+	os.MkdirAll(repo, 0755)
+	os.WriteFile(target, []byte("VERSION = 0\n"), 0644)
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", "-b", "main")
+	git("add", "version.txt")
+	git("commit", "-q", "-m", "initial")
+	env.lifecycleDB.Exec(`INSERT OR REPLACE INTO config (key, value) VALUES ('git_commits', 'true')`)
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "version", "description": "Bump the version", "type": "code", "target": target}},
+	})
+	var sessionID string
+	env.lifecycleDB.QueryRow(`SELECT session_id FROM sessions`).Scan(&sessionID)
+	env.call(t, "loop", map[string]interface{}{"mode": "refine", "session_id": sessionID, "block_id": "version", "audit_feedback": "Use version 2"})
+
+	// The pending diff is against HEAD and writes nothing
+	text := env.call(t, "loop", map[string]interface{}{"mode": "git_diff", "session_id": sessionID})
+	if !strings.Contains(text, "-VERSION = 0") || !strings.Contains(text, "+VERSION = 2") || !strings.Contains(text, "a/version.txt") {
+		t.Errorf("Expected the pending diff against HEAD, got %s", text)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "VERSION = 0\n" {
+		t.Errorf("Expected git_diff to write nothing, got %q", data)
+	}
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":       "commit",
+		"session_id": sessionID,
+		"block_id":   "version",
+		"code_hash":  env.blockCodeHash(t, "version"),
+	})
+
+	branch := "brainloop/" + sessionID
+	if current := git("rev-parse", "--abbrev-ref", "HEAD"); current != "main" {
+		t.Errorf("Expected main still checked out, got %s", current)
+	}
+	if content := git("show", branch+":version.txt"); content != "VERSION = 2" {
+		t.Errorf("Expected the committed code on %s, got %q", branch, content)
+	}
+	message := git("log", "-1", "--format=%B", branch)
+	for _, want := range []string{"Bump the version", "Audit: Use version 2", "Brainloop-Session: " + sessionID, "Brainloop-Block: version"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("Expected %q in the commit message, got %q", want, message)
+		}
+	}
+	if parent, head := git("rev-parse", branch+"^"), git("rev-parse", "main"); parent != head {
+		t.Errorf("Expected the session branch created from HEAD %s, got parent %s", head, parent)
+	}
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestE2ELoopIterationHistoryOffline lists, diffs and reverts the iterations of a block
+func TestE2ELoopIterationHistoryOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"package main\n\nfunc main() {\n\tprintln(\"v0\")\n}",
+		"package main\n\nfunc main() {\n\tprintln(\"v1\")\n}",
+		"package main\n\nfunc main() {\n\tprintln(\"v2\")\n}",
+	)
+	target := filepath.Join(env.dir, "main.go")
+	if err := os.WriteFile(target, []byte("package main\n\nfunc main() {\n\tprintln(\"disk\")\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "main", "description": "Print", "type": "go", "target": target}},
+	})
+	sessionID := env.sessionOf(t, "main")
+	v0 := env.blockCodeHash(t, "main")
+	for _, feedback := range []string{"print v1", "print v2"} {
+		env.call(t, "loop", map[string]interface{}{"mode": "refine", "session_id": sessionID, "block_id": "main", "audit_feedback": feedback})
+	}
+
+	text := env.call(t, "loop", map[string]interface{}{"mode": "history", "session_id": sessionID, "block_id": "main"})
+	if !strings.Contains(text, v0) || !strings.Contains(text, "print v2") {
+		t.Errorf("Expected every iteration in the history, got %s", text)
+	}
+	text = env.call(t, "loop", map[string]interface{}{"mode": "audit", "session_id": sessionID, "block_id": "main"})
+	if !strings.Contains(text, v0) || !strings.Contains(text, "print v1") {
+		t.Errorf("Expected the history in the audit, got %s", text)
+	}
+
+	text = env.call(t, "loop", map[string]interface{}{"mode": "diff", "session_id": sessionID, "block_id": "main", "from": 0, "to": 2})
+	if !strings.Contains(text, "--- iteration-0") || !strings.Contains(text, "-\tprintln(\"v0\")") || !strings.Contains(text, "+\tprintln(\"v2\")") {
+		t.Errorf("Expected a diff from iteration 0 to 2, got %s", text)
+	}
+	text = env.call(t, "loop", map[string]interface{}{"mode": "diff", "session_id": sessionID, "block_id": "main", "against_disk": true})
+	if !strings.Contains(text, "-\tprintln(\"disk\")") || !strings.Contains(text, "+\tprintln(\"v2\")") {
+		t.Errorf("Expected a diff against the file on disk, got %s", text)
+	}
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "diff", "session_id": sessionID, "block_id": "main", "from": 7}); err == nil {
+		t.Error("Expected an unknown iteration to be rejected")
+	}
+
+	// A revert is a new iteration with the earlier code
+	env.call(t, "loop", map[string]interface{}{"mode": "revert", "session_id": sessionID, "block_id": "main", "iteration": 0})
+	if hash := env.blockCodeHash(t, "main"); hash != v0 {
+		t.Errorf("Expected the iteration 0 code back, got hash %s", hash)
+	}
+	var refinements int
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM block_refinements WHERE block_id = 'main'`).Scan(&refinements)
+	if refinements != 3 {
+		t.Errorf("Expected the revert recorded as a third refinement, got %d", refinements)
+	}
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "revert", "session_id": sessionID, "block_id": "main", "iteration": 3}); err == nil {
+		t.Error("Expected reverting to the current code to be rejected")
+	}
+}
+
+// TestE2ELoopDiffSingleIterationOffline diffs a block that was never refined
+func TestE2ELoopDiffSingleIterationOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"package main\n\nfunc main() {\n\tprintln(\"v0\")\n}",
+		"CREATE TABLE users (id INTEGER PRIMARY KEY);",
+	)
+	target := filepath.Join(env.dir, "main.go")
+	if err := os.WriteFile(target, []byte("package main\n\nfunc main() {\n\tprintln(\"disk\")\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "main", "description": "Print", "type": "go", "target": target},
+			map[string]interface{}{"id": "schema", "description": "Schema", "type": "sql", "target": filepath.Join(env.dir, "app.db"), "depends_on": []interface{}{"main"}},
+		},
+	})
+	sessionID := env.sessionOf(t, "main")
+
+	text := env.call(t, "loop", map[string]interface{}{"mode": "diff", "session_id": sessionID, "block_id": "main"})
+	if !strings.Contains(text, "-\tprintln(\"disk\")") || !strings.Contains(text, "+\tprintln(\"v0\")") {
+		t.Errorf("Expected iteration 0 diffed against the file on disk, got %s", text)
+	}
+	text = env.call(t, "loop", map[string]interface{}{"mode": "diff", "session_id": sessionID, "block_id": "schema"})
+	if strings.Contains(text, "+CREATE") {
+		t.Errorf("Expected an empty diff for a sql block with one iteration, got %s", text)
+	}
+}
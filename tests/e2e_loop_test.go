@@ -0,0 +1,422 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"brainloop/internal/cerebras/cerebrastest"
+	"brainloop/internal/database"
+	"brainloop/internal/loop"
+)
+
+// TestE2ELoopWorkflowOffline runs propose, refine and commit for a SQL block
+func TestE2ELoopWorkflowOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		"```sql\nCREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY, email TEXT NOT NULL UNIQUE);\n```",
+	)
+	targetDB := filepath.Join(env.dir, "app.db")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{map[string]interface{}{
+			"id":          "users",
+			"description": "Users table",
+			"type":        "sql",
+			"target":      targetDB,
+		}},
+	})
+
+	sessionID := env.sessionOf(t, "users")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":           "refine",
+		"session_id":     sessionID,
+		"block_id":       "users",
+		"audit_feedback": "Add an email column and make the schema idempotent",
+	})
+
+	// The refinement replays the original exchange before the feedback
+	requests := standIn.Requests()
+	refineMessages := requests[1].Request.Messages
+	if len(refineMessages) != 4 || refineMessages[2].Content != "CREATE TABLE users (id INTEGER PRIMARY KEY);" {
+		t.Errorf("Expected refine history with the proposed code, got %+v", refineMessages)
+	}
+
+	// Commit writes the reviewed code without another generation
+	env.call(t, "loop", map[string]interface{}{
+		"mode":       "commit",
+		"session_id": sessionID,
+		"block_id":   "users",
+		"code_hash":  env.blockCodeHash(t, "users"),
+	})
+	if len(standIn.Requests()) != 2 {
+		t.Errorf("Expected no generation at commit, got %d requests", len(standIn.Requests()))
+	}
+
+	target, err := sql.Open("sqlite", targetDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	var ddl string
+	if err := target.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'users'`).Scan(&ddl); err != nil {
+		t.Fatalf("committed table missing: %v", err)
+	}
+	if !strings.Contains(ddl, "UNIQUE") {
+		t.Errorf("Expected committed schema, got %s", ddl)
+	}
+
+	var calls int
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM cerebras_usage WHERE provider = 'stand-in-1'`).Scan(&calls)
+	if calls != 2 {
+		t.Errorf("Expected 2 recorded calls served by the stand-in, got %d", calls)
+	}
+}
+
+// TestE2ELoopDependenciesOffline generates, refines and commits blocks along their dependency graph
+func TestE2ELoopDependenciesOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t,
+		"CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		"package repo\n\nconst query = \"SELECT id FROM users\"",
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);",
+		"package repo\n\nconst query = \"SELECT id, email FROM users\"",
+	)
+	targetDB := filepath.Join(env.dir, "app.db")
+	targetGo := filepath.Join(env.dir, "repo.go")
+
+	// The dependant is listed first; generation still starts with the schema
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "repo", "description": "Users repository", "type": "go", "target": targetGo, "depends_on": []interface{}{"schema"}},
+			map[string]interface{}{"id": "schema", "description": "Users table", "type": "sql", "target": targetDB},
+		},
+	})
+
+	requests := standIn.Requests()
+	if len(requests) != 2 || !strings.Contains(requests[1].Request.Messages[1].Content, "CREATE TABLE users (id INTEGER PRIMARY KEY);") {
+		t.Fatalf("Expected the repository prompt to carry the schema code, got %+v", requests)
+	}
+
+	sessionID := env.sessionOf(t, "repo")
+
+	commit := func(blockID string) error {
+		_, err := env.tryCall("loop", map[string]interface{}{
+			"mode": "commit", "session_id": sessionID, "block_id": blockID, "code_hash": env.blockCodeHash(t, blockID),
+		})
+		return err
+	}
+
+	if err := commit("repo"); err == nil || !strings.Contains(err.Error(), "committed first") {
+		t.Fatalf("Expected the dependency to be committed first, got %v", err)
+	}
+
+	// Refining the schema makes the repository stale
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "refine", "session_id": sessionID, "block_id": "schema", "audit_feedback": "Add an email column",
+	})
+	if err := commit("schema"); err != nil {
+		t.Fatal(err)
+	}
+	if err := commit("repo"); err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Fatalf("Expected a stale dependant to be rejected, got %v", err)
+	}
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "refine", "session_id": sessionID, "block_id": "repo", "audit_feedback": "Select the email too",
+	})
+	if refine := standIn.Requests()[3].Request.Messages[1].Content; !strings.Contains(refine, "email TEXT") {
+		t.Errorf("Expected the refined schema as context, got %q", refine)
+	}
+	if err := commit("repo"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(targetGo)
+	if err != nil || !strings.Contains(string(data), "email") {
+		t.Errorf("Expected the refined repository to be written, got %q (%v)", data, err)
+	}
+}
+
+// TestE2ELoopEditBlockOffline patches an existing file instead of rewriting it
+func TestE2ELoopEditBlockOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t)
+	target := filepath.Join(env.dir, "greet.go")
+	original := "package greet\n\n// Hello greets\nfunc Hello() string {\n\treturn \"hello\"\n}\n\n// Bye says goodbye\nfunc Bye() string {\n\treturn \"bye\"\n}\n"
+	if err := os.WriteFile(target, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	standIn.ScriptContent("```diff\n--- a/greet.go\n+++ b/greet.go\n@@ -9,3 +9,3 @@\n func Bye() string {\n-\treturn \"bye\"\n+\treturn \"goodbye\"\n }\n```")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{map[string]interface{}{
+			"id": "bye", "description": "Say goodbye in full", "type": "edit", "target": target,
+		}},
+	})
+
+	// The current file is sent as context
+	if prompt := standIn.Requests()[0].Request.Messages[1].Content; !strings.Contains(prompt, "return \"hello\"") {
+		t.Errorf("Expected the current file in the prompt, got %q", prompt)
+	}
+
+	var sessionID, code string
+	env.lifecycleDB.QueryRow(`SELECT session_id, code FROM session_blocks WHERE block_id = 'bye'`).Scan(&sessionID, &code)
+	if strings.Contains(code, "Hello") {
+		t.Errorf("Expected the block to store the patch only, got %q", code)
+	}
+
+	// The file changes before commit: the patch still applies by its context
+	shifted := "// Package greet greets\n" + original
+	if err := os.WriteFile(target, []byte(shifted), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	audit := env.call(t, "loop", map[string]interface{}{"mode": "audit", "session_id": sessionID, "block_id": "bye"})
+	if !strings.Contains(audit, "+\treturn \"goodbye\"") && !strings.Contains(audit, `+\treturn \"goodbye\"`) {
+		t.Errorf("Expected the audit to show the patch, got %s", audit)
+	}
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "bye", "code_hash": env.blockCodeHash(t, "bye"),
+	})
+
+	data, _ := os.ReadFile(target)
+	if expected := strings.Replace(shifted, "\"bye\"", "\"goodbye\"", 1); string(data) != expected {
+		t.Errorf("Unexpected patched file:\n%s", data)
+	}
+
+	// A patch that no longer applies is a conflict and nothing is written
+	if err := os.WriteFile(target, []byte("package greet\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env.call(t, "loop", map[string]interface{}{"mode": "validate", "session_id": sessionID, "block_id": "bye"})
+
+	var validationJSON string
+	env.lifecycleDB.QueryRow(`SELECT validation_json FROM session_blocks WHERE block_id = 'bye'`).Scan(&validationJSON)
+	if !strings.Contains(validationJSON, "patch does not apply") {
+		t.Errorf("Expected a conflict from the validation gate, got %s", validationJSON)
+	}
+}
+
+// TestE2ELoopRetryOffline keeps generated blocks when one fails and retries the failed one
+func TestE2ELoopRetryOffline(t *testing.T) {
+	standIn := cerebrastest.NewServer()
+	defer standIn.Close()
+	standIn.Script(
+		cerebrastest.Response{Content: "A = 1"},
+		cerebrastest.Response{Status: 400, Body: `{"error":{"message":"context too long"}}`},
+	)
+
+	env := newE2EEnv(t, standIn)
+	block := func(id string, dependsOn ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"id": id, "description": id, "type": "code", "target": filepath.Join(env.dir, id+".txt"), "depends_on": dependsOn}
+	}
+	blockState := func(id string) (string, string, string) {
+		var status, code, generationError string
+		env.lifecycleDB.QueryRow(`SELECT status, COALESCE(code, ''), COALESCE(generation_error, '') FROM session_blocks WHERE block_id = ?`, id).Scan(&status, &code, &generationError)
+		return status, code, generationError
+	}
+
+	// b fails and c, which depends on it, cannot be generated; a is kept
+	text := env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{block("a"), block("b", "a"), block("c", "b")},
+	})
+	if !strings.Contains(text, "1 of 3 block(s) generated, 2 failed") {
+		t.Errorf("Expected a partial propose, got %s", text)
+	}
+	if status, code, _ := blockState("a"); status != "pending" || code != "A = 1" {
+		t.Errorf("Expected a generated, got %s %q", status, code)
+	}
+	if status, code, generationError := blockState("b"); status != "failed" || code != "" || !strings.Contains(generationError, "context too long") {
+		t.Errorf("Expected the generation error stored on b, got %s %q %q", status, code, generationError)
+	}
+	if status, _, generationError := blockState("c"); status != "failed" || !strings.Contains(generationError, "dependency b") {
+		t.Errorf("Expected c failed on its dependency, got %s %q", status, generationError)
+	}
+
+	var sessionID string
+	env.lifecycleDB.QueryRow(`SELECT session_id FROM sessions`).Scan(&sessionID)
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "refine", "session_id": sessionID, "block_id": "b", "audit_feedback": "x"}); err == nil || !strings.Contains(err.Error(), "retry it") {
+		t.Errorf("Expected a failed block to be refused by refine, got %v", err)
+	}
+
+	// The retry generates b then c, with a as context
+	standIn.ScriptContent("B = 1", "C = 1")
+	text = env.call(t, "loop", map[string]interface{}{"mode": "retry", "session_id": sessionID})
+	if !strings.Contains(text, "2 block(s) generated") {
+		t.Errorf("Expected both failed blocks generated, got %s", text)
+	}
+	for id, want := range map[string]string{"b": "B = 1", "c": "C = 1"} {
+		if status, code, generationError := blockState(id); status != "pending" || code != want || generationError != "" {
+			t.Errorf("Expected %s generated by the retry, got %s %q %q", id, status, code, generationError)
+		}
+	}
+	requests := standIn.Requests()
+	if prompt := requests[len(requests)-2].Request.Messages[1].Content; !strings.Contains(prompt, "A = 1") {
+		t.Errorf("Expected the generated dependency in the retried prompt, got %q", prompt)
+	}
+
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "retry", "session_id": sessionID}); err == nil {
+		t.Error("Expected a retry without failed blocks to be refused")
+	}
+}
+
+// TestE2ELoopConcurrencyOffline drives several sessions at once within the generation pool
+func TestE2ELoopConcurrencyOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t)
+
+	block := func(id string) loop.BlockInput {
+		return loop.BlockInput{ID: id, Description: id, Type: "code", Target: filepath.Join(env.dir, id+".txt")}
+	}
+	delay := 100 * time.Millisecond
+
+	// A pool of one runs the generations of independent blocks one at a time
+	env.lifecycleDB.Exec(`INSERT OR REPLACE INTO config (key, value) VALUES ('max_concurrent_generations', '1')`)
+	pooled := loop.NewManager(env.lifecycleDB, env.outputDB, standIn.Client())
+	standIn.Script(
+		cerebrastest.Response{Content: "A = 1", Delay: delay},
+		cerebrastest.Response{Content: "B = 1", Delay: delay},
+		cerebrastest.Response{Content: "C = 1", Delay: delay},
+	)
+	start := time.Now()
+	if _, err := pooled.Propose(loop.ProposeRequest{Blocks: []loop.BlockInput{block("a"), block("b"), block("c")}}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 3*delay {
+		t.Errorf("Expected the generations serialised by the pool, took %v", elapsed)
+	}
+
+	env.lifecycleDB.Exec(`DELETE FROM config WHERE key = 'max_concurrent_generations'`)
+	manager := loop.NewManager(env.lifecycleDB, env.outputDB, standIn.Client())
+	standIn.ScriptContent("X = 1", "Y = 1")
+	first, err := manager.Propose(loop.ProposeRequest{Blocks: []loop.BlockInput{block("x")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := manager.Propose(loop.ProposeRequest{Blocks: []loop.BlockInput{block("y")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposed := first.Blocks[0]
+
+	// A refine waiting on the LLM holds up neither another session nor another refine
+	standIn.Script(
+		cerebrastest.Response{Content: "X = 2", Delay: 4 * delay},
+		cerebrastest.Response{Content: "X = 3"},
+	)
+	slow := make(chan error, 1)
+	go func() {
+		_, err := manager.Refine(loop.RefineRequest{SessionID: first.SessionID, BlockID: "x", AuditFeedback: "slow"})
+		slow <- err
+	}()
+	time.Sleep(delay)
+
+	start = time.Now()
+	committed, err := manager.Commit(loop.CommitRequest{SessionID: second.SessionID, BlockID: "y", CodeHash: second.Blocks[0].CodeHash})
+	if err != nil || !committed.Success {
+		t.Fatalf("Expected the other session to commit, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*delay {
+		t.Errorf("Expected the commit of another session not to wait for the refine, took %v", elapsed)
+	}
+
+	fast, err := manager.Refine(loop.RefineRequest{SessionID: first.SessionID, BlockID: "x", AuditFeedback: "fast", Version: proposed.Version})
+	if err != nil {
+		t.Fatalf("Expected the concurrent refine to land, got %v", err)
+	}
+
+	// The slow refine was generated from the proposed version: it conflicts instead of overwriting
+	if err := <-slow; !errors.Is(err, database.ErrVersionConflict) {
+		t.Errorf("Expected a version conflict for the slow refine, got %v", err)
+	}
+	if hash := env.blockCodeHash(t, "x"); hash != fast.Block.CodeHash {
+		t.Errorf("Expected the landed refine kept, got code hash %s", hash)
+	}
+	var refinements int
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM block_refinements WHERE block_id = 'x'`).Scan(&refinements)
+	if refinements != 1 {
+		t.Errorf("Expected only the landed refine recorded, got %d", refinements)
+	}
+
+	// A refine of an outdated review is refused before any generation
+	requests := len(standIn.Requests())
+	if _, err := manager.Refine(loop.RefineRequest{SessionID: first.SessionID, BlockID: "x", AuditFeedback: "late", Version: proposed.Version}); !errors.Is(err, database.ErrVersionConflict) {
+		t.Errorf("Expected a refine of an outdated version refused, got %v", err)
+	}
+	if len(standIn.Requests()) != requests {
+		t.Error("Expected no generation for a refused refine")
+	}
+}
+
+// TestE2ELoopRefineCommittedOffline refuses to refine or revert a committed block
+func TestE2ELoopRefineCommittedOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t,
+		"package main\n\nfunc a() {}",
+		"package main\n\nfunc b() {}",
+	)
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "a", "description": "A", "type": "go", "target": filepath.Join(env.dir, "a.go")},
+			map[string]interface{}{"id": "b", "description": "B", "type": "go", "target": filepath.Join(env.dir, "b.go")},
+		},
+	})
+	sessionID := env.sessionOf(t, "a")
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "a", "code_hash": env.blockCodeHash(t, "a"),
+	})
+
+	calls := len(standIn.Requests())
+	for _, request := range []map[string]interface{}{
+		{"mode": "refine", "session_id": sessionID, "block_id": "a", "audit_feedback": "Rename it"},
+		{"mode": "revert", "session_id": sessionID, "block_id": "a", "iteration": 0},
+	} {
+		if _, err := env.tryCall("loop", request); err == nil || !strings.Contains(err.Error(), "already committed") {
+			t.Errorf("Expected %s of a committed block to be refused, got %v", request["mode"], err)
+		}
+	}
+	if len(standIn.Requests()) != calls {
+		t.Error("Expected no generation for a committed block")
+	}
+}
+
+// TestE2ELoopRetryWithToolsOffline offers the generation tools to retried blocks
+func TestE2ELoopRetryWithToolsOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t)
+	standIn.Script(
+		cerebrastest.Response{Status: 400, Body: `{"error":{"message":"context too long"}}`},
+		cerebrastest.Response{Content: "package store\n\nfunc Load() {}"},
+	)
+	project := filepath.Join(env.dir, "project")
+	os.MkdirAll(project, 0755)
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":         "propose",
+		"project_root": project,
+		"use_tools":    true,
+		"blocks":       []interface{}{map[string]interface{}{"id": "store", "description": "Store", "type": "go", "target": filepath.Join(project, "store.go")}},
+	})
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "retry", "session_id": env.sessionOf(t, "store"), "use_tools": true,
+	})
+
+	requests := standIn.Requests()
+	if len(requests) != 2 || len(requests[1].Request.Tools) == 0 {
+		t.Fatalf("Expected the retried generation to offer the tools, got %d request(s)", len(requests))
+	}
+	var status string
+	env.lifecycleDB.QueryRow(`SELECT status FROM session_blocks WHERE block_id = 'store'`).Scan(&status)
+	if status != "pending" {
+		t.Errorf("Expected the retried block generated, got %s", status)
+	}
+}
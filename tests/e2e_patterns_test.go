@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestE2ELoopProjectPatternsOffline injects the patterns of the session's project into every generation
+func TestE2ELoopProjectPatternsOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t,
+		"package api\n\nfunc Get() error { return nil }",
+		"package api\n\nfunc Get() error { return nil }\n",
+		"package api\n\n// Get fetches\nfunc Get() error { return nil }\n",
+	)
+	project := filepath.Join(env.dir, "project")
+	store := filepath.Join(project, "store", "store.go")
+	os.MkdirAll(filepath.Dir(store), 0755)
+	os.WriteFile(filepath.Join(project, "go.mod"), []byte("module project\n\ngo 1.21\n"), 0644)
+	os.WriteFile(store, []byte("package store\n\nimport \"fmt\"\n\nfunc Open(path string) error {\n\tif path == \"\" {\n\t\treturn fmt.Errorf(\"open: %w\", errEmpty)\n\t}\n\treturn nil\n}\n"), 0644)
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "api", "description": "API", "type": "go", "target": filepath.Join(project, "api", "api.go")},
+		},
+	})
+	var sessionID, root string
+	env.lifecycleDB.QueryRow(`SELECT session_id, project_root FROM sessions`).Scan(&sessionID, &root)
+	if root != project {
+		t.Errorf("Expected the session bound to the go.mod directory %s, got %s", project, root)
+	}
+
+	patternIDs := func() (string, int) {
+		var blockPattern string
+		var count int
+		env.lifecycleDB.QueryRow(`SELECT COALESCE(pattern_id, '') FROM session_blocks WHERE block_id = 'api'`).Scan(&blockPattern)
+		env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM detected_patterns WHERE source_path = ?`, project).Scan(&count)
+		return blockPattern, count
+	}
+	injected := func() string {
+		requests := standIn.Requests()
+		return requests[len(requests)-1].Request.Messages[0].Content
+	}
+
+	proposed, extractions := patternIDs()
+	if proposed == "" || extractions != 1 {
+		t.Fatalf("Expected one extraction recorded on the block, got pattern %q and %d extraction(s)", proposed, extractions)
+	}
+	if system := injected(); !strings.Contains(system, "DETECTED PROJECT PATTERNS") || !strings.Contains(system, project) {
+		t.Errorf("Expected the project patterns in the propose prompt, got %q", system)
+	}
+
+	// Unchanged project: the cached patterns are injected again
+	env.call(t, "loop", map[string]interface{}{"mode": "refine", "session_id": sessionID, "block_id": "api", "audit_feedback": "newline"})
+	if refined, extractions := patternIDs(); refined != proposed || extractions != 1 {
+		t.Errorf("Expected the cached patterns reused, got pattern %q and %d extraction(s)", refined, extractions)
+	}
+	if !strings.Contains(injected(), "DETECTED PROJECT PATTERNS") {
+		t.Error("Expected the project patterns in the refine prompt")
+	}
+
+	// A modified file invalidates the cache
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(store, later, later)
+	env.call(t, "loop", map[string]interface{}{"mode": "refine", "session_id": sessionID, "block_id": "api", "audit_feedback": "document"})
+	refreshed, extractions := patternIDs()
+	if refreshed == proposed || extractions != 2 {
+		t.Errorf("Expected the patterns extracted again after a change, got pattern %q and %d extraction(s)", refreshed, extractions)
+	}
+
+	audit := env.call(t, "loop", map[string]interface{}{"mode": "audit", "session_id": sessionID, "block_id": "api"})
+	if !strings.Contains(audit, "go_file_count") {
+		t.Errorf("Expected the recorded patterns in the audit, got %s", audit)
+	}
+}
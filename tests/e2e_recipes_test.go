@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestE2ERecipesOffline proposes blocks from versioned recipes with variables
+func TestE2ERecipesOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t)
+
+	env.call(t, "recipe", map[string]interface{}{
+		"mode":        "create",
+		"name":        "schemas",
+		"description": "Schema set of {{worker}}",
+		"type":        "code",
+		"target":      filepath.Join(env.dir, "{{worker}}.schema.txt"),
+	})
+	env.call(t, "recipe", map[string]interface{}{
+		"mode":        "create",
+		"name":        "worker",
+		"description": "Worker {{worker}} with a heartbeat every {{interval}}",
+		"type":        "code",
+		"target":      filepath.Join(env.dir, "{{worker}}.txt"),
+		"depends_on":  []interface{}{"schemas"},
+	})
+	if _, err := env.tryCall("recipe", map[string]interface{}{"mode": "version", "name": "schemas", "depends_on": []interface{}{"worker"}}); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected a dependency cycle to be refused, got %v", err)
+	}
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "propose", "recipe": "worker", "variables": map[string]interface{}{"worker": "ingest"}}); err == nil || !strings.Contains(err.Error(), "interval") {
+		t.Errorf("Expected the missing variable to be refused, got %v", err)
+	}
+
+	// The recipe and the one it depends on become blocks, dependency first;
+	// an extra block depends on a recipe block by the recipe's name
+	standIn.ScriptContent("SCHEMA = 1", "WORKER = 1", "EXTRA = 1")
+	env.call(t, "loop", map[string]interface{}{
+		"mode":      "propose",
+		"recipe":    "worker",
+		"variables": map[string]interface{}{"worker": "ingest", "interval": "30s"},
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "extra", "description": "extra", "type": "code", "target": filepath.Join(env.dir, "extra.txt"), "depends_on": []interface{}{"worker"}},
+		},
+	})
+	blockID := func(prefix string) (string, string, string) {
+		var id, description, target string
+		env.lifecycleDB.QueryRow(`SELECT block_id, description, target FROM session_blocks WHERE block_id LIKE ?`, prefix+"-%").Scan(&id, &description, &target)
+		return id, description, target
+	}
+	schemaID, _, schemaTarget := blockID("schemas")
+	workerID, description, target := blockID("worker")
+	if description != "Worker ingest with a heartbeat every 30s" || target != filepath.Join(env.dir, "ingest.txt") || schemaTarget != filepath.Join(env.dir, "ingest.schema.txt") {
+		t.Errorf("Expected the variables filled in, got %q %q %q", description, target, schemaTarget)
+	}
+	dependencies := func(id string) string {
+		var dep string
+		env.lifecycleDB.QueryRow(`SELECT depends_on FROM block_dependencies WHERE block_id = ?`, id).Scan(&dep)
+		return dep
+	}
+	if dependencies(workerID) != schemaID || dependencies("extra") != workerID {
+		t.Errorf("Expected worker to depend on %s and extra on %s, got %q and %q", schemaID, workerID, dependencies(workerID), dependencies("extra"))
+	}
+	var code string
+	env.lifecycleDB.QueryRow(`SELECT code FROM session_blocks WHERE block_id = ?`, workerID).Scan(&code)
+	if code != "WORKER = 1" {
+		t.Errorf("Expected the worker generated after its schemas, got %q", code)
+	}
+
+	// A new version keeps the fields it does not change; earlier versions stay proposable
+	env.call(t, "recipe", map[string]interface{}{"mode": "version", "name": "worker", "description": "Worker {{worker}}", "note": "no heartbeat"})
+	if text := env.call(t, "recipe", map[string]interface{}{"mode": "list", "name": "worker"}); !strings.Contains(text, "{worker 2 ") || !strings.Contains(text, "{worker 1 ") || !strings.Contains(text, "no heartbeat") {
+		t.Errorf("Expected both versions listed, got %s", text)
+	}
+	if _, err := env.tryCall("recipe", map[string]interface{}{"mode": "version", "name": "worker", "description": "Worker {{worker}}"}); err == nil {
+		t.Error("Expected a version without changes to be refused")
+	}
+
+	standIn.ScriptContent("SCHEMA = 2", "WORKER = 2")
+	env.call(t, "loop", map[string]interface{}{
+		"mode":           "propose",
+		"recipe":         "worker",
+		"recipe_version": 1,
+		"variables":      map[string]interface{}{"worker": "export", "interval": "1m"},
+	})
+	var count int
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM session_blocks WHERE description = 'Worker export with a heartbeat every 1m'`).Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected version 1 proposed again under new block IDs, got %d block(s)", count)
+	}
+}
@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestE2ELoopReportOffline renders the history of a session as Markdown
+func TestE2ELoopReportOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t, "A = 1", "A = 2")
+
+	// The client named at initialize is recorded on the events
+	line, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0", "id": 0, "method": "initialize",
+		"params": map[string]interface{}{"clientInfo": map[string]interface{}{"name": "reviewer", "version": "1.2"}},
+	})
+	env.server.Serve(strings.NewReader(string(line)+"\n"), io.Discard)
+
+	target := filepath.Join(env.dir, "a.txt")
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "a", "description": "Define A", "type": "code", "target": target}},
+	})
+	var sessionID string
+	env.lifecycleDB.QueryRow(`SELECT session_id FROM sessions`).Scan(&sessionID)
+	env.call(t, "loop", map[string]interface{}{"mode": "refine", "session_id": sessionID, "block_id": "a", "audit_feedback": "A must be 2"})
+	env.call(t, "loop", map[string]interface{}{"mode": "commit", "session_id": sessionID, "block_id": "a", "code_hash": env.blockCodeHash(t, "a")})
+	env.call(t, "loop", map[string]interface{}{"mode": "rollback", "session_id": sessionID})
+
+	rows, err := env.lifecycleDB.Query(`SELECT kind, COALESCE(actor, ''), COALESCE(tokens, 0) FROM session_events WHERE session_id = ? ORDER BY event_id`, sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for rows.Next() {
+		var kind, actor string
+		var tokens int
+		rows.Scan(&kind, &actor, &tokens)
+		kinds = append(kinds, kind)
+		if actor != "reviewer 1.2" {
+			t.Errorf("Expected the client recorded on %s, got %q", kind, actor)
+		}
+		if (kind == "generated" || kind == "refined") && tokens == 0 {
+			t.Errorf("Expected the tokens of the %s step, got none", kind)
+		}
+	}
+	rows.Close()
+	if got := strings.Join(kinds, ","); got != "created,generated,refined,validated,committed,rolled_back" {
+		t.Errorf("Expected every step in the event log, got %s", got)
+	}
+
+	report := env.call(t, "loop", map[string]interface{}{"mode": "report", "session_id": sessionID})
+	for _, want := range []string{
+		"# Session `" + sessionID + "`",
+		"## `a`: Define A",
+		"### Iteration 1: refined at temperature 0.3",
+		"> A must be 2",
+		"-A = 1\n+A = 2",
+		"- Cost: 2 call(s)",
+		"## Timeline",
+		"| rolled_back | reviewer 1.2 |",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected %q in the report, got:\n%s", want, report)
+		}
+	}
+
+	path := filepath.Join(env.dir, "reports", "session.md")
+	env.call(t, "loop", map[string]interface{}{"mode": "report", "session_id": sessionID, "path": path})
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), "## Timeline") {
+		t.Errorf("Expected the report written to %s, got %v", path, err)
+	}
+}
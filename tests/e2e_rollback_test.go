@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestE2ELoopRollbackOffline restores files and databases from the backups taken at commit
+func TestE2ELoopRollbackOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"ALTER TABLE users ADD COLUMN email TEXT;",
+		"package main\n\nfunc main() {\n\tprintln(\"new\")\n}",
+		"package main\n\nfunc helper() {}",
+	)
+	targetDB := filepath.Join(env.dir, "app.db")
+	targetGo := filepath.Join(env.dir, "main.go")
+	newGo := filepath.Join(env.dir, "helper.go")
+
+	db, err := sql.Open("sqlite", targetDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY); INSERT INTO users (id) VALUES (1)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	original := "package main\n\nfunc main() {}\n"
+	if err := os.WriteFile(targetGo, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "schema", "description": "Add email", "type": "sql", "target": targetDB},
+			map[string]interface{}{"id": "main", "description": "Print new", "type": "go", "target": targetGo, "depends_on": []interface{}{"schema"}},
+			map[string]interface{}{"id": "helper", "description": "Helper", "type": "go", "target": newGo, "depends_on": []interface{}{"main"}},
+		},
+	})
+
+	sessionID := env.sessionOf(t, "main")
+	for _, blockID := range []string{"schema", "main", "helper"} {
+		env.call(t, "loop", map[string]interface{}{
+			"mode": "commit", "session_id": sessionID, "block_id": blockID, "code_hash": env.blockCodeHash(t, blockID),
+		})
+	}
+
+	// A block whose target changed after its commit is not rolled back silently
+	if err := os.WriteFile(newGo, []byte("package main\n\n// edited by hand\nfunc helper() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "rollback", "session_id": sessionID, "block_id": "helper"}); err == nil || !strings.Contains(err.Error(), "changed since") {
+		t.Fatalf("Expected the integrity check to refuse, got %v", err)
+	}
+	env.call(t, "loop", map[string]interface{}{"mode": "rollback", "session_id": sessionID, "block_id": "helper", "force": true})
+	if _, err := os.Stat(newGo); !os.IsNotExist(err) {
+		t.Errorf("Expected the file created by the commit to be removed, got %v", err)
+	}
+
+	// The rest of the session
+	env.call(t, "loop", map[string]interface{}{"mode": "rollback", "session_id": sessionID})
+
+	data, _ := os.ReadFile(targetGo)
+	if string(data) != original {
+		t.Errorf("Expected the original file back, got %q", data)
+	}
+
+	db, err = sql.Open("sqlite", targetDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var columns, rows int
+	db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users')`).Scan(&columns)
+	db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&rows)
+	if columns != 1 || rows != 1 {
+		t.Errorf("Expected the database snapshot restored, got %d columns and %d rows", columns, rows)
+	}
+
+	var committed int
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM session_blocks WHERE session_id = ? AND status = 'committed'`, sessionID).Scan(&committed)
+	if committed != 0 {
+		t.Errorf("Expected every block back to pending, got %d committed", committed)
+	}
+
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "rollback", "session_id": sessionID}); err == nil {
+		t.Error("Expected nothing left to roll back")
+	}
+}
+
+// TestE2ELoopRollbackRecommitOffline commits the same code again after a rollback
+func TestE2ELoopRollbackRecommitOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t, "package main\n\nfunc main() {}")
+	target := filepath.Join(env.dir, "main.go")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "main", "description": "Main", "type": "go", "target": target}},
+	})
+	sessionID := env.sessionOf(t, "main")
+	commit := map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "main", "code_hash": env.blockCodeHash(t, "main"),
+	}
+	env.call(t, "loop", commit)
+	env.call(t, "loop", map[string]interface{}{"mode": "rollback", "session_id": sessionID})
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("Expected the rollback to remove the file, got %v", err)
+	}
+
+	env.call(t, "loop", commit)
+
+	data, _ := os.ReadFile(target)
+	var status string
+	env.lifecycleDB.QueryRow(`SELECT status FROM session_blocks WHERE block_id = 'main'`).Scan(&status)
+	if string(data) != "package main\n\nfunc main() {}" || status != "committed" {
+		t.Errorf("Expected the block committed again, got status %s and %q", status, data)
+	}
+}
+
+// TestE2ELoopCommitUndoneWhenNotRecordedOffline restores the target when a
+// written block cannot be recorded as committed
+func TestE2ELoopCommitUndoneWhenNotRecordedOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t, "package main\n\nfunc main() {}")
+	target := filepath.Join(env.dir, "main.go")
+	original := "package main\n\n// original\n"
+	os.WriteFile(target, []byte(original), 0644)
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "main", "description": "Main", "type": "go", "target": target}},
+	})
+	if _, err := env.lifecycleDB.Exec(`
+		CREATE TRIGGER refuse_commit BEFORE UPDATE OF status ON session_blocks
+		WHEN NEW.status = 'committed'
+		BEGIN SELECT RAISE(ABORT, 'refused'); END
+	`); err != nil {
+		t.Fatal(err)
+	}
+	commit := map[string]interface{}{
+		"mode": "commit", "session_id": env.sessionOf(t, "main"), "block_id": "main", "code_hash": env.blockCodeHash(t, "main"),
+	}
+	if _, err := env.tryCall("loop", commit); err == nil || !strings.Contains(err.Error(), "every target was restored") {
+		t.Fatalf("Expected the commit to be undone, got %v", err)
+	}
+
+	data, _ := os.ReadFile(target)
+	var processed, pending int
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM processed_log`).Scan(&processed)
+	env.lifecycleDB.QueryRow(`SELECT COUNT(*) FROM commit_backups WHERE restored_at IS NULL`).Scan(&pending)
+	if string(data) != original || processed != 0 || pending != 0 {
+		t.Errorf("Expected the target restored and nothing recorded, got %q, %d processed, %d live backup(s)", data, processed, pending)
+	}
+
+	env.lifecycleDB.Exec(`DROP TRIGGER refuse_commit`)
+	env.call(t, "loop", commit)
+}
+
+// TestE2ELoopRollbackPartialFailureOffline returns the blocks a failed
+// rollback fully restored to pending, so a retry finishes the rest
+func TestE2ELoopRollbackPartialFailureOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"package main\n\nfunc a() {}",
+		"package main\n\nfunc b() {}",
+	)
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "propose",
+		"blocks": []interface{}{
+			map[string]interface{}{"id": "a", "description": "A", "type": "go", "target": filepath.Join(env.dir, "a.go")},
+			map[string]interface{}{"id": "b", "description": "B", "type": "go", "target": filepath.Join(env.dir, "b.go"), "depends_on": []interface{}{"a"}},
+		},
+	})
+	sessionID := env.sessionOf(t, "a")
+	for _, blockID := range []string{"a", "b"} {
+		env.call(t, "loop", map[string]interface{}{
+			"mode": "commit", "session_id": sessionID, "block_id": blockID, "code_hash": env.blockCodeHash(t, blockID),
+		})
+	}
+
+	// b is restored first; recording a's restore fails
+	if _, err := env.lifecycleDB.Exec(`
+		CREATE TRIGGER refuse_restore BEFORE UPDATE ON commit_backups
+		WHEN OLD.block_id = 'a'
+		BEGIN SELECT RAISE(ABORT, 'refused'); END
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "rollback", "session_id": sessionID}); err == nil || !strings.Contains(err.Error(), "pending again") {
+		t.Fatalf("Expected the rollback to fail after restoring b, got %v", err)
+	}
+
+	status := func(blockID string) string {
+		var s string
+		env.lifecycleDB.QueryRow(`SELECT status FROM session_blocks WHERE block_id = ?`, blockID).Scan(&s)
+		return s
+	}
+	if status("a") != "committed" || status("b") != "pending" {
+		t.Fatalf("Expected only b back to pending, got a %s and b %s", status("a"), status("b"))
+	}
+
+	env.lifecycleDB.Exec(`DROP TRIGGER refuse_restore`)
+	env.call(t, "loop", map[string]interface{}{"mode": "rollback", "session_id": sessionID, "force": true})
+	if status("a") != "pending" {
+		t.Errorf("Expected the retry to roll back a, got %s", status("a"))
+	}
+}
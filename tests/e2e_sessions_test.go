@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"brainloop/internal/database"
+	"brainloop/internal/mcp"
+)
+
+// TestE2ELoopSessionManagementOffline lists, inspects, abandons, resumes and expires sessions
+func TestE2ELoopSessionManagementOffline(t *testing.T) {
+	env, _ := newScriptedEnv(t,
+		"package main\n\nfunc main() {}",
+		"package main\n\nfunc idle() {}",
+		"package main\n\nfunc busy() {}",
+	)
+	target := filepath.Join(env.dir, "main.go")
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "main", "description": "Main", "type": "go", "target": target}},
+	})
+	sessionID := env.sessionOf(t, "main")
+
+	text := env.call(t, "loop", map[string]interface{}{"mode": "list", "status": "pending_audit", "max_age_hours": 1})
+	if !strings.Contains(text, sessionID) {
+		t.Errorf("Expected the new session in the list, got %s", text)
+	}
+	text = env.call(t, "loop", map[string]interface{}{"mode": "list", "status": "pending_audit", "min_age_hours": 1})
+	if strings.Contains(text, sessionID) {
+		t.Errorf("Expected the new session filtered out by age, got %s", text)
+	}
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "list", "status": "done"}); err == nil {
+		t.Error("Expected an unknown status to be rejected")
+	}
+
+	text = env.call(t, "loop", map[string]interface{}{"mode": "status", "session_id": sessionID})
+	if !strings.Contains(text, "0 of 1 block(s) committed") || strings.Contains(text, "func main") {
+		t.Errorf("Expected block status without code, got %s", text)
+	}
+
+	// An abandoned session refuses refine and commit until resumed
+	env.call(t, "loop", map[string]interface{}{"mode": "abandon", "session_id": sessionID})
+	if _, err := env.tryCall("loop", map[string]interface{}{
+		"mode": "refine", "session_id": sessionID, "block_id": "main", "audit_feedback": "more",
+	}); err == nil || !strings.Contains(err.Error(), "abandoned") {
+		t.Errorf("Expected refine refused on an abandoned session, got %v", err)
+	}
+	if _, err := env.tryCall("loop", map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "main", "code_hash": env.blockCodeHash(t, "main"),
+	}); err == nil || !strings.Contains(err.Error(), "abandoned") {
+		t.Errorf("Expected commit refused on an abandoned session, got %v", err)
+	}
+
+	env.call(t, "loop", map[string]interface{}{"mode": "resume", "session_id": sessionID})
+	env.call(t, "loop", map[string]interface{}{
+		"mode": "commit", "session_id": sessionID, "block_id": "main", "code_hash": env.blockCodeHash(t, "main"),
+	})
+
+	// Committing the last block completes and publishes the session
+	var status string
+	env.lifecycleDB.QueryRow(`SELECT status FROM sessions WHERE session_id = ?`, sessionID).Scan(&status)
+	if status != "committed" {
+		t.Errorf("Expected the session committed, got %s", status)
+	}
+	var published int
+	env.outputDB.QueryRow(`SELECT blocks_committed FROM results WHERE session_id = ?`, sessionID).Scan(&published)
+	if published != 1 {
+		t.Errorf("Expected the session published with 1 block, got %d", published)
+	}
+	if _, err := env.tryCall("loop", map[string]interface{}{"mode": "abandon", "session_id": sessionID}); err == nil {
+		t.Error("Expected a committed session not to be abandoned")
+	}
+
+	// An idle pending_audit session is abandoned by the expiry job
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "idle", "description": "Idle", "type": "go", "target": filepath.Join(env.dir, "idle.go")}},
+	})
+	env.call(t, "loop", map[string]interface{}{
+		"mode":   "propose",
+		"blocks": []interface{}{map[string]interface{}{"id": "busy", "description": "Busy", "type": "go", "target": filepath.Join(env.dir, "busy.go")}},
+	})
+	idleID, busyID := env.sessionOf(t, "idle"), env.sessionOf(t, "busy")
+	past := time.Now().Add(-3 * time.Hour).Unix()
+	for _, id := range []string{idleID, busyID} {
+		env.lifecycleDB.Exec(`UPDATE sessions SET created_at = ? WHERE session_id = ?`, past, id)
+		env.lifecycleDB.Exec(`UPDATE session_blocks SET generated_at = ?, last_refined_at = ? WHERE session_id = ?`, past, past, id)
+		env.lifecycleDB.Exec(`UPDATE session_events SET timestamp = ? WHERE session_id = ?`, past, id)
+	}
+
+	// A validation is activity even though it changes no block
+	env.call(t, "loop", map[string]interface{}{"mode": "validate", "session_id": busyID, "block_id": "busy"})
+	if err := database.NewLifecycleDB(env.lifecycleDB).SetConfig("session_expiry_hours", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The job runs when a server starts
+	if _, err := mcp.NewServer(env.lifecycleDB, env.outputDB, env.metadataDB); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for status = ""; status != "abandoned" && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		env.lifecycleDB.QueryRow(`SELECT status FROM sessions WHERE session_id = ?`, idleID).Scan(&status)
+	}
+	if status != "abandoned" {
+		t.Errorf("Expected the idle session abandoned, got %s", status)
+	}
+	env.lifecycleDB.QueryRow(`SELECT status FROM sessions WHERE session_id = ?`, sessionID).Scan(&status)
+	if status != "committed" {
+		t.Errorf("Expected committed sessions left alone, got %s", status)
+	}
+	env.lifecycleDB.QueryRow(`SELECT status FROM sessions WHERE session_id = ?`, busyID).Scan(&status)
+	if status != "pending_audit" {
+		t.Errorf("Expected the recently validated session left alone, got %s", status)
+	}
+}
@@ -0,0 +1,246 @@
+package tests
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"brainloop/internal/cerebras/cerebrastest"
+	"brainloop/internal/database"
+	"brainloop/internal/mcp"
+	"brainloop/internal/workspace"
+)
+
+// e2eEnv is a brainloop MCP server wired to local databases and stand-in providers
+type e2eEnv struct {
+	dir         string
+	server      *mcp.Server
+	lifecycleDB *sql.DB
+	outputDB    *sql.DB
+	metadataDB  *sql.DB
+	requestID   int
+}
+
+// newE2EEnv initializes the 4 databases in a temp directory and starts an MCP
+// server whose provider chain points at the given stand-in servers
+func newE2EEnv(t *testing.T, providers ...*cerebrastest.Server) *e2eEnv {
+	t.Helper()
+
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, schema := range []string{"input", "lifecycle", "output", "metadata"} {
+		name := fmt.Sprintf("brainloop.%s_schema.sql", schema)
+		data, err := os.ReadFile(filepath.Join(root, "..", name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Schema files and command_security.db are resolved from the working directory
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(root) })
+
+	helper := database.New()
+	lifecycleDB, err := helper.InitLifecycleDB(filepath.Join(dir, "brainloop.lifecycle.db"))
+	if err != nil {
+		t.Fatalf("lifecycle DB: %v", err)
+	}
+	outputDB, err := helper.InitOutputDB(filepath.Join(dir, "brainloop.output.db"))
+	if err != nil {
+		t.Fatalf("output DB: %v", err)
+	}
+	metadataDB, err := helper.InitMetadataDB(filepath.Join(dir, "brainloop.metadata.db"))
+	if err != nil {
+		t.Fatalf("metadata DB: %v", err)
+	}
+	t.Cleanup(func() {
+		lifecycleDB.Close()
+		outputDB.Close()
+		metadataDB.Close()
+	})
+
+	if err := database.NewMetadataDB(metadataDB).SetSecret("CEREBRAS_API_KEY", "offline"); err != nil {
+		t.Fatal(err)
+	}
+
+	var endpoints []map[string]interface{}
+	for i, p := range providers {
+		endpoints = append(endpoints, map[string]interface{}{
+			"name":     fmt.Sprintf("stand-in-%d", i+1),
+			"base_url": p.URL(),
+		})
+	}
+	chain, _ := json.Marshal(map[string]interface{}{"endpoints": endpoints})
+	if err := database.NewLifecycleDB(lifecycleDB).SetConfig("provider_chain", string(chain)); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := mcp.NewServer(lifecycleDB, outputDB, metadataDB)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	return &e2eEnv{dir: dir, server: server, lifecycleDB: lifecycleDB, outputDB: outputDB, metadataDB: metadataDB}
+}
+
+// newScriptedEnv starts a stand-in provider answering with contents, in
+// order, and an environment served by it
+func newScriptedEnv(t *testing.T, contents ...string) (*e2eEnv, *cerebrastest.Server) {
+	t.Helper()
+
+	standIn := cerebrastest.NewServer()
+	t.Cleanup(standIn.Close)
+	if len(contents) > 0 {
+		standIn.ScriptContent(contents...)
+	}
+
+	return newE2EEnv(t, standIn), standIn
+}
+
+// sessionOf returns the session a block was proposed in
+func (e *e2eEnv) sessionOf(t *testing.T, blockID string) string {
+	t.Helper()
+
+	var sessionID string
+	if err := e.lifecycleDB.QueryRow(`SELECT session_id FROM session_blocks WHERE block_id = ?`, blockID).Scan(&sessionID); err != nil {
+		t.Fatalf("block %s not found: %v", blockID, err)
+	}
+	return sessionID
+}
+
+// blockCodeHash returns the hash of a block's current code, as shown to reviewers
+func (e *e2eEnv) blockCodeHash(t *testing.T, blockID string) string {
+	t.Helper()
+
+	var code string
+	if err := e.lifecycleDB.QueryRow(`SELECT code FROM session_blocks WHERE block_id = ?`, blockID).Scan(&code); err != nil {
+		t.Fatalf("block %s not found: %v", blockID, err)
+	}
+	return workspace.HashContent(code)
+}
+
+// call sends a tools/call request and fails the test on error
+func (e *e2eEnv) call(t *testing.T, action string, params map[string]interface{}) string {
+	t.Helper()
+
+	text, err := e.tryCall(action, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+// tryCall sends a tools/call request over the stdio transport and returns the result text
+func (e *e2eEnv) tryCall(action string, params map[string]interface{}) (string, error) {
+	e.requestID++
+	line, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      e.requestID,
+		"method":  "tools/call",
+		"params": map[string]interface{}{
+			"name":      "brainloop",
+			"arguments": map[string]interface{}{"action": action, "params": params},
+		},
+	})
+
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	go func() {
+		e.server.Serve(stdinReader, stdoutWriter)
+		stdoutWriter.Close()
+	}()
+
+	go func() {
+		fmt.Fprintln(stdinWriter, string(line))
+		stdinWriter.Close()
+	}()
+
+	scanner := bufio.NewScanner(stdoutReader)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return "", fmt.Errorf("no response for %s: %v", action, scanner.Err())
+	}
+
+	var response mcp.JSONRPCResponse
+	if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+		return "", fmt.Errorf("invalid response for %s: %v", action, err)
+	}
+	if response.Error != nil {
+		return "", fmt.Errorf("%s failed: %s (%v)", action, response.Error.Message, response.Error.Data)
+	}
+
+	result := response.Result.(map[string]interface{})
+	content := result["content"].([]interface{})[0].(map[string]interface{})
+	return content["text"].(string), nil
+}
+
+// TestE2EGenerateFileOffline generates a file through the MCP transport
+func TestE2EGenerateFileOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t, "```go\npackage main\n\nfunc main() {}\n```")
+
+	outputPath := filepath.Join(env.dir, "main.go")
+	env.call(t, "generate_file", map[string]interface{}{
+		"verified_prompt": "Write an empty main program",
+		"output_path":     outputPath,
+		"code_type":       "go",
+	})
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("generated file missing: %v", err)
+	}
+	if string(data) != "package main\n\nfunc main() {}" {
+		t.Errorf("Unexpected generated content: %q", data)
+	}
+
+	if requests := standIn.Requests(); len(requests) != 1 || requests[0].Request.Messages[1].Content != "Write an empty main program" {
+		t.Errorf("Expected a single request carrying the prompt, got %+v", requests)
+	}
+}
+
+// TestE2EProviderFailoverOffline fails over to the second provider on a 500
+func TestE2EProviderFailoverOffline(t *testing.T) {
+	primary := cerebrastest.NewServer()
+	defer primary.Close()
+	primary.InjectFault(500, 0, 1)
+
+	backup := cerebrastest.NewServer()
+	defer backup.Close()
+	backup.ScriptContent("package main")
+
+	env := newE2EEnv(t, primary, backup)
+
+	env.call(t, "explore", map[string]interface{}{
+		"description": "Anything",
+		"type":        "go",
+	})
+
+	if len(primary.Requests()) != 1 || len(backup.Requests()) != 1 {
+		t.Errorf("Expected one attempt per provider, got %d/%d", len(primary.Requests()), len(backup.Requests()))
+	}
+
+	var status string
+	var errorCount int
+	if err := env.outputDB.QueryRow(`
+		SELECT status, error_count FROM health_checks WHERE check_name = 'provider:stand-in-1'
+	`).Scan(&status, &errorCount); err != nil {
+		t.Fatalf("provider health not recorded: %v", err)
+	}
+	if errorCount != 1 {
+		t.Errorf("Expected 1 recorded error, got %d (%s)", errorCount, status)
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"brainloop/internal/cerebras"
+	"brainloop/internal/cerebras/cerebrastest"
+)
+
+// TestE2EGenerationToolsOffline lets the model call reader tools while
+// generating a file and a loop block, and refuses paths outside the project
+func TestE2EGenerationToolsOffline(t *testing.T) {
+	env, standIn := newScriptedEnv(t)
+	toolCall := func(path string) cerebrastest.Response {
+		return cerebrastest.Response{ToolCalls: []cerebras.ToolCall{{
+			ID:       "call_" + filepath.Base(path),
+			Type:     "function",
+			Function: cerebras.ToolCallFunction{Name: "read_code", Arguments: `{"file_path":"` + path + `"}`},
+		}}}
+	}
+	standIn.Script(
+		toolCall("/etc/passwd"),
+		cerebrastest.Response{Content: "package main\n\nfunc main() {}"},
+		toolCall("../secret.go"),
+		cerebrastest.Response{Content: "package store\n\nfunc Load() {}"},
+		toolCall("../../secret.go"),
+		cerebrastest.Response{Content: "package store\n\nfunc Load() error { return nil }"},
+	)
+
+	project := filepath.Join(env.dir, "project")
+	os.MkdirAll(project, 0755)
+	os.WriteFile(filepath.Join(env.dir, "secret.go"), []byte("package secret\n\nconst Key = \"hunter2\""), 0644)
+
+	// The last tool result of a request is the answer to the call it made
+	toolResult := func(request int) string {
+		t.Helper()
+		messages := standIn.Requests()[request].Request.Messages
+		last := messages[len(messages)-1]
+		if last.Role != "tool" {
+			t.Fatalf("Expected request %d to carry a tool result, got %+v", request, last)
+		}
+		return last.Content
+	}
+
+	env.call(t, "generate_file", map[string]interface{}{
+		"verified_prompt": "Write an empty main program",
+		"output_path":     filepath.Join(project, "main.go"),
+		"code_type":       "go",
+		"use_tools":       true,
+	})
+	if result := toolResult(1); !strings.HasPrefix(result, "error:") || !strings.Contains(result, "must be relative") {
+		t.Errorf("Expected an absolute path to be refused, got %q", result)
+	}
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":         "propose",
+		"project_root": project,
+		"use_tools":    true,
+		"blocks":       []interface{}{map[string]interface{}{"id": "store", "description": "Store", "type": "go", "target": filepath.Join(project, "store.go")}},
+	})
+	if len(standIn.Requests()[2].Request.Tools) == 0 {
+		t.Fatal("Expected the propose generation to offer the tools")
+	}
+	if result := toolResult(3); !strings.Contains(result, "escapes workspace root") {
+		t.Errorf("Expected a path outside the project to be refused, got %q", result)
+	}
+
+	env.call(t, "loop", map[string]interface{}{
+		"mode":           "refine",
+		"session_id":     env.sessionOf(t, "store"),
+		"block_id":       "store",
+		"audit_feedback": "Return an error",
+		"use_tools":      true,
+	})
+	refine := standIn.Requests()[4].Request
+	if len(refine.Tools) == 0 || !strings.Contains(refine.Messages[len(refine.Messages)-1].Content, "Return an error") {
+		t.Errorf("Expected the refine conversation to offer the tools, got %+v", refine)
+	}
+	if result := toolResult(5); !strings.Contains(result, "escapes workspace root") {
+		t.Errorf("Expected a path outside the project to be refused, got %q", result)
+	}
+
+	for _, r := range standIn.Requests() {
+		for _, m := range r.Request.Messages {
+			if strings.Contains(m.Content, "hunter2") {
+				t.Fatalf("A file outside the project reached the provider: %q", m.Content)
+			}
+		}
+	}
+}